@@ -40,9 +40,9 @@ type session_t struct {
 	Group string
 }
 
-// expert flags and constants for logging
-const cSHOWREQUESTHDRS int32 = 0x01 // show request details
-const cSHOWENDPOINT int32 = 0x02    // show requested endpoint
+// expert flags for logging, by name in the "xFlags" of logitcfg.json
+var cSHOWREQUESTHDRS = logit.RegisterXFlag("showRequestHdrs", 0x01) // show request details
+var cSHOWENDPOINT = logit.RegisterXFlag("showEndpoint", 0x02)       // show requested endpoint
 
 var myFlags logit.DFlags_t // holds the logger flags
 var mStats runtime.MemStats
@@ -55,6 +55,7 @@ func main() {
 		os.Exit(1)
 	}
 	defer closeMain()
+	defer logit.DumpOnPanic()
 	logit.GetMyLogInfo(&myFlags)
 	// Now run some simple tests
 	logit.Infof(&myFlags, "golang version: '%s'", runtime.Version())
@@ -77,10 +78,19 @@ func main() {
 	p1.Methods("GET").HandlerFunc(p1Handler)
 	p2 := router.PathPrefix("/dynamic").Subrouter()
 	p2.Methods("GET").HandlerFunc(p2Handler)
+	// the recent logs, for the admins only
+	p3 := router.PathPrefix("/admin").Subrouter()
+	p3.Use(middlewareAdmin)
+	p3.Handle("/logs", logit.RingHandler()).Methods("GET")
+	p3.HandleFunc("/bundle", bundleHandler).Methods("GET")
+	p3.HandleFunc("/config", configHandler).Methods("GET")
+	p3.Handle("/flags", logit.ControlHandler()).Methods("GET", "POST", "DELETE")
 
 	// attach middleware authentication to router
 	//amw := authenticationMiddleware_t{}
 	amw.populateUsers() // populate with users and passwords
+	// the request ID first, so every line of a request has it
+	router.Use(logit.RequestIDMiddleware)
 	router.Use(amw.middlewareAuthorization)
 	//
 	// make a channel to notify main when to shutdown
@@ -98,6 +108,7 @@ func main() {
 		ReadTimeout:  15 * time.Second,
 	}
 	go func() {
+		defer logit.Recover(&myFlags)
 		logit.Infof(&myFlags, "Starting server, listening on '%s'", srv.Addr)
 		err = srv.ListenAndServe() // this will run...
 		if err != nil {
@@ -140,50 +151,110 @@ func closeMain() {
 */
 func (amw *authenticationMiddleware_t) middlewareAuthorization(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLog(r)
 		//token := r.Header.Get("X-Session-Token")
 		session, err := store.Get(r, "session-name")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			logit.Warn(&myFlags, "Problem returning session name from store.")
+			reqLog.Warn("Problem returning session name from store.")
 			return
 		}
 
 		if session.IsNew { // no previous session, check if login
 			if strings.ToLower(r.RequestURI) == "/login" { // wants login page
 				if r.Method == "GET" { // asking for login page
-					logit.Debug(&myFlags, "Login page request")
+					reqLog.Debug("Login page request")
 					next.ServeHTTP(w, r)
 					return
 				} else if r.Method == "POST" { // asking for authentication
-					logit.Debug(&myFlags, "Login authentication request")
+					reqLog.Debug("Login authentication request")
 					next.ServeHTTP(w, r)
 					return
 				}
 			}
 			http.Error(w, "Sorry, Forbidden Page", 403)
-			logit.Warn(&myFlags, "Request for page from unauthorized source.")
+			reqLog.Warn("Request for page from unauthorized source.")
 		} else { // a session is present in header
 			value := session.Values["session"]
 			var session = &session_t{}
 			session, sessionOk := value.(*session_t)
 			if !sessionOk {
 				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-				logit.Warn(&myFlags, "Request for page from unknown session")
+				reqLog.Warn("Request for page from unknown session")
 				return
 			}
+			reqLog = reqLog.With("user", session.User)
 			if !amw.checkUser(session.User, session.Token) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
-				logit.Warnf(&myFlags, "Request for page from unauthorized user: '%s'", session.User)
+				reqLog.Warn("Request for page from unauthorized user")
 				return
 			}
 			// We found the user/token in our map
-			logit.Infof(&myFlags, "Request for page from authorized user: '%s'", session.User)
+			reqLog.Info("Request for page from authorized user")
 			next.ServeHTTP(w, r)
 			return // no error
 		}
 	})
 }
 
+/*
+  middlewareAdmin
+  Only a session of the admin group gets past, the authentication
+  middleware has checked the session already.
+*/
+func middlewareAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := store.Get(r, "session-name")
+		if err == nil {
+			if value, ok := session.Values["session"].(*session_t); ok && value.Group == "admin" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, "Sorry, Forbidden Page", http.StatusForbidden)
+		requestLog(r).Warn("Request for an admin page from a user that is not an admin")
+	})
+}
+
+/*
+  bundleHandler
+  The support bundle zip of the running server
+*/
+func bundleHandler(wtr http.ResponseWriter, rdr *http.Request) {
+	wtr.Header().Set("Content-Type", "application/zip")
+	wtr.Header().Set("Content-Disposition", `attachment; filename="glue-bundle.zip"`)
+	if err := logit.WriteSupportBundle(wtr); err != nil {
+		requestLog(rdr).Errorf("Support bundle failed: %s", err.Error())
+	}
+}
+
+/*
+  configHandler
+  The config the server logs with, after the defaults and the overrides
+*/
+func configHandler(wtr http.ResponseWriter, rdr *http.Request) {
+	wtr.Header().Set("Content-Type", "application/json")
+	if err := logit.Default().WriteEffectiveConfig(wtr); err != nil {
+		requestLog(rdr).Errorf("Effective config failed: %s", err.Error())
+	}
+}
+
+/*
+  requestLog
+  A logger that logs the request ID, the method and the route of the
+  request with every message, the route is the path template that
+  mux matched.
+*/
+func requestLog(r *http.Request) *logit.Entry_t {
+	route := r.URL.Path
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			route = template
+		}
+	}
+	return logit.WithContext(r.Context(), &myFlags).With("method", r.Method, "route", route)
+}
+
 /*
   a GET request has come in for the login page
   return the page to the caller
@@ -215,7 +286,7 @@ func loginPageHandler(wtr http.ResponseWriter, rdr *http.Request) {
   return the index.html page or error if not good creds.
 */
 func loginAuthenticate(wtr http.ResponseWriter, rdr *http.Request) {
-	logit.Debugfx(cSHOWREQUESTHDRS, &myFlags, "Request Header:\n%s", formatRequest(rdr))
+	logit.DebugfxCtx(rdr.Context(), cSHOWREQUESTHDRS, &myFlags, "Request Header:\n%s", formatRequest(rdr))
 	var userName string = ""
 	var passWord string = ""
 	payload := strings.Split(rdr.Form.Encode(), "&")
@@ -232,13 +303,14 @@ func loginAuthenticate(wtr http.ResponseWriter, rdr *http.Request) {
 	}
 	if (len(userName) == 0) || (len(passWord) == 0) {
 		http.Error(wtr, "Not authorized, no user id, and/or password", 401)
-		logit.Warnf(&myFlags, "Not authorized, no user id, and/or password in login request")
+		logit.WarnCtx(rdr.Context(), &myFlags, "Not authorized, no user id, and/or password in login request")
 		return
 	}
 	if (len(userName) > 0) && (len(passWord) > 0) {
-		logit.Debugf(&myFlags, "User:'%s' with password found inside request", userName)
+		reqLog := requestLog(rdr).With("user", userName)
+		reqLog.Debug("User with password found inside request")
 		if amw.checkUser(userName, passWord) {
-			logit.Infof(&myFlags, "User:'%s' validated", userName)
+			reqLog.Info("User validated")
 			// get a new session for this user
 			newSession, err := store.Get(rdr, "session-name")
 			if err != nil {
@@ -276,11 +348,11 @@ func loginAuthenticate(wtr http.ResponseWriter, rdr *http.Request) {
 		}
 	}
 	http.Error(wtr, "Not authorized, bad user id, or password", 401)
-	logit.Warn(&myFlags, "Not authorized, bad user id, or password in login request")
+	logit.WarnCtx(rdr.Context(), &myFlags, "Not authorized, bad user id, or password in login request")
 }
 
 func p1Handler(wtr http.ResponseWriter, rdr *http.Request) {
-	logit.Debugfx(cSHOWENDPOINT, &myFlags, "P1 Endpoint request:'%s'", rdr.RequestURI)
+	logit.DebugfxCtx(rdr.Context(), cSHOWENDPOINT, &myFlags, "P1 Endpoint request:'%s'", rdr.RequestURI)
 	handler := http.Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(""))))
 	//handler.ServeHTTP(wtr, rdr)
 	gzHandler := gziphandler.GzipHandler(handler)
@@ -288,7 +360,7 @@ func p1Handler(wtr http.ResponseWriter, rdr *http.Request) {
 }
 
 func p2Handler(wtr http.ResponseWriter, rdr *http.Request) {
-	logit.Debugfx(cSHOWENDPOINT, &myFlags, "P2 Endpoint request:'%s'", rdr.RequestURI)
+	logit.DebugfxCtx(rdr.Context(), cSHOWENDPOINT, &myFlags, "P2 Endpoint request:'%s'", rdr.RequestURI)
 	handler := http.Handler(http.StripPrefix("/dynamic/", http.FileServer(http.Dir(""))))
 	//handler.ServeHTTP(wtr, rdr)
 	gzHandler := gziphandler.GzipHandler(handler)
@@ -323,7 +395,8 @@ func (amw *authenticationMiddleware_t) checkUser(userName string, token string)
 
 /*
   This generates a string that can be printed or logged.
-  WARNING: This will show the user name and password request.
+  WARNING: This will show the user name and password request,
+  the "redact" of logitcfg.json hides them and the cookies in the log.
 */
 func formatRequest(r *http.Request) string {
 	// Create return string
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"logit"
+	"logit/logittest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+/*
+  TestLoginRejected
+  A login without a password, or with a bad one, is a 401 and a
+  warning with the request ID of the request
+*/
+func TestLoginRejected(t *testing.T) {
+	c := logittest.Capture(t, logittest.Options_t{})
+	logit.GetMyLogInfo(&myFlags)
+	amw.populateUsers()
+	for _, test := range []struct {
+		form url.Values
+		want string
+	}{
+		{url.Values{"username": {"jeff"}}, "no user id, and/or password"},
+		{url.Values{"username": {"jeff"}, "password": {"wrong"}}, "bad user id, or password"},
+	} {
+		req := httptest.NewRequest("POST", "/login/authenticate", nil)
+		req.Form = test.form
+		w := httptest.NewRecorder()
+		loginAuthenticate(w, c.Request(req))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Glue problem: %v returned %d", test.form, w.Code)
+		}
+		logittest.AssertLogged(t, logit.WARN, "^Not authorized, "+test.want+" in login request$")
+	}
+	logittest.AssertLogged(t, logit.DEBUG, "^Request Header:")
+	logittest.AssertNotLogged(t, logit.INFO, "User validated")
+	for _, rec := range c.Records() {
+		if rec.Level == logit.WARN && !hasRequestID(rec, c.RequestID()) {
+			t.Errorf("Glue problem: '%s' has no request ID", rec.Msg)
+		}
+	}
+}
+
+/*
+  TestEndpointLogged
+  The showEndpoint xflag shows the endpoints that are served
+*/
+func TestEndpointLogged(t *testing.T) {
+	c := logittest.Capture(t, logittest.Options_t{XFlags: cSHOWENDPOINT})
+	logit.GetMyLogInfo(&myFlags)
+	w := httptest.NewRecorder()
+	p1Handler(w, c.Request(httptest.NewRequest("GET", "/static/missing.html", nil)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Glue problem: a missing page returned %d", w.Code)
+	}
+	c.AssertLogged(logit.DEBUG, "^P1 Endpoint request:'/static/missing.html'$")
+	c.AssertNotLogged(logit.DEBUG, "^Request Header:")
+}
+
+func hasRequestID(rec logit.Record_t, id string) bool {
+	for _, field := range rec.Fields {
+		if field.Key == logit.RequestIDField && field.Value == id {
+			return true
+		}
+	}
+	return false
+}
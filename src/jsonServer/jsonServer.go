@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"jsonServer/curr1"
+	"logit"
 	"net/http"
 	"os"
 	"strings"
@@ -17,22 +18,25 @@ import (
 
 var currencies = curr1.Load("data/curr1.csv")
 
+var myFlags logit.DFlags_t // holds the logger flags
+var pwd string             // the directory the server started in, found once by main
+
 // api endpoint for service
 // input of this form: {"get" : "Yen"}
 func currs(resp http.ResponseWriter, req *http.Request) {
-	fmt.Println("URL", req.URL)
+	logit.InfofCtx(req.Context(), &myFlags, "URL %s", req.URL)
 	var currRequest curr1.CurrencyRequest
 	dec := json.NewDecoder(req.Body)
 	if err := dec.Decode(&currRequest); err != nil {
 		resp.WriteHeader(http.StatusBadRequest)
-		fmt.Println(err)
+		logit.WarnCtx(req.Context(), &myFlags, err.Error())
 		return
 	}
 
 	result := curr1.Find(currencies, currRequest.Get)
 	enc := json.NewEncoder(resp)
 	if err := enc.Encode(&result); err != nil {
-		fmt.Println(err)
+		logit.ErrorCtx(req.Context(), &myFlags, err.Error())
 		resp.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -45,7 +49,7 @@ func gui(resp http.ResponseWriter, req *http.Request) {
 	file, err := os.Open(path)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
-		fmt.Println(err)
+		logit.ErrorCtx(req.Context(), &myFlags, err.Error())
 		return
 	}
 	io.Copy(resp, file)
@@ -58,7 +62,7 @@ func plotly(resp http.ResponseWriter, req *http.Request) {
 	file, err := os.Open(path)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
-		fmt.Println(err)
+		logit.ErrorCtx(req.Context(), &myFlags, err.Error())
 		return
 	}
 	io.Copy(resp, file)
@@ -71,7 +75,7 @@ func scatter(resp http.ResponseWriter, req *http.Request) {
 	file, err := os.Open(path)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
-		fmt.Println(err)
+		logit.ErrorCtx(req.Context(), &myFlags, err.Error())
 		return
 	}
 	io.Copy(resp, file)
@@ -84,7 +88,7 @@ func scatter_gz(resp http.ResponseWriter, req *http.Request) {
 	file, err := os.Open(path)
 	if err != nil {
 		resp.WriteHeader(http.StatusInternalServerError)
-		fmt.Println(err)
+		logit.ErrorCtx(req.Context(), &myFlags, err.Error())
 		return
 	}
 	io.Copy(resp, file)
@@ -92,7 +96,7 @@ func scatter_gz(resp http.ResponseWriter, req *http.Request) {
 
 func addCookie(resp http.ResponseWriter, req *http.Request) {
 	// add cookie
-	fmt.Println("Add testcookiename")
+	logit.InfoCtx(req.Context(), &myFlags, "Add testcookiename")
 	expire := time.Now().AddDate(0, 0, 1)
 	cookie := http.Cookie{Name: "testcookiename", Value: "testcookievalue", Path: "/", Expires: expire, MaxAge: 86400}
 
@@ -123,12 +127,12 @@ func getPwd() string {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	fmt.Println(pwd)
+	logit.Info(&myFlags, pwd)
 	return pwd
 }
 
 func getProjDir() string {
-	projDir := getPwd()
+	projDir := pwd
 	index := strings.Index(projDir, "/src/jsonServer")
 	if index != -1 {
 		projDir = projDir[0:index]
@@ -167,8 +171,8 @@ func simpleGzText(resp http.ResponseWriter, req *http.Request) {
 func makeHandler(withoutGzHandler http.Handler, withGzHandler http.Handler) http.HandlerFunc {
 	return func(resp http.ResponseWriter, req *http.Request) {
 		// Figure out the requrest filename
-		gzFullPath := getPwd() + req.URL.Path + ".gz"
-		fmt.Println("gzFullPath=", gzFullPath)
+		gzFullPath := pwd + req.URL.Path + ".gz"
+		logit.InfoCtx(req.Context(), &myFlags, "gzFullPath= "+gzFullPath)
 		if _, err := os.Stat(gzFullPath); err == nil {
 			// Note.  This does not work.  The content is still gzipped (or even double gzipped)
 			// A pre gzipped file exists.  Change req.URL.Path, set header and serve
@@ -188,7 +192,20 @@ func makeHandler(withoutGzHandler http.Handler, withGzHandler http.Handler) http
 func main() {
 	var dir string
 
-	flag.StringVar(&dir, "dir", getPwd(), "the directory to serve files from. Defaults to the pwd")
+	// use the default config file, without one the log goes to stdout
+	if _, err := os.Stat("logitcfg.json"); err == nil {
+		if err := logit.OpenLog(""); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	} else if !os.IsNotExist(err) {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	defer logit.CloseLog()
+	logit.GetMyLogInfo(&myFlags)
+	pwd = getPwd()
+	flag.StringVar(&dir, "dir", pwd, "the directory to serve files from. Defaults to the pwd")
 	flag.Parse()
 	fmt.Println("dir=", dir)
 
@@ -221,7 +238,8 @@ func main() {
 	mux.Handle("/static/", staticHandler)
 
 	fmt.Println("Starting http server")
-	if err := http.ListenAndServe(":4040", mux); err != nil {
+	// every request gets an X-Request-ID that its log lines share
+	if err := http.ListenAndServe(":4040", logit.RequestIDMiddleware(mux)); err != nil {
 		fmt.Println(err)
 	}
 	fmt.Println("Does it get here?")
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"logit"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxBatchBytes = 16 * 1024 * 1024 // larger posts are refused
+
+var myFlags logit.DFlags_t // holds the logger flags
+var logStore *store_t      // where the received logs are kept
+
+/*
+  logd
+  A log collector for the logit log server 'url'.
+  POST /logs takes the batches that logit posts.
+  GET /logs?site=&system=&level=&pkg=&since=&until=&limit= returns the
+  stored lines, since and until are RFC3339 times.
+*/
+func main() {
+	var addr, dir, logConfig string
+	flag.StringVar(&addr, "addr", "127.0.0.1:8090", "the address to listen on")
+	flag.StringVar(&dir, "dir", "logdata", "the directory the logs are stored in")
+	flag.StringVar(&logConfig, "logcfg", "", "the logit config file for logd itself")
+	flag.Parse()
+
+	err := logit.OpenLog(logConfig)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	defer logit.CloseLog()
+	logit.GetMyLogInfo(&myFlags)
+
+	logStore, err = newStore(dir)
+	if err != nil {
+		logit.Fatalf(&myFlags, "Cannot use log directory '%s': %s", dir, err)
+		return
+	}
+	logit.Infof(&myFlags, "Storing logs under '%s'", dir)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs", logsHandler)
+	srv := &http.Server{
+		Handler:      mux,
+		Addr:         addr,
+		WriteTimeout: 30 * time.Second,
+		ReadTimeout:  30 * time.Second,
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	go func() {
+		logit.Infof(&myFlags, "Starting logd, listening on '%s'", srv.Addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logit.Fatalf(&myFlags, "HTTP server returned with error: '%s'", err.Error())
+			stop <- os.Interrupt
+		}
+	}()
+	<-stop
+	logit.Info(&myFlags, "Stop signal received")
+	if err := srv.Shutdown(context.Background()); err != nil {
+		logit.Errorf(&myFlags, "could not shutdown: %v", err)
+	}
+}
+
+/*
+  logsHandler
+  POST stores a batch, GET runs a query
+*/
+func logsHandler(wtr http.ResponseWriter, rdr *http.Request) {
+	switch rdr.Method {
+	case http.MethodPost:
+		receiveBatch(wtr, rdr)
+	case http.MethodGet:
+		queryLogs(wtr, rdr)
+	default:
+		wtr.Header().Set("Allow", "GET, POST")
+		http.Error(wtr, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+/*
+  receiveBatch
+  Decode a posted batch and store it
+*/
+func receiveBatch(wtr http.ResponseWriter, rdr *http.Request) {
+	var batch logBatch_t
+	dec := json.NewDecoder(io.LimitReader(rdr.Body, maxBatchBytes))
+	if err := dec.Decode(&batch); err != nil {
+		logit.Warnf(&myFlags, "Bad batch from '%s': %s", rdr.RemoteAddr, err)
+		http.Error(wtr, "bad batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := logStore.save(&batch)
+	if err != nil {
+		logit.Errorf(&myFlags, "Cannot store batch from '%s/%s': %s", batch.SiteID, batch.SystemID, err)
+		http.Error(wtr, "cannot store batch", http.StatusInternalServerError)
+		return
+	}
+	logit.Debugf(&myFlags, "Stored %d lines from '%s/%s', %d were stored already", stored, batch.SiteID,
+		batch.SystemID, len(batch.Lines)-stored)
+	wtr.WriteHeader(http.StatusNoContent)
+}
+
+/*
+  queryLogs
+  Return the stored lines that match the query parameters as plain text
+*/
+func queryLogs(wtr http.ResponseWriter, rdr *http.Request) {
+	q, err := parseQuery(rdr)
+	if err != nil {
+		http.Error(wtr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lines, err := logStore.find(q)
+	if err != nil {
+		logit.Errorf(&myFlags, "Query failed: %s", err)
+		http.Error(wtr, "query failed", http.StatusInternalServerError)
+		return
+	}
+	wtr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		io.WriteString(wtr, line+"\n")
+	}
+}
+
+/*
+  parseQuery
+  Build the query out of the url parameters
+*/
+func parseQuery(rdr *http.Request) (*query_t, error) {
+	values := rdr.URL.Query()
+	q := &query_t{
+		siteID:   values.Get("site"),
+		systemID: values.Get("system"),
+		level:    strings.ToUpper(values.Get("level")),
+		pkg:      values.Get("pkg"),
+	}
+	if _, known := levelRank[q.level]; len(q.level) > 0 && !known {
+		return nil, fmt.Errorf("unknown level '%s'", q.level)
+	}
+	var err error
+	if since := values.Get("since"); len(since) > 0 {
+		if q.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return nil, fmt.Errorf("bad since time: %s", err)
+		}
+	}
+	if until := values.Get("until"); len(until) > 0 {
+		if q.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return nil, fmt.Errorf("bad until time: %s", err)
+		}
+	}
+	if limit := values.Get("limit"); len(limit) > 0 {
+		if q.limit, err = strconv.Atoi(limit); err != nil || q.limit < 0 {
+			return nil, fmt.Errorf("bad limit '%s'", limit)
+		}
+	}
+	return q, nil
+}
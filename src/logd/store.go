@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// logBatch_t is the json body posted by logit to its log server url
+type logBatch_t struct {
+	SiteID   string   `json:"SiteID"`             // customer site name
+	SystemID string   `json:"SystemID"`           // system that produced the lines
+	Sender   string   `json:"sender,omitempty"`   // the logger that posted it, empty for an old logit
+	FirstSeq int64    `json:"firstSeq,omitempty"` // the seq of the first line of the sender
+	Lines    []string `json:"lines"`              // the formatted log lines, oldest first
+}
+
+// sender_t is how far the lines of one sender were stored
+type sender_t struct {
+	Seq  int64     `json:"seq"`  // the last seq stored
+	Seen time.Time `json:"seen"` // when it last posted
+}
+
+// logLine_t is one stored log line broken into its parts
+type logLine_t struct {
+	time  time.Time // when the line was logged
+	level string    // FATAL, ERR, WARN, INFO, DBUG, DBGX or TRCE, or the json level names
+	pkg   string    // package that logged the line
+	file  string    // file that logged the line
+	raw   string    // the full line as it was received
+}
+
+// query_t selects the stored lines to return
+type query_t struct {
+	siteID   string    // only this site, all sites if empty
+	systemID string    // only this system, all systems if empty
+	level    string    // this level and more severe, all levels if empty
+	pkg      string    // only this package, all packages if empty
+	since    time.Time // no lines older than this
+	until    time.Time // no lines newer than this, no limit if zero
+	limit    int       // max lines returned, no limit if zero
+}
+
+// severity of the level labels that logit writes, smaller is worse
+var levelRank = map[string]int{
+	"FATAL": 0,
+	"ERR":   1,
+	"ERROR": 1,
+	"WARN":  2,
+	"INFO":  3,
+	"DBUG":  4,
+	"DEBUG": 4,
+	"DBGX":  4,
+	"TRCE":  5,
+	"TRACE": 5,
+}
+
+const dayLayout = "2006-01-02" // name of the daily log files
+
+const maxLineSize = 1024 * 1024 // a longer line is cut, so the daily files can always be scanned
+const cutMark = " ...[cut]"     // the end of a line that was cut
+
+// the new lines inside a record, e.g. of a stack, are stored as \n so a record stays one line
+var newLineEscaper = strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+
+const sendersFile = "senders.json"      // the senders of a system, next to its daily files
+const senderExpiry = 7 * 24 * time.Hour // a sender that has not posted for this long is forgotten
+
+// store_t keeps the logs on disk as <dir>/<SiteID>/<SystemID>/<day>.log
+type store_t struct {
+	dir     string
+	mutex   sync.Mutex                      // one writer at a time, so lines of a batch stay together
+	senders map[string]map[string]*sender_t // the senders by system directory, loaded when first posted to
+}
+
+/*
+  newStore
+  Make sure the top directory of the store exists
+*/
+func newStore(dir string) (*store_t, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &store_t{dir: dir, senders: make(map[string]map[string]*sender_t)}, nil
+}
+
+/*
+  cleanName
+  Make the SiteID or SystemID safe to use as a directory name
+*/
+func cleanName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || strings.Trim(name, ".") == "" {
+		return "_"
+	}
+	return name
+}
+
+/*
+  parseLine
+  Break a logit text line "RFC3339 LEVEL[pkg:file] msg", or a logit
+  json line, into its parts.
+  Lines that do not look like that are kept with the receive time.
+*/
+func parseLine(raw string, received time.Time) logLine_t {
+	line := logLine_t{time: received, raw: raw}
+	if strings.HasPrefix(raw, "{") {
+		return parseJSONLine(line)
+	}
+	space := strings.IndexByte(raw, ' ')
+	if space < 0 {
+		return line
+	}
+	t, err := time.Parse(time.RFC3339, raw[:space])
+	if err != nil {
+		return line
+	}
+	line.time = t
+	rest := raw[space+1:]
+	lb := strings.IndexByte(rest, '[')
+	rb := strings.IndexByte(rest, ']')
+	if lb < 0 || rb < lb {
+		return line
+	}
+	line.level = rest[:lb]
+	where := strings.SplitN(rest[lb+1:rb], ":", 2)
+	line.pkg = where[0]
+	if len(where) == 2 {
+		line.file = where[1]
+	}
+	return line
+}
+
+/*
+  parseJSONLine
+  Take the parts out of a logit json line
+*/
+func parseJSONLine(line logLine_t) logLine_t {
+	var fields struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Pkg   string `json:"pkg"`
+		File  string `json:"file"`
+	}
+	if err := json.Unmarshal([]byte(line.raw), &fields); err != nil {
+		return line
+	}
+	if t, err := time.Parse(time.RFC3339Nano, fields.Time); err == nil {
+		line.time = t
+	}
+	line.level = fields.Level
+	line.pkg = fields.Pkg
+	line.file = fields.File
+	return line
+}
+
+/*
+  save
+  Append the lines of a batch to the daily file of its site and system.
+  The day comes from the time in each line, so a day boundary inside
+  a batch splits it over two files.
+  A batch with a sender and seqs is posted again when logit did not
+  hear back, the lines that are stored already are skipped. The count
+  is of the lines that were new.
+*/
+func (s *store_t) save(batch *logBatch_t) (int, error) {
+	sysDir := filepath.Join(s.dir, cleanName(batch.SiteID), cleanName(batch.SystemID))
+	if err := os.MkdirAll(sysDir, 0755); err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	lines := batch.Lines
+	var sender *sender_t
+	if len(batch.Sender) > 0 && batch.FirstSeq > 0 {
+		senders := s.loadSenders(sysDir)
+		sender = senders[batch.Sender]
+		if sender == nil {
+			sender = &sender_t{}
+			senders[batch.Sender] = sender
+		}
+		if skip := sender.Seq - batch.FirstSeq + 1; skip >= int64(len(lines)) {
+			lines = nil
+		} else if skip > 0 {
+			lines = lines[skip:]
+		}
+	}
+	if err := s.writeLines(sysDir, lines, received); err != nil {
+		return 0, err
+	}
+	if sender != nil {
+		if last := batch.FirstSeq + int64(len(batch.Lines)) - 1; last > sender.Seq {
+			sender.Seq = last
+		}
+		sender.Seen = received
+		if err := s.saveSenders(sysDir, received); err != nil {
+			return len(lines), err
+		}
+	}
+	return len(lines), nil
+}
+
+/*
+  loadSenders
+  The senders of a system, out of its senders.json the first time
+*/
+func (s *store_t) loadSenders(sysDir string) map[string]*sender_t {
+	senders, found := s.senders[sysDir]
+	if found {
+		return senders
+	}
+	senders = make(map[string]*sender_t)
+	if raw, err := ioutil.ReadFile(filepath.Join(sysDir, sendersFile)); err == nil {
+		json.Unmarshal(raw, &senders) // a bad file only costs some duplicates
+	}
+	s.senders[sysDir] = senders
+	return senders
+}
+
+/*
+  saveSenders
+  Write the senders of a system, the ones that went quiet are dropped
+*/
+func (s *store_t) saveSenders(sysDir string, now time.Time) error {
+	senders := s.senders[sysDir]
+	for id, sender := range senders {
+		if now.Sub(sender.Seen) > senderExpiry {
+			delete(senders, id)
+		}
+	}
+	raw, err := json.MarshalIndent(senders, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(sysDir, sendersFile+".tmp")
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(sysDir, sendersFile))
+}
+
+/*
+  storedLine
+  A line as it is stored, one line of at most maxLineSize bytes.
+  Its new lines are escaped, a longer line is cut where a rune starts.
+*/
+func storedLine(raw string) string {
+	raw = newLineEscaper.Replace(raw)
+	if len(raw) <= maxLineSize {
+		return raw
+	}
+	cut := maxLineSize - len(cutMark)
+	for cut > 0 && !utf8.RuneStart(raw[cut]) {
+		cut--
+	}
+	return raw[:cut] + cutMark
+}
+
+/*
+  writeLines
+  Append lines to the daily files of a system, each as storedLine
+  makes it. The error is the first
+  write, flush or close that failed, e.g. on a full disk, then the
+  batch was not stored and the seq of its sender must not move.
+*/
+func (s *store_t) writeLines(sysDir string, lines []string, received time.Time) (err error) {
+	var fh *os.File
+	var writer *bufio.Writer
+	var day string
+	closeFile := func() error {
+		flushErr := writer.Flush()
+		closeErr := fh.Close()
+		fh = nil
+		if flushErr != nil {
+			return flushErr
+		}
+		return closeErr
+	}
+	defer func() {
+		if fh != nil {
+			if closeErr := closeFile(); err == nil {
+				err = closeErr
+			}
+		}
+	}()
+	for _, raw := range lines {
+		raw = strings.TrimRight(raw, "\r\n")
+		if len(raw) == 0 {
+			continue
+		}
+		line := parseLine(raw, received)
+		lineDay := line.time.UTC().Format(dayLayout)
+		if lineDay != day { // rotate to the file of this day
+			if fh != nil {
+				if err := closeFile(); err != nil {
+					return err
+				}
+			}
+			fh, err = os.OpenFile(filepath.Join(sysDir, lineDay+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				fh = nil
+				return err
+			}
+			writer = bufio.NewWriter(fh)
+			day = lineDay
+		}
+		if _, err := writer.WriteString(storedLine(raw) + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+  matches
+  Check one line against the query
+*/
+func (q *query_t) matches(line *logLine_t) bool {
+	if len(q.level) > 0 {
+		rank, known := levelRank[line.level]
+		if !known || rank > levelRank[q.level] {
+			return false
+		}
+	}
+	if len(q.pkg) > 0 && line.pkg != q.pkg {
+		return false
+	}
+	if !q.since.IsZero() && line.time.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && line.time.After(q.until) {
+		return false
+	}
+	return true
+}
+
+/*
+  find
+  Return the stored lines that match the query, oldest day first.
+  Only the daily files that can hold lines in the time range are read.
+*/
+func (s *store_t) find(q *query_t) ([]string, error) {
+	sites := []string{cleanName(q.siteID)}
+	if len(q.siteID) == 0 {
+		sites = listDir(s.dir)
+	}
+	var result []string
+	for _, site := range sites {
+		systems := []string{cleanName(q.systemID)}
+		if len(q.systemID) == 0 {
+			systems = listDir(filepath.Join(s.dir, site))
+		}
+		for _, system := range systems {
+			sysDir := filepath.Join(s.dir, site, system)
+			for _, name := range listDir(sysDir) {
+				if !strings.HasSuffix(name, ".log") || !q.wantsDay(strings.TrimSuffix(name, ".log")) {
+					continue
+				}
+				lines, err := s.scanFile(filepath.Join(sysDir, name), q, site, system)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, lines...)
+				if q.limit > 0 && len(result) >= q.limit {
+					return result[:q.limit], nil
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+/*
+  wantsDay
+  Check if a daily file can hold lines inside the time range
+*/
+func (q *query_t) wantsDay(day string) bool {
+	start, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return false
+	}
+	end := start.Add(24 * time.Hour)
+	if !q.since.IsZero() && !end.After(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && start.After(q.until) {
+		return false
+	}
+	return true
+}
+
+/*
+  scanFile
+  Read one daily file, the lines are prefixed with their site and system
+*/
+func (s *store_t) scanFile(fileName string, q *query_t, site string, system string) ([]string, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var result []string
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize+1) // with its new line
+	for scanner.Scan() {
+		line := parseLine(scanner.Text(), time.Time{})
+		if q.matches(&line) {
+			result = append(result, fmt.Sprintf("{%s/%s} %s", site, system, line.raw))
+		}
+	}
+	return result, scanner.Err()
+}
+
+/*
+  listDir
+  The sorted names inside a directory, nothing if it cannot be read
+*/
+func listDir(dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  newTestStore
+  A store in a temp dir, with a batch of text and json lines over two days
+*/
+func newTestStore(t *testing.T) *store_t {
+	s, err := newStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	batch := &logBatch_t{SiteID: "BeyondAI", SystemID: "local", Lines: []string{
+		"2020-03-01T23:59:58Z INFO[main:server] Last line of the day",
+		"2020-03-01T23:59:59Z ERR[handlers:auth] Login failed",
+		`{"time":"2020-03-02T00:00:01.5Z","level":"WARN","pkg":"handlers","file":"auth","msg":"Slow login"}`,
+		"2020-03-02T08:00:00Z DBUG[main:server] Debug of the next day\r\n",
+		"",
+	}}
+	if stored, err := s.save(batch); err != nil || stored != 5 {
+		t.Fatalf("Logd problem: stored %d, %v", stored, err)
+	}
+	if _, err := s.save(&logBatch_t{SiteID: "Other", SystemID: "prod", Lines: []string{
+		"2020-03-02T09:00:00Z ERR[main:server] Error of another site"}}); err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	return s
+}
+
+/*
+  TestStoreDays
+  The lines of a batch go to the file of the day they were logged,
+  json lines too, a line that is not logit's goes to the day it came
+*/
+func TestStoreDays(t *testing.T) {
+	s := newTestStore(t)
+	sysDir := filepath.Join(s.dir, "BeyondAI", "local")
+	for day, want := range map[string]int{"2020-03-01": 2, "2020-03-02": 2} {
+		raw, err := ioutil.ReadFile(filepath.Join(sysDir, day+".log"))
+		if err != nil {
+			t.Fatalf("Logd problem %s", err.Error())
+		}
+		if lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n"); len(lines) != want {
+			t.Errorf("Logd problem: %s has %q", day, lines)
+		}
+	}
+	received := time.Now()
+	if _, err := s.save(&logBatch_t{SiteID: "BeyondAI", SystemID: "local", Lines: []string{"not a logit line"}}); err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(sysDir, received.UTC().Format(dayLayout)+".log")); err != nil {
+		t.Errorf("Logd problem: no file for the day it came, %s", err.Error())
+	}
+	line := parseLine(`{"time":"2020-03-02T00:00:01.5Z","level":"WARN","pkg":"handlers","file":"auth"}`, received)
+	if line.level != "WARN" || line.pkg != "handlers" || line.file != "auth" || line.time.Day() != 2 {
+		t.Errorf("Logd problem: json line %+v", line)
+	}
+	if line := parseLine("2020-03-01T23:59:58Z no brackets", received); line.time.Day() != 1 || len(line.level) != 0 {
+		t.Errorf("Logd problem: text line %+v", line)
+	}
+}
+
+/*
+  TestStoreQuery
+  The filters of GET /logs: site, system, level, package, since, until
+  and limit, and the days they read
+*/
+func TestStoreQuery(t *testing.T) {
+	logStore = newTestStore(t)
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Last line", "Login failed", "Slow login", "Debug of the next day", "another site"}},
+		{"site=BeyondAI&system=local", []string{"Last line", "Login failed", "Slow login", "Debug of the next day"}},
+		{"system=prod", []string{"another site"}},
+		{"level=warn", []string{"Login failed", "Slow login", "another site"}},
+		{"level=ERROR&site=BeyondAI", []string{"Login failed"}},
+		{"pkg=handlers", []string{"Login failed", "Slow login"}},
+		{"since=2020-03-02T00:00:00Z&until=2020-03-02T08:30:00Z", []string{"Slow login", "Debug of the next day"}},
+		{"until=2020-03-01T23:59:58Z", []string{"Last line"}},
+		{"limit=2&site=BeyondAI", []string{"Last line", "Login failed"}},
+	} {
+		w := httptest.NewRecorder()
+		logsHandler(w, httptest.NewRequest(http.MethodGet, "/logs?"+test.query, nil))
+		got := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+		if w.Code != http.StatusOK || len(got) != len(test.want) {
+			t.Errorf("Logd problem: '%s' returned %d, %q", test.query, w.Code, got)
+			continue
+		}
+		for i, want := range test.want {
+			if !strings.Contains(got[i], want) || !strings.HasPrefix(got[i], "{") {
+				t.Errorf("Logd problem: '%s' line %d is '%s', wanted '%s'", test.query, i, got[i], want)
+			}
+		}
+	}
+	for _, query := range []string{"level=LOUD", "since=yesterday", "limit=-1"} {
+		w := httptest.NewRecorder()
+		logsHandler(w, httptest.NewRequest(http.MethodGet, "/logs?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Logd problem: '%s' returned %d", query, w.Code)
+		}
+	}
+	q := &query_t{since: time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)}
+	if q.wantsDay("2020-03-01") || !q.wantsDay("2020-03-02") || q.wantsDay("not a day") {
+		t.Errorf("Logd problem: the days since %v", q.since)
+	}
+}
+
+/*
+  TestStoreNames
+  A site or system cannot put files outside of the store
+*/
+func TestStoreNames(t *testing.T) {
+	for name, want := range map[string]string{
+		"BeyondAI":   "BeyondAI",
+		"site-1.a_b": "site-1.a_b",
+		"../../etc":  ".._.._etc",
+		"..":         "_",
+		"":           "_",
+		"a/b\\c d":   "a_b_c_d",
+	} {
+		if got := cleanName(name); got != want {
+			t.Errorf("Logd problem: cleanName('%s') is '%s', wanted '%s'", name, got, want)
+		}
+	}
+	s, err := newStore(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	if _, err := s.save(&logBatch_t{SiteID: "..", SystemID: "../../outside", Lines: []string{
+		"2020-03-01T10:00:00Z INFO[main:server] Where does it go"}}); err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "_", ".._.._outside", "2020-03-01.log")); err != nil {
+		t.Errorf("Logd problem: %s", err.Error())
+	}
+	lines, err := s.find(&query_t{siteID: "..", systemID: "../../outside"})
+	if err != nil || len(lines) != 1 || !strings.HasPrefix(lines[0], "{_/.._.._outside} ") {
+		t.Errorf("Logd problem: %q, %v", lines, err)
+	}
+}
+
+/*
+  TestStoreResend
+  A batch that is posted again, or one that overlaps the last one, only
+  stores the lines past the seq of its sender, also after a restart
+*/
+func TestStoreResend(t *testing.T) {
+	s, err := newStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	batch := func(firstSeq int64, count int) *logBatch_t {
+		b := &logBatch_t{SiteID: "BeyondAI", SystemID: "local", Sender: "sender-1", FirstSeq: firstSeq}
+		for i := 0; i < count; i++ {
+			b.Lines = append(b.Lines, "2020-03-01T10:00:00Z INFO[main:server] seq "+string(rune('a'+firstSeq+int64(i)-1)))
+		}
+		return b
+	}
+	for _, test := range []struct {
+		firstSeq int64
+		count    int
+		stored   int
+	}{
+		{1, 3, 3}, // seq 1 to 3
+		{1, 3, 0}, // the same batch again
+		{3, 3, 2}, // seq 3 is stored already
+		{4, 1, 0}, // inside what is stored
+		{7, 1, 1},
+	} {
+		if stored, err := s.save(batch(test.firstSeq, test.count)); err != nil || stored != test.stored {
+			t.Errorf("Logd problem: from seq %d stored %d, wanted %d, %v", test.firstSeq, stored, test.stored, err)
+		}
+	}
+	restarted, _ := newStore(s.dir)
+	if stored, _ := restarted.save(batch(5, 3)); stored != 0 {
+		t.Errorf("Logd problem: stored %d again after a restart", stored)
+	}
+	old := &logBatch_t{SiteID: "BeyondAI", SystemID: "local", Lines: batch(1, 2).Lines} // an old logit has no seqs
+	if stored, _ := restarted.save(old); stored != 2 {
+		t.Errorf("Logd problem: stored %d of a batch without seqs", stored)
+	}
+	lines, _ := restarted.find(&query_t{})
+	var seqs []string
+	for _, line := range lines {
+		seqs = append(seqs, line[len(line)-1:])
+	}
+	if strings.Join(seqs, "") != "abcdegab" {
+		t.Errorf("Logd problem: stored %q", lines)
+	}
+}
+
+/*
+  TestStoreLongLines
+  A record with new lines is stored and returned as one line, a line
+  that is too long to scan is cut, the lines after it are still found
+*/
+func TestStoreLongLines(t *testing.T) {
+	s, err := newStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	long := "2020-03-01T10:00:01Z INFO[main:server] " + strings.Repeat("é", maxLineSize)
+	if _, err := s.save(&logBatch_t{SiteID: "BeyondAI", SystemID: "local", Lines: []string{
+		"2020-03-01T10:00:00Z ERR[main:server] Panic\ngoroutine 1 [running]:\r\nmain.main()\n",
+		long,
+		"2020-03-01T10:00:02Z WARN[main:server] After the long one",
+	}}); err != nil {
+		t.Fatalf("Logd problem %s", err.Error())
+	}
+	lines, err := s.find(&query_t{})
+	if err != nil || len(lines) != 3 {
+		t.Fatalf("Logd problem: %d lines, %v", len(lines), err)
+	}
+	if want := `{BeyondAI/local} 2020-03-01T10:00:00Z ERR[main:server] Panic\ngoroutine 1 [running]:\nmain.main()`; lines[0] != want {
+		t.Errorf("Logd problem: '%s', wanted '%s'", lines[0], want)
+	}
+	if cut := strings.TrimPrefix(lines[1], "{BeyondAI/local} "); len(cut) > maxLineSize || !strings.HasSuffix(cut, "é"+cutMark) {
+		t.Errorf("Logd problem: the long line is %d bytes, ends '%s'", len(cut), cut[len(cut)-20:])
+	}
+	if !strings.HasSuffix(lines[2], "After the long one") {
+		t.Errorf("Logd problem: '%s'", lines[2])
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"logit"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+  command_t
+  One command of logit, run with the arguments after its name
+*/
+type command_t struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command_t{
+	{"bundle", "write a support bundle zip from a logit config", bundleCommand},
+	{"check", "check a logit config, with the line and column of each problem", checkCommand},
+	{"verify", "verify the audit chain of a log file and its rotated files", verifyCommand},
+}
+
+/*
+  logit
+  The command line tool for the logit logs.
+  logit bundle -config logitcfg.json -o bundle.zip
+  logit check [-pkgs main,handlers] [-effective] logitcfg.yaml
+  logit verify -key audit.key [-head 'seq 12, mac 3f..'] logfile.txt
+*/
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, command := range commands {
+		if command.name == os.Args[1] {
+			if err := command.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "logit %s: %s\n", command.name, err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+/*
+  usage
+  The commands and what they do
+*/
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: logit <command> [flags]")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", command.name, command.usage)
+	}
+}
+
+/*
+  bundleCommand
+  Write the support bundle of a config: its logs, the config as it is
+  parsed and the runtime info. A running process writes a fuller one
+  with logit.WriteSupportBundle.
+*/
+func bundleCommand(args []string) error {
+	var configFileName, outName string
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	flags.StringVar(&configFileName, "config", "logitcfg.json", "the logit config file")
+	flags.StringVar(&outName, "o", "logit-bundle-"+time.Now().Format("20060102-150405")+".zip", "the zip to write")
+	flags.Parse(args)
+
+	out, err := os.Create(outName)
+	if err != nil {
+		return err
+	}
+	if err := logit.WriteConfigBundle(out, configFileName); err != nil {
+		out.Close()
+		os.Remove(outName)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote '%s'\n", outName)
+	return nil
+}
+
+/*
+  checkCommand
+  Check a config file before it is deployed. Each problem is printed
+  with its line and column, errors make the command fail, as a logger
+  would not load the file. -effective prints the config the logger
+  would run with.
+*/
+func checkCommand(args []string) error {
+	var pkgs string
+	var effective bool
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.StringVar(&pkgs, "pkgs", "", "the packages, or package:files, the program logs from, comma separated")
+	flags.BoolVar(&effective, "effective", false, "print the config the logger would run with")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: logit check [-pkgs a,b] [-effective] logitcfg.json")
+	}
+	configFileName := flags.Arg(0)
+
+	var known []string
+	if len(pkgs) > 0 {
+		known = strings.Split(pkgs, ",")
+	}
+	problems, err := logit.CheckConfig(configFileName, known)
+	if err != nil {
+		return err
+	}
+	errorCount := 0
+	for _, problem := range problems {
+		if len(problem.Source) > 0 { // an environment variable
+			fmt.Println(problem.String())
+		} else {
+			fmt.Printf("%s:%s\n", configFileName, problem.String())
+		}
+		if problem.Severity == logit.CONFIG_ERROR {
+			errorCount++
+		}
+	}
+	if effective && errorCount == 0 {
+		if err := logit.WriteEffectiveConfig(os.Stdout, configFileName); err != nil {
+			return err
+		}
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("'%s' has %d errors", configFileName, errorCount)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("'%s' is fine\n", configFileName)
+	}
+	return nil
+}
+
+/*
+  verifyCommand
+  Verify the audit chain of a log file, with its rotated files, or of
+  the files given, oldest first. Each line that was changed, removed
+  or added is printed, the command fails if there is one. -head is a
+  head the logger logged, e.g. to the log server when it closed, the
+  files must have it, so lines cut off their end are found too.
+*/
+func verifyCommand(args []string) error {
+	var keyFile, headText string
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.StringVar(&keyFile, "key", "", "the file with the audit key of the config")
+	flags.StringVar(&headText, "head", "", "a head the logger logged, 'seq 12, mac 3f..'")
+	flags.Parse(args)
+	if flags.NArg() == 0 || len(keyFile) == 0 {
+		return fmt.Errorf("usage: logit verify -key audit.key [-head 'seq 12, mac 3f..'] logfile.txt [more files, oldest first]")
+	}
+	key, err := logit.LoadAuditKey(keyFile)
+	if err != nil {
+		return err
+	}
+	var head *logit.AuditHead_t
+	if len(headText) > 0 {
+		if head, err = logit.ParseAuditHead(headText); err != nil {
+			return err
+		}
+	}
+	files := flags.Args()
+	if len(files) == 1 {
+		files = logit.AuditFiles(files[0])
+		if len(files) == 0 {
+			return fmt.Errorf("no log file '%s'", flags.Arg(0))
+		}
+	}
+	res, err := logit.VerifyAudit(key, files, head)
+	if err != nil {
+		return err
+	}
+	for _, restart := range res.Restarts {
+		fmt.Printf("%s (note)\n", restart.String())
+	}
+	for _, problem := range res.Problems {
+		fmt.Println(problem.String())
+	}
+	fmt.Printf("%d lines in %d files, seq %d to %d, head: seq %d, mac %s\n", res.Lines, res.Files,
+		res.FirstSeq, res.LastSeq, res.LastSeq, res.HeadMac)
+	if len(res.Problems) > 0 {
+		return fmt.Errorf("the audit chain has %d problems", len(res.Problems))
+	}
+	fmt.Println("The audit chain is intact")
+	return nil
+}
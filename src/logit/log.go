@@ -2,75 +2,109 @@
 package logit
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type logLevel_t int
 
+// Level_t is the level of a record, for packages that take one, e.g. logittest
+type Level_t = logLevel_t
+
 const (
 	FATAL logLevel_t = iota
 	ERROR
 	WARN
 	INFO
 	DEBUG
+	TRACE
 )
 
+// logStats_t is updated with atomic operations only
 type logStats_t struct {
-	fatalCount int32 // count of fatal messages output
-	errorCount int32 // count of fatal messages output
-	warnCount  int32 // count of warning messages output to log
-	infoCount  int32 // count of informational messages output to log
-	debugCount int32 // count of debug messages output to log
-	lineCount  int32 // how many lines in the log file
-	logSize    int64 // size of log in bytes
+	fatalCount        int32 // count of fatal messages output
+	errorCount        int32 // count of fatal messages output
+	warnCount         int32 // count of warning messages output to log
+	infoCount         int32 // count of informational messages output to log
+	debugCount        int32 // count of debug messages output to log
+	traceCount        int32 // count of trace messages output to log
+	lineCount         int32 // how many lines in the log file
+	logSize           int64 // size of log in bytes
+	queueDropped      int64 // messages dropped because the writer queue was full
+	sampledDropped    int64 // messages dropped by the sampling rules
+	duplicatesDropped int64 // repeated messages that were only counted
+	urlSent           int64 // lines accepted by the log server
+	urlDropped        int64 // lines the log server never got
+	urlPending        int   // lines waiting to be posted to the log server
+	urlSpoolDepth     int64 // lines in the spool not posted yet
+	urlSpoolBytes     int64 // bytes of the spool on disk
+	urlReplayed       int64 // lines posted out of the spool after the log server was down
+	urlReplayLeft     int64 // lines still to replay
+	syslogSent        int64 // messages written to syslog
+	syslogDropped     int64 // messages syslog never got
+	syslogPending     int   // messages waiting to be sent to syslog
 }
 
-var logStats logStats_t
-
 // logFlags_t holds all the flags loaded from the log config file
 type logFlags_t struct {
-	generation  int32            // the generation that this was reloaded
-	siteID      string           // customer site name for log tracking
-	sysID       string           // id used for url collections
-	url         string           // url of log server
-	useStdOut   bool             // use stdout for log messages
-	logFileName string           // file name of log file
-	logLevel    logLevel_t       // level = debug, info, warn, fatal
-	debugAll    bool             // enable debug for everything
-	dFlags      map[string]bool  // debug per package or package:file
-	xFlags      map[string]int32 // granular debug for package:file
+	generation        int32                   // the generation that this was reloaded
+	siteID            string                  // customer site name for log tracking
+	sysID             string                  // id used for url collections
+	url               string                  // url of log server
+	urlBatch          int                     // max lines posted to the log server at once
+	urlBuffer         int                     // max lines held for the log server
+	urlSpoolDir       string                  // the lines for the log server wait here, in memory if empty
+	urlSpoolMax       int64                   // max bytes of the spool
+	auditKeyFile      string                  // the key of the audit chain of the log file, none if empty
+	auditKey          []byte                  // the key itself
+	syslog            syslog_t                // where and how to send to syslog
+	queueSize         int                     // messages waiting for the writer
+	overflow          overflow_t              // what to do when the writer queue is full
+	useStdOut         bool                    // use stdout for log messages
+	logFileName       string                  // file name of log file
+	rotate            rotate_t                // when to rotate the log file and what to keep
+	sinks             []sinkConfig_t          // the outputs, with their levels, formats and filters
+	sampling          []sampleRule_t          // first N per interval then 1 in M, per level and package
+	duplicateInterval time.Duration           // repeats of a message are summarized this often, 0 for never
+	ringSize          int                     // recent records kept in memory, 0 for none
+	ringLevel         logLevel_t              // the most verbose level the ring keeps
+	ringDumpFile      string                  // where a FATAL or a panic dumps the ring
+	redact            *redactor_t             // hides the secrets of every message, nil for none
+	policies          map[logLevel_t]policy_t // stack, flush and exit or panic, by level
+	bundleRedact      *redactor_t             // hides the secrets in a support bundle
+	stdOutFmt         format_t                // format of the lines to stdout
+	fileFmt           format_t                // format of the lines in the log file
+	urlFmt            format_t                // format of the lines posted to the log server
+	logLevel          logLevel_t              // level = trace, debug, info, warn, error, fatal
+	levels            map[string]logLevel_t   // level per package or package:file
+	maxLevel          logLevel_t              // the most verbose level of all, for the slog handler
+	debugAll          bool                    // enable debug for everything
+	dFlags            map[string]bool         // debug per package or package:file
+	xFlags            map[string]int32        // granular debug for package:file
+	overrides         map[string]override_t   // the control API overrides by package, package:file or all
+	sources           map[string]string       // where each setting came from: the file, the environment or the default
 }
 
-var allLogFlags *logFlags_t
-
-type monitorFunc_t func(bool) (bool, int32) // your function pointer type
-var monitorFunc monitorFunc_t
-
 type DFlags_t struct {
-	generation int32  // what generation of config file did the flags come from
-	pkgName    string // name of this package
-	fileName   string // name of this file
-	dFlag      bool   // debug messages on/off
-	xFlag      int32  // granular debug flags
+	logger     *Logger    // the logger the flags were loaded from
+	generation int32      // what generation of config file did the flags come from
+	pkgName    string     // name of this package
+	fileName   string     // name of this file
+	level      logLevel_t // the level of this package and file
+	dFlag      bool       // debug messages on/off
+	xFlag      int32      // granular debug flags
 }
 
-var logConfigFileName string    // the configuration file name, set by 'OpenLog'
-var logFileHandle *os.File      // handle to the log file itself
-var logFileWriter *bufio.Writer // handle to the writer to the log file
-
 const SHOWMONITOR int32 = 0x01 // show more debug msgs from inside the config monitor
-var myFlags DFlags_t           // typical log flags just like all other files
 
 // these are logs msgs that are placed here before the logger is fully established
 type logDelayed_t struct {
@@ -78,81 +112,188 @@ type logDelayed_t struct {
 	msg      string
 }
 
-var delayedLogs []logDelayed_t
+var dxMutex sync.Mutex // protects the DFlags_t of all the callers
+
+/*
+  Logger
+  Everything for one log: its config, flags, stats, outputs and config monitor.
+  Create one with 'NewLogger', the package functions like 'Info' use
+  the default logger that 'OpenLog' opens.
+*/
+type Logger struct {
+	configFileName string                 // the configuration file name
+	flags          atomic.Value           // holds the *logFlags_t, swapped whole on a reload
+	stats          logStats_t             // updated with atomic operations only
+	myFlags        DFlags_t               // typical log flags just like all other files
+	file           *logFile_t             // the log file and its rotation, nil if there is none
+	shipper        *urlShipper_t          // posts the logs to the log server, if there is a url
+	syslog         *syslogSender_t        // sends the logs to syslog, if there is an address
+	sinks          []*sink_t              // the open outputs, protected by writeMutex
+	watcher        *configWatcher_t       // reloads the config when it changes, nil until started
+	slogOutput     atomic.Value           // holds the *slogOutput_t of SetSlogOutput
+	reloadMutex    sync.Mutex             // one reload at a time
+	sampler        sampler_t              // the counting of the sampling and the duplicates
+	ring           ring_t                 // the recent records at all levels
+	baseFlags      *logFlags_t            // the flags of the config file without the overrides, protected by reloadMutex
+	overrides      map[string]*override_t // the control API overrides, protected by reloadMutex
+	closed         bool                   // Close was called, no override expires, protected by reloadMutex
+	callers        map[string]bool        // the package:files that loaded flags, protected by dxMutex
+
+	callbackMutex sync.Mutex    // protects callbacks
+	callbacks     []func(int32) // the OnReload functions
+
+	closeOnce sync.Once // Close runs once, a second call waits for the first
+	exitOnce  sync.Once // one EXIT policy closes and exits, the others wait for it
+
+	delayMutex  sync.Mutex     // protects delayedLogs
+	delayedLogs []logDelayed_t // logs waiting for the logger to be configured
+
+	queueMutex sync.RWMutex   // senders hold it shared, Close holds it to close the queue
+	queue      chan *Record_t // messages waiting for the writer, nil if no writer running
+	queueDone  chan struct{}  // closed when the writer has written everything
+	overflow   overflow_t     // what to do when the queue is full
+	writeMutex sync.Mutex     // one writer of the outputs at a time
+}
+
+/*
+  getLogFlags
+  The flags of the current generation
+*/
+func (l *Logger) getLogFlags() *logFlags_t {
+	flags, _ := l.flags.Load().(*logFlags_t)
+	return flags
+}
 
 /*
-  OpenLog
+  newLogger
+  A logger that writes to stdout at INFO level until it is configured
+*/
+func newLogger() *Logger {
+	l := &Logger{}
+	l.baseFlags = &logFlags_t{
+		siteID:    "??",
+		useStdOut: true,
+		logLevel:  INFO,
+		maxLevel:  INFO,
+		levels:    make(map[string]logLevel_t),
+		dFlags:    make(map[string]bool),
+		xFlags:    make(map[string]int32),
+		sinks:     []sinkConfig_t{{kind: STDOUT_SINK, name: STDOUT_SINK, enabled: true, level: TRACE}},
+	}
+	l.flags.Store(l.baseFlags)
+	l.sinks = []*sink_t{{sink: &consoleSink_t{out: os.Stdout}}}
+	l.myFlags.pkgName, l.myFlags.fileName = callerNames(1)
+	return l
+}
+
+/*
+  NewLogger
   Open up the logconfig file to setup the logging
   either locally, or to a web service, or to stdout.
 */
-func OpenLog(newLogConfigFileName string) error {
+func NewLogger(newLogConfigFileName string) (*Logger, error) {
 	var err error
+	l := newLogger()
 	if len(newLogConfigFileName) == 0 {
 		// use default log configuration file
-		logConfigFileName = "logitcfg.json"
+		l.configFileName = "logitcfg.json"
 	} else {
-		logConfigFileName = newLogConfigFileName
+		l.configFileName = newLogConfigFileName
 	}
-	delayLog(INFO, fmt.Sprintf("Open logger from config file '%s'.", logConfigFileName))
+	l.delayLog(INFO, fmt.Sprintf("Open logger from config file '%s'.", l.configFileName))
 	//
 	var tFlags logFlags_t
-	err = getConfig(logConfigFileName, &tFlags)
+	err = l.getConfig(l.configFileName, &tFlags)
 	if err != nil { // return if problemn
-		return err
+		return nil, err
 	}
-	allLogFlags = &tFlags
-	//
-	// retrieve the package/file specific flags, just like any package
-	//
-	GetMyLogInfo(&myFlags)
 	//
-	// open the log file for writing
+	// open the log file for appending, the logs of earlier runs are kept
 	//
-	var fileErr error = nil
-	logFileHandle = nil
-	logFileWriter = nil
-	if len(allLogFlags.logFileName) > 0 {
-		logFileHandle, fileErr := os.Create(allLogFlags.logFileName)
+	if len(tFlags.logFileName) > 0 {
+		file, fileErr := openLogFile(tFlags.logFileName)
 		if fileErr == nil {
-			logFileWriter = bufio.NewWriterSize(logFileHandle, 16384)
-		} else { // then send everything to stdout
-			allLogFlags.useStdOut = true
-			delayLog(WARN, fmt.Sprintf("Failed to open output log: '%s'.", allLogFlags.logFileName))
-			delayLog(WARN, "Logs will go to 'stdout'.")
+			l.file = file
+		} else { // the file sink writes to stdout
+			l.delayLog(WARN, fmt.Sprintf("Failed to open output log: '%s'.", tFlags.logFileName))
 		}
 	}
+	if len(tFlags.auditKey) > 0 {
+		if l.file != nil {
+			var msg string
+			l.file.audit, msg = newAudit(tFlags.auditKey, tFlags.logFileName)
+			l.delayLog(INFO, msg)
+		} else {
+			l.delayLog(WARN, "The audit chain needs a log file, there is none.")
+		}
+	}
+	//
+	// open the sinks, this starts the shipping to the log server and syslog
+	//
+	l.writeMutex.Lock()
+	l.flags.Store(&tFlags)
+	l.baseFlags = &tFlags
+	l.sinks = l.openSinks(&tFlags)
+	l.writeMutex.Unlock()
+	l.ring.resize(tFlags.ringSize)
+	//
+	// retrieve the package/file specific flags, just like any package
+	//
+	dxMutex.Lock()
+	l.getLogDXFlags(&l.myFlags)
+	dxMutex.Unlock()
+	//
+	// everything goes through the writer from here on
+	//
+	l.startWriter(tFlags.queueSize, tFlags.overflow)
 	//
 	// !!!The logger can be used at this point.
 	// Flush any logs that were "delayed"
 	//
-	flushDelayLog()
-	logTheFlags(allLogFlags)
+	l.flushDelayLog()
+	l.logTheFlags(&tFlags)
+	l.logTheSinks(&tFlags)
+	l.logTheRotation(&tFlags)
 	//
-	// start the monitoring of log config changed
+	// start the monitoring of log config changes and of SIGHUP,
+	// external rotators send a SIGHUP after they moved the log file
 	//
-	monitorFunc = watchLogConfig(logConfigFileName)
-	monitorFunc(false) // get the baseline
-	return fileErr
+	l.watcher = l.watchLogConfig(l.configFileName)
+	return l, nil
+}
+
+/*
+  Close
+  Close the open log or tell the url. Only the first call closes,
+  the others wait for it.
+*/
+func (l *Logger) Close() {
+	l.closeOnce.Do(l.close)
 }
 
 /*
-  CloseLog
-  Close the open log or tell the url
+  close
+  Stop the writer and the sinks, for Close
 */
-func CloseLog() {
-	logTheLogStats()
-	if monitorFunc != nil {
-		monitorFunc(true) // stop it
-		monitorFunc = nil
+func (l *Logger) close() {
+	l.flushDuplicates() // the repeats still waiting for the end of their interval
+	l.logTheLogStats()
+	if l.watcher != nil {
+		l.stopWatch(l.watcher)
+		l.watcher = nil
 	}
-	Info(&myFlags, "Log file is being closed.")
-	if logFileWriter != nil {
-		tempFileWriter := logFileWriter
-		logFileWriter = nil // turn this off while we wait for the final flush
-		tempFileWriter.Flush()
-		logFileHandle.Close()
-		logFileHandle = nil
+	l.stopOverrideTimers()
+	l.Info(&l.myFlags, "Log file is being closed.")
+	l.stopWriter() // everything queued is written once this returns
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	if l.file != nil && l.file.audit != nil { // the last line of the file, and off the box
+		l.writeAuditHead(l.getLogFlags(), "", l.file.audit.head())
 	}
+	l.closeSinks() // the log server and syslog get their last messages
+	l.file = nil
+	l.shipper = nil
+	l.syslog = nil
 	//fmt.Printf("Logger closed\n")
 }
 
@@ -163,11 +304,13 @@ func CloseLog() {
   When the logger is fully functional, these delayed msgs will
   be posted.
 */
-func delayLog(level logLevel_t, msg string) {
+func (l *Logger) delayLog(level logLevel_t, msg string) {
 	var dlog logDelayed_t
 	dlog.logLevel = level
 	dlog.msg = msg //fmt.Sprintf("Open logger from config file '%s'\n", logConfigFileName)
-	delayedLogs = append(delayedLogs, dlog)
+	l.delayMutex.Lock()
+	l.delayedLogs = append(l.delayedLogs, dlog)
+	l.delayMutex.Unlock()
 }
 
 /*
@@ -176,89 +319,180 @@ func delayLog(level logLevel_t, msg string) {
   When the log is ready to write to, write all the "delayed" logs
   to the log file.
 */
-func flushDelayLog() {
-	for _, logItem := range delayedLogs {
+func (l *Logger) flushDelayLog() {
+	l.delayMutex.Lock()
+	logItems := l.delayedLogs
+	l.delayedLogs = nil // clear out the array
+	l.delayMutex.Unlock()
+	for _, logItem := range logItems {
 		switch logItem.logLevel {
 		case FATAL:
-			Fatal(&myFlags, logItem.msg)
+			l.Fatal(&l.myFlags, logItem.msg)
 		case ERROR:
-			Error(&myFlags, logItem.msg)
+			l.Error(&l.myFlags, logItem.msg)
 		case WARN:
-			Warn(&myFlags, logItem.msg)
+			l.Warn(&l.myFlags, logItem.msg)
 		case INFO:
-			Info(&myFlags, logItem.msg)
+			l.Info(&l.myFlags, logItem.msg)
 		case DEBUG:
-			Debug(&myFlags, logItem.msg)
+			l.Debug(&l.myFlags, logItem.msg)
 		}
 	}
-	delayedLogs = delayedLogs[:0] // clear out the array
 }
 
 /*
   reloadConfig
   This function is called when the log configueration had been modified.
   This will cause all the calling log functions to get there flags reloaded.
+  The OnReload functions are called with the new generation.
   CAVEAT: This will reload the config from the save file as the original
   call to 'OpenLog', and dump to the same log and/or URL.
 */
-func reloadConfig(newGeneration int32) {
-	logTheLogStats()
-	Infof(&myFlags, "log config file '%s' is being reloaded, gen %d.", logConfigFileName, newGeneration)
-	savedURL := allLogFlags.url
-	savedLogFileName := allLogFlags.logFileName
+func (l *Logger) reloadConfig() error {
+	l.reloadMutex.Lock()
+	newGeneration, err := l.reloadFlags()
+	l.reloadMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	l.callReloadCallbacks(newGeneration) // a callback can set or revert overrides
+	return nil
+}
+
+/*
+  reloadFlags
+  Read the config file again and switch to its flags, returns the new
+  generation. The caller holds reloadMutex.
+*/
+func (l *Logger) reloadFlags() (int32, error) {
+	oldFlags := l.getLogFlags()
+	newGeneration := oldFlags.generation + 1
+	l.logTheLogStats()
+	l.Infof(&l.myFlags, "log config file '%s' is being reloaded, gen %d.", l.configFileName, newGeneration)
 	// Now get the new stuff
 	var tFlags logFlags_t
-	err := getConfig(logConfigFileName, &tFlags)
+	err := l.getConfig(l.configFileName, &tFlags)
 	if err != nil {
 		// if problem, throw new config away and log it
-		flushDelayLog()
-		Warnf(&myFlags, "log config file '%s' could not be reloaded, gen %d.", logConfigFileName, newGeneration)
-		Warnf(&myFlags, "Continue to log with gen %d configuration.", tFlags.generation)
-		return
+		l.flushDelayLog()
+		l.Warnf(&l.myFlags, "log config file '%s' could not be reloaded, gen %d: %s", l.configFileName, newGeneration,
+			err.Error())
+		l.Warnf(&l.myFlags, "Continue to log with gen %d configuration.", oldFlags.generation)
+		return oldFlags.generation, err
 	}
 	//
 	// no error was detected so setup flags with new confiuration
-	// BUT transfer over the old url and logFileName from the old,
-	// these are not mutable
+	// BUT transfer over the old url, syslog, logFileName, formats and writer
+	// queue from the old, these are not mutable. The rotation is.
 	//
 	tFlags.generation = newGeneration // set new generation
-	tFlags.url = savedURL
-	tFlags.logFileName = savedLogFileName
-	allLogFlags = &tFlags   // this switches the world to the new config
-	getLogDXFlags(&myFlags) // this is specific to just this file
-	flushDelayLog()         // using the new flags
-	logTheFlags(allLogFlags)
+	tFlags.url = oldFlags.url
+	tFlags.urlSpoolDir = oldFlags.urlSpoolDir
+	tFlags.urlSpoolMax = oldFlags.urlSpoolMax
+	if tFlags.auditKeyFile != oldFlags.auditKeyFile {
+		l.delayLog(WARN, "A reload cannot change the audit key, the chain goes on with the old one.")
+	}
+	tFlags.auditKeyFile = oldFlags.auditKeyFile
+	tFlags.auditKey = oldFlags.auditKey
+	tFlags.syslog = oldFlags.syslog
+	tFlags.logFileName = oldFlags.logFileName
+	tFlags.stdOutFmt = oldFlags.stdOutFmt
+	tFlags.fileFmt = oldFlags.fileFmt
+	tFlags.urlFmt = oldFlags.urlFmt
+	tFlags.queueSize = oldFlags.queueSize
+	tFlags.overflow = oldFlags.overflow
+	sinksChanged := !reflect.DeepEqual(tFlags.sinks, oldFlags.sinks)
+	if !sameSinks(tFlags.sinks, oldFlags.sinks) {
+		l.delayLog(WARN, "A reload cannot add, remove or move sinks, only change their levels, formats and filters.")
+		tFlags.sinks = oldFlags.sinks
+		sinksChanged = false
+	}
+	l.baseFlags = &tFlags
+	newFlags := l.withOverrides(&tFlags)
+	l.flags.Store(newFlags) // this switches the world to the new config
+	l.ring.resize(tFlags.ringSize)
+	dxMutex.Lock()
+	l.getLogDXFlags(&l.myFlags) // this is specific to just this file
+	dxMutex.Unlock()
+	l.flushDelayLog() // using the new flags
+	l.logTheFlags(newFlags)
+	if sinksChanged {
+		l.logTheSinks(&tFlags)
+	}
+	if tFlags.rotate != oldFlags.rotate {
+		l.logTheRotation(&tFlags)
+	}
+	return newGeneration, nil
+}
+
+// dflags_t is one "debugFlags" entry of the config
+type dflags_t struct {
+	Pkg   string `json:"pkg" check:"pkg"`
+	File  string `json:"file"`
+	Level string `json:"level" check:"level"`
+}
+
+// xflags_t is one "xFlags" entry of the config
+type xflags_t struct {
+	Pkg   string      `json:"pkg" check:"pkg"`
+	File  string      `json:"file"`
+	Flags interface{} `json:"flags" check:"xflags"` // a number, names or a list of them
+}
+
+/*
+  configJason_t
+  The config file. The "check" tags tell checkConfig what a value
+  must be besides its json type.
+*/
+type configJason_t struct {
+	SiteID     string                  `json:"SiteID"`
+	SysID      string                  `json:"SystemID"`
+	FileName   string                  `json:"filename"`
+	Url        string                  `json:"url"`
+	UrlBatch   int                     `json:"urlBatchSize"`
+	UrlBuffer  int                     `json:"urlBufferSize"`
+	SpoolDir   string                  `json:"urlSpoolDir"`
+	SpoolMax   interface{}             `json:"urlSpoolMaxSize" check:"size"`
+	Audit      auditJson_t             `json:"audit"`
+	Syslog     syslogJson_t            `json:"syslog"`
+	QueueSize  int                     `json:"queueSize"`
+	Overflow   string                  `json:"overflow" check:"overflow"`
+	Format     string                  `json:"format" check:"format"`
+	StdOutFmt  string                  `json:"stdoutFormat" check:"format"`
+	FileFmt    string                  `json:"fileFormat" check:"format"`
+	UrlFmt     string                  `json:"urlFormat" check:"format"`
+	MaxSize    interface{}             `json:"maxFileSize" check:"size"`
+	Daily      bool                    `json:"rotateDaily"`
+	Keep       int                     `json:"keepFiles"`
+	Compress   bool                    `json:"compress"`
+	StdOut     bool                    `json:"stdout"`
+	Level      string                  `json:"level" check:"level"`
+	Debugflags []dflags_t              `json:"debugFlags"`
+	XFlags     []xflags_t              `json:"xFlags"`
+	Sinks      []json.RawMessage       `json:"sinks" check:"sinks"`
+	Sampling   []sampleJson_t          `json:"sampling"`
+	Duplicates string                  `json:"duplicateInterval" check:"duration"`
+	RingSize   int                     `json:"ringSize"`
+	RingLevel  string                  `json:"ringLevel" check:"level"`
+	RingDump   string                  `json:"ringDumpFile"`
+	Redact     []string                `json:"redact" check:"redact"`
+	RedactRE   []string                `json:"redactPatterns" check:"regexp"`
+	BundleRE   []string                `json:"bundleRedact" check:"regexp"`
+	Policies   map[string]policyJson_t `json:"levelPolicy" check:"levelKeys"`
 }
 
 /*
   getConfig
   Get the logger configuration out of the local file
-  The configuration is in json format
+  The configuration is in json, yaml or toml format, by the extension
+  of the file, LOGIT_LEVEL, LOGIT_FILENAME and LOGIT_DEBUG override it
   Everything in this function writes to the calling parameter "tFlags"
   so if this configuration is flawed, it can simply be discarded.
+  checkConfig goes over the file first, its problems are logged with
+  their line and column. An error in the file returns a *ConfigError_t
+  once the rest is parsed, the logger does not use such a config.
 */
-func getConfig(logConfigFileName string, tFlags *logFlags_t) error {
-	type dflags_t struct {
-		Pkg  string `json:"pkg"`
-		File string `json:"file"`
-	}
-
-	type xflags_t struct {
-		Pkg   string `json:"pkg"`
-		File  string `json:"file"`
-		Flags string `json:"flags"`
-	}
-
-	type configJason_t struct {
-		SiteID     string     `json:"SiteID"`
-		SysID      string     `json:"SystemID"`
-		FileName   string     `json:"filename"`
-		Url        string     `json:"url"`
-		StdOut     bool       `json:"stdout"`
-		Level      string     `json:"level"`
-		Debugflags []dflags_t `json:"debugFlags"`
-		XFlags     []xflags_t `json:"xFlags"`
-	}
+func (l *Logger) getConfig(logConfigFileName string, tFlags *logFlags_t) error {
 	//
 	// setup defaults if the log configuration is not present
 	//
@@ -272,46 +506,141 @@ func getConfig(logConfigFileName string, tFlags *logFlags_t) error {
 	// Try to read the configuration file
 	// TBD: if read error, write the production JSON and retry
 	//
-	raw, err_rf := ioutil.ReadFile(logConfigFileName)
+	doc, err_rf := readConfig(logConfigFileName)
 	if err_rf != nil { // problem reading config
-		delayLog(WARN, fmt.Sprintf("Error loading '%s', Error:'%s'.", logConfigFileName, err_rf.Error()))
+		l.delayLog(WARN, fmt.Sprintf("Error loading '%s', Error:'%s'.", logConfigFileName, err_rf.Error()))
 		return err_rf
 	}
 	//
 	// parse the json format into local jason structs
 	// TBD: if JSON error, write defaults to file and try again
 	//
+	problems := checkConfig(doc, l.knownPkgs())
+	l.delayProblems(logConfigFileName, problems)
 	var res configJason_t
-	err_um := json.Unmarshal(raw, &res)
+	err_um := doc.unmarshal(&res)
 	if err_um != nil {
-		delayLog(WARN, fmt.Sprintf("JSON error: '%s'.", err_um.Error()))
+		if hasConfigErrors(problems) { // the same, with where it is
+			return &ConfigError_t{FileName: logConfigFileName, Problems: problems}
+		}
+		l.delayLog(WARN, fmt.Sprintf("JSON error: '%s'.", err_um.Error()))
 		return err_um
 	}
 	//
 	// get the general configuration flags out of the structs
 	//
+	tFlags.sources = doc.sources()
 	tFlags.siteID = res.SiteID // label to tie the logs to a customer site
 	tFlags.sysID = res.SysID   // id used for url collections
-	delayLog(INFO, fmt.Sprintf("SiteID '%s', SystemID '%s'.", tFlags.siteID, tFlags.sysID))
+	l.delayLog(INFO, fmt.Sprintf("SiteID '%s', SystemID '%s'.", tFlags.siteID, tFlags.sysID))
 	tFlags.url = res.Url
+	tFlags.urlBatch = res.UrlBatch
+	tFlags.urlBuffer = res.UrlBuffer
+	tFlags.urlSpoolDir = res.SpoolDir
+	spoolMax, err_sz := parseSize(res.SpoolMax)
+	tFlags.urlSpoolMax = spoolMax
+	if err_sz != nil {
+		l.delayLog(WARN, "urlSpoolMaxSize: "+err_sz.Error())
+	}
+	tFlags.queueSize = res.QueueSize
+	overflow, err := parseOverflow(res.Overflow)
+	if err != nil {
+		l.delayLog(WARN, err.Error())
+	}
+	tFlags.overflow = overflow
+	//
+	// "format" is for all outputs, each can have its own
+	//
+	format, err := parseFormat(res.Format, TEXT)
+	if err != nil {
+		l.delayLog(WARN, err.Error())
+	}
+	for _, output := range []struct {
+		name   string
+		format *format_t
+	}{
+		{res.StdOutFmt, &tFlags.stdOutFmt},
+		{res.FileFmt, &tFlags.fileFmt},
+		{res.UrlFmt, &tFlags.urlFmt},
+	} {
+		*output.format, err = parseFormat(output.name, format)
+		if err != nil {
+			l.delayLog(WARN, err.Error())
+		}
+	}
+	tFlags.syslog, err = parseSyslog(res.Syslog.Network, res.Syslog.Address, res.Syslog.Facility,
+		res.Syslog.AppName, res.Syslog.Buffer, res.Syslog.MaxSize)
+	if err != nil {
+		l.delayLog(WARN, err.Error())
+	}
+	tFlags.useStdOut = res.StdOut     // true == output to stdout
+	tFlags.logFileName = res.FileName // file name of log file
+	//
+	// the sinks, with "sinks" these decide the file, url and syslog
+	//
+	tFlags.sinks = l.parseSinks(res.Sinks, format, tFlags)
 	if len(tFlags.url) != 0 {
-		delayLog(INFO, fmt.Sprintf("Logs going to log server at '%s'.", tFlags.url))
+		l.delayLog(INFO, fmt.Sprintf("Logs going to log server at '%s'.", tFlags.url))
 	} else {
-		delayLog(INFO, fmt.Sprintf("No log server url was specified in configuration."))
+		l.delayLog(INFO, fmt.Sprintf("No log server url was specified in configuration."))
 	}
-	tFlags.useStdOut = res.StdOut       // true == output to stdout
-	tFlags.logFileName = res.FileName   // file name of log file
-	tFlags.logLevel = WARN              // default is log FATALs, ERRORs, and WARNs
-	llstr := strings.ToUpper(res.Level) // level = trace, debug, info, warn, fatal
-	switch llstr[0] {
-	case 'W':
-		tFlags.logLevel = WARN
-	case 'I':
-		tFlags.logLevel = INFO
-	case 'D':
-		tFlags.logLevel = DEBUG
-	default:
-		tFlags.logLevel = WARN
+	if len(tFlags.syslog.address) != 0 {
+		l.delayLog(INFO, fmt.Sprintf("Logs going to syslog at '%s:%s', facility %d, app-name '%s'.",
+			tFlags.syslog.network, tFlags.syslog.address, tFlags.syslog.facility, tFlags.syslog.appName))
+	}
+	tFlags.rotate.maxSize, err = parseSize(res.MaxSize)
+	if err != nil {
+		l.delayLog(WARN, "maxFileSize: "+err.Error())
+	}
+	tFlags.auditKeyFile = res.Audit.KeyFile
+	var auditErr error // the log does not run without the seals it is set up for
+	tFlags.auditKey, auditErr = loadAuditKey(res.Audit.KeyFile)
+	if auditErr != nil {
+		l.delayLog(ERROR, "audit: "+auditErr.Error())
+	}
+	tFlags.rotate.daily = res.Daily
+	tFlags.rotate.keep = res.Keep
+	tFlags.rotate.compress = res.Compress
+	tFlags.logLevel, err = parseLevel(res.Level, WARN) // default is log FATALs, ERRORs, and WARNs
+	if err != nil {
+		l.delayLog(WARN, err.Error())
+	}
+	//
+	// the sampling rules, and how often repeats are summarized
+	//
+	tFlags.sampling = l.parseSampling(res.Sampling)
+	if len(res.Duplicates) > 0 {
+		tFlags.duplicateInterval, err = time.ParseDuration(res.Duplicates)
+		if err != nil || tFlags.duplicateInterval < 0 {
+			l.delayLog(WARN, fmt.Sprintf("bad duplicateInterval '%s', repeats are not summarized.", res.Duplicates))
+			tFlags.duplicateInterval = 0
+		}
+	}
+	//
+	// the ring of recent records, it keeps everything by default
+	//
+	tFlags.ringSize = res.RingSize
+	if tFlags.ringSize < 0 {
+		tFlags.ringSize = 0
+	}
+	tFlags.ringLevel, err = parseLevel(res.RingLevel, TRACE)
+	if err != nil {
+		l.delayLog(WARN, "ringLevel: "+err.Error())
+	}
+	tFlags.ringDumpFile = res.RingDump
+	//
+	// the secrets every message is cleaned of, and what a support
+	// bundle hides on top of that
+	//
+	var redactErrs []error
+	tFlags.redact, redactErrs = newRedactor(res.Redact, res.RedactRE)
+	for _, err := range redactErrs {
+		l.delayLog(WARN, "redact: "+err.Error())
+	}
+	tFlags.policies = l.parsePolicies(res.Policies)
+	tFlags.bundleRedact, redactErrs = newRedactor(redactionNames(), append(append([]string(nil), res.RedactRE...), res.BundleRE...))
+	for _, err := range redactErrs {
+		l.delayLog(WARN, "bundleRedact: "+err.Error())
 	}
 
 	//
@@ -320,14 +649,42 @@ func getConfig(logConfigFileName string, tFlags *logFlags_t) error {
 	//
 	tFlags.dFlags = make(map[string]bool)
 	tFlags.xFlags = make(map[string]int32)
+	tFlags.levels = make(map[string]logLevel_t)
+	tFlags.maxLevel = tFlags.logLevel
 	tFlags.debugAll = false // maybe set to true below
+	//
+	// an entry with a level sets the level of its package or file,
+	// whatever the level of everything else is
+	//
+	for _, flag := range res.Debugflags {
+		if len(flag.Pkg) == 0 || len(flag.Level) == 0 {
+			continue // skip entry
+		}
+		level, err := parseLevel(flag.Level, tFlags.logLevel)
+		if err != nil {
+			l.delayLog(WARN, err.Error())
+			continue
+		}
+		if strings.ToLower(flag.Pkg) == "all" {
+			l.delayLog(WARN, "debugFlags: use 'level' to set the level of all packages.")
+			continue
+		}
+		if len(flag.File) == 0 { //no file name specified
+			tFlags.levels[flag.Pkg] = level
+		} else { // file name is specified
+			tFlags.levels[flag.Pkg+":"+flag.File] = level
+		}
+		if level > tFlags.maxLevel {
+			tFlags.maxLevel = level
+		}
+	}
 	if tFlags.logLevel >= DEBUG {
 		//
 		// If the "DEBUG" flag is not on, don't bother to
 		// load any of the debug specifiers
 		//
 		for _, flag := range res.Debugflags {
-			if len(flag.Pkg) == 0 { //no pkg name specified
+			if len(flag.Pkg) == 0 || len(flag.Level) > 0 { //no pkg name specified, or a level
 				continue // skip entry
 			}
 			// turn on all if set
@@ -349,13 +706,18 @@ func getConfig(logConfigFileName string, tFlags *logFlags_t) error {
 			continue // skip entry
 		}
 		// there is a pkg name specified
-		value, _ := strconv.ParseInt(xflag.Flags, 0, 32)
-		ivalue := int32(value)
-		if len(xflag.File) == 0 { //no file name specified
-			tFlags.xFlags[xflag.Pkg] = int32(ivalue)
-		} else { // file name is specified
-			tFlags.xFlags[xflag.Pkg+":"+xflag.File] = int32(value)
+		target := xflag.Pkg
+		if len(xflag.File) > 0 { // file name is specified
+			target = xflag.Pkg + ":" + xflag.File
 		}
+		value, _, _ := parseXFlags(xflag.Pkg, xflag.Flags) // checkConfig told about bad ones
+		tFlags.xFlags[target] = value
+	}
+	if hasConfigErrors(problems) {
+		return &ConfigError_t{FileName: logConfigFileName, Problems: problems}
+	}
+	if auditErr != nil {
+		return fmt.Errorf("audit: %s", auditErr.Error())
 	}
 	return nil
 }
@@ -364,12 +726,15 @@ func getConfig(logConfigFileName string, tFlags *logFlags_t) error {
   logFlags
   log the flags so the verification can be done with support bundles
 */
-func logTheFlags(flags *logFlags_t) {
+func (l *Logger) logTheFlags(flags *logFlags_t) {
+	if len(flags.sources) > 0 {
+		l.Info(&l.myFlags, "Config settings:\n  "+strings.Join(describeSources(flags.sources), "\n  "))
+	}
 	// log the debug flags for support bundle verification
-	if flags.debugAll {
-		Info(&myFlags, "All debug flags are enabled.")
+	if flags.debugAll || flags.logLevel > DEBUG {
+		l.Info(&l.myFlags, "All debug flags are enabled.")
 	} else if len(flags.dFlags) == 0 {
-		Info(&myFlags, "No debug flags are enabled.")
+		l.Info(&l.myFlags, "No debug flags are enabled.")
 	} else {
 		keys := make([]string, len(flags.dFlags)) // size it properly
 		i := 0
@@ -383,7 +748,20 @@ func logTheFlags(flags *logFlags_t) {
 		for _, key := range keys {
 			dflagMsg += fmt.Sprintf("\n  flag:'%s', value:%t", key, flags.dFlags[key])
 		}
-		Info(&myFlags, dflagMsg)
+		l.Info(&l.myFlags, dflagMsg)
+	}
+	//
+	if len(flags.levels) > 0 {
+		keys := make([]string, 0, len(flags.levels))
+		for key := range flags.levels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		levelMsg := fmt.Sprintf("Log level %s, and for:", flags.logLevel)
+		for _, key := range keys {
+			levelMsg += fmt.Sprintf("\n  level:'%s', value:%s", key, flags.levels[key])
+		}
+		l.Info(&l.myFlags, levelMsg)
 	}
 	//
 	if len(flags.xFlags) > 0 {
@@ -398,8 +776,29 @@ func logTheFlags(flags *logFlags_t) {
 		xflagMsg := "Expert flags:"
 		for _, key := range keys {
 			xflagMsg += fmt.Sprintf("\n  xflag:'%s', value:'%x'", key, flags.xFlags[key])
+			if names := xFlagNames(targetPkg(key), flags.xFlags[key]); len(names) > 0 {
+				xflagMsg += ", names:" + strings.Join(names, ",")
+			}
 		}
-		Info(&myFlags, xflagMsg)
+		l.Info(&l.myFlags, xflagMsg)
+	}
+	l.logTheOverrides(flags)
+	l.logTheRedaction(flags)
+	l.logThePolicies(flags)
+	l.logTheSampling(flags)
+	if flags.ringSize > 0 {
+		l.Infof(&l.myFlags, "The last %d records down to %s are kept in the ring.", flags.ringSize, flags.ringLevel)
+	}
+}
+
+/*
+  logTheRotation
+  log the rotation settings of the log file
+*/
+func (l *Logger) logTheRotation(flags *logFlags_t) {
+	if l.file != nil {
+		l.Infof(&l.myFlags, "Log file '%s', max size %d, daily %t, keep %d, compress %t.", flags.logFileName,
+			flags.rotate.maxSize, flags.rotate.daily, flags.rotate.keep, flags.rotate.compress)
 	}
 }
 
@@ -407,10 +806,27 @@ func logTheFlags(flags *logFlags_t) {
   logTheLogStats
   Log the collected logstats to this point
 */
-func logTheLogStats() {
-	Infof(&myFlags, "Fatal=%d, Error=%d, Warn=%d, Info=%d, Debug=%d",
-		logStats.fatalCount, logStats.errorCount, logStats.warnCount, logStats.infoCount, logStats.debugCount)
-	Infof(&myFlags, "line count = %d, log size = %d", logStats.lineCount, logStats.logSize)
+func (l *Logger) logTheLogStats() {
+	stats := l.GetLogStats()
+	l.Infof(&l.myFlags, "Fatal=%d, Error=%d, Warn=%d, Info=%d, Debug=%d, Trace=%d",
+		stats.fatalCount, stats.errorCount, stats.warnCount, stats.infoCount, stats.debugCount, stats.traceCount)
+	l.Infof(&l.myFlags, "line count = %d, log size = %d, dropped = %d", stats.lineCount, stats.logSize, stats.queueDropped)
+	if stats.sampledDropped > 0 || stats.duplicatesDropped > 0 {
+		l.Infof(&l.myFlags, "sampled out = %d, repeats = %d", stats.sampledDropped, stats.duplicatesDropped)
+	}
+	if l.shipper != nil {
+		l.Infof(&l.myFlags, "log server sent = %d, dropped = %d, pending = %d",
+			stats.urlSent, stats.urlDropped, stats.urlPending)
+		if l.getLogFlags().urlSpoolDir != "" {
+			l.Infof(&l.myFlags, "log server spool depth = %d, bytes = %d, replayed = %d, replay left = %d",
+				stats.urlSpoolDepth, stats.urlSpoolBytes, stats.urlReplayed, stats.urlReplayLeft)
+		}
+	}
+	if l.syslog != nil {
+		l.Infof(&l.myFlags, "syslog sent = %d, dropped = %d, pending = %d",
+			stats.syslogSent, stats.syslogDropped, stats.syslogPending)
+	}
+	l.logTheSinkStats()
 }
 
 /*
@@ -419,11 +835,36 @@ func logTheLogStats() {
   and levels into the dFlags struct
 */
 func GetMyLogInfo(flags *DFlags_t) {
-	pc, file, _, _ := runtime.Caller(1)
+	packageName, fileName := callerNames(2)
+	l := Default()
+	dxMutex.Lock()
+	defer dxMutex.Unlock()
+	flags.pkgName = packageName
+	flags.fileName = fileName
+	l.getLogDXFlags(flags)
+}
+
+/*
+  callerNames
+  The package and file name of the caller, 'skip' levels up
+*/
+func callerNames(skip int) (string, string) {
+	pc, file, _, _ := runtime.Caller(skip)
+	return frameNames(runtime.FuncForPC(pc).Name(), file)
+}
+
+/*
+  frameNames
+  The package and file name out of the function and file of a stack frame
+*/
+func frameNames(function string, file string) (string, string) {
 	_, fullFileName := path.Split(file)
 	fileName := strings.Split(fullFileName, ".") // take off the ".go" extension
-	parts := strings.Split(runtime.FuncForPC(pc).Name(), ".")
+	parts := strings.Split(function, ".")
 	pl := len(parts)
+	if pl < 2 { // no package in the name
+		return function, fileName[0]
+	}
 
 	packageName := ""
 	if parts[pl-2][0] == '(' {
@@ -431,37 +872,53 @@ func GetMyLogInfo(flags *DFlags_t) {
 	} else {
 		packageName = strings.Join(parts[0:pl-1], ".")
 	}
-	flags.pkgName = packageName
-	flags.fileName = fileName[0]
-	getLogDXFlags(flags)
+	return packageName, fileName[0]
 }
 
 /*
 	ifOldReloadDXFlags
 	Check the generation of the flags. If older than the current
-	generation of flags, reload the d and x flags
+	generation of flags, or from another logger, reload the d and x flags.
+	The caller gets a copy, so other goroutines can reload meanwhile.
 */
-func ifOldReloadDXFlags(flags *DFlags_t) {
-	if flags.generation == allLogFlags.generation {
-		return
+func (l *Logger) ifOldReloadDXFlags(flags *DFlags_t) DFlags_t {
+	dxMutex.Lock()
+	defer dxMutex.Unlock()
+	if flags.logger != l || flags.generation != l.getLogFlags().generation {
+		l.getLogDXFlags(flags)
 	}
-	getLogDXFlags(flags)
+	return *flags
 }
 
 /*
 	getLogDXFlags
 	This uses the package and file names from the DFlags_t
-	to lookup the associated flags from the config
+	to lookup the associated flags from the config.
+	The caller holds dxMutex.
 */
-func getLogDXFlags(flags *DFlags_t) {
+func (l *Logger) getLogDXFlags(flags *DFlags_t) {
+	allLogFlags := l.getLogFlags()
+	flags.logger = l
 	flags.generation = allLogFlags.generation
-	// lookup the xflag for the package and name
-	packageName := flags.pkgName
-	fileName := flags.fileName
+	flags.level, flags.dFlag, flags.xFlag = resolveDXFlags(allLogFlags, flags.pkgName, flags.fileName)
+	if len(flags.pkgName) > 0 { // for the control API
+		if l.callers == nil {
+			l.callers = make(map[string]bool)
+		}
+		l.callers[flags.pkgName+":"+flags.fileName] = true
+	}
+}
+
+/*
+	resolveDXFlags
+	The level, debug flag and xflags of a package and file in the flags:
+	the config file first, then the overrides of the control API.
+*/
+func resolveDXFlags(allLogFlags *logFlags_t, packageName string, fileName string) (logLevel_t, bool, int32) {
 	dp := allLogFlags.dFlags[packageName]
 	df := allLogFlags.dFlags[packageName+":"+fileName]
-	if allLogFlags.debugAll {
-		df = true
+	if allLogFlags.debugAll || allLogFlags.logLevel > DEBUG {
+		df = true // TRACE logs everything, DEBUG included
 	} else {
 		if allLogFlags.logLevel >= DEBUG {
 			if df && dp { // exclusive or, turn it off if both are on
@@ -471,67 +928,167 @@ func getLogDXFlags(flags *DFlags_t) {
 			}
 		}
 	}
+	//
+	// a level set for the file wins over one for the package,
+	// both win over the level of everything else
+	//
+	level := allLogFlags.logLevel
+	lp, hasP := allLogFlags.levels[packageName]
+	lf, hasF := allLogFlags.levels[packageName+":"+fileName]
+	if hasF {
+		level = lf
+	} else if hasP {
+		level = lp
+	}
+	if hasF || hasP {
+		df = level >= DEBUG
+	}
 	xp := allLogFlags.xFlags[packageName]
 	xf := allLogFlags.xFlags[packageName+":"+fileName]
 	xf = xp | xf // "or" the bitwise flags together
 	//
-	flags.dFlag = df
-	flags.xFlag = xf
+	// the overrides, a file wins over its package, both over all
+	//
+	for _, key := range []string{ALL_OVERRIDE, packageName, packageName + ":" + fileName} {
+		o, found := allLogFlags.overrides[key]
+		if !found {
+			continue
+		}
+		if o.hasLevel {
+			level = o.level
+			df = level >= DEBUG
+		}
+		if o.hasDFlag {
+			df = o.dFlag
+		}
+		if o.hasXFlag {
+			xf = o.xFlag
+		}
+	}
+	return level, df, xf
 }
 
 /*
   GetLogStats
   return the stats of the current log file
 */
-func GetLogStats() logStats_t {
-	return logStats
+func (l *Logger) GetLogStats() logStats_t {
+	var stats logStats_t
+	stats.fatalCount = atomic.LoadInt32(&l.stats.fatalCount)
+	stats.errorCount = atomic.LoadInt32(&l.stats.errorCount)
+	stats.warnCount = atomic.LoadInt32(&l.stats.warnCount)
+	stats.infoCount = atomic.LoadInt32(&l.stats.infoCount)
+	stats.debugCount = atomic.LoadInt32(&l.stats.debugCount)
+	stats.traceCount = atomic.LoadInt32(&l.stats.traceCount)
+	stats.lineCount = atomic.LoadInt32(&l.stats.lineCount)
+	stats.logSize = atomic.LoadInt64(&l.stats.logSize)
+	stats.queueDropped = atomic.LoadInt64(&l.stats.queueDropped)
+	stats.sampledDropped = atomic.LoadInt64(&l.stats.sampledDropped)
+	stats.duplicatesDropped = atomic.LoadInt64(&l.stats.duplicatesDropped)
+	if shipper := l.shipper; shipper != nil {
+		stats.urlSent = atomic.LoadInt64(&shipper.sentCount)
+		stats.urlDropped = atomic.LoadInt64(&shipper.droppedCount)
+		stats.urlPending = shipper.pending()
+		stats.urlSpoolDepth, stats.urlSpoolBytes, stats.urlReplayed, stats.urlReplayLeft = shipper.spoolStats()
+	}
+	if syslog := l.syslog; syslog != nil {
+		stats.syslogSent = atomic.LoadInt64(&syslog.sentCount)
+		stats.syslogDropped = atomic.LoadInt64(&syslog.droppedCount)
+		stats.syslogPending = syslog.pending()
+	}
+	return stats
+}
+
+/*
+  levelEnabled
+  Check if a message of this level, or this xflag, is logged for the
+  caller. Fatal messages are always logged, debug messages follow the
+  debug flags, the others the level of the package and file.
+*/
+func levelEnabled(level logLevel_t, xflag int32, f *DFlags_t) bool {
+	switch {
+	case level == FATAL:
+		return true
+	case level == DEBUG && xflag != 0:
+		return (f.xFlag & xflag) != 0
+	case level == DEBUG:
+		return f.dFlag
+	}
+	return f.level >= level
+}
+
+/*
+  levelOn
+  Check if the message has to be built: it is logged for the caller,
+  or the ring keeps it
+*/
+func (l *Logger) levelOn(level logLevel_t, xflag int32, f *DFlags_t) bool {
+	return levelEnabled(level, xflag, f) || l.ringWants(level)
+}
+
+/*
+  countLevel
+  Count a message that is queued, after sampling and dedupe kept it,
+  what they drop is counted by them. -1 for one the queue threw away.
+*/
+func (l *Logger) countLevel(level logLevel_t, delta int32) {
+	switch level {
+	case FATAL:
+		atomic.AddInt32(&l.stats.fatalCount, delta)
+	case ERROR:
+		atomic.AddInt32(&l.stats.errorCount, delta)
+	case WARN:
+		atomic.AddInt32(&l.stats.warnCount, delta)
+	case INFO:
+		atomic.AddInt32(&l.stats.infoCount, delta)
+	case DEBUG:
+		atomic.AddInt32(&l.stats.debugCount, delta)
+	case TRACE:
+		atomic.AddInt32(&l.stats.traceCount, delta)
+	}
 }
 
 /*
   Fatal
   log the fatal messages, which are always enabled
 */
-func Fatal(flags *DFlags_t, str string) {
-	logStats.fatalCount += 1
-	ifOldReloadDXFlags(flags)
-	logMsg("FATAL[" + flags.pkgName + ":" + flags.fileName + "] " + str)
+func (l *Logger) Fatal(flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(FATAL, 0, &f) {
+		l.logMsg(FATAL, 0, &f, nil, str)
+	}
 }
 
 /*
   Fatalf
   Build the message and log the fatal messages
 */
-func Fatalf(flags *DFlags_t, str string, args ...interface{}) {
-	logStats.fatalCount += 1
-	ifOldReloadDXFlags(flags)
-	message := fmt.Sprintf(str, args...)
-	logMsg("FATAL[" + flags.pkgName + ":" + flags.fileName + "] " + message)
-
+func (l *Logger) Fatalf(flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(FATAL, 0, &f) {
+		l.logMsg(FATAL, 0, &f, nil, fmt.Sprintf(str, args...))
+	}
 }
 
 /*
   Error
   log the error messages if enabled
 */
-func Error(flags *DFlags_t, str string) {
-	ifOldReloadDXFlags(flags)
-	if allLogFlags.logLevel >= ERROR {
-		logStats.errorCount += 1
-		logMsg("ERR[" + flags.pkgName + ":" + flags.fileName + "] " + str)
+func (l *Logger) Error(flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(ERROR, 0, &f) {
+		l.logMsg(ERROR, 0, &f, nil, str)
 	}
 }
 
 /*
-  Warnf
+  Errorf
   Build the message and log the error messages if enabled
 */
-func Errorf(flags *DFlags_t, str string, args ...interface{}) {
-	ifOldReloadDXFlags(flags)
-	if allLogFlags.logLevel >= ERROR {
-		logStats.errorCount += 1
-		message := "ERR[" + flags.pkgName + ":" + flags.fileName + "] "
-		message += fmt.Sprintf(str, args...)
-		logMsg(message)
+func (l *Logger) Errorf(flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(ERROR, 0, &f) {
+		l.logMsg(ERROR, 0, &f, nil, fmt.Sprintf(str, args...))
 	}
 }
 
@@ -539,11 +1096,10 @@ func Errorf(flags *DFlags_t, str string, args ...interface{}) {
   Warn
   log the warning messages if enabled
 */
-func Warn(flags *DFlags_t, str string) {
-	ifOldReloadDXFlags(flags)
-	if allLogFlags.logLevel >= WARN {
-		logStats.warnCount += 1
-		logMsg("WARN[" + flags.pkgName + ":" + flags.fileName + "] " + str)
+func (l *Logger) Warn(flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(WARN, 0, &f) {
+		l.logMsg(WARN, 0, &f, nil, str)
 	}
 }
 
@@ -551,13 +1107,10 @@ func Warn(flags *DFlags_t, str string) {
   Warnf
   Build the message and log the warning messages if enabled
 */
-func Warnf(flags *DFlags_t, str string, args ...interface{}) {
-	ifOldReloadDXFlags(flags)
-	if allLogFlags.logLevel >= WARN {
-		logStats.warnCount += 1
-		message := "WARN[" + flags.pkgName + ":" + flags.fileName + "] "
-		message += fmt.Sprintf(str, args...)
-		logMsg(message)
+func (l *Logger) Warnf(flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(WARN, 0, &f) {
+		l.logMsg(WARN, 0, &f, nil, fmt.Sprintf(str, args...))
 	}
 }
 
@@ -565,11 +1118,10 @@ func Warnf(flags *DFlags_t, str string, args ...interface{}) {
   Info
   log the info messages if enabled
 */
-func Info(flags *DFlags_t, str string) {
-	ifOldReloadDXFlags(flags)
-	if allLogFlags.logLevel >= INFO {
-		logStats.infoCount += 1
-		logMsg("INFO[" + flags.pkgName + ":" + flags.fileName + "] " + str)
+func (l *Logger) Info(flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(INFO, 0, &f) {
+		l.logMsg(INFO, 0, &f, nil, str)
 	}
 }
 
@@ -577,13 +1129,10 @@ func Info(flags *DFlags_t, str string) {
   Infof
   Build the message and log the info messages if enabled
 */
-func Infof(flags *DFlags_t, str string, args ...interface{}) {
-	ifOldReloadDXFlags(flags)
-	if allLogFlags.logLevel >= INFO {
-		logStats.infoCount += 1
-		message := "INFO[" + flags.pkgName + ":" + flags.fileName + "] "
-		message += fmt.Sprintf(str, args...)
-		logMsg(message)
+func (l *Logger) Infof(flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(INFO, 0, &f) {
+		l.logMsg(INFO, 0, &f, nil, fmt.Sprintf(str, args...))
 	}
 }
 
@@ -592,11 +1141,10 @@ func Infof(flags *DFlags_t, str string, args ...interface{}) {
   log the debug message if enabled
   This allows individual packages or files to log debug statements.
 */
-func Debug(flags *DFlags_t, str string) {
-	ifOldReloadDXFlags(flags)
-	if flags.dFlag {
-		logStats.debugCount += 1
-		logMsg("DBUG[" + flags.pkgName + ":" + flags.fileName + "] " + str)
+func (l *Logger) Debug(flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(DEBUG, 0, &f) {
+		l.logMsg(DEBUG, 0, &f, nil, str)
 	}
 }
 
@@ -606,11 +1154,10 @@ func Debug(flags *DFlags_t, str string) {
   This allows individual packages or files to log debug statements depending
   on very specific criteria established by the developer.
 */
-func Debugx(xflag int32, flags *DFlags_t, str string) {
-	ifOldReloadDXFlags(flags)
-	if (flags.xFlag & xflag) != 0 {
-		logStats.debugCount += 1
-		logMsg("DBGX[" + flags.pkgName + ":" + flags.fileName + "] " + str)
+func (l *Logger) Debugx(xflag int32, flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(DEBUG, xflag, &f) {
+		l.logMsg(DEBUG, xflag, &f, nil, str)
 	}
 }
 
@@ -619,13 +1166,10 @@ func Debugx(xflag int32, flags *DFlags_t, str string) {
   Build the message and log the debug message if enabled
   This allows individual packages or files to log debug statements.
 */
-func Debugf(flags *DFlags_t, str string, args ...interface{}) {
-	ifOldReloadDXFlags(flags)
-	if flags.dFlag {
-		logStats.debugCount += 1
-		message := "DBUG[" + flags.pkgName + ":" + flags.fileName + "] "
-		message += fmt.Sprintf(str, args...)
-		logMsg(message)
+func (l *Logger) Debugf(flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(DEBUG, 0, &f) {
+		l.logMsg(DEBUG, 0, &f, nil, fmt.Sprintf(str, args...))
 	}
 }
 
@@ -635,139 +1179,108 @@ func Debugf(flags *DFlags_t, str string, args ...interface{}) {
   This allows individual packages or files to log debug statements depending
   on very specific criteria established by the developer.
 */
-func Debugfx(xflag int32, flags *DFlags_t, str string, args ...interface{}) {
-	ifOldReloadDXFlags(flags)
-	if (flags.xFlag & xflag) != 0 {
-		logStats.debugCount += 1
-		message := "DBGX[" + flags.pkgName + ":" + flags.fileName + "] "
-		message += fmt.Sprintf(str, args...)
-		logMsg(message)
+func (l *Logger) Debugfx(xflag int32, flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(DEBUG, xflag, &f) {
+		l.logMsg(DEBUG, xflag, &f, nil, fmt.Sprintf(str, args...))
+	}
+}
+
+/*
+  Trace
+  log the trace message if the level of the package or file is TRACE
+*/
+func (l *Logger) Trace(flags *DFlags_t, str string) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(TRACE, 0, &f) {
+		l.logMsg(TRACE, 0, &f, nil, str)
+	}
+}
+
+/*
+  Tracef
+  Build and log the trace message if the level of the package or file is TRACE
+*/
+func (l *Logger) Tracef(flags *DFlags_t, str string, args ...interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if l.levelOn(TRACE, 0, &f) {
+		l.logMsg(TRACE, 0, &f, nil, fmt.Sprintf(str, args...))
 	}
 }
 
 /*
   logMsg
-  The log message is almost built except for the time fields
-  Send to the log file, and/or stdout, and/or the log server.
-*/
-func logMsg(msg string) {
-	t := time.Now()
-	msgt := t.Format(time.RFC3339) + " " + msg
-	//  update stats
-	logStats.lineCount += 1
-	logStats.logSize += int64(len(msgt))
-	// lock it down
-	mutex := &sync.Mutex{} // Protect the channels at an EOL boundry
-	mutex.Lock()
-	defer mutex.Unlock()
-	// write to standard out
-	if allLogFlags.useStdOut { // write to stdout
-		println(msgt)
-	}
-	// write to local file
-	if logFileWriter != nil {
-		logFileWriter.WriteString(msgt + "\n")
-	}
-	// wrtie to logserver
-	if len(allLogFlags.url) > 0 {
-		msgi := "{" + allLogFlags.sysID + "} " + msgt + "\n"
-		logFileWriter.WriteString(msgi)
-	}
-	//
-	// check if the log configuration file has changed
-	// If so reload and set the next generation
-	//
-	if monitorFunc != nil {
-		changed, newGeneration := monitorFunc(false)
-		if changed {
-			reloadConfig(newGeneration)
+  Build the log record with the time, the config labels and the fields.
+  The ring keeps it, and if it is enabled for the caller it goes through
+  the sampling to the writer, the sinks each format it the way it was
+  configured. A FATAL dumps the ring.
+*/
+func (l *Logger) logMsg(level logLevel_t, xflag int32, f *DFlags_t, fields []Field_t, msg string) {
+	l.logRecord(level, xflag, f, fields, msg, "")
+}
+
+/*
+  logRecord
+  Build the record of a message and hand it on, with the stack trace
+  the policy of its level wants, or this one. The policy is applied
+  once it is logged.
+*/
+func (l *Logger) logRecord(level logLevel_t, xflag int32, f *DFlags_t, fields []Field_t, msg string, stack string) {
+	flags := l.getLogFlags()
+	enabled := levelEnabled(level, xflag, f)
+	policy, hasPolicy := flags.policies[level]
+	if enabled && hasPolicy && policy.stack != NO_STACK && len(stack) == 0 {
+		stack = captureStack(policy.stack)
+	}
+	rec := &Record_t{
+		Time:       time.Now(),
+		Level:      level,
+		XFlag:      xflag,
+		Pkg:        f.pkgName,
+		File:       f.fileName,
+		SiteID:     flags.siteID,
+		SystemID:   flags.sysID,
+		Generation: flags.generation,
+		Fields:     renderFields(fields),
+		Msg:        msg,
+		Stack:      stack,
+		flags:      flags,
+		counted:    true,
+	}
+	if flags.redact != nil { // before the ring and the sinks see it
+		flags.redact.redactRecord(rec)
+	}
+	if flags.ringSize > 0 && level <= flags.ringLevel {
+		l.ring.add(rec)
+	}
+	if !enabled { // only for the ring
+		return
+	}
+	final := level == FATAL || (hasPolicy && policy.action != CONTINUE) // never sampled or summarized
+	if !final && (!l.deduplicated(rec) || !l.sampled(rec)) {
+		return
+	}
+	l.sendRecord(rec)
+	if level == FATAL && flags.ringSize > 0 {
+		if name, err := l.DumpRing("FATAL: " + rec.Msg); err != nil {
+			l.Errorf(&l.myFlags, "Ring could not be dumped to '%s': %s", name, err)
 		}
 	}
+	if hasPolicy {
+		l.applyPolicy(policy, rec)
+	}
 }
 
 /*
-	watchLogConfig
-	monitor the log configuration file for changes
+  sendRecord
+  Queue the record for the writer and count it, and its level, if the
+  queue took it
 */
-func watchLogConfig(logConfigFileName string) func(bool) (bool, int32) {
-	//GetMyLogInfo(&lwFlags)
-	// get the baseline of this file
-	baseLine, err := os.Stat(logConfigFileName)
-	if err != nil {
-		Warnf(&myFlags, "log config file '%s' cannot be monitored, error: %s", logConfigFileName, err)
-		return nil
-	}
-	baseTime := baseLine.ModTime()
-	Infof(&myFlags, "log config file '%s' is monitored", logConfigFileName)
-	//
-	ticker := time.NewTicker(time.Second * 5)
-	stopChan := make(chan bool)              // unbuffered going into go function
-	doneOrChangeChan := make(chan string, 2) // buffered coming from go function
-	changed := false
-	var generation int32
-	monitorFunc := func() {
-	DONE:
-		for {
-			select {
-			case <-ticker.C:
-				Debugx(SHOWMONITOR, &myFlags, "log reconfiguration check.")
-				newStat, err := os.Stat(logConfigFileName)
-				if err != nil {
-					continue
-				}
-				modTime := newStat.ModTime()
-				if baseTime != modTime {
-					Debugfx(SHOWMONITOR, &myFlags, "log config file '%s' was modified.", logConfigFileName)
-					baseTime = modTime
-					changed = true
-					//doneOrChangeChan <- "change"
-				}
-			case <-stopChan:
-				Debugx(SHOWMONITOR, &myFlags, "log reconfiguration check terminated.")
-				ticker.Stop() // remove ticker channel
-				break DONE    // very obscure way to exit nested select statement
-			}
-		}
-		Debugx(SHOWMONITOR, &myFlags, "Exiting log reconfiguration monitor.")
-		doneOrChangeChan <- "done" // signal main loop to continue
-	}
-
-	monitorConfig := func(stopFlag bool) (bool, int32) {
-		if stopFlag {
-			stopChan <- true // tell the goroutine to shutdown
-		DONE:
-			for {
-				select { // a blocking select statement
-				case msg := <-doneOrChangeChan:
-					if msg == "done" {
-						Infof(&myFlags, "log config file '%s' is no longer monitored.", logConfigFileName)
-						break DONE // exit for loop
-					}
-				}
-			}
-		} else {
-			// check for change
-			/*
-				select { // a non-blocking select statement
-				case msg := <-doneOrChangeChan:
-					if msg == "change" {
-						Infof(&lwFlags, "log config file '%s' was modified", logConfigFileName)
-						return true // tell caller of change
-					} else {
-						Warnf(&lwFlags, "unexpected msg '%s' from log config monitor", msg)
-					}
-				default:
-				}
-			*/
-			if changed {
-				changed = false // reset the flag for next time
-				generation += 1
-				Infof(&myFlags, "log config file '%s' was modified, gen %d.", logConfigFileName, generation)
-				return true, generation // file changed flag, and new generation
-			}
+func (l *Logger) sendRecord(rec *Record_t) {
+	if l.queueMsg(rec) { //  update stats
+		atomic.AddInt32(&l.stats.lineCount, 1)
+		if rec.counted {
+			l.countLevel(rec.Level, 1)
 		}
-		return false, generation // file did not change and same old generation
 	}
-	go monitorFunc() // start the background loop
-	return monitorConfig
 }
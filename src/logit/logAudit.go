@@ -0,0 +1,519 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minAuditKeySize = 16 // bytes a key needs at least
+
+// the seal of a line of the log file, in the text and json formats
+const (
+	auditTextSeq = " seq="
+	auditTextMac = " mac="
+	auditJSONSeq = `,"seq":`
+	auditJSONMac = `,"mac":"`
+)
+
+// auditJson_t is the "audit" of the config
+type auditJson_t struct {
+	KeyFile string `json:"keyFile" check:"auditKey"`
+}
+
+/*
+  audit_t
+  The chain of the log file. Every line gets the next seq and the
+  HMAC-SHA256 of the mac of the line before it and the line with its
+  seq, so a line that is changed, removed or added breaks the chain.
+  The chain goes on over rotations and restarts. It is protected by
+  the writeMutex, like the file.
+*/
+type audit_t struct {
+	key []byte
+	seq int64  // seq of the last line, 0 before the first
+	mac []byte // mac of the last line, nil before the first
+}
+
+/*
+  loadAuditKey
+  Read the secret key of the chain, the file has the key and nothing
+  else, a newline at its end is not part of it
+*/
+func loadAuditKey(keyFile string) ([]byte, error) {
+	if len(keyFile) == 0 {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) < minAuditKeySize {
+		return nil, fmt.Errorf("the audit key in '%s' has %d bytes, it needs at least %d", keyFile, len(key), minAuditKeySize)
+	}
+	return key, nil
+}
+
+/*
+  LoadAuditKey
+  The key of the audit chain, out of its key file
+*/
+func LoadAuditKey(keyFile string) ([]byte, error) {
+	if len(keyFile) == 0 {
+		return nil, fmt.Errorf("no audit key file")
+	}
+	return loadAuditKey(keyFile)
+}
+
+/*
+  newAudit
+  The chain of a log file, it goes on from the last line of the file,
+  or of the newest rotated file if the file is empty. The message says
+  where the chain goes on from, or why a new chain starts.
+*/
+func newAudit(key []byte, logFileName string) (*audit_t, string) {
+	a := &audit_t{key: key}
+	files := AuditFiles(logFileName)
+	for i := len(files) - 1; i >= 0; i-- {
+		seq, mac, lines, err := lastSeal(files[i])
+		if err != nil || lines == 0 {
+			continue // an empty file, the one before it has the chain
+		}
+		if seq == 0 {
+			return a, fmt.Sprintf("Audit chain starts at seq 1, the last line of '%s' has no seal.", files[i])
+		}
+		a.seq, a.mac = seq, mac
+		return a, fmt.Sprintf("Audit chain goes on from seq %d of '%s'.", seq, files[i])
+	}
+	return a, "Audit chain starts at seq 1."
+}
+
+/*
+  writeAuditHead
+  Log the head of the chain to every sink, so the log server and
+  syslog keep it off the box. The writeMutex is held.
+*/
+func (l *Logger) writeAuditHead(flags *logFlags_t, where string, head string) {
+	rec := &Record_t{
+		Time:       time.Now(),
+		Level:      INFO,
+		Pkg:        l.myFlags.pkgName,
+		File:       l.myFlags.fileName,
+		SiteID:     flags.siteID,
+		SystemID:   flags.sysID,
+		Generation: flags.generation,
+		Msg:        fmt.Sprintf("Audit chain head%s: %s.", where, head),
+		flags:      flags,
+	}
+	l.writeSinks(flags, rec)
+}
+
+/*
+  sealLine
+  The mac of a line, chained to the mac of the line before it
+*/
+func sealLine(key []byte, prev []byte, body string) []byte {
+	h := hmac.New(sha256.New, key)
+	if prev == nil {
+		prev = make([]byte, sha256.Size) // the start of a chain
+	}
+	h.Write(prev)
+	h.Write([]byte(body))
+	return h.Sum(nil)
+}
+
+/*
+  seal
+  The line with its seq and mac. A json line gets them as its last
+  fields, a text line at its end.
+*/
+func (a *audit_t) seal(line string) string {
+	a.seq++
+	isJSON := strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}")
+	var body string
+	if isJSON {
+		body = line[:len(line)-1] + auditJSONSeq + strconv.FormatInt(a.seq, 10)
+	} else {
+		body = line + auditTextSeq + strconv.FormatInt(a.seq, 10)
+	}
+	a.mac = sealLine(a.key, a.mac, body)
+	if isJSON {
+		return body + auditJSONMac + hex.EncodeToString(a.mac) + `"}`
+	}
+	return body + auditTextMac + hex.EncodeToString(a.mac)
+}
+
+/*
+  head
+  The seq and mac of the last line, for the log
+*/
+func (a *audit_t) head() string {
+	return fmt.Sprintf("seq %d, mac %s", a.seq, hex.EncodeToString(a.mac))
+}
+
+/*
+  unseal
+  Take a sealed line apart: the part the mac is of, the seq and the
+  mac. 'ok' is false for a line without a seal.
+*/
+func unseal(line string) (body string, seq int64, mac []byte, ok bool) {
+	seqMark, macMark, end := auditTextSeq, auditTextMac, ""
+	if strings.HasPrefix(line, "{") && strings.HasSuffix(line, `"}`) {
+		seqMark, macMark, end = auditJSONSeq, auditJSONMac, `"}`
+	}
+	i := strings.LastIndex(line, macMark)
+	if i < 0 {
+		return "", 0, nil, false
+	}
+	mac, err := hex.DecodeString(strings.TrimSuffix(line[i+len(macMark):], end))
+	if err != nil || len(mac) != sha256.Size {
+		return "", 0, nil, false
+	}
+	body = line[:i]
+	j := strings.LastIndex(body, seqMark)
+	if j < 0 {
+		return "", 0, nil, false
+	}
+	seq, err = strconv.ParseInt(body[j+len(seqMark):], 10, 64)
+	if err != nil || seq <= 0 {
+		return "", 0, nil, false
+	}
+	return body, seq, mac, true
+}
+
+/*
+  AuditFiles
+  The rotated files of a log file, oldest first, and the file itself
+*/
+func AuditFiles(logFileName string) []string {
+	files := rotatedFiles(logFileName)
+	if _, err := os.Stat(logFileName); err == nil {
+		files = append(files, logFileName)
+	}
+	return files
+}
+
+/*
+  openAuditFile
+  Read a log file, a rotated file that was compressed is unpacked
+*/
+func openAuditFile(fileName string) (io.ReadCloser, error) {
+	fh, err := os.Open(fileName)
+	if err != nil || !strings.HasSuffix(fileName, ".gz") {
+		return fh, err
+	}
+	zr, err := gzip.NewReader(fh)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, fh}, nil
+}
+
+/*
+  lastSeal
+  The seq and mac of the last line of a file, 0 if it has no seal,
+  and if the file has any lines. A line that was cut short when the
+  process died is not a line.
+*/
+func lastSeal(fileName string) (int64, []byte, int, error) {
+	in, err := openAuditFile(fileName)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+	var last string
+	lines := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		last = line
+		lines++
+	}
+	_, seq, mac, ok := unseal(strings.TrimSuffix(last, "\n"))
+	if !ok {
+		return 0, nil, lines, nil
+	}
+	return seq, mac, lines, nil
+}
+
+/*
+  AuditProblem_t
+  Where the chain of the log files is broken, and how
+*/
+type AuditProblem_t struct {
+	File string // the log file
+	Line int    // the line of the file
+	Seq  int64  // the seq of the line, 0 for none
+	Msg  string
+}
+
+func (p AuditProblem_t) String() string {
+	if p.Line == 0 { // of the files, not of a line
+		return fmt.Sprintf("%s: %s", p.File, p.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+}
+
+/*
+  AuditResult_t
+  What VerifyAudit found: the lines it checked, the seq and mac of the
+  last one, the chains that started over and the problems
+*/
+type AuditResult_t struct {
+	Files    int
+	Lines    int64
+	FirstSeq int64
+	LastSeq  int64
+	HeadMac  string
+	Restarts []AuditProblem_t // a logger that could not go on with the chain started a new one
+	Problems []AuditProblem_t
+}
+
+/*
+  auditVerifier_t
+  The state of VerifyAudit while it goes over the lines
+*/
+type auditVerifier_t struct {
+	key     []byte
+	res     *AuditResult_t
+	seq     int64  // seq of the last good line, 0 for none
+	mac     []byte // mac of the last good line
+	suspect *auditRecord_t
+	head    *AuditHead_t // a head that was logged, nil for none
+	hasHead bool         // the head is in the files
+}
+
+/*
+  AuditHead_t
+  The seq and mac of a line, as Close and a rotation log them
+*/
+type AuditHead_t struct {
+	Seq int64
+	Mac string
+}
+
+// auditRecord_t is one sealed line, with the lines before it that had no seal
+type auditRecord_t struct {
+	file     string
+	line     int
+	body     string
+	seq      int64
+	mac      []byte
+	unsealed int // the first of the lines without a seal before it, 0 for none
+}
+
+/*
+  VerifyAudit
+  Check the chain of the files, oldest first, as AuditFiles has them.
+  A line that was changed, lines that were removed or added, and
+  lines without a seal are problems. The first file can start after
+  seq 1, its older files were pruned. Lines cut off the end of the
+  last file are only found with a head that was logged elsewhere,
+  e.g. by CloseLog to the log server, the files must have it.
+*/
+func VerifyAudit(key []byte, fileNames []string, head *AuditHead_t) (*AuditResult_t, error) {
+	v := &auditVerifier_t{key: key, res: &AuditResult_t{Files: len(fileNames)}, head: head}
+	for _, fileName := range fileNames {
+		if err := v.verifyFile(fileName); err != nil {
+			return v.res, err
+		}
+	}
+	v.settle()
+	v.res.LastSeq = v.seq
+	v.res.HeadMac = hex.EncodeToString(v.mac)
+	if head != nil && !v.hasHead {
+		last := &auditRecord_t{file: fileNames[len(fileNames)-1]}
+		if head.Seq > v.seq {
+			v.problem(last, "the head is seq %d, the log ends at seq %d: lines were removed from its end", head.Seq, v.seq)
+		} else {
+			v.problem(last, "the head, seq %d with mac %s, is not in the log", head.Seq, head.Mac)
+		}
+	}
+	return v.res, nil
+}
+
+/*
+  verifyFile
+  Go over the lines of one file. A line of a message that has more
+  than one line has no seal, the seal is on its last line.
+*/
+func (v *auditVerifier_t) verifyFile(fileName string) error {
+	in, err := openAuditFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+	var pending []string
+	pendingLine := 0
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(pending) == 0 {
+			pendingLine = lineNo
+		}
+		pending = append(pending, line)
+		body, seq, mac, ok := unseal(strings.Join(pending, "\n"))
+		if !ok {
+			continue
+		}
+		rec := &auditRecord_t{file: fileName, line: lineNo, body: body, seq: seq, mac: mac}
+		if len(pending) > 1 && !v.sealed(rec) {
+			// the lines before it may not be part of it
+			if lastBody, _, _, _ := unseal(line); v.sealed(&auditRecord_t{body: lastBody, seq: seq, mac: mac}) {
+				rec.body = lastBody
+				rec.unsealed = pendingLine
+			}
+		}
+		v.check(rec)
+		pending = nil
+	}
+	if len(pending) > 0 {
+		v.problem(&auditRecord_t{file: fileName, line: pendingLine}, "%d lines at the end have no seal", len(pending))
+	}
+	return nil
+}
+
+/*
+  sealed
+  Check the mac of a line against the chain, or against the start of
+  a chain for seq 1
+*/
+func (v *auditVerifier_t) sealed(rec *auditRecord_t) bool {
+	prev := v.mac
+	if rec.seq == 1 {
+		prev = nil
+	}
+	return hmac.Equal(sealLine(v.key, prev, rec.body), rec.mac)
+}
+
+/*
+  check
+  Check one sealed line against the chain. A line with a bad mac is
+  only called changed once the line after it is seen: if that one has
+  the same seq and is good, the bad line was added.
+*/
+func (v *auditVerifier_t) check(rec *auditRecord_t) {
+	v.res.Lines++
+	if rec.unsealed > 0 {
+		v.problem(&auditRecord_t{file: rec.file, line: rec.unsealed, seq: rec.seq},
+			"the lines before seq %d have no seal, they were added or cut short", rec.seq)
+	}
+	if v.suspect != nil {
+		suspect := v.suspect
+		v.suspect = nil
+		if rec.seq == suspect.seq && v.sealed(rec) {
+			v.problem(suspect, "seq %d was added, the line after it has the same seq", suspect.seq)
+			v.accept(rec)
+			return
+		}
+		v.problem(suspect, "seq %d was changed", suspect.seq)
+		v.accept(suspect)
+	}
+	switch {
+	case v.res.FirstSeq == 0: // the first line, the files before it may be gone
+		v.res.FirstSeq = rec.seq
+		if rec.seq == 1 && !v.sealed(rec) {
+			v.suspect = rec
+			return
+		}
+		v.accept(rec)
+	case rec.seq == 1 && v.sealed(rec):
+		v.res.Restarts = append(v.res.Restarts, AuditProblem_t{File: rec.file, Line: rec.line, Seq: 1,
+			Msg: fmt.Sprintf("a new chain starts after seq %d", v.seq)})
+		v.accept(rec)
+	case rec.seq == v.seq+1:
+		if !v.sealed(rec) {
+			v.suspect = rec
+			return
+		}
+		v.accept(rec)
+	case rec.seq > v.seq+1:
+		if rec.seq == v.seq+2 {
+			v.problem(rec, "seq %d was removed", v.seq+1)
+		} else {
+			v.problem(rec, "seq %d to %d were removed", v.seq+1, rec.seq-1)
+		}
+		v.accept(rec) // its mac is of lines that are gone, go on from it
+	default:
+		v.problem(rec, "seq %d comes again after seq %d, it was added or copied", rec.seq, v.seq)
+	}
+}
+
+/*
+  accept
+  The line is the new end of the chain
+*/
+func (v *auditVerifier_t) accept(rec *auditRecord_t) {
+	v.seq = rec.seq
+	v.mac = rec.mac
+	if v.head != nil && rec.seq == v.head.Seq && strings.EqualFold(hex.EncodeToString(rec.mac), v.head.Mac) {
+		v.hasHead = true
+	}
+}
+
+/*
+  settle
+  A bad line at the very end was changed
+*/
+func (v *auditVerifier_t) settle() {
+	if v.suspect != nil {
+		v.problem(v.suspect, "seq %d was changed", v.suspect.seq)
+		v.accept(v.suspect)
+		v.suspect = nil
+	}
+}
+
+/*
+  problem
+  Add a problem at a line
+*/
+func (v *auditVerifier_t) problem(rec *auditRecord_t, format string, args ...interface{}) {
+	v.res.Problems = append(v.res.Problems, AuditProblem_t{File: rec.file, Line: rec.line, Seq: rec.seq,
+		Msg: fmt.Sprintf(format, args...)})
+}
+
+/*
+  ParseAuditHead
+  A head as the log has it, "seq 12, mac 3f..", or as "12:3f.."
+*/
+func ParseAuditHead(text string) (*AuditHead_t, error) {
+	text = strings.TrimSpace(text)
+	var head AuditHead_t
+	if _, err := fmt.Sscanf(text, "seq %d, mac %s", &head.Seq, &head.Mac); err == nil {
+		head.Mac = strings.TrimSuffix(head.Mac, ".")
+	} else {
+		parts := strings.SplitN(text, ":", 2)
+		seq, seqErr := strconv.ParseInt(parts[0], 10, 64)
+		if len(parts) != 2 || seqErr != nil {
+			return nil, fmt.Errorf("bad head '%s', use the 'seq 12, mac 3f..' of the log, or 12:3f..", text)
+		}
+		head = AuditHead_t{Seq: seq, Mac: parts[1]}
+	}
+	if mac, err := hex.DecodeString(head.Mac); err != nil || len(mac) != sha256.Size || head.Seq <= 0 {
+		return nil, fmt.Errorf("bad head '%s', the mac is %d hex digits", text, 2*sha256.Size)
+	}
+	return &head, nil
+}
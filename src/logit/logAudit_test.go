@@ -0,0 +1,209 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+  TestAuditChain
+  Every line of the log file is sealed, the chain goes on over the
+  rotations and a restart, in text and in json, and the head that
+  Close logs is the end of the files
+*/
+func TestAuditChain(t *testing.T) {
+	registerMemorySink(t)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "audit.key")
+	if err := ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "audit.txt")
+	jsonTest := `{
+		"filename": "` + filepath.ToSlash(logFileName) + `",
+		"level": "INFO",
+		"maxFileSize": 2000,
+		"audit": { "keyFile": "` + filepath.ToSlash(keyFile) + `" },
+		"sinks": [ { "type": "file", "format": "text" }, { "type": "memory", "id": "audit" } ]
+	}`
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for run, format := range []string{"text", "json"} {
+		if err := writeConfigFile(configFileName, strings.Replace(jsonTest, `"text"`, `"`+format+`"`, 1)); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		l, err := NewLogger(configFileName)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		for i := 0; i < 20; i++ {
+			l.Infof(&myFlags, "Run %d, line %d of the audit test", run, i)
+		}
+		l.Close()
+	}
+
+	files := AuditFiles(logFileName)
+	lines := getMemorySink(t, "audit").getLines()
+	var head *AuditHead_t
+	for _, line := range lines {
+		if i := strings.Index(line, "Audit chain head: "); i >= 0 {
+			head, _ = ParseAuditHead(line[i+len("Audit chain head: "):])
+		}
+	}
+	all := strings.Join(lines, "\n")
+	if head == nil || !strings.Contains(all, "Audit chain goes on from seq") || !strings.Contains(all, "Audit chain head of '") {
+		t.Fatalf("Logit problem: head %v in\n%s", head, all)
+	}
+	res, err := VerifyAudit([]byte("0123456789abcdef0123456789abcdef"), files, head)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if len(files) < 3 || res.FirstSeq != 1 || res.LastSeq != res.Lines || res.LastSeq <= head.Seq ||
+		len(res.Problems) != 0 || len(res.Restarts) != 0 {
+		t.Errorf("Logit problem: %d files, %+v", len(files), res)
+	}
+	raw, _ := ioutil.ReadFile(logFileName)
+	if !strings.Contains(string(raw), `,"seq":`) {
+		t.Errorf("Logit problem: no json seal in\n%s", raw)
+	}
+}
+
+/*
+  TestAuditKeyMissing
+  A logger with an audit key file that is missing or too short does
+  not start, a reload that cannot load the key is rejected and the
+  chain goes on
+*/
+func TestAuditKeyMissing(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "audit.key")
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	jsonTest := `{
+		"filename": "` + filepath.ToSlash(filepath.Join(dir, "audit.txt")) + `",
+		"stdout": false,
+		"audit": { "keyFile": "` + filepath.ToSlash(keyFile) + `" }
+	}`
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if _, err := NewLogger(configFileName); err == nil || !strings.Contains(err.Error(), "audit.keyFile") {
+		t.Errorf("Logit problem: a logger without its key file, %v", err)
+	}
+	ioutil.WriteFile(keyFile, []byte("too short\n"), 0600)
+	if _, err := NewLogger(configFileName); err == nil || !strings.Contains(err.Error(), "it needs at least 16") {
+		t.Errorf("Logit problem: a logger with a short key, %v", err)
+	}
+
+	ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600)
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	defer l.Close()
+	os.Remove(keyFile)
+	if err := l.reloadConfig(); err == nil || l.Generation() != 0 || l.file.audit == nil {
+		t.Errorf("Logit problem: a reload without the key file, gen %d, %v", l.Generation(), err)
+	}
+}
+
+/*
+  TestAuditTamper
+  A line that is changed, removed, added or copied, lines without a
+  seal and lines cut off the end are all found, with their line
+*/
+func TestAuditTamper(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	audit := &audit_t{key: key}
+	var sealed []string
+	for _, line := range []string{"one", "two", "three", `{"msg":"four"}`, "five", "six"} {
+		sealed = append(sealed, audit.seal(line))
+	}
+	head := &AuditHead_t{Seq: audit.seq, Mac: strings.TrimPrefix(audit.head(), "seq 6, mac ")}
+	forged := &audit_t{key: []byte("not the key, but long"), seq: 2}
+
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"intact", sealed, ""},
+		{"changed", []string{sealed[0], sealed[1], strings.Replace(sealed[2], "three", "3", 1), sealed[3], sealed[4], sealed[5]},
+			":3: seq 3 was changed"},
+		{"json changed", []string{sealed[0], sealed[1], sealed[2], strings.Replace(sealed[3], "four", "4", 1), sealed[4], sealed[5]},
+			":4: seq 4 was changed"},
+		{"removed", []string{sealed[0], sealed[1], sealed[4], sealed[5]}, ":3: seq 3 to 4 were removed"},
+		{"added", []string{sealed[0], sealed[1], forged.seal("forged"), sealed[2], sealed[3], sealed[4], sealed[5]},
+			":3: seq 3 was added"},
+		{"copied", []string{sealed[0], sealed[1], sealed[2], sealed[1], sealed[3], sealed[4], sealed[5]},
+			":4: seq 2 comes again after seq 3"},
+		{"unsealed", []string{sealed[0], "an extra line", sealed[1], sealed[2], sealed[3], sealed[4], sealed[5]},
+			":2: the lines before seq 2 have no seal"},
+		{"cut short", sealed[:4], ": the head is seq 6, the log ends at seq 4"},
+	} {
+		fileName := filepath.Join(dir, strings.Replace(test.name, " ", "_", -1)+".txt")
+		if err := ioutil.WriteFile(fileName, []byte(strings.Join(test.lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		res, err := VerifyAudit(key, []string{fileName}, head)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		if len(test.want) == 0 {
+			if len(res.Problems) != 0 || res.LastSeq != 6 {
+				t.Errorf("Logit problem: %s has %v", test.name, res.Problems)
+			}
+			continue
+		}
+		if len(res.Problems) != 1 || !strings.HasPrefix(res.Problems[0].String(), fileName+test.want) {
+			t.Errorf("Logit problem: %s has %v, wanted '%s'", test.name, res.Problems, test.want)
+		}
+	}
+}
+
+/*
+  TestAuditRestart
+  A logger goes on with the chain of the file, a last line that was
+  cut short starts a new chain, which verify notes
+*/
+func TestAuditRestart(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	logFileName := filepath.Join(t.TempDir(), "restart.txt")
+	audit, msg := newAudit(key, logFileName)
+	if msg != "Audit chain starts at seq 1." {
+		t.Errorf("Logit problem: '%s'", msg)
+	}
+	contents := audit.seal("one") + "\n" + audit.seal("two") + "\n"
+	ioutil.WriteFile(logFileName, []byte(contents), 0644)
+	audit, msg = newAudit(key, logFileName)
+	if audit.seq != 2 || !strings.HasPrefix(msg, "Audit chain goes on from seq 2 of") {
+		t.Errorf("Logit problem: seq %d, '%s'", audit.seq, msg)
+	}
+	contents += audit.seal("three") + "\n" + "four, cut sh\n"
+	ioutil.WriteFile(logFileName, []byte(contents), 0644)
+	audit, msg = newAudit(key, logFileName)
+	if audit.seq != 0 || !strings.HasSuffix(msg, "has no seal.") {
+		t.Errorf("Logit problem: seq %d, '%s'", audit.seq, msg)
+	}
+	fh, _ := os.OpenFile(logFileName, os.O_WRONLY|os.O_APPEND, 0644)
+	fh.WriteString(audit.seal("five") + "\n")
+	fh.Close()
+	res, err := VerifyAudit(key, []string{logFileName}, nil)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if len(res.Restarts) != 1 || res.Restarts[0].Line != 5 || len(res.Problems) != 1 ||
+		!strings.HasSuffix(res.Problems[0].String(), ":4: the lines before seq 1 have no seal, they were added or cut short") {
+		t.Errorf("Logit problem: restarts %v, problems %v", res.Restarts, res.Problems)
+	}
+	if _, err := ParseAuditHead("seq 12, mac 3f"); err == nil {
+		t.Errorf("Logit problem: a short mac is a head")
+	}
+}
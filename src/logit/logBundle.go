@@ -0,0 +1,439 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+const bundleSyncWait = time.Second * 2 // how long a bundle waits for the writer to catch up
+
+/*
+  bundle_t
+  What goes into a support bundle. A running logger has stats and a
+  ring, a bundle made from the config file alone does not.
+*/
+type bundle_t struct {
+	configFileName string
+	flags          *logFlags_t
+	stats          *logStats_t // nil if there is no running logger
+	ring           []*Record_t // nil if there is no running logger
+	messages       []string    // what parsing the config said, for a config bundle
+	source         string      // what made the bundle
+}
+
+/*
+  WriteSupportBundle
+  Write a zip of what support needs to look at a problem: the log file
+  and its rotated files, the config file and the config as it was
+  parsed, with its generation, the stats, the runtime and build info
+  and the ring. The secrets are redacted, see "bundleRedact".
+  e.g. logger.WriteSupportBundle(w) in an admin handler
+*/
+func (l *Logger) WriteSupportBundle(w io.Writer) error {
+	l.syncWriter(bundleSyncWait)
+	stats := l.GetLogStats()
+	flags := l.getLogFlags()
+	ring := l.ring.snapshot()
+	if ring == nil {
+		ring = []*Record_t{}
+	}
+	return writeBundle(w, &bundle_t{
+		configFileName: l.configFileName,
+		flags:          flags,
+		stats:          &stats,
+		ring:           ring,
+		source:         fmt.Sprintf("process %d", os.Getpid()),
+	})
+}
+
+/*
+  WriteConfigBundle
+  Write a support bundle from the config file alone, for when the
+  process is not running: the logs, the config and the runtime info of
+  the caller. There are no stats and no ring.
+  The logit command does this.
+*/
+func WriteConfigBundle(w io.Writer, configFileName string) error {
+	l := newLogger()
+	l.configFileName = configFileName
+	var tFlags logFlags_t
+	var configErr *ConfigError_t
+	if err := l.getConfig(configFileName, &tFlags); err != nil && !errors.As(err, &configErr) {
+		return err
+	}
+	var messages []string // a config with errors is bundled too, the errors are here
+	for _, item := range l.delayedLogs {
+		messages = append(messages, item.logLevel.String()+" "+item.msg)
+	}
+	return writeBundle(w, &bundle_t{
+		configFileName: configFileName,
+		flags:          &tFlags,
+		messages:       messages,
+		source:         "config file",
+	})
+}
+
+/*
+  writeBundle
+  Write the zip of the bundle
+*/
+func writeBundle(w io.Writer, b *bundle_t) error {
+	redactor := b.flags.bundleRedact
+	if redactor == nil { // not configured yet
+		redactor, _ = newRedactor(redactionNames(), nil)
+	}
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	add := func(name string, write func(out io.Writer) error) error {
+		out, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		return write(out)
+	}
+	addText := func(name string, text string) error {
+		return add(name, func(out io.Writer) error {
+			_, err := io.WriteString(out, redactor.redact(text))
+			return err
+		})
+	}
+	addJSON := func(name string, value interface{}) error {
+		raw, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		return addText(name, string(raw)+"\n")
+	}
+
+	var contents []string
+	var problems []string
+	if raw, err := ioutil.ReadFile(b.configFileName); err == nil {
+		name := "config/" + filepath.Base(b.configFileName)
+		if err := addText(name, string(raw)); err != nil {
+			return err
+		}
+		contents = append(contents, name)
+	} else {
+		problems = append(problems, err.Error())
+	}
+	if err := addJSON("config/effective.json", effectiveConfig(b.configFileName, b.flags)); err != nil {
+		return err
+	}
+	contents = append(contents, "config/effective.json")
+	if len(b.messages) > 0 {
+		if err := addText("config/messages.txt", strings.Join(b.messages, "\n")+"\n"); err != nil {
+			return err
+		}
+		contents = append(contents, "config/messages.txt")
+	}
+	if b.stats != nil {
+		if err := addJSON("stats.json", bundleStats(b.stats)); err != nil {
+			return err
+		}
+		contents = append(contents, "stats.json")
+	}
+	if err := addJSON("runtime.json", bundleRuntime()); err != nil {
+		return err
+	}
+	contents = append(contents, "runtime.json")
+	if b.ring != nil {
+		var lines strings.Builder
+		for _, rec := range b.ring {
+			lines.WriteString(rec.format(TEXT) + "\n")
+		}
+		if err := addText("ring.txt", lines.String()); err != nil {
+			return err
+		}
+		contents = append(contents, "ring.txt")
+	}
+	//
+	// the log file and its rotated files, the compressed ones unpacked
+	//
+	for _, fileName := range bundleLogFiles(b.flags) {
+		name := "logs/" + strings.TrimSuffix(filepath.Base(fileName), ".gz")
+		err := add(name, func(out io.Writer) error {
+			return copyRedacted(out, fileName, redactor)
+		})
+		if os.IsNotExist(err) { // rotated away while the bundle was made
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", fileName, err.Error()))
+			continue
+		}
+		contents = append(contents, name)
+	}
+
+	summary := fmt.Sprintf("Support bundle of '%s', generation %d, made %s from the %s.\n",
+		b.configFileName, b.flags.generation, now.Format(time.RFC3339), b.source)
+	for _, name := range contents {
+		summary += "  " + name + "\n"
+	}
+	for _, problem := range problems {
+		summary += "Problem: " + problem + "\n"
+	}
+	if err := addText("bundle.txt", summary); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+/*
+  bundleLogFiles
+  The log file of the file sink and its rotated files, oldest first
+*/
+func bundleLogFiles(flags *logFlags_t) []string {
+	for _, sink := range flags.sinks {
+		if sink.kind == FILE_SINK && len(sink.target) > 0 {
+			return append(rotatedFiles(sink.target), sink.target)
+		}
+	}
+	return nil
+}
+
+/*
+  copyRedacted
+  Copy a log file line by line with the secrets redacted, a gzipped
+  rotated file is unpacked
+*/
+func copyRedacted(out io.Writer, fileName string, redactor *redactor_t) error {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	var in io.Reader = fh
+	if strings.HasSuffix(fileName, ".gz") {
+		zr, err := gzip.NewReader(fh)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		in = zr
+	}
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(out, redactor.redact(line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// bundleConfig_t is the config as it was parsed, in effective.json
+type bundleConfig_t struct {
+	ConfigFile        string            `json:"configFile"`
+	Generation        int32             `json:"generation"`
+	SiteID            string            `json:"SiteID"`
+	SystemID          string            `json:"SystemID"`
+	Level             string            `json:"level"`
+	Levels            map[string]string `json:"levels,omitempty"`
+	DebugAll          bool              `json:"debugAll"`
+	DebugFlags        []string          `json:"debugFlags,omitempty"`
+	XFlags            map[string]string `json:"xFlags,omitempty"`
+	Overrides         []Override_t      `json:"overrides,omitempty"`
+	FileName          string            `json:"filename,omitempty"`
+	MaxFileSize       int64             `json:"maxFileSize,omitempty"`
+	RotateDaily       bool              `json:"rotateDaily"`
+	KeepFiles         int               `json:"keepFiles,omitempty"`
+	Compress          bool              `json:"compress"`
+	Url               string            `json:"url,omitempty"`
+	UrlSpoolDir       string            `json:"urlSpoolDir,omitempty"`
+	UrlSpoolMaxSize   int64             `json:"urlSpoolMaxSize,omitempty"`
+	AuditKeyFile      string            `json:"auditKeyFile,omitempty"`
+	Syslog            string            `json:"syslog,omitempty"`
+	QueueSize         int               `json:"queueSize,omitempty"`
+	Overflow          string            `json:"overflow"`
+	Sinks             []bundleSink_t    `json:"sinks"`
+	Sampling          []string          `json:"sampling,omitempty"`
+	DuplicateInterval string            `json:"duplicateInterval,omitempty"`
+	RingSize          int               `json:"ringSize"`
+	RingLevel         string            `json:"ringLevel,omitempty"`
+	RingDumpFile      string            `json:"ringDumpFile,omitempty"`
+	Sources           map[string]string `json:"sources,omitempty"`
+}
+
+// bundleSink_t is one sink in effective.json
+type bundleSink_t struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Target  string   `json:"target,omitempty"`
+	Enabled bool     `json:"enabled"`
+	Level   string   `json:"level"`
+	Format  string   `json:"format"`
+	Color   bool     `json:"color,omitempty"`
+	Pkgs    []string `json:"pkgs,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+/*
+  effectiveConfig
+  The parsed flags, the way effective.json shows them
+*/
+func effectiveConfig(configFileName string, flags *logFlags_t) *bundleConfig_t {
+	config := &bundleConfig_t{
+		ConfigFile:   configFileName,
+		Generation:   flags.generation,
+		SiteID:       flags.siteID,
+		SystemID:     flags.sysID,
+		Level:        flags.logLevel.String(),
+		DebugAll:     flags.debugAll,
+		FileName:     flags.logFileName,
+		MaxFileSize:  flags.rotate.maxSize,
+		RotateDaily:  flags.rotate.daily,
+		KeepFiles:    flags.rotate.keep,
+		Compress:     flags.rotate.compress,
+		Url:          flags.url,
+		UrlSpoolDir:  flags.urlSpoolDir,
+		AuditKeyFile: flags.auditKeyFile,
+		QueueSize:    flags.queueSize,
+		Overflow:     flags.overflow.String(),
+		RingSize:     flags.ringSize,
+		RingDumpFile: flags.ringDumpFile,
+		Sources:      flags.sources,
+	}
+	if len(flags.urlSpoolDir) > 0 {
+		config.UrlSpoolMaxSize = flags.urlSpoolMax
+		if config.UrlSpoolMaxSize <= 0 {
+			config.UrlSpoolMaxSize = defaultSpoolMaxSize
+		}
+	}
+	if len(flags.levels) > 0 {
+		config.Levels = make(map[string]string)
+		for key, level := range flags.levels {
+			config.Levels[key] = level.String()
+		}
+	}
+	for key, on := range flags.dFlags {
+		if on {
+			config.DebugFlags = append(config.DebugFlags, key)
+		}
+	}
+	sort.Strings(config.DebugFlags)
+	if len(flags.xFlags) > 0 {
+		config.XFlags = make(map[string]string)
+		for key, value := range flags.xFlags {
+			config.XFlags[key] = fmt.Sprintf("0x%x", value)
+		}
+	}
+	if len(flags.syslog.address) > 0 {
+		config.Syslog = fmt.Sprintf("%s:%s facility %d app-name '%s'", flags.syslog.network, flags.syslog.address,
+			flags.syslog.facility, flags.syslog.appName)
+	}
+	if len(flags.overrides) > 0 {
+		config.Overrides = exportOverrides(flags.overrides)
+	}
+	for _, sink := range flags.sinks {
+		config.Sinks = append(config.Sinks, bundleSink_t{Type: sink.kind, Name: sink.name, Target: sink.target,
+			Enabled: sink.enabled, Level: sink.level.String(), Format: sink.format.String(), Color: sink.color,
+			Pkgs: sink.pkgs, Exclude: sink.exclude})
+	}
+	for _, rule := range flags.sampling {
+		level := "all levels"
+		if !rule.allLevels {
+			level = rule.level.String()
+		}
+		config.Sampling = append(config.Sampling, fmt.Sprintf("pkg:'%s', file:'%s', %s, first %d then 1 in %d every %s",
+			rule.pkg, rule.file, level, rule.first, rule.thereafter, rule.interval))
+	}
+	if flags.duplicateInterval > 0 {
+		config.DuplicateInterval = flags.duplicateInterval.String()
+	}
+	if flags.ringSize > 0 {
+		config.RingLevel = flags.ringLevel.String()
+	}
+	return config
+}
+
+/*
+  bundleStats
+  The stats by name, for stats.json
+*/
+func bundleStats(stats *logStats_t) map[string]interface{} {
+	return map[string]interface{}{
+		"fatal":             stats.fatalCount,
+		"error":             stats.errorCount,
+		"warn":              stats.warnCount,
+		"info":              stats.infoCount,
+		"debug":             stats.debugCount,
+		"trace":             stats.traceCount,
+		"lines":             stats.lineCount,
+		"logSize":           stats.logSize,
+		"queueDropped":      stats.queueDropped,
+		"sampledDropped":    stats.sampledDropped,
+		"duplicatesDropped": stats.duplicatesDropped,
+		"urlSent":           stats.urlSent,
+		"urlDropped":        stats.urlDropped,
+		"urlPending":        stats.urlPending,
+		"urlSpoolDepth":     stats.urlSpoolDepth,
+		"urlSpoolBytes":     stats.urlSpoolBytes,
+		"urlReplayed":       stats.urlReplayed,
+		"urlReplayLeft":     stats.urlReplayLeft,
+		"syslogSent":        stats.syslogSent,
+		"syslogDropped":     stats.syslogDropped,
+		"syslogPending":     stats.syslogPending,
+	}
+}
+
+/*
+  bundleRuntime
+  The Go runtime and the build of the process, for runtime.json
+*/
+func bundleRuntime() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info := map[string]interface{}{
+		"goVersion":  runtime.Version(),
+		"goos":       runtime.GOOS,
+		"goarch":     runtime.GOARCH,
+		"cpus":       runtime.NumCPU(),
+		"goroutines": runtime.NumGoroutine(),
+		"pid":        os.Getpid(),
+		"args":       os.Args,
+		"heapAlloc":  mem.HeapAlloc,
+		"sys":        mem.Sys,
+		"numGC":      mem.NumGC,
+	}
+	if host, err := os.Hostname(); err == nil {
+		info["hostname"] = host
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		deps := make([]string, 0, len(build.Deps))
+		for _, dep := range build.Deps {
+			deps = append(deps, dep.Path+" "+dep.Version)
+		}
+		settings := make(map[string]string)
+		for _, setting := range build.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		info["build"] = map[string]interface{}{
+			"path":     build.Path,
+			"main":     build.Main.Path + " " + build.Main.Version,
+			"deps":     deps,
+			"settings": settings,
+		}
+	}
+	return info
+}
@@ -0,0 +1,543 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// how bad a problem of the config is
+const (
+	CONFIG_ERROR   = "error"   // the config is not used
+	CONFIG_WARNING = "warning" // the config is used, the entry may not do what was meant
+)
+
+/*
+  ConfigProblem_t
+  Something wrong in a config file, where it is: the line and column,
+  both from 1, and the path of the entry, e.g. "sinks[1].level". A
+  value an environment variable set has the variable as its Source,
+  and no line.
+*/
+type ConfigProblem_t struct {
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Severity string `json:"severity"`
+	Msg      string `json:"msg"`
+}
+
+/*
+  String
+  The problem as "line:column: severity: path: msg", or with the
+  environment variable instead of the line and column
+*/
+func (p ConfigProblem_t) String() string {
+	where := fmt.Sprintf("%d:%d: %s: ", p.Line, p.Column, p.Severity)
+	if len(p.Source) > 0 {
+		where = fmt.Sprintf("%s: %s: ", p.Source, p.Severity)
+	}
+	if len(p.Path) > 0 {
+		where += p.Path + ": "
+	}
+	return where + p.Msg
+}
+
+/*
+  ConfigError_t
+  A config file with errors, getConfig returns it and the logger keeps
+  what it had. Problems has the errors and the warnings.
+*/
+type ConfigError_t struct {
+	FileName string
+	Problems []ConfigProblem_t
+}
+
+/*
+  Error
+  The first error and how many there are
+*/
+func (e *ConfigError_t) Error() string {
+	var errs []ConfigProblem_t
+	for _, p := range e.Problems {
+		if p.Severity == CONFIG_ERROR {
+			errs = append(errs, p)
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Sprintf("config file '%s' has no errors", e.FileName)
+	}
+	msg := fmt.Sprintf("config file '%s':%s", e.FileName, errs[0])
+	if len(errs) > 1 {
+		msg += fmt.Sprintf(" (and %d more errors)", len(errs)-1)
+	}
+	return msg
+}
+
+/*
+  configCheck_t
+  Goes over a config file that was read, with where every entry is,
+  and checks the values against the json tags and the "check" tags of
+  configJason_t.
+*/
+type configCheck_t struct {
+	doc    *configDoc_t
+	pkgs   map[string]bool        // the packages and package:files known to log, nil if not known
+	object map[string]interface{} // the object whose members are checked
+}
+
+/*
+  CheckConfig
+  Check a config file without opening a logger, for 'logit check'.
+  'pkgs' are the packages, or package:files, the program logs from,
+  the entries for others are warned about. Nil does not check them.
+  Xflag names are checked against the names this program registered,
+  if it registered any. The file can be json, yaml or toml, by its
+  extension, the environment variables are applied as a logger would.
+  The error is for a file that cannot be read.
+*/
+func CheckConfig(configFileName string, pkgs []string) ([]ConfigProblem_t, error) {
+	doc, err := readConfig(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	var known map[string]bool
+	if pkgs != nil {
+		known = make(map[string]bool)
+		for _, pkg := range pkgs {
+			known[pkg] = true
+			if i := strings.Index(pkg, ":"); i > 0 {
+				known[pkg[:i]] = true
+			}
+		}
+	}
+	return checkConfig(doc, known), nil
+}
+
+/*
+  knownPkgs
+  The packages and package:files that loaded their flags from this
+  logger, nil before any did, so the first config is not warned about
+*/
+func (l *Logger) knownPkgs() map[string]bool {
+	dxMutex.Lock()
+	defer dxMutex.Unlock()
+	if len(l.callers) == 0 {
+		return nil
+	}
+	known := make(map[string]bool)
+	for caller := range l.callers {
+		known[caller] = true
+		if i := strings.Index(caller, ":"); i > 0 {
+			known[caller[:i]] = true
+		}
+	}
+	return known
+}
+
+/*
+  checkConfig
+  The problems of the config file, the ones of the environment first,
+  then in the order of the file
+*/
+func checkConfig(doc *configDoc_t, pkgs map[string]bool) []ConfigProblem_t {
+	if doc.value != nil {
+		c := &configCheck_t{doc: doc, pkgs: pkgs}
+		c.checkValue("", doc.value, reflect.TypeOf(configJason_t{}), "")
+	}
+	problems := doc.problems
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return problems
+}
+
+/*
+  addAt
+  Add a problem of the entry at 'path'
+*/
+func (c *configCheck_t) addAt(path string, severity string, format string, args ...interface{}) {
+	c.doc.add(c.doc.where(path), path, severity, fmt.Sprintf(format, args...))
+}
+
+/*
+  jsonKind
+  What a json value is, for the messages
+*/
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "true or false"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+/*
+  checkValue
+  Check a value against the type of its field, and its "check" tag
+*/
+func (c *configCheck_t) checkValue(path string, value interface{}, t reflect.Type, check string) {
+	if value == nil { // null is the same as leaving it out
+		return
+	}
+	if t == reflect.TypeOf(json.RawMessage{}) || t.Kind() == reflect.Interface {
+		c.checkTag(path, value, check)
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			c.addAt(path, CONFIG_ERROR, "must be an object, not %s", jsonKind(value))
+			return
+		}
+		c.checkObject(path, object, []reflect.Type{t}, false)
+	case reflect.Slice:
+		list, isList := value.([]interface{})
+		if !isList {
+			c.addAt(path, CONFIG_ERROR, "must be a list, not %s", jsonKind(value))
+			return
+		}
+		if check == "sinks" {
+			for i, item := range list {
+				c.checkSink(fmt.Sprintf("%s[%d]", path, i), item)
+			}
+			return
+		}
+		for i, item := range list {
+			c.checkValue(fmt.Sprintf("%s[%d]", path, i), item, t.Elem(), check)
+		}
+	case reflect.Map:
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			c.addAt(path, CONFIG_ERROR, "must be an object, not %s", jsonKind(value))
+			return
+		}
+		for key, item := range object {
+			member := joinConfigPath(path, key)
+			if check == "levelKeys" {
+				if _, err := parseLevel(key, INFO); err != nil {
+					c.addAt(member, CONFIG_ERROR, "%s", err.Error())
+				}
+			}
+			c.checkValue(member, item, t.Elem(), "")
+		}
+	case reflect.String:
+		if _, isString := value.(string); !isString {
+			c.addAt(path, CONFIG_ERROR, "must be a string, not %s", jsonKind(value))
+			return
+		}
+		c.checkTag(path, value, check)
+	case reflect.Bool:
+		if _, isBool := value.(bool); !isBool {
+			c.addAt(path, CONFIG_ERROR, "must be true or false, not %s", jsonKind(value))
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		number, isNumber := value.(float64)
+		if !isNumber || number != math.Trunc(number) {
+			c.addAt(path, CONFIG_ERROR, "must be a whole number, not %s", jsonKind(value))
+		}
+	}
+}
+
+/*
+  checkObject
+  Check the members of an object against the fields of the structs.
+  A member that no struct has is a warning, unless 'open' says the
+  object can have members of its own, like a registered sink.
+*/
+func (c *configCheck_t) checkObject(path string, object map[string]interface{}, types []reflect.Type, open bool) {
+	outer := c.object
+	c.object = object
+	defer func() { c.object = outer }()
+	for key, item := range object {
+		member := joinConfigPath(path, key)
+		field, found := findConfigField(types, key)
+		if !found {
+			if !open {
+				c.addAt(member, CONFIG_WARNING, "unknown field '%s'%s", key, suggestConfigField(types, key))
+			}
+			continue
+		}
+		c.checkValue(member, item, field.Type, field.Tag.Get("check"))
+	}
+}
+
+/*
+  member
+  The text of a member of the object that is checked, "" if it has none
+*/
+func (c *configCheck_t) member(key string) string {
+	for name, value := range c.object {
+		if text, isString := value.(string); isString && strings.EqualFold(name, key) {
+			return strings.TrimSpace(text)
+		}
+	}
+	return ""
+}
+
+/*
+  findConfigField
+  The field of one of the structs with this json name. Like
+  encoding/json, the case of the name does not matter.
+*/
+func findConfigField(types []reflect.Type, key string) (reflect.StructField, bool) {
+	for _, t := range types {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if strings.EqualFold(name, key) {
+				return field, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+/*
+  suggestConfigField
+  ", did you mean 'x'?" for a field that is one letter off, or has the
+  letters of a field in another order, empty if none is
+*/
+func suggestConfigField(types []reflect.Type, key string) string {
+	sorted := func(s string) string {
+		letters := strings.Split(strings.ToLower(s), "")
+		sort.Strings(letters)
+		return strings.Join(letters, "")
+	}
+	for _, t := range types {
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if sorted(name) == sorted(key) || oneEditApart(strings.ToLower(name), strings.ToLower(key)) {
+				return fmt.Sprintf(", did you mean '%s'?", name)
+			}
+		}
+	}
+	return ""
+}
+
+/*
+  oneEditApart
+  Check if one letter added, removed or changed makes 'a' into 'b'
+*/
+func oneEditApart(a string, b string) bool {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if len(a)-len(b) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(b) {
+		return true
+	}
+	if len(a) == len(b) {
+		return a[i+1:] == b[i+1:]
+	}
+	return a[i+1:] == b[i:]
+}
+
+/*
+  checkSink
+  A sink entry has the fields of its type: the builtin types have
+  sinkJson_t, syslog has syslogJson_t as well, a registered type can
+  have any fields of its own.
+*/
+func (c *configCheck_t) checkSink(path string, value interface{}) {
+	object, isObject := value.(map[string]interface{})
+	if !isObject {
+		c.addAt(path, CONFIG_ERROR, "must be an object, not %s", jsonKind(value))
+		return
+	}
+	kind, _ := object["type"].(string)
+	types := []reflect.Type{reflect.TypeOf(sinkJson_t{})}
+	open := false
+	switch {
+	case kind == SYSLOG_SINK:
+		types = append(types, reflect.TypeOf(syslogJson_t{}))
+	case builtinSinks[kind]:
+	case len(kind) == 0:
+		c.addAt(path, CONFIG_ERROR, "a sink needs a type")
+		return
+	default:
+		if getSinkFactory(kind) == nil {
+			c.addAt(joinConfigPath(path, "type"), CONFIG_WARNING, "no sink type '%s' is registered", kind)
+		}
+		open = true
+	}
+	c.checkObject(path, object, types, open)
+}
+
+/*
+  checkTag
+  Check a value for what its "check" tag says it is
+*/
+func (c *configCheck_t) checkTag(path string, value interface{}, check string) {
+	text, _ := value.(string)
+	var err error
+	switch check {
+	case "level":
+		_, err = parseLevel(text, INFO)
+	case "format":
+		_, err = parseFormat(text, TEXT)
+	case "overflow":
+		_, err = parseOverflow(text)
+	case "size":
+		_, err = parseSize(value)
+	case "auditKey":
+		_, err = loadAuditKey(text)
+	case "duration":
+		if len(text) > 0 {
+			if d, parseErr := time.ParseDuration(text); parseErr != nil || d < 0 {
+				err = fmt.Errorf("'%s' is not a duration, use e.g. 10s, 5m or 1h", text)
+			}
+		}
+	case "stack":
+		if _, found := stackNames[strings.ToLower(text)]; !found && len(text) > 0 {
+			err = fmt.Errorf("unknown stack '%s', use none, goroutine or all", text)
+		}
+	case "action":
+		if _, found := actionNames[strings.ToLower(text)]; !found && len(text) > 0 {
+			err = fmt.Errorf("unknown action '%s', use continue, exit or panic", text)
+		}
+	case "redact":
+		_, errs := newRedactor([]string{text}, nil)
+		if len(errs) > 0 {
+			err = errs[0]
+		}
+	case "regexp":
+		if _, compileErr := regexp.Compile(text); compileErr != nil {
+			err = fmt.Errorf("bad pattern '%s': %s", text, compileErr.Error())
+		}
+	case "xflags":
+		var unknown []string
+		pkg := c.member("pkg") // the names are those of the package of the entry
+		_, unknown, err = parseXFlags(pkg, value)
+		// a program that registers no names, e.g. 'logit check', cannot tell
+		if registered := registeredXFlags(pkg); err == nil && len(unknown) > 0 && len(registered) > 0 {
+			c.addAt(path, CONFIG_WARNING, "unknown xflag name '%s', registered are %s", strings.Join(unknown, "', '"), strings.Join(registered, ", "))
+		}
+	case "pkg", "pkgs":
+		c.checkPkg(path, text, check == "pkgs")
+	}
+	if err != nil {
+		c.addAt(path, CONFIG_ERROR, "%s", err.Error())
+	}
+}
+
+/*
+  checkPkg
+  Warn about a package, or a package:file, that nothing logs from.
+  Only done when the packages are known.
+*/
+func (c *configCheck_t) checkPkg(path string, pkg string, withFile bool) {
+	if c.pkgs == nil || len(pkg) == 0 || strings.EqualFold(pkg, "all") {
+		return
+	}
+	name := pkg
+	if i := strings.Index(pkg, ":"); withFile && i > 0 {
+		name = pkg[:i]
+	}
+	if !c.pkgs[name] {
+		c.addAt(path, CONFIG_WARNING, "package '%s' does not log in this program", name)
+	}
+}
+
+/*
+  delayProblems
+  Log the problems of a config file, the errors as ERROR and the
+  warnings as WARN. Those of the environment do not have the file.
+*/
+func (l *Logger) delayProblems(configFileName string, problems []ConfigProblem_t) {
+	for _, p := range problems {
+		level := WARN
+		if p.Severity == CONFIG_ERROR {
+			level = ERROR
+		}
+		if len(p.Source) > 0 {
+			l.delayLog(level, p.String())
+			continue
+		}
+		l.delayLog(level, configFileName+":"+p.String())
+	}
+}
+
+/*
+  hasConfigErrors
+  Check if any of the problems is an error
+*/
+func hasConfigErrors(problems []ConfigProblem_t) bool {
+	for _, p := range problems {
+		if p.Severity == CONFIG_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  WriteEffectiveConfig
+  Write the config the logger runs with, as json: the config file
+  after defaults, with the overrides
+*/
+func (l *Logger) WriteEffectiveConfig(w io.Writer) error {
+	return writeEffectiveConfig(w, effectiveConfig(l.configFileName, l.getLogFlags()))
+}
+
+/*
+  WriteEffectiveConfig
+  Write the config a logger would run with, as json, from the config
+  file alone. A config with errors is written too, with the error.
+*/
+func WriteEffectiveConfig(w io.Writer, configFileName string) error {
+	l := newLogger()
+	var tFlags logFlags_t
+	err := l.getConfig(configFileName, &tFlags)
+	var configErr *ConfigError_t
+	if err != nil && !errors.As(err, &configErr) {
+		return err
+	}
+	if werr := writeEffectiveConfig(w, effectiveConfig(configFileName, &tFlags)); werr != nil {
+		return werr
+	}
+	return err
+}
+
+/*
+  writeEffectiveConfig
+  Write the effective config as indented json
+*/
+func writeEffectiveConfig(w io.Writer, config *bundleConfig_t) error {
+	out, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
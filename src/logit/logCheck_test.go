@@ -0,0 +1,166 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+  TestCheckConfig
+  Every problem is found, with its line and column, in the order of
+  the file. Bad values are errors, unknown names are warnings.
+*/
+func TestCheckConfig(t *testing.T) {
+	registerMemorySink(t)
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	jsonTest := `{
+	"level": "eror",
+	"levle": "INFO",
+	"ringSize": "big",
+	"duplicateInterval": "often",
+	"debugFlags": [ { "pkg": "main" }, { "pkg": "nosuch", "level": "DEBUG" } ],
+	"xFlags": [ { "pkg": "main", "flags": "0xZZ" }, { "pkg": "logit", "flags": [ "testShowBody", "noSuchFlag" ] } ],
+	"sinks": [
+		{ "type": "file", "filename": "x.txt", "fromat": "json" },
+		{ "type": "memory", "id": "check", "level": "WARN" },
+		{ "type": "kafka", "brokers": "k1" }
+	],
+	"levelPolicy": { "LOUD": { "action": "explode" } },
+	"redact": [ "password", "passport" ],
+	"stdout": true,
+	"stdout": false
+}`
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	problems, err := CheckConfig(configFileName, []string{"main:main", "logit:logCheck_test"})
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	wants := []string{
+		"2:2: error: level: unknown log level 'eror', use TRACE, DEBUG, INFO, WARN, ERROR or FATAL",
+		"3:2: warning: levle: unknown field 'levle', did you mean 'level'?",
+		"4:2: error: ringSize: must be a whole number, not a string",
+		"5:2: error: duplicateInterval: 'often' is not a duration, use e.g. 10s, 5m or 1h",
+		"6:39: warning: debugFlags[1].pkg: package 'nosuch' does not log in this program",
+		"7:31: error: xFlags[0].flags: xflag '0xZZ' is not a number",
+		"7:68: warning: xFlags[1].flags: unknown xflag name 'noSuchFlag', registered are testShowBody,",
+		"9:42: warning: sinks[0].fromat: unknown field 'fromat', did you mean 'format'?",
+		"11:5: warning: sinks[2].type: no sink type 'kafka' is registered",
+		"13:19: error: levelPolicy.LOUD: unknown log level 'LOUD', use TRACE, DEBUG, INFO, WARN, ERROR or FATAL",
+		"13:29: error: levelPolicy.LOUD.action: unknown action 'explode', use continue, exit or panic",
+		"14:26: error: redact[1]: unknown redact pattern 'passport', use all or ",
+		"16:2: warning: stdout: given more than once, the last one is used",
+	}
+	if len(problems) != len(wants) {
+		t.Errorf("Logit problem: %d problems\n%v", len(problems), problems)
+	}
+	for i := 0; i < len(problems) && i < len(wants); i++ {
+		if !strings.HasPrefix(problems[i].String(), wants[i]) {
+			t.Errorf("Logit problem: problem '%s', wanted '%s'", problems[i], wants[i])
+		}
+	}
+}
+
+/*
+  TestCheckConfigSyntax
+  Bad json is one error, where the json went wrong
+*/
+func TestCheckConfigSyntax(t *testing.T) {
+	for raw, want := range map[string]string{
+		"{\n  \"level\": \"INFO\"\n  \"ringSize\": 10\n}": "3:3: error: bad json: invalid character '\"' after object key:value pair",
+		"{\n  \"level\": \"INFO\",\n":                     "3:1: error: bad json: the file ends before the config does",
+		"{ \"level\": \"INFO\" } {}":                      "1:21: error: bad json: there is more after the config object",
+		"[ 1, 2 ]":                                        "1:1: error: must be an object, not a list",
+	} {
+		problems := checkConfig(parseConfig(CONFIG_JSON, []byte(raw)), nil)
+		if len(problems) != 1 || problems[0].String() != want {
+			t.Errorf("Logit problem: %q has %v, wanted '%s'", raw, problems, want)
+		}
+	}
+	if problems := checkConfig(parseConfig(CONFIG_JSON, []byte(`{ "level": "INFO", "ringSize": 10, "sinks": [ { "type": "stdout" } ] }`)), nil); len(problems) != 0 {
+		t.Errorf("Logit problem: a good config has %v", problems)
+	}
+}
+
+/*
+  TestReloadRejected
+  A config with an error is not loaded, nor reloaded, and the reason
+  is logged. The config of the logger stays as it was.
+*/
+func TestReloadRejected(t *testing.T) {
+	registerMemorySink(t)
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	good := `{
+		"level": "INFO",
+		"sinks": [ { "type": "memory", "id": "rejected" } ]
+	}`
+	bad := strings.Replace(good, `"INFO"`, `"eror"`, 1)
+	if err := writeConfigFile(configFileName, bad); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var configErr *ConfigError_t
+	if _, err := NewLogger(configFileName); !errors.As(err, &configErr) || len(configErr.Problems) != 1 {
+		t.Fatalf("Logit problem: a logger with a bad level, %v", err)
+	}
+
+	writeConfigFile(configFileName, good)
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	writeConfigFile(configFileName, bad)
+	if err := l.Reload(); !errors.As(err, &configErr) {
+		t.Errorf("Logit problem: the reload gave %v", err)
+	}
+	if flags := l.getLogFlags(); flags.generation != 0 || flags.logLevel != INFO {
+		t.Errorf("Logit problem: gen %d, level %s", flags.generation, flags.logLevel)
+	}
+	l.Close()
+	lines := strings.Join(getMemorySink(t, "rejected").getLines(), "\n")
+	if !strings.Contains(lines, "ERR[logit:log] "+configFileName+":2:3: error: level: unknown log level 'eror'") ||
+		!strings.Contains(lines, "could not be reloaded, gen 1: config file '"+configFileName+"':2:3: error: level:") {
+		t.Errorf("Logit problem: the sink got\n%s", lines)
+	}
+}
+
+/*
+  TestWriteEffectiveConfig
+  The effective config of a file, and of a logger with an override
+*/
+func TestWriteEffectiveConfig(t *testing.T) {
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	if err := writeConfigFile(configFileName, `{ "filename": "", "level": "DEBUG", "ringSize": 10 }`); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var buf bytes.Buffer
+	if err := WriteEffectiveConfig(&buf, configFileName); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var config bundleConfig_t
+	if err := json.Unmarshal(buf.Bytes(), &config); err != nil || config.Level != "DEBUG" || config.RingSize != 10 {
+		t.Errorf("Logit problem: %v\n%s", err, buf.String())
+	}
+
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer l.Close()
+	l.SetOverride(Override_t{Target: "main", Level: "TRACE"})
+	buf.Reset()
+	if err := l.WriteEffectiveConfig(&buf); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	config = bundleConfig_t{}
+	if err := json.Unmarshal(buf.Bytes(), &config); err != nil || len(config.Overrides) != 1 || config.Generation != 1 {
+		t.Errorf("Logit problem: %v\n%s", err, buf.String())
+	}
+}
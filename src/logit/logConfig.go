@@ -0,0 +1,391 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the formats of a config file, by its extension
+const (
+	CONFIG_JSON = "json" // .json, and any other extension
+	CONFIG_YAML = "yaml" // .yaml or .yml
+	CONFIG_TOML = "toml" // .toml
+)
+
+// the environment variables that override the config file
+const (
+	ENV_LEVEL    = "LOGIT_LEVEL"    // the level, e.g. LOGIT_LEVEL=DEBUG
+	ENV_FILENAME = "LOGIT_FILENAME" // the log file
+	ENV_DEBUG    = "LOGIT_DEBUG"    // the debug flags, e.g. LOGIT_DEBUG=main,handlers:auth
+)
+
+// where a setting came from, besides an environment variable
+const (
+	SOURCE_FILE    = "file"
+	SOURCE_DEFAULT = "default"
+)
+
+/*
+  configPos_t
+  Where an entry of a config is: its line and column in the file, or
+  the environment variable that set it
+*/
+type configPos_t struct {
+	line   int
+	column int
+	env    string
+}
+
+/*
+  configDoc_t
+  A config file read into what encoding/json makes of json, whatever
+  format the file is in, with where every entry is. The environment
+  variables are applied on top of the file.
+*/
+type configDoc_t struct {
+	format   string
+	value    interface{}            // nil when the file could not be read as its format
+	index    map[string]configPos_t // where every entry is, by path, e.g. "sinks[1].level"
+	env      map[string]string      // the top level settings the environment set, and by what variable
+	problems []ConfigProblem_t      // what went wrong reading the file
+}
+
+/*
+  configFormat
+  The format of a config file, by its extension
+*/
+func configFormat(configFileName string) string {
+	switch strings.ToLower(filepath.Ext(configFileName)) {
+	case ".yaml", ".yml":
+		return CONFIG_YAML
+	case ".toml":
+		return CONFIG_TOML
+	}
+	return CONFIG_JSON
+}
+
+/*
+  readConfig
+  Read a config file in its format and apply the environment
+  variables. The error is for a file that cannot be read at all, a
+  file that is not good json, yaml or toml has its problems.
+*/
+func readConfig(configFileName string) (*configDoc_t, error) {
+	raw, err := ioutil.ReadFile(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	doc := parseConfig(configFormat(configFileName), raw)
+	doc.applyEnv()
+	return doc, nil
+}
+
+/*
+  parseConfig
+  Read the config in this format, without the environment
+*/
+func parseConfig(format string, raw []byte) *configDoc_t {
+	doc := &configDoc_t{format: format, index: make(map[string]configPos_t), env: make(map[string]string)}
+	switch format {
+	case CONFIG_YAML:
+		doc.value = parseYAML(raw, doc)
+	case CONFIG_TOML:
+		doc.value = parseTOML(raw, doc)
+	default:
+		if indexJSON(raw, doc) {
+			var value interface{}
+			json.Unmarshal(raw, &value) // indexJSON passed, so this works
+			doc.value = value
+		}
+	}
+	return doc
+}
+
+/*
+  unmarshal
+  Fill the config struct, as if the file had been json
+*/
+func (doc *configDoc_t) unmarshal(res interface{}) error {
+	if doc.value == nil {
+		return fmt.Errorf("the config file is not good %s", doc.format)
+	}
+	raw, err := json.Marshal(doc.value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, res)
+}
+
+/*
+  add
+  Add a problem at a line and column of the file
+*/
+func (doc *configDoc_t) add(pos configPos_t, path string, severity string, msg string) {
+	doc.problems = append(doc.problems, ConfigProblem_t{Line: pos.line, Column: pos.column, Path: path,
+		Source: pos.env, Severity: severity, Msg: msg})
+}
+
+/*
+  where
+  Where the entry at 'path' is. An entry the index does not have, e.g.
+  in a value an environment variable set, is where its parent is.
+*/
+func (doc *configDoc_t) where(path string) configPos_t {
+	for {
+		if pos, found := doc.index[path]; found || len(path) == 0 {
+			return pos
+		}
+		if i := strings.LastIndexAny(path, ".["); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+}
+
+/*
+  applyEnv
+  LOGIT_LEVEL and LOGIT_FILENAME take the place of "level" and
+  "filename", LOGIT_FILENAME of the "filename" of a file sink too.
+  LOGIT_DEBUG, packages or package:files separated by commas, takes
+  the place of the "debugFlags" entries that turn debug on, the
+  entries with a level stay. As debug flags do nothing below DEBUG,
+  LOGIT_DEBUG makes the level DEBUG if it is less, unless LOGIT_LEVEL
+  sets it. A variable that is empty is not used.
+*/
+func (doc *configDoc_t) applyEnv() {
+	config, isObject := doc.value.(map[string]interface{})
+	if !isObject {
+		return // the checks say what is wrong
+	}
+	set := func(key string, value interface{}, env string) {
+		for name := range config {
+			if strings.EqualFold(name, key) {
+				delete(config, name)
+			}
+		}
+		config[key] = value
+		doc.env[key] = env
+		for path := range doc.index {
+			if path == key || strings.HasPrefix(path, key+".") || strings.HasPrefix(path, key+"[") {
+				delete(doc.index, path)
+			}
+		}
+		doc.index[key] = configPos_t{env: env}
+	}
+	if level := os.Getenv(ENV_LEVEL); len(level) > 0 {
+		set("level", level, ENV_LEVEL)
+	}
+	if fileName := os.Getenv(ENV_FILENAME); len(fileName) > 0 {
+		set("filename", fileName, ENV_FILENAME)
+		sinks, _ := config["sinks"].([]interface{})
+		for i, sink := range sinks {
+			if entry, isObject := sink.(map[string]interface{}); isObject && entry["type"] == FILE_SINK && entry["filename"] != nil {
+				entry["filename"] = fileName
+				doc.index[fmt.Sprintf("sinks[%d].filename", i)] = configPos_t{env: ENV_FILENAME}
+			}
+		}
+	}
+	debug := os.Getenv(ENV_DEBUG)
+	if len(debug) == 0 {
+		return
+	}
+	//
+	// keep the entries with a level, and where they are
+	//
+	var flags []interface{}
+	kept := make(map[string]configPos_t)
+	oldFlags, _ := config["debugFlags"].([]interface{})
+	for i, flag := range oldFlags {
+		if entry, isObject := flag.(map[string]interface{}); isObject && entry["level"] != nil {
+			from, to := fmt.Sprintf("debugFlags[%d]", i), fmt.Sprintf("debugFlags[%d]", len(flags))
+			for path, pos := range doc.index {
+				if path == from || strings.HasPrefix(path, from+".") {
+					kept[to+path[len(from):]] = pos
+				}
+			}
+			flags = append(flags, flag)
+		}
+	}
+	for _, item := range strings.Split(debug, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		entry := map[string]interface{}{"pkg": item}
+		if i := strings.Index(item, ":"); i > 0 {
+			entry = map[string]interface{}{"pkg": item[:i], "file": item[i+1:]}
+		}
+		kept[fmt.Sprintf("debugFlags[%d]", len(flags))] = configPos_t{env: ENV_DEBUG}
+		flags = append(flags, entry)
+	}
+	set("debugFlags", flags, ENV_DEBUG)
+	for path, pos := range kept {
+		doc.index[path] = pos
+	}
+	if len(doc.env["level"]) > 0 {
+		return // LOGIT_LEVEL says what the level is
+	}
+	if level, isString := config["level"].(string); isString || config["level"] == nil {
+		if parsed, err := parseLevel(level, WARN); err == nil && parsed < DEBUG {
+			set("level", "DEBUG", ENV_DEBUG)
+		}
+	}
+}
+
+/*
+  sources
+  Where each setting came from: the file, an environment variable or,
+  for those the environment can set, the default
+*/
+func (doc *configDoc_t) sources() map[string]string {
+	sources := map[string]string{"level": SOURCE_DEFAULT, "filename": SOURCE_DEFAULT, "debugFlags": SOURCE_DEFAULT}
+	config, _ := doc.value.(map[string]interface{})
+	for key := range config {
+		sources[key] = SOURCE_FILE
+	}
+	for key, env := range doc.env {
+		sources[key] = env
+	}
+	return sources
+}
+
+/*
+  describeSources
+  The sources for the log, by setting
+*/
+func describeSources(sources map[string]string) []string {
+	keys := make([]string, 0, len(sources))
+	for key := range sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("setting:'%s', from:%s", key, sources[key])
+	}
+	return lines
+}
+
+/*
+  position
+  The line and column of an offset of the file, both from 1
+*/
+func position(raw []byte, offset int64) configPos_t {
+	pos := configPos_t{line: 1, column: 1}
+	for i := int64(0); i < offset && i < int64(len(raw)); i++ {
+		if raw[i] == '\n' {
+			pos.line++
+			pos.column = 1
+		} else {
+			pos.column++
+		}
+	}
+	return pos
+}
+
+/*
+  nextToken
+  The offset of the next token after 'offset', past the spaces, commas
+  and colons the decoder has not read yet
+*/
+func nextToken(raw []byte, offset int64) int64 {
+	for offset < int64(len(raw)) && strings.IndexByte(" \t\r\n,:", raw[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+/*
+  indexJSON
+  Record where every entry of a json config is, and report a syntax
+  error with where it is. A key that is given twice is a warning, the
+  last one is the one that is used.
+*/
+func indexJSON(raw []byte, doc *configDoc_t) bool {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	var walk func(path string) error
+	walk = func(path string) error {
+		start := nextToken(raw, dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, found := doc.index[path]; !found {
+			doc.index[path] = position(raw, start)
+		}
+		switch tok {
+		case json.Delim('{'):
+			seen := make(map[string]bool)
+			for dec.More() {
+				keyStart := nextToken(raw, dec.InputOffset())
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				member := joinConfigPath(path, key)
+				if seen[key] {
+					doc.add(position(raw, keyStart), member, CONFIG_WARNING, "given more than once, the last one is used")
+				}
+				seen[key] = true
+				doc.index[member] = position(raw, keyStart)
+				if err := walk(member); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+		return nil
+	}
+	err := walk("")
+	offset := dec.InputOffset()
+	if err == nil {
+		offset = nextToken(raw, offset)
+		if _, trailing := dec.Token(); trailing != io.EOF {
+			err = errors.New("there is more after the config object")
+		}
+	}
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset - 1 // the offset is after the character that is wrong
+		}
+		if err == io.ErrUnexpectedEOF || err == io.EOF || (syntaxErr != nil && syntaxErr.Offset >= int64(len(raw))) {
+			offset = int64(len(raw))
+			err = errors.New("the file ends before the config does")
+		}
+		doc.add(position(raw, offset), "", CONFIG_ERROR, "bad json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+/*
+  joinConfigPath
+  The path of a member of an object
+*/
+func joinConfigPath(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
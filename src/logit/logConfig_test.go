@@ -0,0 +1,223 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+  TestConfigFormats
+  The same config in json, yaml and toml is the same config, the
+  extension of the file says what it is in
+*/
+func TestConfigFormats(t *testing.T) {
+	jsonConfig := `{
+		"SiteID": "site 1",
+		"level": "DEBUG",
+		"ringSize": 100,
+		"maxFileSize": "10MB",
+		"compress": true,
+		"redactPatterns": [ "customer=(?P<secret>\\w+)", "a#b" ],
+		"debugFlags": [ { "pkg": "main" }, { "pkg": "logit", "file": "logConfig_test", "level": "TRACE" } ],
+		"xFlags": [ { "pkg": "main", "flags": [ "testShowHeaders", 4 ] } ],
+		"syslog": { "network": "udp" },
+		"sinks": [
+			{ "type": "stdout", "format": "json" },
+			{ "type": "url", "url": "http://logd:8080/logs", "pkgs": [ "main" ] }
+		],
+		"levelPolicy": { "FATAL": { "stack": "all", "action": "exit" } }
+	}`
+	yamlConfig := `---
+# the same config
+SiteID: "site 1"
+level: DEBUG   # more than INFO
+ringSize: 100
+maxFileSize: 10MB
+compress: true
+redactPatterns: [ 'customer=(?P<secret>\w+)', "a#b" ]
+debugFlags:
+- pkg: main
+- pkg: logit
+  file: logConfig_test
+  level: TRACE
+xFlags:
+  - { pkg: main, flags: [ testShowHeaders, 0x4 ] }
+syslog:
+  network: udp
+sinks:
+  - type: stdout
+    format: json
+  -
+    type: url
+    url: http://logd:8080/logs
+    pkgs: [ main ]
+levelPolicy:
+  FATAL: { stack: all,
+           action: exit }
+`
+	tomlConfig := `# the same config
+SiteID = "site 1"
+level = "DEBUG"
+ringSize = 1_00
+maxFileSize = '10MB'
+compress = true
+redactPatterns = [ 'customer=(?P<secret>\w+)',
+                   "a#b", ]
+debugFlags = [ { pkg = "main" }, { pkg = "logit", file = "logConfig_test", level = "TRACE" } ]
+xFlags = [ { pkg = "main", flags = [ "testShowHeaders", 0x4 ] } ]
+syslog.network = "udp"
+
+[[sinks]]
+type = "stdout"
+format = "json"
+
+[[sinks]]
+type = "url"
+url = "http://logd:8080/logs"
+pkgs = [ "main" ]
+
+[levelPolicy.FATAL]
+stack = "all"
+action = "exit"
+`
+	want := parseConfig(CONFIG_JSON, []byte(jsonConfig))
+	for _, test := range []struct {
+		fileName string
+		raw      string
+	}{
+		{"logitcfg.yaml", yamlConfig},
+		{"logitcfg.YML", yamlConfig},
+		{"logitcfg.toml", tomlConfig},
+		{"logitcfg.cfg", jsonConfig},
+	} {
+		doc := parseConfig(configFormat(test.fileName), []byte(test.raw))
+		if len(doc.problems) != 0 || !reflect.DeepEqual(doc.value, want.value) {
+			t.Errorf("Logit problem: %s is %v, %v", test.fileName, doc.value, doc.problems)
+		}
+		if problems := checkConfig(doc, nil); len(problems) != 0 {
+			t.Errorf("Logit problem: %s has %v", test.fileName, problems)
+		}
+	}
+}
+
+/*
+  TestConfigFormatProblems
+  Bad yaml and toml are one error, where they went wrong, and the
+  checks of a config have the lines and columns of the file
+*/
+func TestConfigFormatProblems(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		raw    string
+		want   string
+	}{
+		{CONFIG_YAML, "level: INFO\n\tringSize: 3", "2:1: error: bad yaml: tabs cannot indent yaml, use spaces"},
+		{CONFIG_YAML, "level: INFO\n  ringSize: 3", "2:3: error: bad yaml: this is indented more than the lines before it"},
+		{CONFIG_YAML, "level: 'INFO\n", "1:8: error: bad yaml: the quote is not closed"},
+		{CONFIG_YAML, "redact: |\n  password\n", "1:9: error: bad yaml: block scalars are not used in a config, use a quoted string"},
+		{CONFIG_YAML, "sinks:\n  - { type: stdout\n", "2:19: error: bad yaml: a ',' or '}' is missing"},
+		{CONFIG_YAML, "level INFO", "1:1: error: bad yaml: 'level INFO' is not 'key: value'"},
+		{CONFIG_YAML, "level: INFO\n---\nlevel: DEBUG", "2:1: error: bad yaml: a config is one document"},
+		{CONFIG_YAML, "level: INFO\nlevel: WARN\n", "2:1: warning: level: given more than once, the last one is used"},
+		{CONFIG_YAML, "sinks:\n- type: file\n  fromat: json\n", "3:3: warning: sinks[0].fromat: unknown field 'fromat', did you mean 'format'?"},
+		{CONFIG_YAML, "- level: INFO\n", "1:1: error: must be an object, not a list"},
+		{CONFIG_TOML, "level \"INFO\"", "1:7: error: bad toml: '=' is missing"},
+		{CONFIG_TOML, "when = 2024-01-01", "1:8: error: bad toml: dates are not used in a config, use a string"},
+		{CONFIG_TOML, "level = \"INFO", "1:9: error: bad toml: the string is not closed"},
+		{CONFIG_TOML, "level = INFO", "1:9: error: bad toml: 'INFO' is not a value, a string needs quotes"},
+		{CONFIG_TOML, "ringSize = [1 2]", "1:15: error: bad toml: a ',' or ']' is missing"},
+		{CONFIG_TOML, "ringSize = 1 2", "1:14: error: bad toml: the line goes on after its value"},
+		{CONFIG_TOML, "[sinks]\ntype = \"file\"\n[[sinks]]", "3:1: error: bad toml: 'sinks' is a table, not an array of tables"},
+		{CONFIG_TOML, "[syslog]\nnetwork = \"udp\"\n\n[syslog]\nappName = \"x\"", "4:1: warning: syslog: given more than once, the keys are merged"},
+		{CONFIG_TOML, "[[sinks]]\ntype = \"file\"\nlevel = \"eror\"", "3:1: error: sinks[0].level: unknown log level 'eror', use TRACE, DEBUG, INFO, WARN, ERROR or FATAL"},
+	} {
+		problems := checkConfig(parseConfig(test.format, []byte(test.raw)), nil)
+		if len(problems) != 1 || problems[0].String() != test.want {
+			t.Errorf("Logit problem: %s %q has %v, wanted '%s'", test.format, test.raw, problems, test.want)
+		}
+	}
+}
+
+/*
+  TestConfigEnv
+  LOGIT_LEVEL, LOGIT_FILENAME and LOGIT_DEBUG win over the file, when
+  the logger opens and when it reloads, and the log says where each
+  setting came from
+*/
+func TestConfigEnv(t *testing.T) {
+	registerMemorySink(t)
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.yaml")
+	yamlConfig := `
+level: INFO
+filename: ""
+debugFlags:
+  - pkg: main
+  - { pkg: logit, file: logOther, level: TRACE }
+sinks:
+  - type: memory
+    id: env
+  - { type: file, filename: file.txt }
+`
+	if err := writeConfigFile(configFileName, yamlConfig); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	t.Setenv(ENV_DEBUG, "logit:logConfig_test, logd")
+	t.Setenv(ENV_FILENAME, filepath.Join(dir, "env.txt"))
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	flags := l.getLogFlags()
+	if flags.logLevel != DEBUG || flags.logFileName != filepath.Join(dir, "env.txt") ||
+		!flags.dFlags["logit:logConfig_test"] || !flags.dFlags["logd"] || flags.dFlags["main"] ||
+		flags.levels["logit:logOther"] != TRACE {
+		t.Errorf("Logit problem: level %s, file '%s', dflags %v, levels %v", flags.logLevel, flags.logFileName,
+			flags.dFlags, flags.levels)
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Debug(&myFlags, "Debug from the environment")
+
+	writeConfigFile(configFileName, strings.Replace(yamlConfig, "level: INFO", "level: WARN", 1))
+	t.Setenv(ENV_LEVEL, "TRACE")
+	if err := l.Reload(); err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	if flags := l.getLogFlags(); flags.generation != 1 || flags.logLevel != TRACE || flags.sources["level"] != ENV_LEVEL {
+		t.Errorf("Logit problem: gen %d, level %s from %s", flags.generation, flags.logLevel, flags.sources["level"])
+	}
+	t.Setenv(ENV_LEVEL, "ERROR") // LOGIT_DEBUG does not raise it
+	if err := l.Reload(); err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	if flags := l.getLogFlags(); flags.logLevel != ERROR || flags.sources["level"] != ENV_LEVEL || flags.sources["debugFlags"] != ENV_DEBUG {
+		t.Errorf("Logit problem: level %s from %s, debugFlags from %s", flags.logLevel, flags.sources["level"], flags.sources["debugFlags"])
+	}
+	t.Setenv(ENV_LEVEL, "loud")
+	var configErr *ConfigError_t
+	if err := l.Reload(); !errors.As(err, &configErr) ||
+		!strings.HasPrefix(err.Error(), "config file '"+configFileName+"':LOGIT_LEVEL: error: level: unknown log level 'loud'") {
+		t.Errorf("Logit problem: the reload gave %v", err)
+	}
+	l.Close()
+
+	lines := strings.Join(getMemorySink(t, "env").getLines(), "\n")
+	for _, want := range []string{
+		"DBUG[logit:logConfig_test] Debug from the environment",
+		"Config settings:\n  setting:'debugFlags', from:LOGIT_DEBUG\n  setting:'filename', from:LOGIT_FILENAME\n" +
+			"  setting:'level', from:LOGIT_DEBUG\n  setting:'sinks', from:file",
+		"setting:'level', from:LOGIT_LEVEL",
+		"ERR[logit:log] LOGIT_LEVEL: error: level: unknown log level 'loud'",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("Logit problem: no '%s' in\n%s", want, lines)
+		}
+	}
+}
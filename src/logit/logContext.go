@@ -0,0 +1,493 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type contextKey_t int
+
+// the keys of what logit keeps in a context
+const (
+	requestIDKey contextKey_t = iota
+	traceParentKey
+)
+
+const RequestIDHeader = "X-Request-ID"  // the header the request ID comes and goes in
+const TraceParentHeader = "traceparent" // the W3C trace context header
+const maxRequestIDLength = 128          // a longer X-Request-ID is replaced
+
+// the field names of the correlation IDs in a record
+const (
+	RequestIDField = "requestId"
+	TraceIDField   = "traceId"
+	SpanIDField    = "spanId"
+)
+
+var requestIDCount uint64 // makes the IDs unique if there is no randomness
+
+/*
+  TraceParent_t
+  A W3C traceparent header, version-traceid-parentid-flags,
+  e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+*/
+type TraceParent_t struct {
+	Version  string // 2 hex digits, 00 for now
+	TraceID  string // 32 hex digits, the whole trace
+	ParentID string // 16 hex digits, the span of the caller
+	Flags    string // 2 hex digits, 01 is sampled
+}
+
+/*
+  String
+  The traceparent as its header value
+*/
+func (tp TraceParent_t) String() string {
+	return tp.Version + "-" + tp.TraceID + "-" + tp.ParentID + "-" + tp.Flags
+}
+
+/*
+  ParseTraceParent
+  Check and split a traceparent header. Upper case hex, all zero IDs
+  and the version ff are not valid. A later version may have more
+  fields after the flags, they are ignored.
+*/
+func ParseTraceParent(header string) (TraceParent_t, error) {
+	var tp TraceParent_t
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return tp, fmt.Errorf("traceparent '%s' does not have 4 fields", header)
+	}
+	tp = TraceParent_t{Version: parts[0], TraceID: parts[1], ParentID: parts[2], Flags: parts[3]}
+	if !isLowerHex(tp.Version, 2) || tp.Version == "ff" || (tp.Version == "00" && len(parts) != 4) {
+		return tp, fmt.Errorf("traceparent '%s' has a bad version", header)
+	}
+	if !isLowerHex(tp.TraceID, 32) || tp.TraceID == strings.Repeat("0", 32) {
+		return tp, fmt.Errorf("traceparent '%s' has a bad trace id", header)
+	}
+	if !isLowerHex(tp.ParentID, 16) || tp.ParentID == strings.Repeat("0", 16) {
+		return tp, fmt.Errorf("traceparent '%s' has a bad parent id", header)
+	}
+	if !isLowerHex(tp.Flags, 2) {
+		return tp, fmt.Errorf("traceparent '%s' has bad flags", header)
+	}
+	return tp, nil
+}
+
+/*
+  isLowerHex
+  Check that 's' is 'size' lower case hex digits
+*/
+func isLowerHex(s string, size int) bool {
+	if len(s) != size {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+  NewRequestID
+  A new random request ID, 32 hex digits
+*/
+func NewRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil { // unlikely, still unique in this process
+		return fmt.Sprintf("%016x%016x", time.Now().UnixNano(), atomic.AddUint64(&requestIDCount, 1))
+	}
+	return hex.EncodeToString(id)
+}
+
+/*
+  validRequestID
+  Check that a request ID from a client can be logged as is: not too
+  long, printable ascii, no spaces or quotes
+*/
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+  WithRequestID
+  A context that carries the request ID
+*/
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+/*
+  RequestID
+  The request ID of the context, empty if it has none
+*/
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+/*
+  WithTraceParent
+  A context that carries the traceparent
+*/
+func WithTraceParent(ctx context.Context, tp TraceParent_t) context.Context {
+	return context.WithValue(ctx, traceParentKey, tp)
+}
+
+/*
+  TraceParent
+  The traceparent of the context, false if it has none
+*/
+func TraceParent(ctx context.Context) (TraceParent_t, bool) {
+	if ctx == nil {
+		return TraceParent_t{}, false
+	}
+	tp, found := ctx.Value(traceParentKey).(TraceParent_t)
+	return tp, found
+}
+
+/*
+  contextFields
+  The request ID and the trace of the context as fields, nil if it
+  has neither
+*/
+func contextFields(ctx context.Context) []Field_t {
+	var fields []Field_t
+	if id := RequestID(ctx); len(id) > 0 {
+		fields = append(fields, Field_t{Key: RequestIDField, Value: id})
+	}
+	if tp, found := TraceParent(ctx); found {
+		fields = append(fields, Field_t{Key: TraceIDField, Value: tp.TraceID}, Field_t{Key: SpanIDField, Value: tp.ParentID})
+	}
+	return fields
+}
+
+/*
+  hasField
+  Check if one of the fields is key=value
+*/
+func hasField(fields []Field_t, key string, value string) bool {
+	for _, field := range fields {
+		if field.Key == key && fieldString(field.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  RequestIDMiddleware
+  Give every request an ID: the X-Request-ID of the client if it can
+  be logged as is, a new one if not. The ID goes back in the response
+  header, it and a valid traceparent go in the context of the request
+  for the ...Ctx functions and WithContext.
+  e.g. router.Use(logit.RequestIDMiddleware)
+*/
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+		if header := r.Header.Get(TraceParentHeader); len(header) > 0 {
+			if tp, err := ParseTraceParent(header); err == nil {
+				ctx = WithTraceParent(ctx, tp)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/*
+  WithContext
+  A child of the default logger that logs the request ID and the
+  trace of the context with every message
+*/
+func WithContext(ctx context.Context, flags *DFlags_t) *Entry_t {
+	return &Entry_t{flags: flags, fields: contextFields(ctx)}
+}
+
+/*
+  WithContext
+  A child of this logger that logs the request ID and the trace of
+  the context with every message
+*/
+func (l *Logger) WithContext(ctx context.Context, flags *DFlags_t) *Entry_t {
+	return &Entry_t{logger: l, flags: flags, fields: contextFields(ctx)}
+}
+
+/*
+  WithContext
+  A child that logs the request ID and the trace of the context as well
+*/
+func (e *Entry_t) WithContext(ctx context.Context) *Entry_t {
+	fields := e.fields
+	for _, field := range contextFields(ctx) {
+		fields = addFields(fields, []interface{}{field.Key, field.Value})
+	}
+	return &Entry_t{logger: e.logger, flags: e.flags, fields: fields}
+}
+
+/*
+  logCtx
+  Log the message with the fields of the context if the level is on.
+  The message and the fields are only built when it is logged.
+*/
+func (l *Logger) logCtx(ctx context.Context, level logLevel_t, xflag int32, flags *DFlags_t, str string, args []interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	if !l.levelOn(level, xflag, &f) {
+		return
+	}
+	if args != nil {
+		str = fmt.Sprintf(str, args...)
+	}
+	l.logMsg(level, xflag, &f, contextFields(ctx), str)
+}
+
+/*
+  FatalCtx
+  log the fatal messages, which are always enabled, with the IDs of the context
+*/
+func (l *Logger) FatalCtx(ctx context.Context, flags *DFlags_t, str string) {
+	l.logCtx(ctx, FATAL, 0, flags, str, nil)
+}
+
+/*
+  FatalfCtx
+  Build the message and log the fatal messages, with the IDs of the context
+*/
+func (l *Logger) FatalfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, FATAL, 0, flags, str, args)
+}
+
+/*
+  ErrorCtx
+  log the error messages if enabled, with the IDs of the context
+*/
+func (l *Logger) ErrorCtx(ctx context.Context, flags *DFlags_t, str string) {
+	l.logCtx(ctx, ERROR, 0, flags, str, nil)
+}
+
+/*
+  ErrorfCtx
+  Build the message and log the error messages if enabled, with the IDs of the context
+*/
+func (l *Logger) ErrorfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, ERROR, 0, flags, str, args)
+}
+
+/*
+  WarnCtx
+  log the warning messages if enabled, with the IDs of the context
+*/
+func (l *Logger) WarnCtx(ctx context.Context, flags *DFlags_t, str string) {
+	l.logCtx(ctx, WARN, 0, flags, str, nil)
+}
+
+/*
+  WarnfCtx
+  Build the message and log the warning messages if enabled, with the IDs of the context
+*/
+func (l *Logger) WarnfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, WARN, 0, flags, str, args)
+}
+
+/*
+  InfoCtx
+  log the info messages if enabled, with the IDs of the context
+*/
+func (l *Logger) InfoCtx(ctx context.Context, flags *DFlags_t, str string) {
+	l.logCtx(ctx, INFO, 0, flags, str, nil)
+}
+
+/*
+  InfofCtx
+  Build the message and log the info messages if enabled, with the IDs of the context
+*/
+func (l *Logger) InfofCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, INFO, 0, flags, str, args)
+}
+
+/*
+  DebugCtx
+  log the debug message if enabled, with the IDs of the context
+*/
+func (l *Logger) DebugCtx(ctx context.Context, flags *DFlags_t, str string) {
+	l.logCtx(ctx, DEBUG, 0, flags, str, nil)
+}
+
+/*
+  DebugxCtx
+  log the debug message if enabled by an xflag, with the IDs of the context
+*/
+func (l *Logger) DebugxCtx(ctx context.Context, xflag int32, flags *DFlags_t, str string) {
+	l.logCtx(ctx, DEBUG, xflag, flags, str, nil)
+}
+
+/*
+  DebugfCtx
+  Build the message and log the debug message if enabled, with the IDs of the context
+*/
+func (l *Logger) DebugfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, DEBUG, 0, flags, str, args)
+}
+
+/*
+  DebugfxCtx
+  Build and log the debug message if enabled by an xflag, with the IDs of the context
+*/
+func (l *Logger) DebugfxCtx(ctx context.Context, xflag int32, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, DEBUG, xflag, flags, str, args)
+}
+
+/*
+  TraceCtx
+  log the trace message if the level is TRACE, with the IDs of the context
+*/
+func (l *Logger) TraceCtx(ctx context.Context, flags *DFlags_t, str string) {
+	l.logCtx(ctx, TRACE, 0, flags, str, nil)
+}
+
+/*
+  TracefCtx
+  Build and log the trace message if the level is TRACE, with the IDs of the context
+*/
+func (l *Logger) TracefCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	l.logCtx(ctx, TRACE, 0, flags, str, args)
+}
+
+/*
+  FatalCtx
+  log the fatal messages of the default logger, with the IDs of the context
+*/
+func FatalCtx(ctx context.Context, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, FATAL, 0, flags, str, nil)
+}
+
+/*
+  FatalfCtx
+  Build and log the fatal messages of the default logger, with the IDs of the context
+*/
+func FatalfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, FATAL, 0, flags, str, args)
+}
+
+/*
+  ErrorCtx
+  log the error messages of the default logger, with the IDs of the context
+*/
+func ErrorCtx(ctx context.Context, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, ERROR, 0, flags, str, nil)
+}
+
+/*
+  ErrorfCtx
+  Build and log the error messages of the default logger, with the IDs of the context
+*/
+func ErrorfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, ERROR, 0, flags, str, args)
+}
+
+/*
+  WarnCtx
+  log the warning messages of the default logger, with the IDs of the context
+*/
+func WarnCtx(ctx context.Context, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, WARN, 0, flags, str, nil)
+}
+
+/*
+  WarnfCtx
+  Build and log the warning messages of the default logger, with the IDs of the context
+*/
+func WarnfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, WARN, 0, flags, str, args)
+}
+
+/*
+  InfoCtx
+  log the info messages of the default logger, with the IDs of the context
+*/
+func InfoCtx(ctx context.Context, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, INFO, 0, flags, str, nil)
+}
+
+/*
+  InfofCtx
+  Build and log the info messages of the default logger, with the IDs of the context
+*/
+func InfofCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, INFO, 0, flags, str, args)
+}
+
+/*
+  DebugCtx
+  log the debug message of the default logger, with the IDs of the context
+*/
+func DebugCtx(ctx context.Context, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, DEBUG, 0, flags, str, nil)
+}
+
+/*
+  DebugxCtx
+  log the debug message of the default logger if enabled by an xflag,
+  with the IDs of the context
+*/
+func DebugxCtx(ctx context.Context, xflag int32, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, DEBUG, xflag, flags, str, nil)
+}
+
+/*
+  DebugfCtx
+  Build and log the debug message of the default logger, with the IDs of the context
+*/
+func DebugfCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, DEBUG, 0, flags, str, args)
+}
+
+/*
+  DebugfxCtx
+  Build and log the debug message of the default logger if enabled by
+  an xflag, with the IDs of the context
+*/
+func DebugfxCtx(ctx context.Context, xflag int32, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, DEBUG, xflag, flags, str, args)
+}
+
+/*
+  TraceCtx
+  log the trace message of the default logger, with the IDs of the context
+*/
+func TraceCtx(ctx context.Context, flags *DFlags_t, str string) {
+	Default().logCtx(ctx, TRACE, 0, flags, str, nil)
+}
+
+/*
+  TracefCtx
+  Build and log the trace message of the default logger, with the IDs of the context
+*/
+func TracefCtx(ctx context.Context, flags *DFlags_t, str string, args ...interface{}) {
+	Default().logCtx(ctx, TRACE, 0, flags, str, args)
+}
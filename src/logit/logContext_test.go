@@ -0,0 +1,143 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+/*
+  TestParseTraceParent
+  A valid traceparent is split, bad ones are not accepted
+*/
+func TestParseTraceParent(t *testing.T) {
+	tp, err := ParseTraceParent(testTraceParent)
+	if err != nil || tp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || tp.ParentID != "00f067aa0ba902b7" || tp.Flags != "01" {
+		t.Errorf("Logit problem: %+v, %v", tp, err)
+	}
+	if tp.String() != testTraceParent {
+		t.Errorf("Logit problem: traceparent '%s'", tp.String())
+	}
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-later"); err != nil {
+		t.Errorf("Logit problem: a later version %v", err)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(bad); err == nil {
+			t.Errorf("Logit problem: '%s' was accepted", bad)
+		}
+	}
+}
+
+/*
+  serveWithRequestID
+  Send a request with these headers through the middleware, returns
+  the context the handler got and the response
+*/
+func serveWithRequestID(headers map[string]string) (context.Context, *httptest.ResponseRecorder) {
+	var ctx context.Context
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return ctx, w
+}
+
+/*
+  TestRequestIDMiddleware
+  The ID of the client is kept, a missing or bad one is replaced, the
+  response has it, a valid traceparent gets into the context
+*/
+func TestRequestIDMiddleware(t *testing.T) {
+	ctx, w := serveWithRequestID(map[string]string{RequestIDHeader: "abc-123", TraceParentHeader: testTraceParent})
+	if RequestID(ctx) != "abc-123" || w.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Errorf("Logit problem: request ID '%s', response '%s'", RequestID(ctx), w.Header().Get(RequestIDHeader))
+	}
+	if tp, found := TraceParent(ctx); !found || tp.String() != testTraceParent {
+		t.Errorf("Logit problem: traceparent %+v", tp)
+	}
+
+	ctx, w = serveWithRequestID(map[string]string{RequestIDHeader: "has space", TraceParentHeader: "junk"})
+	id := RequestID(ctx)
+	if len(id) != 32 || !isLowerHex(id, 32) || w.Header().Get(RequestIDHeader) != id {
+		t.Errorf("Logit problem: request ID '%s', response '%s'", id, w.Header().Get(RequestIDHeader))
+	}
+	if _, found := TraceParent(ctx); found {
+		t.Errorf("Logit problem: a bad traceparent is in the context")
+	}
+	if other, _ := serveWithRequestID(nil); RequestID(other) == id || len(RequestID(other)) == 0 {
+		t.Errorf("Logit problem: request IDs '%s' and '%s'", id, RequestID(other))
+	}
+}
+
+/*
+  TestLogContext
+  The ...Ctx functions and WithContext log the IDs of the context, the
+  ring can list the records of one request
+*/
+func TestLogContext(t *testing.T) {
+	registerMemorySink(t)
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	jsonTest := `
+	{
+		"level": "INFO",
+		"ringSize": 10,
+		"sinks": [ { "type": "memory", "id": "context", "pkgs": [ "logit:logContext_test" ] } ]
+	}`
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	tp, _ := ParseTraceParent(testTraceParent)
+	ctx := WithTraceParent(WithRequestID(context.Background(), "req-1"), tp)
+	l.InfofCtx(ctx, &myFlags, "Request %d", 1)
+	l.WithContext(ctx, &myFlags).With("user", "jeff").Warn("Slow request")
+	l.With(&myFlags, "user", "bob").WithContext(WithRequestID(context.Background(), "req-2")).Info("Other request")
+	l.InfoCtx(context.Background(), &myFlags, "No request")
+	w := httptest.NewRecorder()
+	l.RingHandler().ServeHTTP(w, httptest.NewRequest("GET", "/logs?requestId=req-1&pkg=logit:logContext_test", nil))
+	l.Close()
+
+	lines := getMemorySink(t, "context").getLines()
+	wants := []string{
+		"INFO[logit:logContext_test] Request 1 requestId=req-1 traceId=4bf92f3577b34da6a3ce929d0e0e4736 spanId=00f067aa0ba902b7",
+		"WARN[logit:logContext_test] Slow request requestId=req-1 traceId=4bf92f3577b34da6a3ce929d0e0e4736 spanId=00f067aa0ba902b7 user=jeff",
+		"INFO[logit:logContext_test] Other request user=bob requestId=req-2",
+		"INFO[logit:logContext_test] No request",
+	}
+	if len(lines) != len(wants) {
+		t.Fatalf("Logit problem: the sink got %q", lines)
+	}
+	for i, want := range wants {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("Logit problem: line '%s', wanted '%s'", lines[i], want)
+		}
+	}
+	if ring := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(ring) != 2 || !strings.HasSuffix(ring[1], wants[1]) {
+		t.Errorf("Logit problem: the ring of req-1 has %q", ring)
+	}
+}
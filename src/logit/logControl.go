@@ -0,0 +1,455 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ALL_OVERRIDE = "all" // the target of an override for every package
+
+/*
+  Override_t
+  A change of the level, debug flag or xflags of a package, a
+  package:file or "all", made at runtime without the config file.
+  Empty fields are not changed. The strings are the same as in the
+  config: level "DEBUG", xFlag "0x3" or "showEndpoint,showRequestHdrs",
+  ttl "15m". Without a ttl the
+  override stays until it is reverted, a reload of the config keeps it.
+*/
+type Override_t struct {
+	Target  string     `json:"target"`
+	Level   string     `json:"level,omitempty"`
+	DFlag   *bool      `json:"dFlag,omitempty"`
+	XFlag   string     `json:"xFlag,omitempty"`
+	TTL     string     `json:"ttl,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"` // when a ttl reverts it, set by the logger
+}
+
+/*
+  FlagsInfo_t
+  The flags a package:file, or a package, logs with right now
+*/
+type FlagsInfo_t struct {
+	Target     string   `json:"target"`
+	Level      string   `json:"level"`
+	DFlag      bool     `json:"dFlag"`
+	XFlag      string   `json:"xFlag"`
+	XFlagNames []string `json:"xFlagNames,omitempty"` // the registered names of the bits that are set
+	Overridden bool     `json:"overridden"`           // an override changes them
+}
+
+// override_t is an Override_t as the logger uses it
+type override_t struct {
+	pkg      string // the package of the target, "" for all, it names the xflag bits
+	level    logLevel_t
+	hasLevel bool
+	dFlag    bool
+	hasDFlag bool
+	xFlag    int32
+	hasXFlag bool
+	ttl      time.Duration
+	expires  time.Time   // zero if there is no ttl
+	timer    *time.Timer // reverts it at 'expires'
+}
+
+/*
+  parseOverride
+  Check an Override_t and turn it into the override
+*/
+func parseOverride(o Override_t) (override_t, error) {
+	var parsed override_t
+	target := strings.TrimSpace(o.Target)
+	if len(target) == 0 || strings.Count(target, ":") > 1 || strings.HasPrefix(target, ":") || strings.HasSuffix(target, ":") {
+		return parsed, fmt.Errorf("target '%s' is not all, a package or a package:file", o.Target)
+	}
+	if target != ALL_OVERRIDE {
+		parsed.pkg = targetPkg(target)
+	}
+	var err error
+	if len(o.Level) > 0 {
+		parsed.level, err = parseLevel(o.Level, INFO)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.hasLevel = true
+	}
+	if o.DFlag != nil {
+		parsed.dFlag = *o.DFlag
+		parsed.hasDFlag = true
+	}
+	if len(o.XFlag) > 0 {
+		value, unknown, err := parseXFlags(parsed.pkg, o.XFlag)
+		if err != nil {
+			return parsed, err
+		}
+		if len(unknown) > 0 {
+			return parsed, fmt.Errorf("xFlag '%s' is not a number or registered names, registered are %s",
+				o.XFlag, strings.Join(registeredXFlags(parsed.pkg), ", "))
+		}
+		parsed.xFlag = value
+		parsed.hasXFlag = true
+	}
+	if !parsed.hasLevel && !parsed.hasDFlag && !parsed.hasXFlag {
+		return parsed, errors.New("an override needs a level, a dFlag or an xFlag")
+	}
+	if len(o.TTL) > 0 {
+		parsed.ttl, err = time.ParseDuration(o.TTL)
+		if err != nil || parsed.ttl <= 0 {
+			return parsed, fmt.Errorf("ttl '%s' is not a duration", o.TTL)
+		}
+	}
+	return parsed, nil
+}
+
+/*
+  export
+  The override as an Override_t
+*/
+func (o *override_t) export(target string) Override_t {
+	e := Override_t{Target: target}
+	if o.hasLevel {
+		e.Level = o.level.String()
+	}
+	if o.hasDFlag {
+		dFlag := o.dFlag
+		e.DFlag = &dFlag
+	}
+	if o.hasXFlag {
+		e.XFlag = fmt.Sprintf("0x%x", o.xFlag)
+	}
+	if o.ttl > 0 {
+		e.TTL = o.ttl.String()
+		expires := o.expires
+		e.Expires = &expires
+	}
+	return e
+}
+
+/*
+  describe
+  The override for the log
+*/
+func (o *override_t) describe() string {
+	var parts []string
+	if o.hasLevel {
+		parts = append(parts, "level "+o.level.String())
+	}
+	if o.hasDFlag {
+		parts = append(parts, fmt.Sprintf("dFlag %t", o.dFlag))
+	}
+	if o.hasXFlag {
+		parts = append(parts, "xFlag "+describeXFlags(o.pkg, o.xFlag))
+	}
+	if o.ttl > 0 {
+		parts = append(parts, "until "+o.expires.Format(time.RFC3339))
+	}
+	return strings.Join(parts, ", ")
+}
+
+/*
+  withOverrides
+  The flags of the config file with the overrides on top.
+  The caller holds reloadMutex.
+*/
+func (l *Logger) withOverrides(base *logFlags_t) *logFlags_t {
+	if len(l.overrides) == 0 {
+		return base
+	}
+	flags := *base
+	flags.overrides = make(map[string]override_t, len(l.overrides))
+	for target, o := range l.overrides {
+		flags.overrides[target] = *o
+		if o.hasLevel && o.level > flags.maxLevel {
+			flags.maxLevel = o.level
+		}
+		if o.hasDFlag && o.dFlag && flags.maxLevel < DEBUG {
+			flags.maxLevel = DEBUG
+		}
+	}
+	return &flags
+}
+
+/*
+  applyOverrides
+  Switch to a new generation of the flags with the overrides as they
+  are now, so every DFlags_t reloads. Returns the new generation.
+  The caller holds reloadMutex.
+*/
+func (l *Logger) applyOverrides() int32 {
+	base := *l.baseFlags
+	base.generation = l.getLogFlags().generation + 1
+	l.baseFlags = &base
+	l.flags.Store(l.withOverrides(&base))
+	dxMutex.Lock()
+	l.getLogDXFlags(&l.myFlags)
+	dxMutex.Unlock()
+	return base.generation
+}
+
+/*
+  SetOverride
+  Override the flags of a target until it is reverted or its ttl is
+  over. It replaces an override of the same target.
+  Returns the new generation.
+  e.g. logger.SetOverride(logit.Override_t{Target: "main:server", Level: "DEBUG", TTL: "15m"})
+*/
+func (l *Logger) SetOverride(o Override_t) (int32, error) {
+	parsed, err := parseOverride(o)
+	if err != nil {
+		return l.Generation(), err
+	}
+	target := strings.TrimSpace(o.Target)
+	l.reloadMutex.Lock()
+	if l.overrides == nil {
+		l.overrides = make(map[string]*override_t)
+	}
+	if old := l.overrides[target]; old != nil && old.timer != nil {
+		old.timer.Stop()
+	}
+	if parsed.ttl > 0 {
+		expires := time.Now().Add(parsed.ttl)
+		parsed.expires = expires
+		parsed.timer = time.AfterFunc(parsed.ttl, func() { l.expireOverride(target, expires) })
+	}
+	l.overrides[target] = &parsed
+	generation := l.applyOverrides()
+	l.Infof(&l.myFlags, "Override of '%s': %s, gen %d.", target, parsed.describe(), generation)
+	l.reloadMutex.Unlock()
+	l.callReloadCallbacks(generation) // a callback can set or revert overrides
+	return generation, nil
+}
+
+/*
+  RevertOverride
+  Remove the override of a target, it logs with the flags of the config
+  file again. Returns the new generation.
+*/
+func (l *Logger) RevertOverride(target string) (int32, error) {
+	target = strings.TrimSpace(target)
+	l.reloadMutex.Lock()
+	o := l.overrides[target]
+	if o == nil {
+		l.reloadMutex.Unlock()
+		return l.getLogFlags().generation, fmt.Errorf("there is no override of '%s'", target)
+	}
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	delete(l.overrides, target)
+	generation := l.applyOverrides()
+	l.Infof(&l.myFlags, "Override of '%s' reverted to the config file, gen %d.", target, generation)
+	l.reloadMutex.Unlock()
+	l.callReloadCallbacks(generation)
+	return generation, nil
+}
+
+/*
+  RevertOverrides
+  Remove all the overrides. Returns the generation, a new one only if
+  there were overrides.
+*/
+func (l *Logger) RevertOverrides() int32 {
+	l.reloadMutex.Lock()
+	if len(l.overrides) == 0 {
+		l.reloadMutex.Unlock()
+		return l.getLogFlags().generation
+	}
+	for _, o := range l.overrides {
+		if o.timer != nil {
+			o.timer.Stop()
+		}
+	}
+	l.overrides = nil
+	generation := l.applyOverrides()
+	l.Infof(&l.myFlags, "All overrides reverted to the config file, gen %d.", generation)
+	l.reloadMutex.Unlock()
+	l.callReloadCallbacks(generation)
+	return generation
+}
+
+/*
+  expireOverride
+  The ttl of an override is over, unless it was replaced since or the
+  logger is closed
+*/
+func (l *Logger) expireOverride(target string, expires time.Time) {
+	l.reloadMutex.Lock()
+	o := l.overrides[target]
+	if l.closed || o == nil || !o.expires.Equal(expires) {
+		l.reloadMutex.Unlock()
+		return
+	}
+	delete(l.overrides, target)
+	generation := l.applyOverrides()
+	l.Infof(&l.myFlags, "Override of '%s' expired, gen %d.", target, generation)
+	l.reloadMutex.Unlock()
+	l.callReloadCallbacks(generation)
+}
+
+/*
+  stopOverrideTimers
+  No override expires after Close. A timer that already fired cannot be
+  stopped, it finds the logger closed.
+*/
+func (l *Logger) stopOverrideTimers() {
+	l.reloadMutex.Lock()
+	defer l.reloadMutex.Unlock()
+	l.closed = true
+	for _, o := range l.overrides {
+		if o.timer != nil {
+			o.timer.Stop()
+		}
+	}
+}
+
+/*
+  Overrides
+  The overrides in use, by target
+*/
+func (l *Logger) Overrides() []Override_t {
+	return exportOverrides(l.getLogFlags().overrides)
+}
+
+/*
+  exportOverrides
+  The overrides of the flags as Override_t, by target
+*/
+func exportOverrides(overrides map[string]override_t) []Override_t {
+	list := make([]Override_t, 0, len(overrides))
+	for target, o := range overrides {
+		list = append(list, o.export(target))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+	return list
+}
+
+/*
+  EffectiveFlags
+  The flags every package:file that logged so far logs with, and the
+  packages and files of the config and of the overrides
+*/
+func (l *Logger) EffectiveFlags() []FlagsInfo_t {
+	flags := l.getLogFlags()
+	targets := make(map[string]bool)
+	dxMutex.Lock()
+	for target := range l.callers {
+		targets[target] = true
+	}
+	dxMutex.Unlock()
+	for target := range flags.levels {
+		targets[target] = true
+	}
+	for target := range flags.dFlags {
+		targets[target] = true
+	}
+	for target := range flags.xFlags {
+		targets[target] = true
+	}
+	for target := range flags.overrides {
+		if target != ALL_OVERRIDE {
+			targets[target] = true
+		}
+	}
+	list := make([]FlagsInfo_t, 0, len(targets))
+	for target := range targets {
+		pkgName, fileName := target, ""
+		if i := strings.Index(target, ":"); i >= 0 {
+			pkgName, fileName = target[:i], target[i+1:]
+		}
+		level, dFlag, xFlag := resolveDXFlags(flags, pkgName, fileName)
+		_, all := flags.overrides[ALL_OVERRIDE]
+		_, pkg := flags.overrides[pkgName]
+		_, file := flags.overrides[target]
+		list = append(list, FlagsInfo_t{Target: target, Level: level.String(), DFlag: dFlag,
+			XFlag: fmt.Sprintf("0x%x", xFlag), XFlagNames: xFlagNames(pkgName, xFlag), Overridden: all || pkg || file})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+	return list
+}
+
+/*
+  logTheOverrides
+  Log the overrides of the control API, if there are any
+*/
+func (l *Logger) logTheOverrides(flags *logFlags_t) {
+	if len(flags.overrides) == 0 {
+		return
+	}
+	targets := make([]string, 0, len(flags.overrides))
+	for target := range flags.overrides {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	overrideMsg := "Overrides of the config file:"
+	for _, target := range targets {
+		o := flags.overrides[target]
+		overrideMsg += fmt.Sprintf("\n  override:'%s', %s", target, o.describe())
+	}
+	l.Info(&l.myFlags, overrideMsg)
+}
+
+// controlState_t is what the control handler answers with
+type controlState_t struct {
+	Generation int32         `json:"generation"`
+	Flags      []FlagsInfo_t `json:"flags"`
+	Overrides  []Override_t  `json:"overrides"`
+}
+
+/*
+  ControlHandler
+  An http.Handler for the overrides:
+  GET lists the effective flags and the overrides,
+  POST sets the override of the json Override_t in the body,
+  DELETE ?target=main:server reverts one, DELETE without a target all.
+  Every answer is the json of the flags and the overrides.
+  It changes what is logged, so mount it where only admins can get to it.
+*/
+func (l *Logger) ControlHandler() http.Handler {
+	return http.HandlerFunc(l.serveControl)
+}
+
+/*
+  serveControl
+  The control handler
+*/
+func (l *Logger) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		var o Override_t
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&o); err != nil {
+			http.Error(w, "bad override: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := l.SetOverride(o); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		if len(target) == 0 {
+			l.RevertOverrides()
+		} else if _, err := l.RevertOverride(target); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Only GET, POST and DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	flags := l.getLogFlags()
+	state := controlState_t{Generation: flags.generation, Flags: l.EffectiveFlags(),
+		Overrides: exportOverrides(flags.overrides)}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(state)
+}
@@ -0,0 +1,272 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  findFlags
+  The effective flags of a target
+*/
+func findFlags(l *Logger, target string) *FlagsInfo_t {
+	for _, info := range l.EffectiveFlags() {
+		if info.Target == target {
+			return &info
+		}
+	}
+	return nil
+}
+
+/*
+  TestParseOverride
+  An override needs a target and something to change
+*/
+func TestParseOverride(t *testing.T) {
+	on := true
+	for _, o := range []Override_t{
+		{Level: "DEBUG"},
+		{Target: "main:server:x", Level: "DEBUG"},
+		{Target: ":server", Level: "DEBUG"},
+		{Target: "main"},
+		{Target: "main", Level: "LOUD"},
+		{Target: "main", XFlag: "lots"},
+		{Target: "main", DFlag: &on, TTL: "soon"},
+		{Target: "main", DFlag: &on, TTL: "-1m"},
+	} {
+		if _, err := parseOverride(o); err == nil {
+			t.Errorf("Logit problem: override %+v was accepted", o)
+		}
+	}
+	parsed, err := parseOverride(Override_t{Target: "main:server", Level: "trace", XFlag: "0x6", TTL: "15m"})
+	if err != nil || !parsed.hasLevel || parsed.level != TRACE || parsed.hasDFlag || parsed.xFlag != 6 || parsed.ttl != time.Minute*15 {
+		t.Errorf("Logit problem: parsed %+v, %v", parsed, err)
+	}
+}
+
+/*
+  TestOverride
+  An override turns on debug, a reload keeps it, a revert goes back to
+  the config file. Every change is a new generation.
+*/
+func TestOverride(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"xFlags": [ { "pkg": "logit", "file": "logControl_test", "flags": "0x1" } ],
+		"sinks": [ { "type": "memory", "id": "override", "pkgs": [ "logit:logControl_test" ] } ]`)
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Debug(&myFlags, "Debug before the override")
+
+	generation, err := l.SetOverride(Override_t{Target: "logit:logControl_test", Level: "DEBUG"})
+	if err != nil || generation != 1 {
+		t.Fatalf("Logit problem: generation %d, %v", generation, err)
+	}
+	l.Debug(&myFlags, "Debug with the override")
+	if info := findFlags(l, "logit:logControl_test"); info == nil || info.Level != "DEBUG" || !info.DFlag || !info.Overridden {
+		t.Errorf("Logit problem: effective flags %+v", info)
+	}
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.Debug(&myFlags, "Debug after a reload")
+	if overrides := l.Overrides(); len(overrides) != 1 || overrides[0].Level != "DEBUG" {
+		t.Errorf("Logit problem: the reload lost the override, %+v", overrides)
+	}
+
+	generation, err = l.RevertOverride("logit:logControl_test")
+	if err != nil || generation != 3 {
+		t.Errorf("Logit problem: generation %d, %v", generation, err)
+	}
+	l.Debug(&myFlags, "Debug after the revert")
+	if _, err := l.RevertOverride("logit:logControl_test"); err == nil {
+		t.Errorf("Logit problem: an override was reverted twice")
+	}
+	if info := findFlags(l, "logit:logControl_test"); info == nil || info.Level != "INFO" || info.DFlag || info.Overridden {
+		t.Errorf("Logit problem: effective flags %+v", info)
+	}
+	l.Close()
+
+	lines := strings.Join(getMemorySink(t, "override").getLines(), "\n")
+	if strings.Contains(lines, "before the override") || strings.Contains(lines, "after the revert") ||
+		!strings.Contains(lines, "Debug with the override") || !strings.Contains(lines, "Debug after a reload") {
+		t.Errorf("Logit problem: the sink got\n%s", lines)
+	}
+}
+
+/*
+  TestOverrideXFlag
+  An xFlag override replaces the xflags of the config, one for all
+  loses to one for the file
+*/
+func TestOverrideXFlag(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"xFlags": [ { "pkg": "logit", "file": "logControl_test", "flags": "0x1" } ],
+		"sinks": [ { "type": "memory", "id": "xflag", "pkgs": [ "logit:logControl_test" ] } ]`)
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	off := false
+	l.SetOverride(Override_t{Target: "logit:logControl_test", XFlag: "0x4"})
+	l.SetOverride(Override_t{Target: ALL_OVERRIDE, XFlag: "0x8", DFlag: &off})
+	l.Debugx(0x1, &myFlags, "Xflag of the config")
+	l.Debugx(0x4, &myFlags, "Xflag of the override")
+	l.Debugx(0x8, &myFlags, "Xflag of all")
+	l.RevertOverrides()
+	l.Debugx(0x1, &myFlags, "Xflag of the config again")
+	l.Close()
+
+	lines := strings.Join(getMemorySink(t, "xflag").getLines(), "\n")
+	if strings.Contains(lines, "Xflag of the config\n") || strings.Contains(lines, "Xflag of all") ||
+		!strings.Contains(lines, "Xflag of the override") || !strings.Contains(lines, "Xflag of the config again") {
+		t.Errorf("Logit problem: the sink got\n%s", lines)
+	}
+}
+
+/*
+  TestOverrideTTL
+  An override with a ttl reverts itself
+*/
+func TestOverrideTTL(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"xFlags": [ { "pkg": "logit", "file": "logControl_test", "flags": "0x1" } ],
+		"sinks": [ { "type": "memory", "id": "ttl", "pkgs": [ "logit:logControl_test" ] } ]`)
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	defer l.Close()
+	reloaded := make(chan int32, 4)
+	l.OnReload(func(generation int32) { reloaded <- generation })
+	on := true
+	if _, err := l.SetOverride(Override_t{Target: "logit", DFlag: &on, TTL: "50ms"}); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if overrides := l.Overrides(); len(overrides) != 1 || overrides[0].Expires == nil {
+		t.Errorf("Logit problem: overrides %+v", overrides)
+	}
+	for _, expected := range []int32{1, 2} {
+		select {
+		case generation := <-reloaded:
+			if generation != expected {
+				t.Errorf("Logit problem: generation %d, expected %d", generation, expected)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Logit problem: no generation %d", expected)
+		}
+	}
+	if overrides := l.Overrides(); len(overrides) != 0 {
+		t.Errorf("Logit problem: the override did not expire, %+v", overrides)
+	}
+}
+
+/*
+  TestOverrideInCallback
+  An OnReload function can set and revert overrides, a reload of the
+  config too, and an override whose timer fires after Close does not
+  expire
+*/
+func TestOverrideInCallback(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"xFlags": [ { "pkg": "logit", "file": "logControl_test", "flags": "0x1" } ],
+		"sinks": [ { "type": "memory", "id": "callback", "pkgs": [ "logit:logControl_test" ] } ]`)
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	on := true
+	l.OnReload(func(generation int32) {
+		if generation == 1 {
+			l.RevertOverride("logit")
+		} else if generation == 3 {
+			l.SetOverride(Override_t{Target: "main", DFlag: &on})
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		l.SetOverride(Override_t{Target: "logit", DFlag: &on}) // gen 1, the callback reverts it, gen 2
+		l.reloadConfig()                                       // gen 3, the callback sets one, gen 4
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Logit problem: an override in a reload callback deadlocks")
+	}
+	if overrides := l.Overrides(); l.Generation() != 4 || len(overrides) != 1 || overrides[0].Target != "main" {
+		t.Errorf("Logit problem: gen %d, overrides %+v", l.Generation(), overrides)
+	}
+	l.SetOverride(Override_t{Target: "logit", DFlag: &on, TTL: "1h"})
+	expires := l.overrides["logit"].expires
+	l.Close()
+	generation := l.Generation()
+	l.expireOverride("logit", expires) // as if its timer fired while Close stopped it
+	if l.Generation() != generation {
+		t.Errorf("Logit problem: an override expired after Close, gen %d", l.Generation())
+	}
+}
+
+/*
+  TestControlHandler
+  Set, list and revert the overrides over http
+*/
+func TestControlHandler(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"xFlags": [ { "pkg": "logit", "file": "logControl_test", "flags": "0x1" } ],
+		"sinks": [ { "type": "memory", "id": "handler", "pkgs": [ "logit:logControl_test" ] } ]`)
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "So the file is known")
+
+	do := func(method string, target string, body string) (int, controlState_t) {
+		w := httptest.NewRecorder()
+		l.ControlHandler().ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var state controlState_t
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+				t.Fatalf("Logit problem: %s\n%s", err.Error(), w.Body.String())
+			}
+		}
+		return w.Code, state
+	}
+	code, state := do("POST", "/flags", `{ "target": "logit:logControl_test", "level": "TRACE", "ttl": "1h" }`)
+	if code != http.StatusOK || state.Generation != 1 || len(state.Overrides) != 1 || state.Overrides[0].Expires == nil {
+		t.Errorf("Logit problem: %d %+v", code, state)
+	}
+	code, state = do("GET", "/flags", "")
+	found := false
+	for _, info := range state.Flags {
+		if info.Target == "logit:logControl_test" {
+			found = info.Level == "TRACE" && info.XFlag == "0x1" && info.Overridden
+		}
+	}
+	if code != http.StatusOK || !found {
+		t.Errorf("Logit problem: %d %+v", code, state)
+	}
+	for _, body := range []string{`{ "target": "logit" }`, `{ "target": "logit", "level": "DEBUG", "color": "red" }`, `not json`} {
+		if code, _ = do("POST", "/flags", body); code != http.StatusBadRequest {
+			t.Errorf("Logit problem: '%s' got %d", body, code)
+		}
+	}
+	if code, _ = do("DELETE", "/flags?target=main", ""); code != http.StatusNotFound {
+		t.Errorf("Logit problem: reverting no override got %d", code)
+	}
+	code, state = do("DELETE", "/flags?target=logit:logControl_test", "")
+	if code != http.StatusOK || state.Generation != 2 || len(state.Overrides) != 0 {
+		t.Errorf("Logit problem: %d %+v", code, state)
+	}
+	if code, _ = do("PATCH", "/flags", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("Logit problem: PATCH got %d", code)
+	}
+}
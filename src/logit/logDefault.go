@@ -0,0 +1,250 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+var defaultLogger atomic.Value // holds the *Logger used by the package functions
+
+func init() {
+	defaultLogger.Store(newLogger()) // stdout only, until 'OpenLog' is called
+}
+
+/*
+  Default
+  The logger used by the package functions
+*/
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+/*
+  SetDefault
+  Make 'l' the logger used by the package functions.
+  The previous default is returned, it is not closed.
+*/
+func SetDefault(l *Logger) *Logger {
+	return defaultLogger.Swap(l).(*Logger)
+}
+
+/*
+  OpenLog
+  Open up the logconfig file to setup the default logger
+  either locally, or to a web service, or to stdout.
+*/
+func OpenLog(newLogConfigFileName string) error {
+	l, err := NewLogger(newLogConfigFileName)
+	if err != nil {
+		return err
+	}
+	SetDefault(l)
+	return nil
+}
+
+/*
+  CloseLog
+  Close the default logger
+*/
+func CloseLog() {
+	Default().Close()
+}
+
+/*
+  GetLogStats
+  return the stats of the default logger
+*/
+func GetLogStats() logStats_t {
+	return Default().GetLogStats()
+}
+
+/*
+  Fatal
+  log the fatal messages, which are always enabled
+*/
+func Fatal(flags *DFlags_t, str string) {
+	Default().Fatal(flags, str)
+}
+
+/*
+  Fatalf
+  Build the message and log the fatal messages
+*/
+func Fatalf(flags *DFlags_t, str string, args ...interface{}) {
+	Default().Fatalf(flags, str, args...)
+}
+
+/*
+  Error
+  log the error messages if enabled
+*/
+func Error(flags *DFlags_t, str string) {
+	Default().Error(flags, str)
+}
+
+/*
+  Errorf
+  Build the message and log the error messages if enabled
+*/
+func Errorf(flags *DFlags_t, str string, args ...interface{}) {
+	Default().Errorf(flags, str, args...)
+}
+
+/*
+  Warn
+  log the warning messages if enabled
+*/
+func Warn(flags *DFlags_t, str string) {
+	Default().Warn(flags, str)
+}
+
+/*
+  Warnf
+  Build the message and log the warning messages if enabled
+*/
+func Warnf(flags *DFlags_t, str string, args ...interface{}) {
+	Default().Warnf(flags, str, args...)
+}
+
+/*
+  Info
+  log the info messages if enabled
+*/
+func Info(flags *DFlags_t, str string) {
+	Default().Info(flags, str)
+}
+
+/*
+  Infof
+  Build the message and log the info messages if enabled
+*/
+func Infof(flags *DFlags_t, str string, args ...interface{}) {
+	Default().Infof(flags, str, args...)
+}
+
+/*
+  Debug
+  log the debug message if enabled
+*/
+func Debug(flags *DFlags_t, str string) {
+	Default().Debug(flags, str)
+}
+
+/*
+  Debugx
+  log the debug message if enabled by an xflag
+*/
+func Debugx(xflag int32, flags *DFlags_t, str string) {
+	Default().Debugx(xflag, flags, str)
+}
+
+/*
+  Debugf
+  Build the message and log the debug message if enabled
+*/
+func Debugf(flags *DFlags_t, str string, args ...interface{}) {
+	Default().Debugf(flags, str, args...)
+}
+
+/*
+  Debugfx
+  Build and log the debug message if enabled by an xflag
+*/
+func Debugfx(xflag int32, flags *DFlags_t, str string, args ...interface{}) {
+	Default().Debugfx(xflag, flags, str, args...)
+}
+
+/*
+  Trace
+  log the trace message if the level of the package or file is TRACE
+*/
+func Trace(flags *DFlags_t, str string) {
+	Default().Trace(flags, str)
+}
+
+/*
+  Tracef
+  Build and log the trace message if the level of the package or file is TRACE
+*/
+func Tracef(flags *DFlags_t, str string, args ...interface{}) {
+	Default().Tracef(flags, str, args...)
+}
+
+/*
+  RingHandler
+  The ring handler of the default logger at the time of each request
+*/
+func RingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Default().serveRing(w, r)
+	})
+}
+
+/*
+  DumpRing
+  Dump the ring of the default logger to its ring dump file
+*/
+func DumpRing(reason string) (string, error) {
+	return Default().DumpRing(reason)
+}
+
+/*
+  DumpOnPanic
+  Defer it at the top of main or of a goroutine: a panic dumps the
+  ring of the default logger before it goes on.
+  e.g. defer logit.DumpOnPanic()
+*/
+func DumpOnPanic() {
+	if r := recover(); r != nil {
+		Default().dumpForPanic(r)
+		panic(r)
+	}
+}
+
+/*
+  WriteSupportBundle
+  Write a support bundle of the default logger
+*/
+func WriteSupportBundle(w io.Writer) error {
+	return Default().WriteSupportBundle(w)
+}
+
+/*
+  ControlHandler
+  The control handler of the default logger at the time of each request
+*/
+func ControlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Default().serveControl(w, r)
+	})
+}
+
+/*
+  SetOverride
+  Override the flags of a target in the default logger
+*/
+func SetOverride(o Override_t) (int32, error) {
+	return Default().SetOverride(o)
+}
+
+/*
+  RevertOverride
+  Remove the override of a target from the default logger
+*/
+func RevertOverride(target string) (int32, error) {
+	return Default().RevertOverride(target)
+}
+
+/*
+  Recover
+  Defer it at the top of a goroutine or a handler: a panic is logged
+  with its stack by the default logger, and the goroutine goes on.
+  e.g. defer logit.Recover(&myFlags)
+*/
+func Recover(flags *DFlags_t) {
+	if r := recover(); r != nil {
+		Default().recovered(flags, r)
+	}
+}
@@ -0,0 +1,334 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Field_t is one key/value pair that is logged with a message
+type Field_t struct {
+	Key   string
+	Value interface{}
+}
+
+const missingValue = "!MISSING" // value of a key that was passed without one
+
+/*
+  Entry_t
+  A child logger that adds its fields to every message it logs.
+  Get one with 'With', each 'With' on it returns a new child that
+  has the fields of its parent as well, the parent is not changed.
+*/
+type Entry_t struct {
+	logger *Logger   // nil means the default logger at the time of logging
+	flags  *DFlags_t // the DFlags_t of the caller
+	fields []Field_t // in the order they were added
+}
+
+/*
+  With
+  A child of the default logger that logs the key/value pairs
+  with every message, e.g. With(&myFlags, "user", user, "path", path).Info("...")
+*/
+func With(flags *DFlags_t, keyValues ...interface{}) *Entry_t {
+	return &Entry_t{flags: flags, fields: addFields(nil, keyValues)}
+}
+
+/*
+  With
+  A child of this logger that logs the key/value pairs with every message
+*/
+func (l *Logger) With(flags *DFlags_t, keyValues ...interface{}) *Entry_t {
+	return &Entry_t{logger: l, flags: flags, fields: addFields(nil, keyValues)}
+}
+
+/*
+  With
+  A child that logs these key/value pairs as well.
+  A key that is already there gets the new value.
+*/
+func (e *Entry_t) With(keyValues ...interface{}) *Entry_t {
+	return &Entry_t{logger: e.logger, flags: e.flags, fields: addFields(e.fields, keyValues)}
+}
+
+/*
+  Fields
+  A copy of the fields of the entry
+*/
+func (e *Entry_t) Fields() []Field_t {
+	return append([]Field_t(nil), e.fields...)
+}
+
+/*
+  addFields
+  Add the key/value pairs to a copy of the fields.
+  Keys that are not strings are printed, a last key without a value
+  gets the value "!MISSING".
+*/
+func addFields(fields []Field_t, keyValues []interface{}) []Field_t {
+	result := make([]Field_t, len(fields), len(fields)+(len(keyValues)+1)/2)
+	copy(result, fields)
+	for i := 0; i < len(keyValues); i += 2 {
+		key, isString := keyValues[i].(string)
+		if !isString {
+			key = fmt.Sprint(keyValues[i])
+		}
+		var value interface{} = missingValue
+		if i+1 < len(keyValues) {
+			value = keyValues[i+1]
+		}
+		replaced := false
+		for j := range result {
+			if result[j].Key == key {
+				result[j].Value = value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			result = append(result, Field_t{Key: key, Value: value})
+		}
+	}
+	return result
+}
+
+/*
+  getLogger
+  The logger of the entry, the default logger if it has none
+*/
+func (e *Entry_t) getLogger() *Logger {
+	if e.logger != nil {
+		return e.logger
+	}
+	return Default()
+}
+
+/*
+  log
+  Log the message with the fields if the level is on.
+  The message is only built when it is logged.
+*/
+func (e *Entry_t) log(level logLevel_t, xflag int32, str string, args []interface{}) {
+	l := e.getLogger()
+	f := l.ifOldReloadDXFlags(e.flags)
+	if !l.levelOn(level, xflag, &f) {
+		return
+	}
+	if args != nil {
+		str = fmt.Sprintf(str, args...)
+	}
+	l.logMsg(level, xflag, &f, e.fields, str)
+}
+
+/*
+  Fatal
+  log the fatal messages, which are always enabled
+*/
+func (e *Entry_t) Fatal(str string) {
+	e.log(FATAL, 0, str, nil)
+}
+
+/*
+  Fatalf
+  Build the message and log the fatal messages
+*/
+func (e *Entry_t) Fatalf(str string, args ...interface{}) {
+	e.log(FATAL, 0, str, args)
+}
+
+/*
+  Error
+  log the error messages if enabled
+*/
+func (e *Entry_t) Error(str string) {
+	e.log(ERROR, 0, str, nil)
+}
+
+/*
+  Errorf
+  Build the message and log the error messages if enabled
+*/
+func (e *Entry_t) Errorf(str string, args ...interface{}) {
+	e.log(ERROR, 0, str, args)
+}
+
+/*
+  Warn
+  log the warning messages if enabled
+*/
+func (e *Entry_t) Warn(str string) {
+	e.log(WARN, 0, str, nil)
+}
+
+/*
+  Warnf
+  Build the message and log the warning messages if enabled
+*/
+func (e *Entry_t) Warnf(str string, args ...interface{}) {
+	e.log(WARN, 0, str, args)
+}
+
+/*
+  Info
+  log the info messages if enabled
+*/
+func (e *Entry_t) Info(str string) {
+	e.log(INFO, 0, str, nil)
+}
+
+/*
+  Infof
+  Build the message and log the info messages if enabled
+*/
+func (e *Entry_t) Infof(str string, args ...interface{}) {
+	e.log(INFO, 0, str, args)
+}
+
+/*
+  Debug
+  log the debug message if enabled
+*/
+func (e *Entry_t) Debug(str string) {
+	e.log(DEBUG, 0, str, nil)
+}
+
+/*
+  Debugx
+  log the debug message if enabled by an xflag
+*/
+func (e *Entry_t) Debugx(xflag int32, str string) {
+	e.log(DEBUG, xflag, str, nil)
+}
+
+/*
+  Debugf
+  Build the message and log the debug message if enabled
+*/
+func (e *Entry_t) Debugf(str string, args ...interface{}) {
+	e.log(DEBUG, 0, str, args)
+}
+
+/*
+  Debugfx
+  Build and log the debug message if enabled by an xflag
+*/
+func (e *Entry_t) Debugfx(xflag int32, str string, args ...interface{}) {
+	e.log(DEBUG, xflag, str, args)
+}
+
+/*
+  Trace
+  log the trace message if the level of the package or file is TRACE
+*/
+func (e *Entry_t) Trace(str string) {
+	e.log(TRACE, 0, str, nil)
+}
+
+/*
+  Tracef
+  Build and log the trace message if the level of the package or file is TRACE
+*/
+func (e *Entry_t) Tracef(str string, args ...interface{}) {
+	e.log(TRACE, 0, str, args)
+}
+
+/*
+  renderFields
+  A copy of the fields with the values as the text they are written as.
+  The record is built on the caller's goroutine, the writer only gets
+  the text, so a map or a slice the caller changes later is not read
+  while it changes, and a String or Error method of a nil pointer does
+  not panic in the writer, fmt.Sprint prints it as <nil>.
+  Plain numbers and booleans are kept, they are values without methods,
+  and stay numbers in json.
+*/
+func renderFields(fields []Field_t) []Field_t {
+	if len(fields) == 0 {
+		return fields
+	}
+	rendered := make([]Field_t, len(fields))
+	for i, field := range fields {
+		rendered[i].Key = field.Key
+		switch v := field.Value.(type) {
+		case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			rendered[i].Value = v
+		default:
+			rendered[i].Value = fmt.Sprint(v)
+		}
+	}
+	return rendered
+}
+
+/*
+  fieldString
+  The value of a field as text, errors and Stringers use their own text
+*/
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+/*
+  formatTextFields
+  The fields as " key=value key=value", empty values and values with
+  spaces, '=' or anything that needs escaping are quoted
+*/
+func formatTextFields(fields []Field_t) string {
+	var sb strings.Builder
+	for _, field := range fields {
+		value := fieldString(field.Value)
+		sb.WriteString(" " + field.Key + "=")
+		if len(value) == 0 || strings.ContainsAny(value, " =") || strconv.Quote(value) != `"`+value+`"` {
+			sb.WriteString(strconv.Quote(value))
+		} else {
+			sb.WriteString(value)
+		}
+	}
+	return sb.String()
+}
+
+/*
+  formatJSONFields
+  The fields as one json object, in the order they were added.
+  Values that json cannot encode are written as text.
+*/
+func formatJSONFields(fields []Field_t) json.RawMessage {
+	if len(fields) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		enc.Encode(field.Key)
+		buf.Truncate(buf.Len() - 1) // Encode ends with a newline
+		buf.WriteByte(':')
+		value := field.Value
+		if err, isError := value.(error); isError {
+			value = err.Error() // most errors encode as {}
+		}
+		mark := buf.Len()
+		if enc.Encode(value) != nil {
+			buf.Truncate(mark)
+			enc.Encode(fieldString(field.Value))
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return json.RawMessage(buf.Bytes())
+}
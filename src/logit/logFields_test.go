@@ -0,0 +1,141 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  TestLogFieldsText
+  The fields follow the message as key=value, quoted when needed
+*/
+func TestLogFieldsText(t *testing.T) {
+	when := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	e := With(nil, "user", "jeff", "path", "/static/a b.html").With("status", 403, "err", errors.New("no"), "empty", "")
+	rec := Record_t{Time: when, Level: WARN, Pkg: "main", File: "main", Fields: e.fields, Msg: "Forbidden"}
+	want := `2019-03-04T05:06:07Z WARN[main:main] Forbidden user=jeff path="/static/a b.html" status=403 err=no empty=""`
+	if line := rec.format(TEXT); line != want {
+		t.Errorf("Logit problem: text line '%s'", line)
+	}
+}
+
+/*
+  TestLogFieldsJSON
+  The fields are their own object in the json line, in the order they were added
+*/
+func TestLogFieldsJSON(t *testing.T) {
+	e := With(nil, "user", "jeff", "status", 403, "err", errors.New("no <way>"))
+	rec := Record_t{Time: time.Now(), Level: INFO, Fields: e.fields, Msg: "hi"}
+	line := rec.format(JSON)
+	if !strings.Contains(line, `"fields":{"user":"jeff","status":403,"err":"no <way>"}`) {
+		t.Errorf("Logit problem: json line '%s'", line)
+	}
+	var fields jsonRecord_t
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		t.Fatalf("Logit problem: json line '%s': %s", line, err)
+	}
+	// no fields, no "fields"
+	rec.Fields = nil
+	if line := rec.format(JSON); strings.Contains(line, "fields") {
+		t.Errorf("Logit problem: json line '%s'", line)
+	}
+}
+
+/*
+  TestLogWith
+  A child gets the fields of its parent, the parent is not changed,
+  a key that is added again gets the new value
+*/
+func TestLogWith(t *testing.T) {
+	parent := With(nil, "method", "GET", "route", "/static")
+	child := parent.With("user", "jeff", "route", "/dynamic", "odd")
+	if got := formatTextFields(parent.Fields()); got != " method=GET route=/static" {
+		t.Errorf("Logit problem: parent fields '%s'", got)
+	}
+	if got := formatTextFields(child.Fields()); got != " method=GET route=/dynamic user=jeff odd=!MISSING" {
+		t.Errorf("Logit problem: child fields '%s'", got)
+	}
+}
+
+/*
+  TestLogWithLevels
+  An entry follows the debug flags and xflags of its caller
+*/
+func TestLogWithLevels(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	jsonTest := `
+	{
+		"SiteID": "BeyondAI",
+		"filename": "` + logFileName + `",
+		"level": "DEBUG",
+		"debugFlags": [ { "pkg": "logit", "file": "logFields_test" } ]
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	e := l.With(&myFlags, "user", "jeff")
+	e.Warnf("logged %d", 1)
+	e.Debug("debug logged")
+	e.Debugx(0x01, "xflag not logged")
+	stats := l.GetLogStats()
+	l.Close()
+	if stats.warnCount != 1 || stats.debugCount != 1 {
+		t.Errorf("Logit problem: stats %+v", stats)
+	}
+	raw, err := ioutil.ReadFile(logFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	text := string(raw)
+	if !strings.Contains(text, "WARN[logit:logFields_test] logged 1 user=jeff") ||
+		!strings.Contains(text, "DBUG[logit:logFields_test] debug logged user=jeff") ||
+		strings.Contains(text, "not logged") {
+		t.Errorf("Logit problem: log file:\n%s", text)
+	}
+}
+
+type nilStringer_t struct{ name string }
+
+func (s *nilStringer_t) String() string {
+	return s.name // panics for a nil pointer
+}
+
+/*
+  TestLogFieldsRendered
+  The values are text when the record is built, a map changed after it
+  is logged is logged as it was, a nil Stringer is no panic, numbers
+  stay numbers
+*/
+func TestLogFieldsRendered(t *testing.T) {
+	var nilStringer *nilStringer_t
+	config := map[string]int{"retries": 3}
+	fields := renderFields(With(nil, "config", config, "who", nilStringer, "count", 3, "ok", true).fields)
+	config["retries"] = 4
+	if fields[0].Value != "map[retries:3]" {
+		t.Errorf("Logit problem: config is %#v", fields[0].Value)
+	}
+	if fields[1].Value != "<nil>" {
+		t.Errorf("Logit problem: nil Stringer is %#v", fields[1].Value)
+	}
+	if fields[2].Value != 3 || fields[3].Value != true {
+		t.Errorf("Logit problem: count and ok are %#v, %#v", fields[2].Value, fields[3].Value)
+	}
+	if renderFields(nil) != nil {
+		t.Errorf("Logit problem: no fields should stay nil")
+	}
+}
@@ -0,0 +1,212 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type format_t int
+
+// how a log line is written to an output
+const (
+	TEXT format_t = iota // RFC3339 + " " + "INFO[pkg:file] msg"
+	JSON                 // one json object per line
+)
+
+var formatNames = map[string]format_t{
+	"text": TEXT,
+	"json": JSON,
+}
+
+/*
+  parseFormat
+  Turn a format string of the config into the format.
+  An empty string means 'dflt'.
+*/
+func parseFormat(name string, dflt format_t) (format_t, error) {
+	if len(name) == 0 {
+		return dflt, nil
+	}
+	format, found := formatNames[strings.ToLower(name)]
+	if !found {
+		return dflt, fmt.Errorf("unknown log format '%s', use text or json", name)
+	}
+	return format, nil
+}
+
+/*
+  String
+  The name of the format, as the config has it
+*/
+func (format format_t) String() string {
+	for name, f := range formatNames {
+		if f == format {
+			return name
+		}
+	}
+	return fmt.Sprintf("FORMAT(%d)", int(format))
+}
+
+/*
+  Record_t
+  One log message on its way to the sinks, before it is formatted.
+  A Sink gets the record and the line in the format of that sink.
+*/
+type Record_t struct {
+	Time       time.Time  // when it was logged
+	Level      logLevel_t // FATAL to TRACE
+	XFlag      int32      // the xflag of a Debugx message, 0 for the others
+	Pkg        string     // package that logged it
+	File       string     // file that logged it
+	SiteID     string     // customer site name
+	SystemID   string     // system id
+	Generation int32      // generation of the config it was logged with
+	Fields     []Field_t  // the key/value pairs of a 'With' entry
+	Msg        string     // the message itself
+	Stack      string     // the stack trace of a level policy or a recovered panic
+
+	flags   *logFlags_t   // the config it was logged with, for the sinks
+	synced  chan struct{} // not a message, closed when the writer gets to it
+	counted bool          // its level is counted in the stats once it is queued, a summary is not
+}
+
+// the level names of the json format
+var levelNames = map[logLevel_t]string{
+	FATAL: "FATAL",
+	ERROR: "ERROR",
+	WARN:  "WARN",
+	INFO:  "INFO",
+	DEBUG: "DEBUG",
+	TRACE: "TRACE",
+}
+
+// the level labels of the text format
+var levelLabels = map[logLevel_t]string{
+	FATAL: "FATAL",
+	ERROR: "ERR",
+	WARN:  "WARN",
+	INFO:  "INFO",
+	DEBUG: "DBUG",
+	TRACE: "TRCE",
+}
+
+// the names the config can use for the levels
+var levelConfigNames = map[string]logLevel_t{
+	"FATAL":   FATAL,
+	"ERROR":   ERROR,
+	"ERR":     ERROR,
+	"WARN":    WARN,
+	"WARNING": WARN,
+	"INFO":    INFO,
+	"DEBUG":   DEBUG,
+	"DBUG":    DEBUG,
+	"TRACE":   TRACE,
+	"TRCE":    TRACE,
+}
+
+/*
+  parseLevel
+  Turn a level string of the config into the level.
+  An empty string means 'dflt'.
+*/
+func parseLevel(name string, dflt logLevel_t) (logLevel_t, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return dflt, nil
+	}
+	level, found := levelConfigNames[strings.ToUpper(name)]
+	if !found {
+		return dflt, fmt.Errorf("unknown log level '%s', use TRACE, DEBUG, INFO, WARN, ERROR or FATAL", name)
+	}
+	return level, nil
+}
+
+/*
+  String
+  The name of the level
+*/
+func (level logLevel_t) String() string {
+	if name, found := levelNames[level]; found {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(level))
+}
+
+/*
+  label
+  The label of the record in the text format
+*/
+func (rec *Record_t) label() string {
+	if rec.Level == DEBUG && rec.XFlag != 0 {
+		return "DBGX"
+	}
+	return levelLabels[rec.Level]
+}
+
+/*
+  format
+  Format the record as one line, without the newline
+*/
+func (rec *Record_t) format(format format_t) string {
+	if format == JSON {
+		return rec.formatJSON()
+	}
+	return rec.formatText()
+}
+
+/*
+  formatText
+  RFC3339 + " " + "INFO[pkg:file] msg" + " key=value" for each field
+*/
+func (rec *Record_t) formatText() string {
+	line := rec.Time.Format(time.RFC3339) + " " + rec.label() + "[" + rec.Pkg + ":" + rec.File + "] " + rec.Msg +
+		formatTextFields(rec.Fields)
+	if len(rec.Stack) > 0 { // the only record that is more than one line
+		line += "\n" + rec.Stack
+	}
+	return line
+}
+
+// jsonRecord_t sets the names and order of the fields in the json format
+type jsonRecord_t struct {
+	Time       string          `json:"time"`
+	Level      string          `json:"level"`
+	XFlag      int32           `json:"xflag,omitempty"`
+	Pkg        string          `json:"pkg"`
+	File       string          `json:"file"`
+	SiteID     string          `json:"SiteID"`
+	SystemID   string          `json:"SystemID"`
+	Generation int32           `json:"generation"`
+	Msg        string          `json:"msg"`
+	Fields     json.RawMessage `json:"fields,omitempty"`
+	Stack      string          `json:"stack,omitempty"`
+}
+
+/*
+  formatJSON
+  One json object, newlines in the message are escaped so it stays one line.
+  The fields are an object of their own, so they cannot clash with the others.
+*/
+func (rec *Record_t) formatJSON() string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep <, > and & readable
+	enc.Encode(jsonRecord_t{
+		Time:       rec.Time.Format(time.RFC3339Nano),
+		Level:      rec.Level.String(),
+		XFlag:      rec.XFlag,
+		Pkg:        rec.Pkg,
+		File:       rec.File,
+		SiteID:     rec.SiteID,
+		SystemID:   rec.SystemID,
+		Generation: rec.Generation,
+		Msg:        rec.Msg,
+		Fields:     formatJSONFields(rec.Fields),
+		Stack:      rec.Stack,
+	})
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -0,0 +1,127 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  TestLogFormatText
+  The text format is unchanged: RFC3339 + " " + "INFO[pkg:file] msg"
+*/
+func TestLogFormatText(t *testing.T) {
+	when := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	rec := Record_t{Time: when, Level: ERROR, Pkg: "main", File: "main", Msg: "went wrong"}
+	if line := rec.format(TEXT); line != "2019-03-04T05:06:07Z ERR[main:main] went wrong" {
+		t.Errorf("Logit problem: text line '%s'", line)
+	}
+	rec.Level = DEBUG
+	rec.XFlag = 0x02
+	if line := rec.format(TEXT); line != "2019-03-04T05:06:07Z DBGX[main:main] went wrong" {
+		t.Errorf("Logit problem: text line '%s'", line)
+	}
+}
+
+/*
+  TestLogFormatJSON
+  Every part of the record is its own json field
+*/
+func TestLogFormatJSON(t *testing.T) {
+	when := time.Date(2019, 3, 4, 5, 6, 7, 8, time.UTC)
+	rec := Record_t{Time: when, Level: WARN, Pkg: "main", File: "main",
+		SiteID: "BeyondAI", SystemID: "local", Generation: 3, Msg: "two\nlines <b>"}
+	line := rec.format(JSON)
+	if strings.Contains(line, "\n") {
+		t.Errorf("Logit problem: json line '%s' is not one line", line)
+	}
+	var fields jsonRecord_t
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		t.Fatalf("Logit problem: json line '%s': %s", line, err)
+	}
+	want := jsonRecord_t{Time: "2019-03-04T05:06:07.000000008Z", Level: "WARN", Pkg: "main", File: "main",
+		SiteID: "BeyondAI", SystemID: "local", Generation: 3, Msg: "two\nlines <b>"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("Logit problem: json fields %+v", fields)
+	}
+}
+
+/*
+  TestLogConfigFormats
+  The file gets json while stdout keeps the text format
+*/
+func TestLogConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	jsonTest := `
+	{
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "` + logFileName + `",
+		"stdout": true,
+		"level": "INFO",
+		"format": "text",
+		"fileFormat": "json"
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if flags := l.getLogFlags(); flags.stdOutFmt != TEXT || flags.fileFmt != JSON || flags.urlFmt != TEXT {
+		t.Errorf("Logit problem: formats %d, %d, %d", flags.stdOutFmt, flags.fileFmt, flags.urlFmt)
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Info in json")
+	l.Close()
+
+	fh, err := os.Open(logFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer fh.Close()
+	found := false
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		var fields jsonRecord_t
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			t.Fatalf("Logit problem: line '%s' is not json", scanner.Text())
+		}
+		if fields.Msg == "Info in json" {
+			found = fields.Level == "INFO" && fields.Pkg == "logit" && fields.File == "logFormat_test" &&
+				fields.SiteID == "BeyondAI" && fields.SystemID == "local"
+		}
+	}
+	if !found {
+		raw, _ := ioutil.ReadFile(logFileName)
+		t.Errorf("Logit problem: json line not found in:\n%s", raw)
+	}
+}
+
+/*
+  TestParseFormat
+  The format names from the config file
+*/
+func TestParseFormat(t *testing.T) {
+	if format, err := parseFormat("", JSON); format != JSON || err != nil {
+		t.Errorf("Logit problem: empty format is %d, %v", format, err)
+	}
+	if format, err := parseFormat("JSON", TEXT); format != JSON || err != nil {
+		t.Errorf("Logit problem: JSON format is %d, %v", format, err)
+	}
+	if _, err := parseFormat("xml", TEXT); err == nil {
+		t.Errorf("Logit problem: unknown format was accepted")
+	}
+}
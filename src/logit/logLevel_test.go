@@ -0,0 +1,175 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+  TestParseLevel
+  Full level names, the text labels, and no panic on an empty level
+*/
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]logLevel_t{
+		"":        WARN,
+		"fatal":   FATAL,
+		"ERROR":   ERROR,
+		"err":     ERROR,
+		"Warn":    WARN,
+		"warning": WARN,
+		"INFO":    INFO,
+		"debug":   DEBUG,
+		"TRACE":   TRACE,
+		" trce ":  TRACE,
+	} {
+		level, err := parseLevel(name, WARN)
+		if err != nil || level != want {
+			t.Errorf("Logit problem: level '%s' is %s, %v", name, level, err)
+		}
+	}
+	if level, err := parseLevel("verbose", INFO); err == nil || level != INFO {
+		t.Errorf("Logit problem: unknown level is %s, %v", level, err)
+	}
+}
+
+/*
+  TestLogLevelConfig
+  ERROR and FATAL are levels of their own, an empty level is WARN
+*/
+func TestLogLevelConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	for level, want := range map[string]logLevel_t{"": WARN, "ERROR": ERROR, "FATAL": FATAL, "TRACE": TRACE} {
+		err := writeConfigFile(configFileName, `{ "filename": "", "level": "`+level+`" }`)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		l := newLogger()
+		var tFlags logFlags_t
+		if err := l.getConfig(configFileName, &tFlags); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		if tFlags.logLevel != want {
+			t.Errorf("Logit problem: level '%s' is %s", level, tFlags.logLevel)
+		}
+	}
+}
+
+/*
+  TestLogLevelTrace
+  A global TRACE level logs the debug messages too, without debugFlags,
+  a global DEBUG level still leaves them to the debugFlags
+*/
+func TestLogLevelTrace(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "trace.txt")
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for _, level := range []string{"TRACE", "DEBUG"} {
+		if err := writeConfigFile(configFileName, `{ "filename": "`+filepath.ToSlash(logFileName)+`", "level": "`+level+`" }`); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		l, err := NewLogger(configFileName)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		l.Debug(&myFlags, "debug at "+level)
+		l.Trace(&myFlags, "trace at "+level)
+		l.Close()
+	}
+	raw, _ := ioutil.ReadFile(logFileName)
+	text := string(raw)
+	for _, want := range []string{"DBUG[logit:logLevel_test] debug at TRACE", "TRCE[logit:logLevel_test] trace at TRACE"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Logit problem: '%s' not found in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "debug at DEBUG") || strings.Contains(text, "trace at DEBUG") {
+		t.Errorf("Logit problem: DEBUG without debugFlags logged debug or trace:\n%s", text)
+	}
+}
+
+/*
+  TestLogLevelPerFile
+  A file level wins over its package level, which wins over the level
+  of everything else. A reload changes them by generation.
+*/
+func TestLogLevelPerFile(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	jsonTest := `
+	{
+		"filename": "` + logFileName + `",
+		"level": "WARN",
+		"debugFlags": [
+			{ "pkg": "logit", "level": "INFO" },
+			{ "pkg": "logit", "file": "logLevel_test", "level": "TRACE" },
+			{ "pkg": "noisy", "level": "ERROR" }
+		]
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	otherFlags := DFlags_t{pkgName: "logit", fileName: "other"}
+	noisyFlags := DFlags_t{pkgName: "noisy", fileName: "noisy"}
+	mainFlags := DFlags_t{pkgName: "main", fileName: "main"}
+
+	l.Trace(&myFlags, "file trace")
+	l.Debug(&myFlags, "file debug")
+	l.Info(&otherFlags, "package info")
+	l.Debug(&otherFlags, "package debug")
+	l.Warn(&noisyFlags, "noisy warn")
+	l.Error(&noisyFlags, "noisy error")
+	l.Info(&mainFlags, "main info")
+	l.Warn(&mainFlags, "main warn")
+	f := l.ifOldReloadDXFlags(&myFlags)
+	if f.level != TRACE || !f.dFlag {
+		t.Errorf("Logit problem: file level %s, debug %t", f.level, f.dFlag)
+	}
+	//
+	// the file gets WARN, less than the INFO of its package
+	//
+	jsonTest = strings.Replace(jsonTest, `"level": "TRACE"`, `"level": "WARN"`, 1)
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.reloadConfig()
+	l.Info(&myFlags, "file info after reload")
+	l.Trace(&myFlags, "file trace after reload")
+	l.Warn(&myFlags, "file warn after reload")
+	stats := l.GetLogStats()
+	l.Close()
+	if stats.traceCount != 1 {
+		t.Errorf("Logit problem: trace count %d", stats.traceCount)
+	}
+
+	raw, _ := ioutil.ReadFile(logFileName)
+	text := string(raw)
+	for _, want := range []string{"TRCE[logit:logLevel_test] file trace", "DBUG[logit:logLevel_test] file debug",
+		"INFO[logit:other] package info", "ERR[noisy:noisy] noisy error", "WARN[main:main] main warn",
+		"WARN[logit:logLevel_test] file warn after reload"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Logit problem: '%s' not found in:\n%s", want, text)
+		}
+	}
+	for _, unwanted := range []string{"package debug", "noisy warn", "main info"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("Logit problem: '%s' found in:\n%s", unwanted, text)
+		}
+	}
+	if strings.Contains(text, "file info after reload") || strings.Contains(text, "file trace after reload") {
+		t.Errorf("Logit problem: the reload did not change the level:\n%s", text)
+	}
+}
@@ -0,0 +1,232 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type action_t int
+
+// what the logger does after a message of a level with a policy
+const (
+	CONTINUE action_t = iota // go on, the default
+	EXIT                     // close the logger and exit the process
+	PANIC                    // flush the sinks and panic with the message
+)
+
+var actionNames = map[string]action_t{
+	"continue": CONTINUE,
+	"exit":     EXIT,
+	"panic":    PANIC,
+}
+
+type stack_t int
+
+// the stack trace a message of a level with a policy gets
+const (
+	NO_STACK        stack_t = iota // none, the default
+	GOROUTINE_STACK                // the goroutine that logged it
+	ALL_STACKS                     // every goroutine
+)
+
+var stackNames = map[string]stack_t{
+	"none":      NO_STACK,
+	"goroutine": GOROUTINE_STACK,
+	"all":       ALL_STACKS,
+}
+
+const policyFlushWait = time.Second * 5 // how long a flush waits for the writer
+const maxStackSize = 4 * 1024 * 1024    // the most of all the stacks that is logged
+const defaultExitCode = 1               // the exit code of an exit policy without one
+
+var exitFunc = os.Exit // the tests do not exit
+
+var logitPkgPath = reflect.TypeOf(Logger{}).PkgPath() // frames of this package are not in a stack
+
+/*
+  policy_t
+  One "levelPolicy" entry of the config: what a message of the level
+  does besides being logged
+*/
+type policy_t struct {
+	stack    stack_t  // the stack trace that is attached to the message
+	flush    bool     // wait until the sinks have the message
+	action   action_t // then continue, exit or panic
+	exitCode int      // the exit code of EXIT
+}
+
+// policyJson_t is one "levelPolicy" entry of the config, by level
+type policyJson_t struct {
+	Stack    string `json:"stack" check:"stack"`
+	Flush    bool   `json:"flush"`
+	Action   string `json:"action" check:"action"`
+	ExitCode *int   `json:"exitCode"`
+}
+
+/*
+  parsePolicies
+  Turn the "levelPolicy" of the config into the policies by level.
+  An exit or a panic always flushes.
+*/
+func (l *Logger) parsePolicies(entries map[string]policyJson_t) map[logLevel_t]policy_t {
+	policies := make(map[logLevel_t]policy_t)
+	for name, entry := range entries {
+		level, err := parseLevel(name, INFO)
+		if err != nil {
+			l.delayLog(WARN, "levelPolicy: "+err.Error())
+			continue
+		}
+		policy := policy_t{flush: entry.Flush, exitCode: defaultExitCode}
+		var found bool
+		if len(entry.Stack) > 0 {
+			if policy.stack, found = stackNames[strings.ToLower(entry.Stack)]; !found {
+				l.delayLog(WARN, fmt.Sprintf("levelPolicy: unknown stack '%s' for %s, use none, goroutine or all.",
+					entry.Stack, level))
+			}
+		}
+		if len(entry.Action) > 0 {
+			if policy.action, found = actionNames[strings.ToLower(entry.Action)]; !found {
+				l.delayLog(WARN, fmt.Sprintf("levelPolicy: unknown action '%s' for %s, use continue, exit or panic.",
+					entry.Action, level))
+			}
+		}
+		if entry.ExitCode != nil {
+			policy.exitCode = *entry.ExitCode
+		}
+		if policy.action != CONTINUE {
+			policy.flush = true
+		}
+		policies[level] = policy
+	}
+	return policies
+}
+
+/*
+  captureStack
+  The stack of the goroutine that logs, from the caller of logit on,
+  or the stacks of all the goroutines
+*/
+func captureStack(kind stack_t) string {
+	if kind == ALL_STACKS {
+		buf := make([]byte, 64*1024)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) || len(buf) >= maxStackSize {
+				return strings.TrimRight(string(buf[:n]), "\n")
+			}
+			buf = make([]byte, 2*len(buf))
+		}
+	}
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var sb strings.Builder
+	inLogit := true // the frames of logit itself are left out
+	for {
+		frame, more := frames.Next()
+		if inLogit && strings.HasPrefix(frame.Function, logitPkgPath+".") && !strings.HasSuffix(frame.File, "_test.go") {
+			if !more {
+				break
+			}
+			continue
+		}
+		inLogit = false
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+/*
+  applyPolicy
+  Flush, then exit, panic or continue, once the record is logged.
+  An EXIT while another goroutine exits waits for that one.
+*/
+func (l *Logger) applyPolicy(policy policy_t, rec *Record_t) {
+	switch policy.action {
+	case EXIT:
+		l.exitOnce.Do(func() {
+			l.Infof(&l.myFlags, "Exiting with code %d after a %s message.", policy.exitCode, rec.Level)
+			l.Close() // everything gets to the sinks, the log server and syslog too
+			exitFunc(policy.exitCode)
+		})
+	case PANIC:
+		l.syncWriter(policyFlushWait)
+		panic(rec.Level.String() + ": " + rec.Msg)
+	default:
+		if policy.flush {
+			l.syncWriter(policyFlushWait)
+		}
+	}
+}
+
+/*
+  Recover
+  Defer it at the top of a goroutine or a handler: a panic is logged
+  as an ERROR with its stack and the flags of the caller, the ring is
+  dumped, and the goroutine goes on after the deferred call.
+  e.g. defer logger.Recover(&myFlags)
+*/
+func (l *Logger) Recover(flags *DFlags_t) {
+	if r := recover(); r != nil {
+		l.recovered(flags, r)
+	}
+}
+
+/*
+  recovered
+  Log a recovered panic with the stack of where it happened
+*/
+func (l *Logger) recovered(flags *DFlags_t, r interface{}) {
+	f := l.ifOldReloadDXFlags(flags)
+	msg := fmt.Sprintf("panic: %v", r)
+	if l.levelOn(ERROR, 0, &f) {
+		l.logRecord(ERROR, 0, &f, nil, msg, captureStack(GOROUTINE_STACK))
+	}
+	if l.getLogFlags().ringSize > 0 {
+		if name, err := l.DumpRing(msg); err != nil {
+			l.Errorf(&l.myFlags, "Ring could not be dumped to '%s': %s", name, err)
+		}
+	}
+}
+
+/*
+  logThePolicies
+  Log the level policies, if there are any
+*/
+func (l *Logger) logThePolicies(flags *logFlags_t) {
+	if len(flags.policies) == 0 {
+		return
+	}
+	policyMsg := "Level policies:"
+	for level := FATAL; level <= TRACE; level++ {
+		policy, found := flags.policies[level]
+		if !found {
+			continue
+		}
+		stack := "no stack"
+		for name, kind := range stackNames {
+			if kind == policy.stack && kind != NO_STACK {
+				stack = name + " stack"
+			}
+		}
+		action := "continue"
+		for name, a := range actionNames {
+			if a == policy.action {
+				action = name
+			}
+		}
+		if policy.action == EXIT {
+			action += fmt.Sprintf(" %d", policy.exitCode)
+		}
+		policyMsg += fmt.Sprintf("\n  level:'%s', %s, flush %t, %s", level, stack, policy.flush, action)
+	}
+	l.Info(&l.myFlags, policyMsg)
+}
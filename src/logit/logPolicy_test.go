@@ -0,0 +1,195 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+  TestParsePolicies
+  Bad levels, stacks and actions are reported, an exit always flushes
+*/
+func TestParsePolicies(t *testing.T) {
+	l := newLogger()
+	code := 7
+	policies := l.parsePolicies(map[string]policyJson_t{
+		"FATAL": {Stack: "all", Action: "exit", ExitCode: &code},
+		"error": {Stack: "goroutine"},
+		"WARN":  {Stack: "lots", Action: "explode"},
+		"LOUD":  {Flush: true},
+	})
+	if p := policies[FATAL]; p.stack != ALL_STACKS || !p.flush || p.action != EXIT || p.exitCode != 7 {
+		t.Errorf("Logit problem: FATAL policy %+v", p)
+	}
+	if p := policies[ERROR]; p.stack != GOROUTINE_STACK || p.flush || p.action != CONTINUE || p.exitCode != 1 {
+		t.Errorf("Logit problem: ERROR policy %+v", p)
+	}
+	if len(policies) != 3 || len(l.delayedLogs) != 3 {
+		t.Errorf("Logit problem: %d policies, %d warnings", len(policies), len(l.delayedLogs))
+	}
+}
+
+/*
+  TestPolicyStack
+  An ERROR gets the stack of the caller, without the frames of logit,
+  a WARN gets none
+*/
+func TestPolicyStack(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "DEBUG",
+		"duplicateInterval": "1h",
+		"sinks": [ { "type": "memory", "id": "stack", "format": "json", "pkgs": [ "logit:logPolicy_test" ] } ],
+		"levelPolicy": { "ERROR": { "stack": "goroutine", "flush": true } }`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Warn(&myFlags, "No stack")
+	l.With(&myFlags, "disk", "sda").Error("With a stack")
+	l.Close()
+
+	lines := getMemorySink(t, "stack").getLines()
+	if len(lines) != 2 {
+		t.Fatalf("Logit problem: the sink got %q", lines)
+	}
+	var warn, stacked jsonRecord_t
+	json.Unmarshal([]byte(lines[0]), &warn)
+	json.Unmarshal([]byte(lines[1]), &stacked)
+	if len(warn.Stack) != 0 {
+		t.Errorf("Logit problem: a warning has a stack\n%s", warn.Stack)
+	}
+	if !strings.HasPrefix(stacked.Stack, "logit.TestPolicyStack\n\t") || !strings.Contains(stacked.Stack, "logPolicy_test.go:") {
+		t.Errorf("Logit problem: the stack is\n%s", stacked.Stack)
+	}
+}
+
+/*
+  TestPolicyExit
+  A FATAL closes the logger and exits with the code of the policy
+*/
+func TestPolicyExit(t *testing.T) {
+	exitCode := -1
+	exitFunc = func(code int) { exitCode = code }
+	defer func() { exitFunc = os.Exit }()
+	l := newTestLogger(t, `
+		"level": "DEBUG",
+		"duplicateInterval": "1h",
+		"sinks": [ { "type": "memory", "id": "exit", "format": "text", "pkgs": [ "logit:logPolicy_test" ] } ],
+		"levelPolicy": { "FATAL": { "action": "exit", "exitCode": 3 } }`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Fatal(&myFlags, "Out of disk")
+	if exitCode != 3 {
+		t.Errorf("Logit problem: exit code %d", exitCode)
+	}
+	sink := getMemorySink(t, "exit")
+	if lines := sink.getLines(); !sink.closed || len(lines) != 1 || !strings.HasSuffix(lines[0], "FATAL[logit:logPolicy_test] Out of disk") {
+		t.Errorf("Logit problem: closed %t, the sink got %q", sink.closed, lines)
+	}
+}
+
+/*
+  TestPolicyExitOnce
+  FATALs from many goroutines close the logger and exit once, the
+  others wait, a Close after it does nothing
+*/
+func TestPolicyExitOnce(t *testing.T) {
+	var exits int32
+	exitFunc = func(code int) {
+		atomic.AddInt32(&exits, 1)
+		time.Sleep(time.Millisecond * 20) // the others wait while it exits
+	}
+	defer func() { exitFunc = os.Exit }()
+	l := newTestLogger(t, `
+		"level": "DEBUG",
+		"duplicateInterval": "1h",
+		"sinks": [ { "type": "memory", "id": "exit-once", "format": "text", "pkgs": [ "logit:logPolicy_test" ] } ],
+		"levelPolicy": { "FATAL": { "action": "exit", "exitCode": 3 } }`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Fatalf(&myFlags, "Out of disk %d", i)
+			if atomic.LoadInt32(&exits) != 1 {
+				t.Errorf("Logit problem: a FATAL went on before the exit")
+			}
+		}(i)
+	}
+	wg.Wait()
+	l.Close()
+	if exits != 1 || !getMemorySink(t, "exit-once").closed {
+		t.Errorf("Logit problem: %d exits", exits)
+	}
+}
+
+/*
+  TestPolicyPanic
+  An ERROR panics once it is logged, a repeat is not summarized away
+*/
+func TestPolicyPanic(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "DEBUG",
+		"duplicateInterval": "1h",
+		"sinks": [ { "type": "memory", "id": "panic", "format": "text", "pkgs": [ "logit:logPolicy_test" ] } ],
+		"levelPolicy": { "ERROR": { "action": "panic" } }`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 0; i < 2; i++ {
+		var recovered interface{}
+		func() {
+			defer func() { recovered = recover() }()
+			l.Error(&myFlags, "Invariant broken")
+		}()
+		if recovered != "ERROR: Invariant broken" {
+			t.Errorf("Logit problem: recovered %v", recovered)
+		}
+	}
+	if lines := getMemorySink(t, "panic").getLines(); len(lines) != 2 {
+		t.Errorf("Logit problem: the sink got %q", lines)
+	}
+}
+
+/*
+  panicky
+  Panics, for TestRecover
+*/
+func panicky(l *Logger, flags *DFlags_t) {
+	defer l.Recover(flags)
+	var m map[string]int
+	m["oops"] = 1
+}
+
+/*
+  TestRecover
+  A recovered panic is logged with the flags of the caller and the
+  stack of where it happened
+*/
+func TestRecover(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "DEBUG",
+		"duplicateInterval": "1h",
+		"sinks": [ { "type": "memory", "id": "recover", "format": "text", "pkgs": [ "logit:logPolicy_test" ] } ],
+		"levelPolicy": {}`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	panicky(l, &myFlags)
+	stats := l.GetLogStats()
+	l.Close()
+
+	lines := getMemorySink(t, "recover").getLines()
+	if len(lines) != 1 || stats.errorCount != 1 {
+		t.Fatalf("Logit problem: %d errors, the sink got %q", stats.errorCount, lines)
+	}
+	if !strings.Contains(lines[0], "ERR[logit:logPolicy_test] panic: assignment to entry in nil map\n") ||
+		!strings.Contains(lines[0], "logit.panicky\n\t") || !strings.Contains(lines[0], "logit.TestRecover\n\t") {
+		t.Errorf("Logit problem: the sink got\n%s", lines[0])
+	}
+}
@@ -0,0 +1,203 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const redactedText = "[REDACTED]" // what a secret is replaced with
+
+/*
+  redactions
+  The named patterns of the "redact" config. Only the "secret" group
+  of a pattern is replaced, the whole match if the pattern has no such
+  group. "all" in the config means every one of them.
+*/
+var redactions = map[string][]string{
+	// passwords in json, in form bodies and other key=value text, and in
+	// maps and structs as fmt prints them, key:value
+	"password": {
+		`(?i)"[a-z_-]*(?:password|passwd|pwd)"\s*:\s*"(?P<secret>[^"]*)"`,
+		`(?i)\b[a-z_-]*(?:password|passwd|pwd)=(?P<secret>[^\s&"]+)`,
+		`(?i)\b[a-z_-]*(?:password|passwd|pwd):\[?(?P<secret>[^\s\]}]+)`,
+	},
+	// secrets, tokens and api keys in json, key=value text, and maps and structs
+	"token": {
+		`(?i)"[a-z_-]*(?:secret|token|apikey|api_key)"\s*:\s*"(?P<secret>[^"]*)"`,
+		`(?i)\b[a-z_-]*(?:secret|token|apikey|api_key)=(?P<secret>[^\s&"]+)`,
+		`(?i)\b[a-z_-]*(?:secret|token|apikey|api_key):\[?(?P<secret>[^\s\]}]+)`,
+	},
+	// the credentials of an Authorization header, of any scheme
+	"authorization": {
+		`(?i)\b(?:proxy-)?authorization:\s*(?P<secret>[^\r\n]+)`,
+	},
+	// bearer tokens wherever they are
+	"bearer": {
+		`(?i)\bbearer\s+(?P<secret>[a-z0-9._~+/=-]+)`,
+	},
+	// the cookies of a Cookie or Set-Cookie header
+	"cookie": {
+		`(?i)\b(?:set-)?cookie:\s*(?P<secret>[^\r\n]+)`,
+	},
+	// json web tokens, header.payload.signature
+	"jwt": {
+		`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+	},
+	// the password of a url
+	"url": {
+		`://[^/@\s:]+:(?P<secret>[^/@\s]+)@`,
+	},
+}
+
+/*
+  redactionNames
+  The names of the named patterns, sorted
+*/
+func redactionNames() []string {
+	names := make([]string, 0, len(redactions))
+	for name := range redactions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+  redactor_t
+  Replaces the secrets in text. The "redact" and "redactPatterns" of
+  the config make the one every message goes through before the sinks
+  and the ring get it. A support bundle uses all the named patterns,
+  those of the config and the "bundleRedact" patterns.
+*/
+type redactor_t struct {
+	names    []string // the named patterns, for the log
+	custom   []string // the regular expressions of the config, for the log
+	patterns []*regexp.Regexp
+}
+
+/*
+  newRedactor
+  A redactor with the named patterns and these regular expressions.
+  An unknown name or a pattern that does not compile is left out and
+  reported. Nil if there is nothing to redact.
+*/
+func newRedactor(names []string, custom []string) (*redactor_t, []error) {
+	r := &redactor_t{}
+	var errs []error
+	for _, name := range names {
+		if strings.EqualFold(strings.TrimSpace(name), "all") {
+			names = redactionNames()
+			break
+		}
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		patterns, found := redactions[name]
+		if !found {
+			errs = append(errs, fmt.Errorf("unknown redact pattern '%s', use all or %s", name,
+				strings.Join(redactionNames(), ", ")))
+			continue
+		}
+		for _, pattern := range patterns {
+			r.patterns = append(r.patterns, regexp.MustCompile(pattern))
+		}
+		r.names = append(r.names, name)
+	}
+	for _, pattern := range custom {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("redact pattern '%s': %s", pattern, err.Error()))
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+		r.custom = append(r.custom, pattern)
+	}
+	if len(r.patterns) == 0 {
+		return nil, errs
+	}
+	return r, errs
+}
+
+/*
+  redact
+  The text with every secret replaced
+*/
+func (r *redactor_t) redact(text string) string {
+	for _, re := range r.patterns {
+		group := re.SubexpIndex("secret")
+		if group < 0 {
+			text = re.ReplaceAllLiteralString(text, redactedText)
+			continue
+		}
+		matches := re.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var out strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 {
+				continue
+			}
+			out.WriteString(text[last:start])
+			out.WriteString(redactedText)
+			last = end
+		}
+		out.WriteString(text[last:])
+		text = out.String()
+	}
+	return text
+}
+
+/*
+  redactRecord
+  Redact the message, the stack and the fields of a record. A field is
+  redacted with its key, so "password" and its value is caught by the
+  key=value patterns, whatever its type, as it is written as text.
+  A field that had a secret becomes the redacted text, the others keep
+  their value. The fields are copied, a record built by hand may share
+  them.
+*/
+func (r *redactor_t) redactRecord(rec *Record_t) {
+	rec.Msg = r.redact(rec.Msg)
+	if len(rec.Stack) > 0 {
+		rec.Stack = r.redact(rec.Stack) // panic values and recovered messages are in it
+	}
+	var fields []Field_t
+	for i, field := range rec.Fields {
+		text := fieldString(field.Value)
+		redacted := r.redact(field.Key + "=" + text)
+		if redacted == field.Key+"="+text {
+			continue
+		}
+		if fields == nil {
+			fields = append([]Field_t(nil), rec.Fields...)
+		}
+		fields[i].Value = strings.TrimPrefix(redacted, field.Key+"=")
+	}
+	if fields != nil {
+		rec.Fields = fields
+	}
+}
+
+/*
+  logTheRedaction
+  Log what is redacted, if anything
+*/
+func (l *Logger) logTheRedaction(flags *logFlags_t) {
+	if flags.redact == nil {
+		return
+	}
+	redactMsg := "Redacting:"
+	for _, name := range flags.redact.names {
+		redactMsg += fmt.Sprintf("\n  redact:'%s'", name)
+	}
+	for _, pattern := range flags.redact.custom {
+		redactMsg += fmt.Sprintf("\n  pattern:'%s'", pattern)
+	}
+	l.Info(&l.myFlags, redactMsg)
+}
@@ -0,0 +1,299 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  ring_t
+  The most recent records in memory, at every level down to the ring
+  level of the config, even those that no output gets. A support
+  session reads them through RingHandler, a FATAL or a panic dumps
+  them to a file.
+*/
+type ring_t struct {
+	mutex   sync.Mutex
+	records []*Record_t // the oldest is at 'next' once the ring is full
+	next    int         // where the next record goes
+	count   int         // records in the ring
+}
+
+/*
+  resize
+  Change the size of the ring, the newest records are kept
+*/
+func (r *ring_t) resize(size int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if size == len(r.records) {
+		return
+	}
+	old := r.snapshotLocked()
+	if len(old) > size {
+		old = old[len(old)-size:]
+	}
+	r.records = make([]*Record_t, size)
+	r.count = copy(r.records, old)
+	r.next = r.count
+	if size > 0 {
+		r.next %= size
+	}
+}
+
+/*
+  add
+  Put the record in the ring, over the oldest one if it is full
+*/
+func (r *ring_t) add(rec *Record_t) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.records) == 0 {
+		return
+	}
+	r.records[r.next] = rec
+	r.next = (r.next + 1) % len(r.records)
+	if r.count < len(r.records) {
+		r.count += 1
+	}
+}
+
+/*
+  snapshot
+  The records of the ring, oldest first
+*/
+func (r *ring_t) snapshot() []*Record_t {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.snapshotLocked()
+}
+
+func (r *ring_t) snapshotLocked() []*Record_t {
+	records := make([]*Record_t, 0, r.count)
+	start := r.next - r.count
+	if start < 0 {
+		start += len(r.records)
+	}
+	for i := 0; i < r.count; i++ {
+		records = append(records, r.records[(start+i)%len(r.records)])
+	}
+	return records
+}
+
+/*
+  ringWants
+  Check if the ring keeps messages of this level
+*/
+func (l *Logger) ringWants(level logLevel_t) bool {
+	flags := l.getLogFlags()
+	return flags.ringSize > 0 && level <= flags.ringLevel
+}
+
+// ringFilter_t picks the records of the ring to list
+type ringFilter_t struct {
+	level logLevel_t // this level and the more severe ones
+	pkgs  []string   // packages or package:files, all if empty
+	since time.Time  // records from this time on
+	limit int        // the newest this many, all if 0
+	reqID string     // only the records of this request, all if empty
+}
+
+/*
+  matches
+  Check if the record passes the filter
+*/
+func (f *ringFilter_t) matches(rec *Record_t) bool {
+	if rec.Level > f.level || rec.Time.Before(f.since) {
+		return false
+	}
+	if len(f.reqID) > 0 && !hasField(rec.Fields, RequestIDField, f.reqID) {
+		return false
+	}
+	if len(f.pkgs) == 0 {
+		return true
+	}
+	for _, name := range f.pkgs {
+		if name == rec.Pkg || name == rec.Pkg+":"+rec.File {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  ringRecords
+  The records of the ring that pass the filter, oldest first
+*/
+func (l *Logger) ringRecords(filter ringFilter_t) []*Record_t {
+	var records []*Record_t
+	for _, rec := range l.ring.snapshot() {
+		if filter.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	if filter.limit > 0 && len(records) > filter.limit {
+		records = records[len(records)-filter.limit:]
+	}
+	return records
+}
+
+/*
+  parseRingFilter
+  The filter of a request to the ring handler:
+  level=WARN, pkg=main,logd:store, since=RFC3339 time or a duration
+  like 5m, limit=100
+*/
+func parseRingFilter(r *http.Request) (ringFilter_t, error) {
+	query := r.URL.Query()
+	filter := ringFilter_t{level: TRACE}
+	var err error
+	if level := query.Get("level"); len(level) > 0 {
+		filter.level, err = parseLevel(level, TRACE)
+		if err != nil {
+			return filter, err
+		}
+	}
+	for _, pkgs := range query["pkg"] {
+		for _, pkg := range strings.Split(pkgs, ",") {
+			if pkg = strings.TrimSpace(pkg); len(pkg) > 0 {
+				filter.pkgs = append(filter.pkgs, pkg)
+			}
+		}
+	}
+	if since := query.Get("since"); len(since) > 0 {
+		if ago, err := time.ParseDuration(since); err == nil {
+			filter.since = time.Now().Add(-ago)
+		} else if filter.since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return filter, fmt.Errorf("since '%s' is not a time or a duration", since)
+		}
+	}
+	if limit := query.Get("limit"); len(limit) > 0 {
+		filter.limit, err = strconv.Atoi(limit)
+		if err != nil || filter.limit < 0 {
+			return filter, fmt.Errorf("limit '%s' is not a count", limit)
+		}
+	}
+	filter.reqID = query.Get(RequestIDField)
+	return filter, nil
+}
+
+/*
+  RingHandler
+  An http.Handler that lists the records of the ring, oldest first,
+  one per line. The query picks them: level, pkg, since, limit and
+  requestId, and format=json gives json lines instead of text.
+  It shows everything that was logged, so mount it where only
+  admins can get to it.
+*/
+func (l *Logger) RingHandler() http.Handler {
+	return http.HandlerFunc(l.serveRing)
+}
+
+/*
+  serveRing
+  The ring handler
+*/
+func (l *Logger) serveRing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseRingFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := parseFormat(r.URL.Query().Get("format"), TEXT)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == JSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	out := bufio.NewWriter(w)
+	for _, rec := range l.ringRecords(filter) {
+		out.WriteString(rec.format(format) + "\n")
+	}
+	out.Flush()
+}
+
+/*
+  ringDumpName
+  Where the ring is dumped: the "ringDumpFile" of the config, or
+  next to the log file
+*/
+func (l *Logger) ringDumpName() string {
+	flags := l.getLogFlags()
+	if len(flags.ringDumpFile) > 0 {
+		return flags.ringDumpFile
+	}
+	if len(flags.logFileName) > 0 {
+		ext := filepath.Ext(flags.logFileName)
+		return strings.TrimSuffix(flags.logFileName, ext) + ".ring" + ext
+	}
+	return "logit.ring.txt"
+}
+
+/*
+  DumpRing
+  Write the records of the ring to the ring dump file, with the
+  reason on the first line. The file is replaced by every dump.
+  Returns the name of the file.
+*/
+func (l *Logger) DumpRing(reason string) (string, error) {
+	name := l.ringDumpName()
+	records := l.ring.snapshot()
+	fh, err := os.Create(name)
+	if err != nil {
+		return name, err
+	}
+	out := bufio.NewWriter(fh)
+	fmt.Fprintf(out, "%s Ring dump of %d records: %s\n", time.Now().Format(time.RFC3339), len(records), reason)
+	for _, rec := range records {
+		out.WriteString(rec.format(TEXT) + "\n")
+	}
+	if err := out.Flush(); err != nil {
+		fh.Close()
+		return name, err
+	}
+	return name, fh.Close()
+}
+
+/*
+  DumpOnPanic
+  Defer it at the top of main or of a goroutine: a panic dumps the
+  ring before it goes on.
+  e.g. defer logger.DumpOnPanic()
+*/
+func (l *Logger) DumpOnPanic() {
+	if r := recover(); r != nil {
+		l.dumpForPanic(r)
+		panic(r)
+	}
+}
+
+/*
+  dumpForPanic
+  Log the panic and dump the ring, the panic goes on afterwards
+*/
+func (l *Logger) dumpForPanic(r interface{}) {
+	reason := fmt.Sprintf("panic: %v", r)
+	l.Error(&l.myFlags, reason)
+	if l.getLogFlags().ringSize == 0 {
+		return
+	}
+	if name, err := l.DumpRing(reason); err != nil {
+		l.Errorf(&l.myFlags, "Ring could not be dumped to '%s': %s", name, err)
+	}
+}
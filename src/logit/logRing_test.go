@@ -0,0 +1,203 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+  TestRingWrap
+  The ring keeps the newest records, also when it is resized
+*/
+func TestRingWrap(t *testing.T) {
+	var ring ring_t
+	ring.add(&Record_t{Msg: "lost"}) // no size, no ring
+	ring.resize(3)
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		ring.add(&Record_t{Msg: msg})
+	}
+	msgs := func() string {
+		var msgs []string
+		for _, rec := range ring.snapshot() {
+			msgs = append(msgs, rec.Msg)
+		}
+		return strings.Join(msgs, ",")
+	}
+	if got := msgs(); got != "3,4,5" {
+		t.Errorf("Logit problem: ring has %s", got)
+	}
+	ring.resize(2)
+	if got := msgs(); got != "4,5" {
+		t.Errorf("Logit problem: smaller ring has %s", got)
+	}
+	ring.resize(4)
+	ring.add(&Record_t{Msg: "6"})
+	if got := msgs(); got != "4,5,6" {
+		t.Errorf("Logit problem: bigger ring has %s", got)
+	}
+}
+
+/*
+  TestRingLevels
+  The ring keeps the messages below the level of the config,
+  the file does not get them and they are not counted
+*/
+func TestRingLevels(t *testing.T) {
+	logFileName := filepath.Join(t.TempDir(), "logTestFile.txt")
+	l := newTestLogger(t, `
+		"filename": "`+logFileName+`",
+		"stdout": false,
+		"level": "WARN",
+		"ringSize": 100,
+		"ringDumpFile": "`+filepath.Join(t.TempDir(), "dump.txt")+`"`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Trace(&myFlags, "Trace only in the ring")
+	l.Debug(&myFlags, "Debug only in the ring")
+	l.Infof(&myFlags, "Info %s", "only in the ring")
+	l.Warn(&myFlags, "Warning everywhere")
+	stats := l.GetLogStats()
+	l.Close()
+
+	records := l.ringRecords(ringFilter_t{level: TRACE, pkgs: []string{"logit:logRing_test"}})
+	var msgs []string
+	for _, rec := range records {
+		msgs = append(msgs, rec.Msg)
+	}
+	if strings.Join(msgs, ",") != "Trace only in the ring,Debug only in the ring,Info only in the ring,Warning everywhere" {
+		t.Errorf("Logit problem: ring has %q", msgs)
+	}
+	if stats.infoCount != 0 || stats.debugCount != 0 || stats.traceCount != 0 || stats.warnCount != 1 {
+		t.Errorf("Logit problem: stats %+v", stats)
+	}
+	raw, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if strings.Contains(string(raw), "only in the ring") || !strings.Contains(string(raw), "Warning everywhere") {
+		t.Errorf("Logit problem: log file has\n%s", raw)
+	}
+}
+
+/*
+  TestRingHandler
+  The handler lists the records by level, package, time and count
+*/
+func TestRingHandler(t *testing.T) {
+	l := newTestLogger(t, `
+		"filename": "`+filepath.Join(t.TempDir(), "logTestFile.txt")+`",
+		"stdout": false,
+		"level": "WARN",
+		"ringSize": 100,
+		"ringDumpFile": "`+filepath.Join(t.TempDir(), "dump.txt")+`"`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "first info")
+	l.Error(&myFlags, "first error")
+	l.Info(&myFlags, "second info")
+	l.Error(&myFlags, "second error")
+
+	get := func(query string) (int, string) {
+		w := httptest.NewRecorder()
+		l.RingHandler().ServeHTTP(w, httptest.NewRequest("GET", "/logs?"+query, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := get("pkg=logit:logRing_test")
+	if code != http.StatusOK || strings.Count(body, "\n") != 4 || !strings.HasSuffix(body, "ERR[logit:logRing_test] second error\n") {
+		t.Errorf("Logit problem: %d\n%s", code, body)
+	}
+	code, body = get("level=ERROR&pkg=other,logit&since=1h")
+	if code != http.StatusOK || strings.Contains(body, "info") || strings.Count(body, "error\n") != 2 {
+		t.Errorf("Logit problem: %d\n%s", code, body)
+	}
+	code, body = get("level=ERROR&pkg=logit&limit=1&format=json")
+	var rec jsonRecord_t
+	if err := json.Unmarshal([]byte(body), &rec); code != http.StatusOK || err != nil || rec.Msg != "second error" {
+		t.Errorf("Logit problem: %d\n%s", code, body)
+	}
+	if code, _ = get("since=2100-01-01T00:00:00Z"); code != http.StatusOK {
+		t.Errorf("Logit problem: since a time got %d", code)
+	}
+	for _, query := range []string{"level=LOUD", "since=yesterday", "limit=-1", "format=xml"} {
+		if code, _ = get(query); code != http.StatusBadRequest {
+			t.Errorf("Logit problem: '%s' got %d", query, code)
+		}
+	}
+	w := httptest.NewRecorder()
+	l.RingHandler().ServeHTTP(w, httptest.NewRequest("POST", "/logs", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Logit problem: POST got %d", w.Code)
+	}
+}
+
+/*
+  TestRingDumpOnFatal
+  A FATAL dumps the ring, with what was below the level
+*/
+func TestRingDumpOnFatal(t *testing.T) {
+	dumpFileName := filepath.Join(t.TempDir(), "dump.txt")
+	l := newTestLogger(t, `
+		"filename": "`+filepath.Join(t.TempDir(), "logTestFile.txt")+`",
+		"stdout": false,
+		"level": "WARN",
+		"ringSize": 100,
+		"ringDumpFile": "`+dumpFileName+`"`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Debug(&myFlags, "What led up to it")
+	l.Fatal(&myFlags, "Out of memory")
+
+	raw, err := os.ReadFile(dumpFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if !strings.HasSuffix(lines[0], "FATAL: Out of memory") || !strings.Contains(string(raw), "DBUG[logit:logRing_test] What led up to it") ||
+		!strings.HasSuffix(lines[len(lines)-1], "FATAL[logit:logRing_test] Out of memory") {
+		t.Errorf("Logit problem: dump has\n%s", raw)
+	}
+}
+
+/*
+  TestDumpOnPanic
+  A panic dumps the ring and goes on
+*/
+func TestDumpOnPanic(t *testing.T) {
+	dumpFileName := filepath.Join(t.TempDir(), "dump.txt")
+	l := newTestLogger(t, `
+		"filename": "`+filepath.Join(t.TempDir(), "logTestFile.txt")+`",
+		"stdout": false,
+		"level": "WARN",
+		"ringSize": 100,
+		"ringDumpFile": "`+dumpFileName+`"`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Before the panic")
+
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		defer l.DumpOnPanic()
+		panic("boom")
+	}()
+	if recovered != "boom" {
+		t.Errorf("Logit problem: the panic did not go on, got %v", recovered)
+	}
+	raw, err := os.ReadFile(dumpFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if !strings.Contains(string(raw), "Ring dump of") || !strings.Contains(string(raw), "panic: boom") ||
+		!strings.Contains(string(raw), "Before the panic") {
+		t.Errorf("Logit problem: dump has\n%s", raw)
+	}
+}
@@ -0,0 +1,354 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotate_t holds the rotation settings of the log file
+type rotate_t struct {
+	maxSize  int64 // rotate before the file grows past this, 0 for no limit
+	daily    bool  // rotate when the day changes, local time
+	keep     int   // rotated files kept, 0 keeps them all
+	compress bool  // gzip the rotated files
+}
+
+const (
+	rotateStamp = "20060102-150405.000" // time a file was rotated, part of its name
+	dayStamp    = "2006-01-02"          // the day a file was started
+	rotateRetry = time.Minute           // after a failed rotation, how long until the next try
+)
+
+var renameFile = os.Rename // the tests make the rotation fail
+
+/*
+  logFile_t
+  The log file and its rotation.
+  All but the compressing and pruning is done holding the writeMutex
+  of the logger.
+*/
+type logFile_t struct {
+	name   string        // the name from the config, rotated files are named after it
+	handle *os.File      // handle to the log file itself
+	writer *bufio.Writer // handle to the writer to the log file
+	audit  *audit_t      // seals every line, nil if the config has no "audit"
+	size   int64         // bytes in the current file
+	day    string        // the day the current file was started
+
+	retryAt   time.Time // a rotation failed, the next try is after this
+	retrySize int64     // or once the file grows past this multiple of the max size
+	failing   bool      // the failed rotation was reported, until one works again
+
+	maint      sync.WaitGroup // compress and prune still running
+	maintMutex sync.Mutex     // one compress and prune at a time
+}
+
+/*
+  parseSize
+  Turn a size of the config, like "maxFileSize", into bytes.
+  A number is bytes, a string can end with KB, MB or GB.
+*/
+func parseSize(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if v < 0 {
+			return 0, fmt.Errorf("size %v is negative", v)
+		}
+		return int64(v), nil
+	case string:
+		str := strings.ToUpper(strings.TrimSpace(v))
+		unit := int64(1)
+		for suffix, size := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+			if strings.HasSuffix(str, suffix) {
+				str = strings.TrimSpace(strings.TrimSuffix(str, suffix))
+				unit = size
+				break
+			}
+		}
+		str = strings.TrimSuffix(str, "B")
+		size, err := strconv.ParseInt(str, 10, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("bad size '%s', use e.g. 500KB, 10MB or 1GB", v)
+		}
+		return size * unit, nil
+	}
+	return 0, fmt.Errorf("bad size '%v', use e.g. 500KB, 10MB or 1GB", value)
+}
+
+/*
+  openLogFile
+  Open the log file for appending, the logs of earlier runs are kept
+*/
+func openLogFile(name string) (*logFile_t, error) {
+	lf := &logFile_t{name: name}
+	if err := lf.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+/*
+  open
+  Open or create the file and pick up its size and the day it was started
+*/
+func (lf *logFile_t) open(now time.Time) error {
+	handle, err := os.OpenFile(lf.name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	lf.handle = handle
+	lf.writer = bufio.NewWriterSize(handle, 16384)
+	lf.size = 0
+	lf.day = now.Format(dayStamp)
+	if info, err := handle.Stat(); err == nil && info.Size() > 0 {
+		lf.size = info.Size()
+		lf.day = info.ModTime().Format(dayStamp) // when it was last written
+	}
+	return nil
+}
+
+/*
+  closeFile
+  Flush and close the current file, the error is the first that failed
+*/
+func (lf *logFile_t) closeFile() error {
+	flushErr := lf.writer.Flush()
+	closeErr := lf.handle.Close()
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+/*
+  flush
+  Push what is buffered out to the file
+*/
+func (lf *logFile_t) flush() error {
+	return lf.writer.Flush()
+}
+
+/*
+  close
+  Close the file and wait for the compressing and pruning to finish
+*/
+func (lf *logFile_t) close() error {
+	err := lf.closeFile()
+	lf.maint.Wait()
+	return err
+}
+
+/*
+  reopen
+  Close and open the file by its name again, for rotators that
+  renamed it from outside
+*/
+func (lf *logFile_t) reopen() error {
+	closeErr := lf.closeFile()
+	if err := lf.open(time.Now()); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+/*
+  write
+  Write one line, rotating first if the line does not fit
+  or the day has changed. The name of the rotated file is returned,
+  empty if there was no rotation, and the error of the write.
+  A rotation that fails is reported once, and tried again after a
+  while or at the next multiple of the max size, not on every line.
+*/
+func (lf *logFile_t) write(line string, now time.Time, rotate rotate_t) (string, error) {
+	var rotated string
+	if lf.needsRotate(int64(len(line)), now, rotate) {
+		var err error
+		rotated, err = lf.rotate(now, rotate)
+		if len(rotated) == 0 { // the logs stay in this file for now
+			lf.retryAt = now.Add(rotateRetry)
+			if rotate.maxSize > 0 {
+				lf.retrySize = (lf.size/rotate.maxSize + 1) * rotate.maxSize
+			}
+			if !lf.failing {
+				fmt.Fprintf(os.Stderr, "logit: rotating '%s' failed, trying again in %s: %s\n", lf.name, rotateRetry, err)
+				lf.failing = true
+			}
+		} else {
+			lf.retryAt, lf.failing = time.Time{}, false
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "logit: closing '%s' failed: %s\n", rotated, err)
+			}
+		}
+	}
+	n, err := lf.writer.WriteString(line)
+	lf.size += int64(n)
+	return rotated, err
+}
+
+/*
+  needsRotate
+  Check the size and the day, an empty file is never rotated.
+  After a failed rotation it waits for the retry.
+*/
+func (lf *logFile_t) needsRotate(n int64, now time.Time, rotate rotate_t) bool {
+	if lf.size == 0 {
+		return false
+	}
+	if now.Before(lf.retryAt) && (rotate.maxSize == 0 || lf.size+n <= lf.retrySize) {
+		return false
+	}
+	if rotate.maxSize > 0 && lf.size+n > rotate.maxSize {
+		return true
+	}
+	return rotate.daily && now.Format(dayStamp) != lf.day
+}
+
+/*
+  rotate
+  Rename the current file to "<name>-<time><ext>" and start a new one.
+  The compressing and pruning of the rotated files is done in the
+  background. If the rename fails the logs stay in the current file.
+*/
+func (lf *logFile_t) rotate(now time.Time, rotate rotate_t) (string, error) {
+	closeErr := lf.closeFile() // the end of the rotated file may be lost
+	rotated := lf.rotatedName(now)
+	renameErr := renameFile(lf.name, rotated)
+	if err := lf.open(now); err != nil {
+		return "", err
+	}
+	if renameErr != nil {
+		return "", renameErr
+	}
+	lf.maint.Add(1)
+	go func() {
+		defer lf.maint.Done()
+		lf.maintain(rotate)
+	}()
+	return rotated, closeErr
+}
+
+/*
+  maintain
+  Remove the oldest rotated files past 'keep' and compress the others.
+  It looks at all the rotated files, so it does not matter which
+  rotation started it.
+*/
+func (lf *logFile_t) maintain(rotate rotate_t) {
+	lf.maintMutex.Lock()
+	defer lf.maintMutex.Unlock()
+	files := rotatedFiles(lf.name)
+	if rotate.keep > 0 {
+		for len(files) > rotate.keep {
+			os.Remove(files[0])
+			files = files[1:]
+		}
+	}
+	if rotate.compress {
+		for _, name := range files {
+			if strings.HasSuffix(name, ".gz") {
+				continue
+			}
+			if err := compressFile(name); err != nil {
+				fmt.Fprintf(os.Stderr, "logit: compressing '%s' failed: %s\n", name, err)
+			}
+		}
+	}
+}
+
+/*
+  rotatedName
+  A name for the rotated file that is not used yet.
+  The names sort in the order the files were rotated.
+*/
+func (lf *logFile_t) rotatedName(now time.Time) string {
+	ext := filepath.Ext(lf.name)
+	base := strings.TrimSuffix(lf.name, ext)
+	for {
+		name := base + "-" + now.Format(rotateStamp) + ext
+		_, err := os.Stat(name)
+		_, errGz := os.Stat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(errGz) {
+			return name
+		}
+		now = now.Add(time.Millisecond)
+	}
+}
+
+/*
+  rotatedFiles
+  The rotated files of a log file, oldest first
+*/
+func rotatedFiles(name string) []string {
+	ext := filepath.Ext(name)
+	base := filepath.Base(strings.TrimSuffix(name, ext))
+	dir := filepath.Dir(name)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		stamp := entry.Name()
+		if !strings.HasPrefix(stamp, base+"-") {
+			continue
+		}
+		stamp = strings.TrimPrefix(stamp, base+"-")
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ext)
+		if _, err := time.Parse(rotateStamp, stamp); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files
+}
+
+/*
+  compressFile
+  gzip the file to "<file>.gz" and remove it
+*/
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmpName := name + ".gz.tmp" // not seen as a rotated file until it is complete
+	out, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
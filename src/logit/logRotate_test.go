@@ -0,0 +1,208 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  TestParseSize
+  The maxFileSize of the config, bytes or with a unit
+*/
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		value interface{}
+		size  int64
+	}{
+		{nil, 0},
+		{float64(4096), 4096},
+		{"4096", 4096},
+		{"500KB", 500 << 10},
+		{"10mb", 10 << 20},
+		{"1 GB", 1 << 30},
+	} {
+		size, err := parseSize(test.value)
+		if err != nil || size != test.size {
+			t.Errorf("Logit problem: size of %v is %d, %v", test.value, size, err)
+		}
+	}
+	for _, bad := range []interface{}{"ten", "-1", float64(-1), true} {
+		if _, err := parseSize(bad); err == nil {
+			t.Errorf("Logit problem: size %v was accepted", bad)
+		}
+	}
+}
+
+/*
+  TestLogRotateSize
+  The file is rotated before it grows past the max size,
+  the rotated files are compressed and only 'keepFiles' are kept
+*/
+func TestLogRotateSize(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	jsonTest := `
+	{
+		"SiteID": "BeyondAI",
+		"filename": "` + logFileName + `",
+		"level": "INFO",
+		"maxFileSize": "2KB",
+		"keepFiles": 2,
+		"compress": true
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 0; i < 200; i++ {
+		l.Infof(&myFlags, "Rotate line %03d %s", i, strings.Repeat("x", 40))
+	}
+	l.Close()
+
+	files := rotatedFiles(logFileName)
+	if len(files) != 2 {
+		t.Fatalf("Logit problem: rotated files %v", files)
+	}
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("Logit problem: '%s' is not compressed", name)
+			continue
+		}
+		fh, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		zr, err := gzip.NewReader(fh)
+		if err != nil {
+			t.Fatalf("Logit problem: '%s' %s", name, err.Error())
+		}
+		raw, err := ioutil.ReadAll(zr)
+		fh.Close()
+		if err != nil || len(raw) == 0 || len(raw) > 2048 {
+			t.Errorf("Logit problem: '%s' has %d bytes, %v", name, len(raw), err)
+		}
+	}
+	info, err := os.Stat(logFileName)
+	if err != nil || info.Size() > 2048 {
+		t.Errorf("Logit problem: current log file %v, %v", info, err)
+	}
+	raw, _ := ioutil.ReadFile(logFileName)
+	if !strings.Contains(string(raw), "Log file is being closed.") {
+		t.Errorf("Logit problem: last lines are not in the current file:\n%s", raw)
+	}
+}
+
+/*
+  TestLogRotateDaily
+  A new day starts a new file, an empty file is never rotated
+*/
+func TestLogRotateDaily(t *testing.T) {
+	dir := t.TempDir()
+	logFileName := filepath.Join(dir, "daily.log")
+	day1 := time.Date(2019, 3, 4, 23, 59, 0, 0, time.Local)
+	day2 := day1.Add(2 * time.Minute)
+	lf, err := openLogFile(logFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	lf.day = day1.Format(dayStamp)
+	rotate := rotate_t{daily: true}
+	lf.write("day one\n", day1, rotate)
+	lf.write("day two\n", day2, rotate)
+	lf.write("day two again\n", day2, rotate)
+	lf.close()
+
+	files := rotatedFiles(logFileName)
+	if len(files) != 1 || !strings.Contains(files[0], "daily-20190305-") {
+		t.Fatalf("Logit problem: rotated files %v", files)
+	}
+	old, _ := ioutil.ReadFile(files[0])
+	current, _ := ioutil.ReadFile(logFileName)
+	if string(old) != "day one\n" || string(current) != "day two\nday two again\n" {
+		t.Errorf("Logit problem: rotated '%s', current '%s'", old, current)
+	}
+}
+
+/*
+  TestLogRotateFails
+  A rotation that fails is tried again at the next multiple of the max
+  size, or a minute later, not on every line. The lines stay in the
+  file, and one that works starts over.
+*/
+func TestLogRotateFails(t *testing.T) {
+	logFileName := filepath.Join(t.TempDir(), "failing.log")
+	lf, err := openLogFile(logFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer lf.close()
+	tries := 0
+	renameFile = func(string, string) error {
+		tries++
+		return os.ErrPermission
+	}
+	defer func() { renameFile = os.Rename }()
+	now := time.Now()
+	rotate := rotate_t{maxSize: 100}
+	for i := 0; i < 50; i++ { // 500 bytes, past 100, 200, 300 and 400
+		lf.write("line "+strconv.Itoa(i%10)+"...\n", now, rotate)
+	}
+	if tries != 4 || !lf.failing || lf.size != 500 {
+		t.Errorf("Logit problem: %d tries, failing %v, %d bytes", tries, lf.failing, lf.size)
+	}
+	lf.write("past 500.\n", now.Add(time.Second), rotate)
+	lf.write("not yet..\n", now.Add(2*time.Second), rotate)
+	if tries != 5 {
+		t.Errorf("Logit problem: %d tries before the minute is up", tries)
+	}
+	lf.write("a minute.\n", now.Add(time.Second+rotateRetry), rotate)
+	if tries != 6 {
+		t.Errorf("Logit problem: %d tries a minute later", tries)
+	}
+	renameFile = os.Rename
+	if rotated, _ := lf.write("it works again\n", now.Add(3*rotateRetry), rotate); len(rotated) == 0 || lf.failing {
+		t.Errorf("Logit problem: not rotated once the rename works, failing %v", lf.failing)
+	}
+}
+
+/*
+  TestLogAppend
+  Opening the log again keeps the lines of the last run
+*/
+func TestLogAppend(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	err := writeConfigFile(configFileName, `{ "filename": "`+logFileName+`", "level": "INFO" }`)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for run := 1; run <= 2; run++ {
+		l, err := NewLogger(configFileName)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		l.Infof(&myFlags, "Run %d", run)
+		l.Close()
+	}
+	raw, _ := ioutil.ReadFile(logFileName)
+	if !strings.Contains(string(raw), "] Run 1") || !strings.Contains(string(raw), "] Run 2") {
+		t.Errorf("Logit problem: log file:\n%s", raw)
+	}
+}
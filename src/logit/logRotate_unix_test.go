@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*
+  TestLogReopenHUP
+  After an external rotator moved the file, a SIGHUP starts a new one
+*/
+func TestLogReopenHUP(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	err := writeConfigFile(configFileName, `{ "filename": "`+logFileName+`", "level": "INFO" }`)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Before the move")
+	movedName := logFileName + ".1"
+	if err := os.Rename(logFileName, movedName); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	reopened := false
+	for i := 0; i < 100 && !reopened; i++ { // the signal is handled in the background
+		time.Sleep(10 * time.Millisecond)
+		_, err := os.Stat(logFileName)
+		reopened = err == nil
+	}
+	l.Info(&myFlags, "After the move")
+	l.Close()
+	if !reopened {
+		t.Fatalf("Logit problem: log file was not reopened")
+	}
+	moved, _ := ioutil.ReadFile(movedName)
+	current, _ := ioutil.ReadFile(logFileName)
+	if !strings.Contains(string(moved), "Before the move") || strings.Contains(string(moved), "After the move") ||
+		!strings.Contains(string(current), "After the move") {
+		t.Errorf("Logit problem: moved:\n%s\ncurrent:\n%s", moved, current)
+	}
+}
@@ -0,0 +1,328 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultSampleInterval = time.Second // the interval of a sampling rule without one
+
+/*
+  sampleRule_t
+  One "sampling" entry of the config: in every interval the first
+  'first' messages of a level of the package or file are logged, then
+  1 in 'thereafter' of them. No 'thereafter' drops the rest.
+*/
+type sampleRule_t struct {
+	pkg        string        // the package, all packages if empty
+	file       string        // the file of the package, all files if empty
+	level      logLevel_t    // the level of the messages
+	allLevels  bool          // no level, the rule is for all the levels but FATAL
+	first      int64         // messages logged at the start of each interval
+	thereafter int64         // then 1 in this many is logged
+	interval   time.Duration // the counting starts over every interval
+}
+
+/*
+  score
+  How specific the rule is, the most specific rule that matches is used
+*/
+func (r *sampleRule_t) score() int {
+	score := 0
+	if len(r.file) > 0 {
+		score += 4
+	}
+	if len(r.pkg) > 0 {
+		score += 2
+	}
+	if !r.allLevels {
+		score += 1
+	}
+	return score
+}
+
+/*
+  matches
+  Check if the rule is for a message of this level, package and file
+*/
+func (r *sampleRule_t) matches(level logLevel_t, pkgName string, fileName string) bool {
+	return (r.allLevels || r.level == level) && (len(r.pkg) == 0 || r.pkg == pkgName) &&
+		(len(r.file) == 0 || r.file == fileName)
+}
+
+/*
+  findSampleRule
+  The most specific rule for the message, nil if none matches
+*/
+func findSampleRule(rules []sampleRule_t, level logLevel_t, pkgName string, fileName string) *sampleRule_t {
+	var found *sampleRule_t
+	for i := range rules {
+		if rules[i].matches(level, pkgName, fileName) && (found == nil || rules[i].score() > found.score()) {
+			found = &rules[i]
+		}
+	}
+	return found
+}
+
+// sampleCount_t counts the messages of one level, package and file in an interval
+type sampleCount_t struct {
+	generation int32     // the config the counting was started with
+	start      time.Time // the start of the interval
+	count      int64     // messages in this interval
+}
+
+// duplicate_t is the last message of one level, package and file, and how often it repeated
+type duplicate_t struct {
+	text  string      // the message and its fields
+	rec   *Record_t   // the first of the repeats, for the summary
+	since time.Time   // the start of the interval
+	count int64       // repeats not logged in this interval
+	timer *time.Timer // logs the summary at the end of the interval
+}
+
+/*
+  sampler_t
+  The counting for the sampling rules and the duplicates of a logger.
+  It sits between the level methods and the writer, a message it
+  drops is counted in the stats but is never queued.
+*/
+type sampler_t struct {
+	mutex      sync.Mutex
+	counts     map[string]*sampleCount_t // by level, package and file
+	duplicates map[string]*duplicate_t   // by level, package and file
+	timers     sync.WaitGroup            // the summary timers that are set or running
+	closed     bool                      // Close flushed the duplicates, no more are kept
+}
+
+/*
+  sampleKey
+  What the counting is done by
+*/
+func sampleKey(rec *Record_t) string {
+	return rec.Level.String() + "|" + rec.Pkg + ":" + rec.File
+}
+
+/*
+  sampled
+  Check the sampling rules of the config the record was logged with.
+  Returns false if the record is dropped.
+*/
+func (l *Logger) sampled(rec *Record_t) bool {
+	rule := findSampleRule(rec.flags.sampling, rec.Level, rec.Pkg, rec.File)
+	if rule == nil {
+		return true
+	}
+	s := &l.sampler
+	key := sampleKey(rec)
+	s.mutex.Lock()
+	if s.counts == nil {
+		s.counts = make(map[string]*sampleCount_t)
+	}
+	counter := s.counts[key]
+	if counter == nil || counter.generation != rec.Generation || rec.Time.Sub(counter.start) >= rule.interval {
+		counter = &sampleCount_t{generation: rec.Generation, start: rec.Time}
+		s.counts[key] = counter
+	}
+	counter.count += 1
+	count := counter.count
+	s.mutex.Unlock()
+	if count <= rule.first || (rule.thereafter > 0 && (count-rule.first)%rule.thereafter == 0) {
+		return true
+	}
+	atomic.AddInt64(&l.stats.sampledDropped, 1)
+	return false
+}
+
+/*
+  deduplicated
+  Drop a message that is the same as the last one of its level,
+  package and file in the interval. The count of the repeats is
+  logged when the interval is over, or before the next message that
+  is not the same. Returns false if the record is dropped.
+*/
+func (l *Logger) deduplicated(rec *Record_t) bool {
+	interval := rec.flags.duplicateInterval
+	if interval <= 0 {
+		return true
+	}
+	s := &l.sampler
+	key := sampleKey(rec)
+	text := rec.Msg + formatTextFields(rec.Fields)
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return true
+	}
+	if s.duplicates == nil {
+		s.duplicates = make(map[string]*duplicate_t)
+	}
+	dup := s.duplicates[key]
+	if dup != nil && dup.text == text && rec.Time.Sub(dup.since) < interval {
+		dup.count += 1
+		if dup.timer == nil {
+			s.timers.Add(1)
+			dup.timer = time.AfterFunc(interval-rec.Time.Sub(dup.since), func() {
+				defer s.timers.Done()
+				l.summarizeDuplicate(key)
+			})
+		}
+		s.mutex.Unlock()
+		atomic.AddInt64(&l.stats.duplicatesDropped, 1)
+		return false
+	}
+	var summary *Record_t
+	if dup != nil {
+		summary = dup.summary(&s.timers)
+	}
+	s.duplicates[key] = &duplicate_t{text: text, rec: rec, since: rec.Time}
+	s.mutex.Unlock()
+	if summary != nil { // the repeats go before the new message
+		l.sendRecord(summary)
+	}
+	return true
+}
+
+/*
+  summary
+  The "message repeated K times" record of the repeats, nil if there
+  were none. The timer is stopped and the count starts over.
+  Call with the sampler mutex held.
+*/
+func (dup *duplicate_t) summary(timers *sync.WaitGroup) *Record_t {
+	if dup.timer != nil {
+		if dup.timer.Stop() { // it will not run, a running one is done when it returns
+			timers.Done()
+		}
+		dup.timer = nil
+	}
+	if dup.count == 0 {
+		return nil
+	}
+	summary := *dup.rec
+	summary.Time = time.Now()
+	summary.counted = false // the repeats are counted as duplicates
+	summary.Msg = fmt.Sprintf("message repeated %d times: %s", dup.count, dup.rec.Msg)
+	dup.count = 0
+	return &summary
+}
+
+/*
+  summarizeDuplicate
+  The end of the interval of a repeated message, log how often it repeated.
+  The interval starts over, so a message that keeps repeating gets a
+  summary every interval.
+*/
+func (l *Logger) summarizeDuplicate(key string) {
+	s := &l.sampler
+	s.mutex.Lock()
+	var summary *Record_t
+	if dup := s.duplicates[key]; dup != nil {
+		summary = dup.summary(&s.timers)
+		dup.since = time.Now()
+	}
+	s.mutex.Unlock()
+	if summary != nil {
+		l.sendRecord(summary)
+	}
+}
+
+/*
+  flushDuplicates
+  Log the repeats that are waiting for the end of their interval,
+  Close does this before the writer is stopped. The timers are
+  stopped, and the ones that were already running are waited for,
+  so no summary comes after the writer is gone.
+*/
+func (l *Logger) flushDuplicates() {
+	s := &l.sampler
+	var summaries []*Record_t
+	s.mutex.Lock()
+	s.closed = true
+	for key, dup := range s.duplicates {
+		if summary := dup.summary(&s.timers); summary != nil {
+			summaries = append(summaries, summary)
+		}
+		delete(s.duplicates, key)
+	}
+	s.mutex.Unlock()
+	s.timers.Wait()
+	for _, summary := range summaries {
+		l.sendRecord(summary)
+	}
+}
+
+/*
+  parseSampling
+  Turn the "sampling" entries of the config into the rules
+*/
+func (l *Logger) parseSampling(entries []sampleJson_t) []sampleRule_t {
+	var rules []sampleRule_t
+	for _, entry := range entries {
+		rule := sampleRule_t{pkg: entry.Pkg, file: entry.File, first: entry.First, thereafter: entry.Thereafter,
+			interval: defaultSampleInterval}
+		if len(entry.Level) == 0 {
+			rule.allLevels = true
+		} else {
+			level, err := parseLevel(entry.Level, WARN)
+			if err != nil {
+				l.delayLog(WARN, "sampling: "+err.Error())
+				continue
+			}
+			if level == FATAL {
+				l.delayLog(WARN, "sampling: FATAL messages are never sampled.")
+				continue
+			}
+			rule.level = level
+		}
+		if len(entry.Interval) > 0 {
+			interval, err := time.ParseDuration(entry.Interval)
+			if err != nil || interval <= 0 {
+				l.delayLog(WARN, fmt.Sprintf("sampling: bad interval '%s', using %s.", entry.Interval, defaultSampleInterval))
+			} else {
+				rule.interval = interval
+			}
+		}
+		if rule.first < 0 || rule.thereafter < 0 {
+			l.delayLog(WARN, fmt.Sprintf("sampling: first %d and thereafter %d cannot be negative.",
+				rule.first, rule.thereafter))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// sampleJson_t is one "sampling" entry of the config
+type sampleJson_t struct {
+	Pkg        string `json:"pkg" check:"pkg"`
+	File       string `json:"file"`
+	Level      string `json:"level" check:"level"`
+	First      int64  `json:"first"`
+	Thereafter int64  `json:"thereafter"`
+	Interval   string `json:"interval" check:"duration"`
+}
+
+/*
+  logTheSampling
+  Log the sampling rules and the duplicate suppression, if there are any
+*/
+func (l *Logger) logTheSampling(flags *logFlags_t) {
+	if len(flags.sampling) > 0 {
+		sampleMsg := "Sampling:"
+		for _, rule := range flags.sampling {
+			level := "all levels"
+			if !rule.allLevels {
+				level = rule.level.String()
+			}
+			sampleMsg += fmt.Sprintf("\n  pkg:'%s', file:'%s', %s, first %d then 1 in %d every %s",
+				rule.pkg, rule.file, level, rule.first, rule.thereafter, rule.interval)
+		}
+		l.Info(&l.myFlags, sampleMsg)
+	}
+	if flags.duplicateInterval > 0 {
+		l.Infof(&l.myFlags, "Repeated messages are summarized every %s.", flags.duplicateInterval)
+	}
+}
@@ -0,0 +1,184 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  TestFindSampleRule
+  The most specific rule wins: file over package over all, and a
+  level over all levels
+*/
+func TestFindSampleRule(t *testing.T) {
+	rules := []sampleRule_t{
+		{allLevels: true, first: 1},
+		{pkg: "main", allLevels: true, first: 2},
+		{pkg: "main", level: WARN, first: 3},
+		{pkg: "main", file: "server", allLevels: true, first: 4},
+		{level: ERROR, first: 5},
+	}
+	for _, test := range []struct {
+		level    logLevel_t
+		pkg      string
+		file     string
+		expected int64
+	}{
+		{INFO, "logd", "store", 1},
+		{ERROR, "logd", "store", 5},
+		{INFO, "main", "main", 2},
+		{WARN, "main", "main", 3},
+		{WARN, "main", "server", 4},
+		{ERROR, "main", "main", 2},
+	} {
+		rule := findSampleRule(rules, test.level, test.pkg, test.file)
+		if rule == nil || rule.first != test.expected {
+			t.Errorf("Logit problem: %s %s:%s got rule %+v", test.level, test.pkg, test.file, rule)
+		}
+	}
+	if rule := findSampleRule(rules[2:3], INFO, "main", "main"); rule != nil {
+		t.Errorf("Logit problem: a WARN rule matched INFO")
+	}
+}
+
+/*
+  TestSampling
+  The first 3 warnings are logged, then 1 in 5, the rest are counted
+*/
+func TestSampling(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"sinks": [ { "type": "memory", "id": "sampling", "pkgs": [ "logit:logSample_test" ] } ],
+		"sampling": [
+			{ "pkg": "logit", "file": "logSample_test", "level": "WARN", "first": 3, "thereafter": 5, "interval": "1h" } ]`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 1; i <= 20; i++ {
+		l.Warnf(&myFlags, "Dependency is down, try %d", i)
+		l.Infof(&myFlags, "Info %d is not sampled", i)
+	}
+	stats := l.GetLogStats()
+	l.Close()
+
+	var warnings []string
+	infos := 0
+	for _, line := range getMemorySink(t, "sampling").getLines() {
+		if strings.Contains(line, "Dependency is down") {
+			warnings = append(warnings, line[strings.LastIndex(line, " ")+1:])
+		} else if strings.Contains(line, "is not sampled") {
+			infos += 1
+		}
+	}
+	if strings.Join(warnings, ",") != "1,2,3,8,13,18" || infos != 20 {
+		t.Errorf("Logit problem: warnings %q and %d infos were logged", warnings, infos)
+	}
+	if stats.sampledDropped != 14 || stats.warnCount != 6 || stats.infoCount < 20 {
+		t.Errorf("Logit problem: %d sampled out, %d warnings counted", stats.sampledDropped, stats.warnCount)
+	}
+}
+
+/*
+  TestDuplicates
+  Repeats of a message are counted and summarized before the next
+  message that is different, and when the logger is closed
+*/
+func TestDuplicates(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"sinks": [ { "type": "memory", "id": "duplicates", "pkgs": [ "logit:logSample_test" ] } ],
+		"duplicateInterval": "1h"`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 0; i < 10; i++ {
+		l.Warn(&myFlags, "Connection refused")
+	}
+	l.Warn(&myFlags, "Connection is back")
+	l.Error(&myFlags, "Disk full")
+	l.Error(&myFlags, "Disk full")
+	l.Error(&myFlags, "Disk full")
+	stats := l.GetLogStats()
+	l.Close()
+
+	var lines []string
+	for _, line := range getMemorySink(t, "duplicates").getLines() {
+		lines = append(lines, line[strings.Index(line, " ")+1:])
+	}
+	expected := []string{
+		"WARN[logit:logSample_test] Connection refused",
+		"WARN[logit:logSample_test] message repeated 9 times: Connection refused",
+		"WARN[logit:logSample_test] Connection is back",
+		"ERR[logit:logSample_test] Disk full",
+		"ERR[logit:logSample_test] message repeated 2 times: Disk full",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Logit problem: logged\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+	if stats.duplicatesDropped != 11 || stats.warnCount != 2 || stats.errorCount != 1 {
+		t.Errorf("Logit problem: %d repeats, %d warnings and %d errors counted", stats.duplicatesDropped,
+			stats.warnCount, stats.errorCount)
+	}
+}
+
+/*
+  TestDuplicatesClosed
+  Close stops the timers of the repeats and logs their summaries,
+  nothing is kept after it
+*/
+func TestDuplicatesClosed(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"sinks": [ { "type": "memory", "id": "closed", "pkgs": [ "logit:logSample_test" ] } ],
+		"duplicateInterval": "30ms"`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 0; i < 3; i++ {
+		l.Warn(&myFlags, "Timeout")
+		l.Error(&myFlags, "Disk full")
+		l.Error(&myFlags, "Disk full")
+	}
+	l.Close()
+	sink := getMemorySink(t, "closed")
+	closed := sink.getLines()
+	time.Sleep(time.Millisecond * 60) // past the interval
+	if lines := sink.getLines(); len(lines) != len(closed) || len(closed) != 4 {
+		t.Errorf("Logit problem: %q when closed, %q after", closed, lines)
+	}
+	l.sampler.mutex.Lock()
+	kept := len(l.sampler.duplicates)
+	l.sampler.mutex.Unlock()
+	if kept > 0 || !l.deduplicated(&Record_t{Level: WARN, Msg: "Timeout", flags: l.getLogFlags()}) {
+		t.Errorf("Logit problem: %d duplicates kept after Close", kept)
+	}
+}
+
+/*
+  TestDuplicatesInterval
+  A message that keeps repeating is summarized at the end of every interval
+*/
+func TestDuplicatesInterval(t *testing.T) {
+	l := newTestLogger(t, `
+		"level": "INFO",
+		"sinks": [ { "type": "memory", "id": "interval", "pkgs": [ "logit:logSample_test" ] } ],
+		"duplicateInterval": "50ms"`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 0; i < 5; i++ {
+		l.Warn(&myFlags, "Timeout")
+	}
+	sink := getMemorySink(t, "interval")
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		lines := sink.getLines()
+		if len(lines) == 2 {
+			if !strings.HasSuffix(lines[1], "WARN[logit:logSample_test] message repeated 4 times: Timeout") {
+				t.Errorf("Logit problem: summary '%s'", lines[1])
+			}
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Errorf("Logit problem: no summary at the end of the interval, got %q", sink.getLines())
+}
@@ -0,0 +1,515 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+  Sink
+  An output of the logger. The writer goroutine calls Write with the
+  record and the line in the format of the sink, without a newline,
+  and Flush when its queue runs empty. Close is called once, by the
+  Close of the logger. Only records that pass the level and filters
+  of the sink get to Write.
+*/
+type Sink interface {
+	Write(rec *Record_t, line string) error
+	Flush() error
+	Close() error
+}
+
+/*
+  SinkConfig_t
+  What a SinkFactory_t gets to make a sink. The Options are the whole
+  config entry of the sink, for the settings only that sink knows.
+*/
+type SinkConfig_t struct {
+	Type     string          // the name the sink was registered with
+	Name     string          // the name of the entry, the Type if it has none
+	SiteID   string          // customer site name
+	SystemID string          // system id
+	Options  json.RawMessage // the config entry of the sink
+}
+
+// SinkFactory_t makes a sink of a registered type for a config entry
+type SinkFactory_t func(config SinkConfig_t) (Sink, error)
+
+// the sink types of logit itself
+const (
+	STDOUT_SINK = "stdout"
+	STDERR_SINK = "stderr"
+	FILE_SINK   = "file"
+	URL_SINK    = "url"
+	SYSLOG_SINK = "syslog"
+)
+
+var builtinSinks = map[string]bool{
+	STDOUT_SINK: true,
+	STDERR_SINK: true,
+	FILE_SINK:   true,
+	URL_SINK:    true,
+	SYSLOG_SINK: true,
+}
+
+var sinkMutex sync.Mutex                       // protects sinkFactories
+var sinkFactories = map[string]SinkFactory_t{} // the registered sink types
+
+/*
+  RegisterSink
+  Make a sink type known by name, so the "sinks" of a config can use it.
+  Register it before the logger is opened.
+*/
+func RegisterSink(name string, factory SinkFactory_t) error {
+	if len(name) == 0 || factory == nil {
+		return errors.New("a sink needs a name and a factory")
+	}
+	if builtinSinks[name] {
+		return fmt.Errorf("sink type '%s' is part of logit", name)
+	}
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	if _, found := sinkFactories[name]; found {
+		return fmt.Errorf("sink type '%s' is already registered", name)
+	}
+	sinkFactories[name] = factory
+	return nil
+}
+
+/*
+  getSinkFactory
+  The factory of a registered sink type, nil if there is none
+*/
+func getSinkFactory(name string) SinkFactory_t {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	return sinkFactories[name]
+}
+
+// sinkConfig_t is one entry of the "sinks" of the config
+type sinkConfig_t struct {
+	kind    string          // stdout, stderr, file, url, syslog or a registered type
+	name    string          // the name of the entry, the kind if it has none
+	target  string          // the file, url or address the sink writes to
+	enabled bool            // false for the stdout of "stdout": false
+	level   logLevel_t      // the most verbose level the sink gets
+	format  format_t        // the format of the lines
+	color   bool            // color the lines by level, stdout and stderr only
+	pkgs    []string        // only these packages or package:files, all if empty
+	exclude []string        // never these packages or package:files
+	options json.RawMessage // the whole entry, for a registered type
+}
+
+/*
+  key
+  Two entries with the same key are the same sink, the rest of the
+  entry is the settings a reload can change
+*/
+func (c *sinkConfig_t) key() string {
+	return c.kind + "/" + c.name + "/" + c.target
+}
+
+/*
+  accepts
+  Check the level and the filters of the sink
+*/
+func (c *sinkConfig_t) accepts(rec *Record_t) bool {
+	if !c.enabled || rec.Level > c.level {
+		return false
+	}
+	matches := func(names []string) bool {
+		for _, name := range names {
+			if name == rec.Pkg || name == rec.Pkg+":"+rec.File {
+				return true
+			}
+		}
+		return false
+	}
+	if len(c.pkgs) > 0 && !matches(c.pkgs) {
+		return false
+	}
+	return !matches(c.exclude)
+}
+
+/*
+  sameSinks
+  A reload can change the levels, formats and filters of the sinks,
+  but not which sinks there are
+*/
+func sameSinks(a []sinkConfig_t, b []sinkConfig_t) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].key() != b[i].key() {
+			return false
+		}
+	}
+	return true
+}
+
+// sinkJson_t is one "sinks" entry of the config, a syslog sink has the syslogJson_t too
+type sinkJson_t struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Level    string   `json:"level" check:"level"`
+	Format   string   `json:"format" check:"format"`
+	Color    bool     `json:"color"`
+	Pkgs     []string `json:"pkgs" check:"pkgs"`
+	Exclude  []string `json:"exclude" check:"pkgs"`
+	FileName string   `json:"filename"`
+	Url      string   `json:"url"`
+}
+
+/*
+  parseSinks
+  Turn the "sinks" of the config into the sink entries. The file, url
+  and syslog sinks take the "filename", "url" and "syslog" of the config
+  when they do not have their own, and set them when they do.
+  Without "sinks" the entries come from "stdout", "filename", "url"
+  and "syslog" as they always have.
+*/
+func (l *Logger) parseSinks(raw []json.RawMessage, format format_t, tFlags *logFlags_t) []sinkConfig_t {
+	if raw == nil {
+		sinks := []sinkConfig_t{{kind: STDOUT_SINK, name: STDOUT_SINK, enabled: tFlags.useStdOut,
+			level: TRACE, format: tFlags.stdOutFmt}}
+		if len(tFlags.logFileName) > 0 {
+			sinks = append(sinks, sinkConfig_t{kind: FILE_SINK, name: FILE_SINK, target: tFlags.logFileName,
+				enabled: true, level: TRACE, format: tFlags.fileFmt})
+		}
+		if len(tFlags.url) > 0 {
+			sinks = append(sinks, sinkConfig_t{kind: URL_SINK, name: URL_SINK, target: tFlags.url,
+				enabled: true, level: TRACE, format: tFlags.urlFmt})
+		}
+		if len(tFlags.syslog.address) > 0 {
+			sinks = append(sinks, sinkConfig_t{kind: SYSLOG_SINK, name: SYSLOG_SINK,
+				target: tFlags.syslog.network + ":" + tFlags.syslog.address, enabled: true, level: TRACE})
+		}
+		return sinks
+	}
+	var sinks []sinkConfig_t
+	names := make(map[string]bool) // of the sinks
+	kinds := make(map[string]bool) // of the file, url and syslog sinks
+	tFlags.useStdOut = false
+	for i, entry := range raw {
+		var res sinkJson_t
+		if err := json.Unmarshal(entry, &res); err != nil {
+			l.delayLog(WARN, fmt.Sprintf("sink %d is ignored: '%s'.", i+1, err))
+			continue
+		}
+		c := sinkConfig_t{kind: res.Type, name: res.Name, enabled: true, color: res.Color,
+			pkgs: res.Pkgs, exclude: res.Exclude, options: entry}
+		if len(c.name) == 0 {
+			c.name = c.kind
+		}
+		if names[c.name] {
+			l.delayLog(WARN, fmt.Sprintf("sink %d is ignored, there is already a sink '%s'.", i+1, c.name))
+			continue
+		}
+		var err error
+		c.level, err = parseLevel(res.Level, TRACE)
+		if err != nil {
+			l.delayLog(WARN, err.Error())
+		}
+		c.format, err = parseFormat(res.Format, format)
+		if err != nil {
+			l.delayLog(WARN, err.Error())
+		}
+		switch c.kind {
+		case STDOUT_SINK, STDERR_SINK:
+			tFlags.useStdOut = tFlags.useStdOut || c.kind == STDOUT_SINK
+		case FILE_SINK, URL_SINK, SYSLOG_SINK:
+			if kinds[c.kind] { // these are the log file, the log server and syslog of the stats
+				l.delayLog(WARN, fmt.Sprintf("sink %d is ignored, there can be only one '%s' sink.", i+1, c.kind))
+				continue
+			}
+			kinds[c.kind] = true
+			switch c.kind {
+			case FILE_SINK:
+				if len(res.FileName) > 0 {
+					tFlags.logFileName = res.FileName
+				}
+				c.target = tFlags.logFileName
+			case URL_SINK:
+				if len(res.Url) > 0 {
+					tFlags.url = res.Url
+				}
+				c.target = tFlags.url
+			case SYSLOG_SINK:
+				var s syslogJson_t
+				json.Unmarshal(entry, &s)
+				if len(s.Address) > 0 {
+					tFlags.syslog, err = parseSyslog(s.Network, s.Address, s.Facility, s.AppName, s.Buffer, s.MaxSize)
+					if err != nil {
+						l.delayLog(WARN, err.Error())
+					}
+				}
+				c.target = tFlags.syslog.network + ":" + tFlags.syslog.address
+			}
+			if len(c.target) == 0 || c.target == ":" {
+				l.delayLog(WARN, fmt.Sprintf("sink '%s' is ignored, it has nowhere to write.", c.name))
+				continue
+			}
+		default:
+			if len(c.kind) == 0 || getSinkFactory(c.kind) == nil {
+				l.delayLog(WARN, fmt.Sprintf("sink '%s' is ignored, there is no sink type '%s'.", c.name, c.kind))
+				continue
+			}
+		}
+		names[c.name] = true
+		sinks = append(sinks, c)
+	}
+	//
+	// the outputs that no sink uses are not opened
+	//
+	if !kinds[FILE_SINK] {
+		tFlags.logFileName = ""
+	}
+	if !kinds[URL_SINK] {
+		tFlags.url = ""
+	}
+	if !kinds[SYSLOG_SINK] {
+		tFlags.syslog = syslog_t{}
+	}
+	return sinks
+}
+
+// sink_t is one open sink and how it is doing
+type sink_t struct {
+	sink         Sink
+	entry        int   // its entry in the sinks of the config, a reload cannot move it
+	writeCount   int64 // records written
+	failedCount  int64 // records the sink could not write
+	failedLogged int64 // failures already in the stats log
+}
+
+/*
+  openSinks
+  Open a sink for every entry. A file that cannot be opened, or a
+  registered sink that fails, writes to stdout instead.
+*/
+func (l *Logger) openSinks(flags *logFlags_t) []*sink_t {
+	var sinks []*sink_t
+	for i, c := range flags.sinks {
+		var sink Sink
+		switch c.kind {
+		case STDOUT_SINK:
+			sink = &consoleSink_t{out: os.Stdout, color: c.color}
+		case STDERR_SINK:
+			sink = &consoleSink_t{out: os.Stderr, color: c.color}
+		case FILE_SINK:
+			if l.file != nil {
+				sink = &fileSink_t{logger: l, file: l.file}
+			}
+		case URL_SINK:
+			l.shipper = newUrlShipper(flags.url, flags.siteID, flags.sysID)
+			if flags.urlBatch > 0 {
+				l.shipper.batchSize = flags.urlBatch
+			}
+			if flags.urlBuffer > 0 {
+				l.shipper.bufferSize = flags.urlBuffer
+			}
+			if len(flags.urlSpoolDir) > 0 {
+				if spool, err := openSpool(flags.urlSpoolDir, flags.urlSpoolMax); err != nil {
+					l.delayLog(WARN, fmt.Sprintf("Failed to open the spool '%s': '%s', the lines for the log server are kept in memory.",
+						flags.urlSpoolDir, err))
+				} else {
+					l.shipper.useSpool(spool)
+					if depth := spool.depth(); depth > 0 {
+						l.delayLog(INFO, fmt.Sprintf("The spool '%s' has %d lines for the log server, they go first.",
+							flags.urlSpoolDir, depth))
+					}
+				}
+			}
+			l.shipper.start()
+			sink = l.shipper
+		case SYSLOG_SINK:
+			l.syslog = newSyslogSender(flags.syslog)
+			l.syslog.start()
+			sink = l.syslog
+		default:
+			var err error
+			sink, err = getSinkFactory(c.kind)(SinkConfig_t{Type: c.kind, Name: c.name,
+				SiteID: flags.siteID, SystemID: flags.sysID, Options: c.options})
+			if err != nil {
+				sink = nil
+				l.delayLog(WARN, fmt.Sprintf("Failed to open sink '%s': '%s'.", c.name, err))
+			}
+		}
+		if sink == nil {
+			l.delayLog(WARN, fmt.Sprintf("Logs of sink '%s' will go to 'stdout'.", c.name))
+			sink = &consoleSink_t{out: os.Stdout, color: c.color}
+		}
+		sinks = append(sinks, &sink_t{sink: sink, entry: i})
+	}
+	return sinks
+}
+
+/*
+  writeSinks
+  Give the record to every sink that accepts it, formatting it
+  once for each format
+*/
+func (l *Logger) writeSinks(flags *logFlags_t, rec *Record_t) {
+	var lines [2]string // by format_t
+	for _, s := range l.sinks {
+		if s.entry >= len(flags.sinks) {
+			continue
+		}
+		c := &flags.sinks[s.entry]
+		if !c.accepts(rec) {
+			continue
+		}
+		if len(lines[c.format]) == 0 {
+			lines[c.format] = rec.format(c.format)
+		}
+		if err := s.sink.Write(rec, lines[c.format]); err != nil {
+			atomic.AddInt64(&s.failedCount, 1)
+		} else {
+			atomic.AddInt64(&s.writeCount, 1)
+		}
+	}
+}
+
+/*
+  flushSinks
+  Push what the sinks buffer out
+*/
+func (l *Logger) flushSinks() {
+	for _, s := range l.sinks {
+		if err := s.sink.Flush(); err != nil {
+			atomic.AddInt64(&s.failedCount, 1)
+		}
+	}
+}
+
+/*
+  closeSinks
+  Close all the sinks, stdout and stderr stay for what is logged
+  after the logger is closed
+*/
+func (l *Logger) closeSinks() {
+	var console []*sink_t
+	for _, s := range l.sinks {
+		if _, isConsole := s.sink.(*consoleSink_t); isConsole {
+			console = append(console, s)
+		}
+		s.sink.Close()
+	}
+	l.sinks = console
+}
+
+/*
+  logTheSinks
+  Log the sinks and their levels, at open and when a reload changes them
+*/
+func (l *Logger) logTheSinks(flags *logFlags_t) {
+	for _, c := range flags.sinks {
+		if !c.enabled {
+			continue
+		}
+		filters := ""
+		if len(c.pkgs) > 0 {
+			filters += ", only " + strings.Join(c.pkgs, ",")
+		}
+		if len(c.exclude) > 0 {
+			filters += ", not " + strings.Join(c.exclude, ",")
+		}
+		l.Infof(&l.myFlags, "Sink '%s' of type %s, level %s%s.", c.name, c.kind, c.level, filters)
+	}
+}
+
+/*
+  logTheSinkStats
+  Log the sinks that failed to write since the last time
+*/
+func (l *Logger) logTheSinkStats() {
+	l.writeMutex.Lock()
+	sinks := l.sinks
+	l.writeMutex.Unlock()
+	flags := l.getLogFlags()
+	for _, s := range sinks {
+		failed := atomic.LoadInt64(&s.failedCount)
+		if failed == atomic.SwapInt64(&s.failedLogged, failed) || s.entry >= len(flags.sinks) {
+			continue
+		}
+		l.Warnf(&l.myFlags, "sink '%s' written = %d, failed = %d",
+			flags.sinks[s.entry].name, atomic.LoadInt64(&s.writeCount), failed)
+	}
+}
+
+// the colors of the levels on a terminal
+var levelColors = map[logLevel_t]string{
+	FATAL: "\x1b[35m", // magenta
+	ERROR: "\x1b[31m", // red
+	WARN:  "\x1b[33m", // yellow
+	INFO:  "\x1b[32m", // green
+	DEBUG: "\x1b[36m", // cyan
+	TRACE: "\x1b[90m", // gray
+}
+
+const colorReset = "\x1b[0m"
+
+// consoleSink_t writes the lines to stdout or stderr
+type consoleSink_t struct {
+	out   io.Writer
+	color bool // the line in the color of its level
+}
+
+func (s *consoleSink_t) Write(rec *Record_t, line string) error {
+	if s.color {
+		line = levelColors[rec.Level] + line + colorReset
+	}
+	_, err := io.WriteString(s.out, line+"\n")
+	return err
+}
+
+func (s *consoleSink_t) Flush() error { return nil }
+
+func (s *consoleSink_t) Close() error { return nil }
+
+/*
+  fileSink_t
+  Writes the lines to the log file, which rotates by the settings of
+  the current config. The log size of the stats is what went here.
+  With an audit chain every line is sealed, and the head of the chain
+  is logged when the file rotates.
+*/
+type fileSink_t struct {
+	logger *Logger
+	file   *logFile_t
+	inHead bool // writing the head, a rotation now does not log another one
+}
+
+func (s *fileSink_t) Write(rec *Record_t, line string) error {
+	audit := s.file.audit
+	var head string
+	if audit != nil {
+		head = audit.head() // of the last line in the file, before a rotation
+		line = audit.seal(line)
+	}
+	flags := s.logger.getLogFlags()
+	rotated, err := s.file.write(line+"\n", rec.Time, flags.rotate)
+	atomic.AddInt64(&s.logger.stats.logSize, int64(len(line)))
+	if audit != nil && len(rotated) > 0 && !s.inHead {
+		s.inHead = true
+		s.logger.writeAuditHead(flags, fmt.Sprintf(" of '%s'", rotated), head)
+		s.inHead = false
+	}
+	return err
+}
+
+func (s *fileSink_t) Flush() error {
+	return s.file.flush()
+}
+
+func (s *fileSink_t) Close() error {
+	return s.file.close()
+}
@@ -0,0 +1,293 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memorySink_t keeps the lines it gets, for the tests
+type memorySink_t struct {
+	mutex  sync.Mutex
+	lines  []string
+	levels []logLevel_t
+	closed bool
+}
+
+func (s *memorySink_t) Write(rec *Record_t, line string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lines = append(s.lines, line)
+	s.levels = append(s.levels, rec.Level)
+	return nil
+}
+
+func (s *memorySink_t) Flush() error { return nil }
+
+func (s *memorySink_t) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memorySink_t) getLines() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+var memorySinksOnce sync.Once
+var memorySinksMutex sync.Mutex
+var memorySinks = map[string]*memorySink_t{} // by the "id" of the config entry
+
+/*
+  registerMemorySink
+  Register the "memory" sink type once for all the tests
+*/
+func registerMemorySink(t *testing.T) {
+	memorySinksOnce.Do(func() {
+		err := RegisterSink("memory", func(config SinkConfig_t) (Sink, error) {
+			var options struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(config.Options, &options); err != nil {
+				return nil, err
+			}
+			sink := &memorySink_t{}
+			memorySinksMutex.Lock()
+			memorySinks[options.ID] = sink
+			memorySinksMutex.Unlock()
+			return sink, nil
+		})
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+	})
+}
+
+/*
+  getMemorySink
+  The memory sink of this id
+*/
+func getMemorySink(t *testing.T, id string) *memorySink_t {
+	memorySinksMutex.Lock()
+	defer memorySinksMutex.Unlock()
+	sink := memorySinks[id]
+	if sink == nil {
+		t.Fatalf("Logit problem: there is no memory sink '%s'", id)
+	}
+	return sink
+}
+
+/*
+  TestSinkLevels
+  Everything goes to the file as json, the errors to one sink and
+  only this file to another
+*/
+func TestSinkLevels(t *testing.T) {
+	registerMemorySink(t)
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	jsonTest := `
+	{
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"level": "DEBUG",
+		"debugFlags": [ { "pkg": "logit" } ],
+		"sinks": [
+			{ "type": "file", "filename": "` + logFileName + `", "format": "json" },
+			{ "type": "memory", "name": "errors", "id": "levels-errors", "level": "ERROR" },
+			{ "type": "memory", "name": "tests", "id": "levels-tests", "pkgs": [ "logit:logSink_test" ] },
+			{ "type": "memory", "name": "others", "id": "levels-others", "exclude": [ "logit:logSink_test" ] }
+		]
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if flags := l.getLogFlags(); flags.useStdOut || flags.logFileName != logFileName || len(flags.sinks) != 4 {
+		t.Errorf("Logit problem: stdout %t, file '%s', %d sinks", flags.useStdOut, flags.logFileName, len(flags.sinks))
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Debug(&myFlags, "Debug to the file")
+	l.Warn(&myFlags, "Warning to the file")
+	l.Error(&myFlags, "Error to the file and the errors")
+	l.Close()
+
+	errors := getMemorySink(t, "levels-errors")
+	if lines := errors.getLines(); len(lines) != 1 || !strings.HasSuffix(lines[0], "Error to the file and the errors") {
+		t.Errorf("Logit problem: the errors sink got %q", lines)
+	}
+	if !errors.closed {
+		t.Errorf("Logit problem: the sink was not closed")
+	}
+	if lines := getMemorySink(t, "levels-tests").getLines(); len(lines) != 3 {
+		t.Errorf("Logit problem: the tests sink got %q", lines)
+	}
+	for _, line := range getMemorySink(t, "levels-others").getLines() {
+		if strings.Contains(line, "[logit:logSink_test]") {
+			t.Errorf("Logit problem: the others sink got '%s'", line)
+		}
+	}
+	raw, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	found := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		var fields jsonRecord_t
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Logit problem: line '%s' is not json", line)
+		}
+		if fields.File == "logSink_test" {
+			found += 1
+		}
+	}
+	if found != 3 {
+		t.Errorf("Logit problem: %d of the 3 messages are in the file", found)
+	}
+}
+
+/*
+  TestSinkReload
+  A reload changes the level of a sink, but cannot add one
+*/
+func TestSinkReload(t *testing.T) {
+	registerMemorySink(t)
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	const jsonTest string = `
+	{
+		"level": "INFO",
+		"sinks": [ { "type": "memory", "id": "reload", "level": "%s" } %s ]
+	}`
+	writeConfigFile(configFileName, strings.Replace(strings.Replace(jsonTest, "%s", "WARN", 1), "%s", "", 1))
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.stopWatch(l.watcher) // reload only when the test says so
+	l.watcher = nil
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Info below the level of the sink")
+
+	writeConfigFile(configFileName, strings.Replace(strings.Replace(jsonTest, "%s", "INFO", 1), "%s", "", 1))
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l.Info(&myFlags, "Info at the level of the sink")
+
+	writeConfigFile(configFileName, strings.Replace(strings.Replace(jsonTest, "%s", "WARN", 1), "%s",
+		`, { "type": "stderr" }`, 1))
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if flags := l.getLogFlags(); len(flags.sinks) != 1 || flags.sinks[0].level != INFO {
+		t.Errorf("Logit problem: a reload changed the sinks to %+v", flags.sinks)
+	}
+	l.Close()
+	lines := strings.Join(getMemorySink(t, "reload").getLines(), "\n")
+	if strings.Contains(lines, "Info below the level of the sink") || !strings.Contains(lines, "Info at the level of the sink") {
+		t.Errorf("Logit problem: the sink got\n%s", lines)
+	}
+}
+
+/*
+  TestSinkEntries
+  Each sink keeps its own config entry, also after Close leaves only
+  stdout, and the failed writes of the log file are counted
+*/
+func TestSinkEntries(t *testing.T) {
+	registerMemorySink(t)
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	jsonTest := `
+	{
+		"level": "INFO",
+		"sinks": [
+			{ "type": "memory", "id": "entries", "level": "ERROR" },
+			{ "type": "file", "filename": "` + filepath.ToSlash(filepath.Join(dir, "entries.txt")) + `" },
+			{ "type": "stdout", "level": "INFO" }
+		]
+	}`
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	//
+	// the file is closed under the logger, the lines cannot get to it
+	//
+	l.writeMutex.Lock()
+	file := l.sinks[1]
+	l.file.handle.Close()
+	l.writeMutex.Unlock()
+	l.Info(&myFlags, strings.Repeat("x", 20000)) // more than the buffer of the file
+	l.Sync()
+	if atomic.LoadInt64(&file.failedCount) == 0 {
+		t.Errorf("Logit problem: the file sink wrote %d, failed none", atomic.LoadInt64(&file.writeCount))
+	}
+	l.Close()
+
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	if len(l.sinks) != 1 || l.sinks[0].entry != 2 {
+		t.Fatalf("Logit problem: %d sinks after Close", len(l.sinks))
+	}
+	var out bytes.Buffer
+	l.sinks[0].sink.(*consoleSink_t).out = &out
+	l.writeSinks(l.getLogFlags(), &Record_t{Time: time.Now(), Level: INFO, Pkg: "logit", File: "logSink_test", Msg: "After Close"})
+	if !strings.Contains(out.String(), "INFO[logit:logSink_test] After Close") {
+		t.Errorf("Logit problem: stdout got '%s' after Close", out.String())
+	}
+}
+
+/*
+  TestRegisterSink
+  The names of logit and names already taken cannot be registered
+*/
+func TestRegisterSink(t *testing.T) {
+	registerMemorySink(t)
+	factory := func(config SinkConfig_t) (Sink, error) { return &memorySink_t{}, nil }
+	if err := RegisterSink("file", factory); err == nil {
+		t.Errorf("Logit problem: the file sink was registered")
+	}
+	if err := RegisterSink("memory", factory); err == nil {
+		t.Errorf("Logit problem: the memory sink was registered twice")
+	}
+	if err := RegisterSink("", factory); err == nil {
+		t.Errorf("Logit problem: a sink without a name was registered")
+	}
+}
+
+/*
+  TestConsoleColor
+  The console sink colors the line by level, and only if asked
+*/
+func TestConsoleColor(t *testing.T) {
+	var buf bytes.Buffer
+	rec := &Record_t{Level: ERROR}
+	(&consoleSink_t{out: &buf, color: true}).Write(rec, "an error")
+	(&consoleSink_t{out: &buf}).Write(rec, "plain")
+	if buf.String() != "\x1b[31man error\x1b[0m\nplain\n" {
+		t.Errorf("Logit problem: console wrote %q", buf.String())
+	}
+}
@@ -0,0 +1,294 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"sync"
+)
+
+// the slog levels of the logit levels that slog does not have
+const (
+	SlogLevelFatal = slog.LevelError + 4
+	SlogLevelTrace = slog.LevelDebug - 4
+)
+
+// XFlagKey is the attribute key that makes a slog debug message a Debugx message
+const XFlagKey = "xflag"
+
+/*
+  XFlag
+  The attribute for a slog debug message that is only logged when
+  this xflag is set for the caller, just like Debugx.
+  e.g. slog.Debug("Request Header", logit.XFlag(cSHOWREQUESTHDRS))
+*/
+func XFlag(xflag int32) slog.Attr {
+	return slog.Int64(XFlagKey, int64(xflag))
+}
+
+/*
+  SlogHandler_t
+  A slog.Handler that logs through logit. The level of the config and
+  the dFlags and xFlags of the package and file that called slog decide
+  what is logged, and they follow the reloads of the config.
+  The attributes are logged as fields, those of a group as "group.key".
+*/
+type SlogHandler_t struct {
+	logger *Logger   // nil means the default logger at the time of logging
+	flags  *DFlags_t // the flags of all the messages, nil to use the caller of each message
+	fields []Field_t // the attributes added by WithAttrs
+	xflag  int32     // an xflag added by WithAttrs
+	prefix string    // the groups of WithGroup, "group." for each
+	cache  *sync.Map // DFlags_t per "pkg:file" of the callers, shared by the children
+}
+
+/*
+  NewSlogHandler
+  A slog.Handler for the logger 'l', nil for the default logger.
+  With 'flags' nil the flags are those of the package and file that
+  called slog, otherwise every message uses 'flags'.
+*/
+func NewSlogHandler(l *Logger, flags *DFlags_t) *SlogHandler_t {
+	return &SlogHandler_t{logger: l, flags: flags, cache: &sync.Map{}}
+}
+
+/*
+  getLogger
+  The logger of the handler, the default logger if it has none
+*/
+func (h *SlogHandler_t) getLogger() *Logger {
+	if h.logger != nil {
+		return h.logger
+	}
+	return Default()
+}
+
+/*
+  slogToLevel
+  The logit level of a slog level
+*/
+func slogToLevel(level slog.Level) logLevel_t {
+	switch {
+	case level >= SlogLevelFatal:
+		return FATAL
+	case level >= slog.LevelError:
+		return ERROR
+	case level >= slog.LevelWarn:
+		return WARN
+	case level >= slog.LevelInfo:
+		return INFO
+	case level >= slog.LevelDebug:
+		return DEBUG
+	}
+	return TRACE
+}
+
+/*
+  levelToSlog
+  The slog level of a logit level
+*/
+func levelToSlog(level logLevel_t) slog.Level {
+	switch level {
+	case FATAL:
+		return SlogLevelFatal
+	case ERROR:
+		return slog.LevelError
+	case WARN:
+		return slog.LevelWarn
+	case INFO:
+		return slog.LevelInfo
+	case DEBUG:
+		return slog.LevelDebug
+	}
+	return SlogLevelTrace
+}
+
+/*
+  Enabled
+  The flags of the caller are only known in Handle, so without 'flags'
+  a level is enabled if any package could let it through. The levels
+  the ring keeps are always enabled.
+*/
+func (h *SlogHandler_t) Enabled(ctx context.Context, level slog.Level) bool {
+	l := h.getLogger()
+	logLevel := slogToLevel(level)
+	if l.ringWants(logLevel) {
+		return true
+	}
+	if h.flags != nil {
+		f := l.ifOldReloadDXFlags(h.flags)
+		if logLevel == DEBUG {
+			return f.dFlag || f.xFlag != 0
+		}
+		return logLevel == FATAL || f.level >= logLevel
+	}
+	flags := l.getLogFlags()
+	if logLevel == DEBUG {
+		return flags.debugAll || len(flags.dFlags) > 0 || len(flags.xFlags) > 0 || flags.maxLevel >= DEBUG
+	}
+	return logLevel == FATAL || flags.maxLevel >= logLevel
+}
+
+/*
+  callerFlags
+  The flags of the package and file that logged the record
+*/
+func (h *SlogHandler_t) callerFlags(l *Logger, pc uintptr) DFlags_t {
+	if h.flags != nil {
+		return l.ifOldReloadDXFlags(h.flags)
+	}
+	pkgName, fileName := "slog", "slog"
+	if pc != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		pkgName, fileName = frameNames(frame.Function, frame.File)
+	}
+	key := pkgName + ":" + fileName
+	cached, found := h.cache.Load(key)
+	if !found {
+		cached, _ = h.cache.LoadOrStore(key, &DFlags_t{pkgName: pkgName, fileName: fileName})
+	}
+	return l.ifOldReloadDXFlags(cached.(*DFlags_t))
+}
+
+/*
+  Handle
+  Log the record if its level, or its xflag, is on for the caller
+*/
+func (h *SlogHandler_t) Handle(ctx context.Context, r slog.Record) error {
+	l := h.getLogger()
+	f := h.callerFlags(l, r.PC)
+	level := slogToLevel(r.Level)
+	xflag := h.xflag
+	fields := h.fields
+	var ctxFields []Field_t
+	if ctx != nil {
+		ctxFields = contextFields(ctx) // the request ID and the trace, as the ...Ctx functions log them
+	}
+	if r.NumAttrs() > 0 || len(ctxFields) > 0 {
+		fields = append(make([]Field_t, 0, len(h.fields)+len(ctxFields)+r.NumAttrs()), h.fields...)
+		fields = append(fields, ctxFields...)
+		r.Attrs(func(attr slog.Attr) bool {
+			fields = appendAttr(fields, h.prefix, attr, &xflag)
+			return true
+		})
+	}
+	if level != DEBUG {
+		xflag = 0
+	}
+	if l.levelOn(level, xflag, &f) {
+		l.logMsg(level, xflag, &f, fields, r.Message)
+	}
+	return nil
+}
+
+/*
+  WithAttrs
+  A handler that logs these attributes as well
+*/
+func (h *SlogHandler_t) WithAttrs(attrs []slog.Attr) slog.Handler {
+	child := *h
+	child.fields = append([]Field_t(nil), h.fields...)
+	for _, attr := range attrs {
+		child.fields = appendAttr(child.fields, h.prefix, attr, &child.xflag)
+	}
+	return &child
+}
+
+/*
+  WithGroup
+  A handler that puts the attributes that follow in the group
+*/
+func (h *SlogHandler_t) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	child := *h
+	child.prefix = h.prefix + name + "."
+	return &child
+}
+
+/*
+  appendAttr
+  Add the attribute to the fields, groups are flattened to "group.key".
+  The xflag attribute sets 'xflag' instead.
+*/
+func appendAttr(fields []Field_t, prefix string, attr slog.Attr, xflag *int32) []Field_t {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+	if attr.Key == XFlagKey && prefix == "" && attr.Value.Kind() == slog.KindInt64 {
+		*xflag |= int32(attr.Value.Int64())
+		return fields
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			fields = appendAttr(fields, groupPrefix, groupAttr, xflag)
+		}
+		return fields
+	}
+	return append(fields, Field_t{Key: prefix + attr.Key, Value: attr.Value.Any()})
+}
+
+// slogOutput_t holds the slog.Handler that gets the logit records, if any
+type slogOutput_t struct {
+	handler slog.Handler
+}
+
+/*
+  SetSlogOutput
+  Send everything this logger writes to the slog.Handler 'h' as well,
+  nil stops it. The package, file, generation and xflag of a message
+  are attributes, followed by its fields.
+  A logit SlogHandler_t cannot be used here, it would log in a circle.
+*/
+func (l *Logger) SetSlogOutput(h slog.Handler) error {
+	if _, isLogit := h.(*SlogHandler_t); isLogit {
+		return errors.New("a logit slog handler cannot be the slog output of logit")
+	}
+	l.slogOutput.Store(&slogOutput_t{handler: h})
+	return nil
+}
+
+/*
+  getSlogOutput
+  The slog.Handler of SetSlogOutput, nil if there is none
+*/
+func (l *Logger) getSlogOutput() slog.Handler {
+	output, _ := l.slogOutput.Load().(*slogOutput_t)
+	if output == nil {
+		return nil
+	}
+	return output.handler
+}
+
+/*
+  writeSlog
+  Hand one record to the slog output, if its level is enabled there
+*/
+func (l *Logger) writeSlog(h slog.Handler, rec *Record_t) {
+	ctx := context.Background()
+	level := levelToSlog(rec.Level)
+	if !h.Enabled(ctx, level) {
+		return
+	}
+	r := slog.NewRecord(rec.Time, level, rec.Msg, 0)
+	r.AddAttrs(
+		slog.String("pkg", rec.Pkg),
+		slog.String("file", rec.File),
+		slog.Int("generation", int(rec.Generation)),
+	)
+	if rec.XFlag != 0 {
+		r.AddAttrs(XFlag(rec.XFlag))
+	}
+	for _, field := range rec.Fields {
+		r.AddAttrs(slog.Any(field.Key, field.Value))
+	}
+	h.Handle(ctx, r)
+}
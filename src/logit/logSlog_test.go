@@ -0,0 +1,155 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+  TestSlogHandler
+  slog messages follow the level, the dFlags and the xFlags
+  of the file that logged them, attributes become fields
+*/
+func TestSlogHandler(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	logFileName := filepath.Join(dir, "logTestFile.txt")
+	jsonTest := `
+	{
+		"filename": "` + logFileName + `",
+		"level": "DEBUG",
+		"debugFlags": [ { "pkg": "logit", "file": "logSlog_test" } ],
+		"xFlags": [ { "pkg": "logit", "file": "logSlog_test", "flags": "0x4" } ]
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	logger := slog.New(NewSlogHandler(l, nil))
+	logger.Info("slog info", "user", "jeff", slog.Group("req", "id", 7))
+	logger.WithGroup("http").With("method", "GET").Warn("slog warn", "status", 403)
+	logger.Debug("slog debug")
+	logger.Debug("slog debugx on", XFlag(0x4))
+	logger.Debug("slog debugx off", XFlag(0x8))
+	logger.InfoContext(WithRequestID(context.Background(), "req-42"), "slog in a request", "user", "jeff")
+	stats := l.GetLogStats()
+	l.Close()
+	if stats.warnCount != 1 || stats.debugCount != 2 {
+		t.Errorf("Logit problem: stats %+v", stats)
+	}
+	raw, _ := ioutil.ReadFile(logFileName)
+	text := string(raw)
+	for _, want := range []string{
+		"INFO[logit:logSlog_test] slog info user=jeff req.id=7",
+		"WARN[logit:logSlog_test] slog warn http.method=GET http.status=403",
+		"DBUG[logit:logSlog_test] slog debug",
+		"DBGX[logit:logSlog_test] slog debugx on",
+		"INFO[logit:logSlog_test] slog in a request requestId=req-42 user=jeff",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Logit problem: '%s' not found in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "debugx off") {
+		t.Errorf("Logit problem: xflag 0x8 was logged:\n%s", text)
+	}
+}
+
+/*
+  TestSlogHandlerLevel
+  Without debug flags the slog debug messages are not even built
+*/
+func TestSlogHandlerLevel(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	err := writeConfigFile(configFileName, `{ "filename": "", "level": "WARN" }`)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	h := NewSlogHandler(l, &myFlags)
+	for level, want := range map[slog.Level]bool{
+		slog.LevelDebug: false,
+		slog.LevelInfo:  false,
+		slog.LevelWarn:  true,
+		slog.LevelError: true,
+		SlogLevelFatal:  true,
+	} {
+		if h.Enabled(context.Background(), level) != want {
+			t.Errorf("Logit problem: level %s enabled is not %t", level, want)
+		}
+	}
+}
+
+// syncBuffer_t is a bytes.Buffer for the writer goroutine and the test
+type syncBuffer_t struct {
+	buf   bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *syncBuffer_t) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+/*
+  TestSlogOutput
+  logit messages go to a slog.Handler with their labels and fields
+*/
+func TestSlogOutput(t *testing.T) {
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	err := writeConfigFile(configFileName, `{ "filename": "", "level": "DEBUG" }`)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if err := l.SetSlogOutput(NewSlogHandler(l, nil)); err == nil {
+		t.Errorf("Logit problem: a logit handler was accepted as the slog output")
+	}
+	out := &syncBuffer_t{}
+	if err := l.SetSlogOutput(slog.NewJSONHandler(out, nil)); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.With(&myFlags, "user", "jeff").Warn("to slog")
+	l.Close()
+
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(out.buf.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Logit problem: slog line '%s': %s", line, err)
+		}
+		if fields["msg"] == "to slog" {
+			found = fields["level"] == "WARN" && fields["pkg"] == "logit" &&
+				fields["file"] == "logSlog_test" && fields["user"] == "jeff"
+		}
+	}
+	if !found {
+		t.Errorf("Logit problem: slog output:\n%s", out.buf.String())
+	}
+}
@@ -0,0 +1,548 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaults and names of the spool of the log server
+const (
+	defaultSpoolMaxSize = 100 << 20      // max bytes the spool keeps on disk
+	spoolSegments       = 8              // the max size is spread over this many segment files
+	spoolSuffix         = ".spool"       // a segment is <first seq>.spool
+	spoolIDFile         = "spool.id"     // the sender id, the same after a restart
+	spoolCursorFile     = "spool.cursor" // the seq of the next line to post
+)
+
+// spoolSegment_t is one file of the spool
+type spoolSegment_t struct {
+	firstSeq int64 // seq of its first line
+	count    int64 // lines in it
+	size     int64 // bytes in it
+}
+
+// spoolPos_t is where a line of the spool is
+type spoolPos_t struct {
+	seq     int64 // the seq of the line
+	segment int64 // the firstSeq of its segment
+	offset  int64 // where it starts in the segment
+}
+
+/*
+  spool_t
+  The lines for the log server, on disk, for as long as the server is
+  down. A line is one record, quoted, in a segment file, a line gets
+  the next sequence number when it is written. The cursor file has the
+  seq of the first line the server has not taken. When the spool is
+  over its max size, the oldest segment goes.
+  One goroutine writes, the writer of the logger, another one peeks
+  and commits, the shipper. The mutex is only held to look at or
+  change the state, never over disk I/O, so neither stalls the other.
+*/
+type spool_t struct {
+	mutex       sync.Mutex // protects the fields below, except file
+	dir         string
+	maxSize     int64             // max bytes of all segments
+	sender      string            // the sender id that goes with every batch
+	segments    []*spoolSegment_t // oldest first, the lines go into the last one
+	file        *os.File          // the last segment, nil until a line is written, only the writer uses it
+	size        int64             // bytes of all segments
+	nextSeq     int64             // seq of the next line written
+	sendSeq     int64             // seq of the next line to post
+	read        spoolPos_t        // where peek goes on from
+	inFlight    int64             // peek handed out the lines before this seq, 0 if none
+	replayUntil int64             // the lines before this were spooled while the server was down
+	down        bool              // the last post failed
+	replayed    int64             // lines posted that were spooled while the server was down
+	dropped     int64             // lines the cap threw away before they were posted
+}
+
+/*
+  openSpool
+  Open the spool in 'dir', with what an earlier run left in it.
+  A last line that was only partly written is cut off. What was not
+  posted yet is replayed first.
+*/
+func openSpool(dir string, maxSize int64) (*spool_t, error) {
+	if maxSize <= 0 {
+		maxSize = defaultSpoolMaxSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	sp := &spool_t{dir: dir, maxSize: maxSize, nextSeq: 1}
+	id, err := ioutil.ReadFile(filepath.Join(dir, spoolIDFile))
+	sp.sender = strings.TrimSpace(string(id))
+	if err != nil || len(sp.sender) == 0 {
+		sp.sender = NewRequestID()
+		if err := writeFileAtomic(filepath.Join(dir, spoolIDFile), sp.sender+"\n"); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), spoolSuffix) {
+			continue
+		}
+		firstSeq, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), spoolSuffix), 10, 64)
+		if err != nil || firstSeq <= 0 {
+			continue // not one of ours
+		}
+		seg, err := sp.recover(firstSeq)
+		if err != nil {
+			return nil, err
+		}
+		sp.segments = append(sp.segments, seg)
+		sp.size += seg.size
+	}
+	sort.Slice(sp.segments, func(i, j int) bool { return sp.segments[i].firstSeq < sp.segments[j].firstSeq })
+	if n := len(sp.segments); n > 0 {
+		last := sp.segments[n-1]
+		sp.nextSeq = last.firstSeq + last.count
+	}
+	cursor, _ := ioutil.ReadFile(filepath.Join(dir, spoolCursorFile))
+	sp.sendSeq, _ = strconv.ParseInt(strings.TrimSpace(string(cursor)), 10, 64)
+	if len(sp.segments) == 0 && sp.sendSeq > sp.nextSeq {
+		sp.nextSeq = sp.sendSeq // everything was posted, go on with the seqs
+	}
+	if len(sp.segments) > 0 && sp.sendSeq < sp.segments[0].firstSeq {
+		sp.sendSeq = sp.segments[0].firstSeq
+	}
+	if sp.sendSeq > sp.nextSeq || sp.sendSeq <= 0 {
+		sp.sendSeq = sp.nextSeq
+	}
+	sp.replayUntil = sp.nextSeq
+	removeFiles(sp.prune())
+	return sp, nil
+}
+
+/*
+  recover
+  Count the lines of a segment, a partial line at its end is cut off
+*/
+func (sp *spool_t) recover(firstSeq int64) (*spoolSegment_t, error) {
+	name := sp.segmentName(firstSeq)
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	seg := &spoolSegment_t{firstSeq: firstSeq}
+	reader := bufio.NewReader(fh)
+	for {
+		record, err := reader.ReadString('\n')
+		if err != nil {
+			break // a record without its newline was cut short
+		}
+		seg.count++
+		seg.size += int64(len(record))
+	}
+	info, err := fh.Stat()
+	fh.Close()
+	if err == nil && info.Size() > seg.size {
+		err = os.Truncate(name, seg.size)
+	}
+	return seg, err
+}
+
+/*
+  segmentName
+  The file of the segment that starts at 'firstSeq'
+*/
+func (sp *spool_t) segmentName(firstSeq int64) string {
+	return filepath.Join(sp.dir, fmt.Sprintf("%020d%s", firstSeq, spoolSuffix))
+}
+
+/*
+  write
+  Add a line to the spool. A new segment is started when the last one
+  has its share of the max size, the oldest segment goes when the
+  spool would be over it.
+*/
+func (sp *spool_t) write(line string) error {
+	record := strconv.Quote(line) + "\n"
+	sp.mutex.Lock()
+	n := len(sp.segments)
+	start := sp.file == nil || n == 0 || sp.segments[n-1].size >= sp.maxSize/spoolSegments
+	sp.mutex.Unlock()
+	if start {
+		if err := sp.startSegment(); err != nil {
+			return err
+		}
+	}
+	var dropped []string
+	sp.mutex.Lock()
+	for len(sp.segments) > 1 && sp.size+int64(len(record)) > sp.maxSize {
+		dropped = append(dropped, sp.dropOldest())
+	}
+	sp.mutex.Unlock()
+	removeFiles(dropped)
+	if _, err := sp.file.WriteString(record); err != nil {
+		return err
+	}
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	seg := sp.segments[len(sp.segments)-1]
+	seg.count++
+	seg.size += int64(len(record))
+	sp.size += int64(len(record))
+	sp.nextSeq++
+	if sp.down {
+		sp.replayUntil = sp.nextSeq
+	}
+	return nil
+}
+
+/*
+  startSegment
+  Open the segment the lines go into: the last one, if it has room
+  and was not opened yet, or a new one
+*/
+func (sp *spool_t) startSegment() error {
+	sp.mutex.Lock()
+	n := len(sp.segments)
+	reopen := sp.file == nil && n > 0 && sp.segments[n-1].size < sp.maxSize/spoolSegments
+	firstSeq := sp.nextSeq
+	if reopen {
+		firstSeq = sp.segments[n-1].firstSeq
+	}
+	sp.mutex.Unlock()
+	if reopen {
+		fh, err := os.OpenFile(sp.segmentName(firstSeq), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		sp.file = fh
+		return nil
+	}
+	fh, err := os.OpenFile(sp.segmentName(firstSeq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := sp.close(); err != nil {
+		fh.Close()
+		return err
+	}
+	sp.file = fh
+	sp.mutex.Lock()
+	sp.segments = append(sp.segments, &spoolSegment_t{firstSeq: firstSeq})
+	sp.mutex.Unlock()
+	return nil
+}
+
+/*
+  dropOldest
+  Throw away the oldest segment. Its lines that were not posted are
+  dropped, unless they are being posted right now. The mutex is held,
+  the caller removes the file that is returned.
+*/
+func (sp *spool_t) dropOldest() string {
+	seg := sp.segments[0]
+	end := seg.firstSeq + seg.count
+	from := sp.sendSeq
+	if sp.inFlight > from {
+		from = sp.inFlight
+	}
+	if end > from {
+		sp.dropped += end - from
+	}
+	if sp.sendSeq < end {
+		sp.sendSeq = end
+	}
+	sp.segments = sp.segments[1:]
+	sp.size -= seg.size
+	return sp.segmentName(seg.firstSeq)
+}
+
+/*
+  peek
+  Up to 'max' lines from the next one to post, with the seq of the
+  first. Nothing is taken out of the spool until 'commit'. The files
+  are read without the mutex, from a copy of the segments.
+*/
+func (sp *spool_t) peek(max int) (int64, []string, spoolPos_t) {
+	sp.mutex.Lock()
+	first := sp.sendSeq
+	pos := sp.read
+	segments := make([]spoolSegment_t, len(sp.segments))
+	for i, seg := range sp.segments {
+		segments[i] = *seg
+	}
+	until := first + int64(max)
+	if until > sp.nextSeq {
+		until = sp.nextSeq
+	}
+	if until > first {
+		sp.inFlight = until // the cap does not count them as dropped while they are read
+	}
+	sp.mutex.Unlock()
+	if pos.seq != first || findSegment(segments, pos.segment) == nil {
+		pos = sp.seek(segments, first)
+	}
+	var lines []string
+	for pos.seq < until && findSegment(segments, pos.segment) != nil {
+		fh, err := os.Open(sp.segmentName(pos.segment))
+		if err != nil {
+			break
+		}
+		fh.Seek(pos.offset, io.SeekStart)
+		reader := bufio.NewReader(fh)
+		seg := findSegment(segments, pos.segment)
+		for pos.seq < until && pos.seq < seg.firstSeq+seg.count {
+			record, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line, err := strconv.Unquote(strings.TrimSuffix(record, "\n"))
+			if err != nil {
+				line = strings.TrimSuffix(record, "\n") // keep it as it is, rather than lose it
+			}
+			lines = append(lines, line)
+			pos.seq++
+			pos.offset += int64(len(record))
+		}
+		fh.Close()
+		if pos.seq < seg.firstSeq+seg.count || findSegment(segments, pos.seq) == nil {
+			break // not read to its end, or the lines go on in it
+		}
+		pos = spoolPos_t{seq: pos.seq, segment: pos.seq}
+	}
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	sp.inFlight = 0
+	if len(lines) > 0 {
+		sp.inFlight = pos.seq
+	}
+	if lost := minSeq(sp.sendSeq, until) - pos.seq; lost > 0 {
+		sp.dropped += lost // the cap took them before they could be read
+	}
+	return first, lines, pos
+}
+
+/*
+  seek
+  Where the line with this seq is, in a copy of the segments
+*/
+func (sp *spool_t) seek(segments []spoolSegment_t, seq int64) spoolPos_t {
+	for _, seg := range segments {
+		if seq >= seg.firstSeq+seg.count {
+			continue
+		}
+		pos := spoolPos_t{seq: seg.firstSeq, segment: seg.firstSeq}
+		if seq == pos.seq {
+			return pos
+		}
+		fh, err := os.Open(sp.segmentName(seg.firstSeq))
+		if err != nil {
+			return pos
+		}
+		reader := bufio.NewReader(fh)
+		for pos.seq < seq {
+			record, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			pos.seq++
+			pos.offset += int64(len(record))
+		}
+		fh.Close()
+		return pos
+	}
+	return spoolPos_t{seq: seq, segment: seq}
+}
+
+/*
+  findSegment
+  The segment that starts at 'firstSeq', nil if there is none
+*/
+func findSegment(segments []spoolSegment_t, firstSeq int64) *spoolSegment_t {
+	for i := range segments {
+		if segments[i].firstSeq == firstSeq {
+			return &segments[i]
+		}
+	}
+	return nil
+}
+
+func minSeq(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+/*
+  commit
+  The server has the lines up to 'pos', so the cursor moves past them
+  and the segments that were all posted go. The cursor is saved after
+  the mutex is released.
+*/
+func (sp *spool_t) commit(pos spoolPos_t) error {
+	sp.mutex.Lock()
+	sp.inFlight = 0
+	if pos.seq <= sp.sendSeq {
+		sp.mutex.Unlock()
+		return nil // the cap dropped them meanwhile
+	}
+	from := sp.sendSeq
+	if from < sp.replayUntil {
+		until := pos.seq
+		if until > sp.replayUntil {
+			until = sp.replayUntil
+		}
+		sp.replayed += until - from
+	}
+	sp.sendSeq = pos.seq
+	sp.read = pos
+	sp.down = false
+	posted := sp.prune()
+	cursor := strconv.FormatInt(sp.sendSeq, 10) + "\n"
+	sp.mutex.Unlock()
+	removeFiles(posted)
+	return writeFileAtomic(filepath.Join(sp.dir, spoolCursorFile), cursor)
+}
+
+/*
+  failed
+  A post failed, the lines that come until one works are replayed
+*/
+func (sp *spool_t) failed() {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	sp.inFlight = 0
+	sp.down = true
+	sp.replayUntil = sp.nextSeq
+}
+
+/*
+  prune
+  Take out the segments that were all posted, except the one the lines
+  go into. The mutex is held, the caller removes the files returned.
+*/
+func (sp *spool_t) prune() []string {
+	var posted []string
+	for len(sp.segments) > 1 {
+		seg := sp.segments[0]
+		if seg.firstSeq+seg.count > sp.sendSeq {
+			break
+		}
+		posted = append(posted, sp.segmentName(seg.firstSeq))
+		sp.segments = sp.segments[1:]
+		sp.size -= seg.size
+	}
+	return posted
+}
+
+/*
+  removeFiles
+  Remove the files of segments that are no longer in the spool
+*/
+func removeFiles(names []string) {
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+/*
+  depth and droppedLines
+  The lines not posted yet, and the lines the cap threw away
+*/
+func (sp *spool_t) depth() int64 {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.nextSeq - sp.sendSeq
+}
+
+func (sp *spool_t) droppedLines() int64 {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.dropped
+}
+
+/*
+  stats
+  The depth and bytes of the spool, the lines replayed and how many
+  are left to replay
+*/
+func (sp *spool_t) stats() (depth int64, size int64, replayed int64, replayLeft int64) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	if sp.replayUntil > sp.sendSeq {
+		replayLeft = sp.replayUntil - sp.sendSeq
+	}
+	return sp.nextSeq - sp.sendSeq, sp.size, sp.replayed, replayLeft
+}
+
+/*
+  close
+  Sync and close the segment the lines go into, what is not posted
+  stays on disk for the next run
+*/
+func (sp *spool_t) close() error {
+	if sp.file == nil {
+		return nil
+	}
+	err := sp.file.Sync()
+	if closeErr := sp.file.Close(); err == nil {
+		err = closeErr
+	}
+	sp.file = nil
+	return err
+}
+
+/*
+  writeFileAtomic
+  Write a small file so that a crash leaves the old or the new one.
+  The file and its directory are synced, so the rename is on disk too.
+*/
+func writeFileAtomic(name string, contents string) error {
+	tmp := name + ".tmp"
+	fh, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fh.WriteString(contents)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+/*
+  syncDir
+  Sync a directory, so what was renamed in it stays. Windows cannot
+  sync a directory, there the rename is as safe as it gets.
+*/
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if err := fh.Sync(); err != nil && runtime.GOOS != "windows" {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  TestSpoolReplay
+  The lines spooled while the log server is down, or before a restart,
+  are posted in order once it is back, with the seqs of the sender
+*/
+func TestSpoolReplay(t *testing.T) {
+	receiver := &urlReceiver_t{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	dir := t.TempDir()
+
+	spool, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	shipper := newUrlShipper(server.URL, "BeyondAI", "local")
+	shipper.batchSize = 4
+	shipper.useSpool(spool)
+	for i := 0; i < 5; i++ {
+		shipper.post(fmt.Sprintf("line %d", i))
+	}
+	if err := shipper.sendAll(); err == nil {
+		t.Errorf("Logit problem: a post to a server that is down worked")
+	}
+	shipper.post("line 5")
+	if depth, _, _, left := shipper.spoolStats(); depth != 6 || left != 6 {
+		t.Errorf("Logit problem: depth %d, replay left %d while the server is down", depth, left)
+	}
+	if err := shipper.sendAll(); err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	if depth, size, replayed, left := shipper.spoolStats(); depth != 0 || size == 0 || replayed != 6 || left != 0 {
+		t.Errorf("Logit problem: depth %d, size %d, replayed %d, left %d", depth, size, replayed, left)
+	}
+	//
+	// a restart with lines the server did not get
+	//
+	receiver.failures = 1
+	shipper.post("line 6")
+	shipper.post("line 7")
+	shipper.sendAll()
+	spool.close()
+	spool, err = openSpool(dir, 0)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if spool.depth() != 2 || spool.sender != shipper.sender {
+		t.Errorf("Logit problem: depth %d and sender '%s' after the restart", spool.depth(), spool.sender)
+	}
+	shipper = newUrlShipper(server.URL, "BeyondAI", "local")
+	shipper.useSpool(spool)
+	shipper.post("line 8")
+	if err := shipper.sendAll(); err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	spool.close()
+
+	lines := receiver.lines()
+	if len(lines) != 9 {
+		t.Fatalf("Logit problem: log server got %q", lines)
+	}
+	for i, line := range lines {
+		if line != fmt.Sprintf("line %d", i) {
+			t.Errorf("Logit problem: line %d is '%s', out of order", i, line)
+		}
+	}
+	seq := int64(1)
+	for _, batch := range receiver.batches {
+		if batch.FirstSeq != seq || batch.Sender != spool.sender {
+			t.Errorf("Logit problem: batch from '%s' at seq %d, wanted %d", batch.Sender, batch.FirstSeq, seq)
+		}
+		seq += int64(len(batch.Lines))
+	}
+	//
+	// a crash before the cursor was saved posts again, with the same seqs
+	//
+	if err := writeFileAtomic(filepath.Join(dir, spoolCursorFile), "8\n"); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	spool, err = openSpool(dir, 0)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer spool.close()
+	if first, lines, _ := spool.peek(10); first != 8 || len(lines) != 2 || lines[0] != "line 7" {
+		t.Errorf("Logit problem: replay from %d of %q", first, lines)
+	}
+}
+
+/*
+  TestSpoolCap
+  A spool that is full throws away its oldest segment, the lines of it
+  that were not posted are dropped
+*/
+func TestSpoolCap(t *testing.T) {
+	shipper := newUrlShipper("http://127.0.0.1:0/never", "BeyondAI", "local")
+	spool, err := openSpool(t.TempDir(), 800)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer spool.close()
+	shipper.useSpool(spool)
+	for i := 0; i < 100; i++ {
+		shipper.post(fmt.Sprintf("line %02d", i))
+	}
+	depth, size, _, _ := shipper.spoolStats()
+	if size > 800 || depth+shipper.droppedCount != 100 || shipper.droppedCount == 0 {
+		t.Errorf("Logit problem: size %d, depth %d, dropped %d", size, depth, shipper.droppedCount)
+	}
+	first, lines, _ := spool.peek(1)
+	if len(lines) != 1 || lines[0] != fmt.Sprintf("line %02d", first-1) || first != 101-depth {
+		t.Errorf("Logit problem: the oldest line kept is %q at seq %d", lines, first)
+	}
+}
+
+/*
+  TestSpoolShipping
+  The writer spools while the shipper posts, with small segments that
+  come and go, every line gets to the server once and in order
+*/
+func TestSpoolShipping(t *testing.T) {
+	receiver := &urlReceiver_t{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	dir := t.TempDir()
+	spool, err := openSpool(dir, 8*200)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	shipper := newUrlShipper(server.URL, "BeyondAI", "local")
+	shipper.batchSize = 7
+	shipper.useSpool(spool)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			shipper.post(fmt.Sprintf("line %03d", i))
+		}
+	}()
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		default:
+		}
+		if err := shipper.sendAll(); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+	}
+	spool.close()
+	lines := receiver.lines()
+	if int64(len(lines))+shipper.droppedCount != 500 {
+		t.Fatalf("Logit problem: %d lines posted, %d dropped", len(lines), shipper.droppedCount)
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i] <= lines[i-1] {
+			t.Errorf("Logit problem: '%s' after '%s'", lines[i], lines[i-1])
+		}
+	}
+	cursor, _ := ioutil.ReadFile(filepath.Join(dir, spoolCursorFile))
+	if strings.TrimSpace(string(cursor)) != "501" {
+		t.Errorf("Logit problem: cursor '%s'", cursor)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) > 0 {
+		t.Errorf("Logit problem: left %q", tmps)
+	}
+}
+
+/*
+  TestSpoolTorn
+  A line that was only partly written when the process died is cut
+  off, the lines before it are kept and the seqs go on after them
+*/
+func TestSpoolTorn(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	for _, line := range []string{"first", "with \"quotes\"\nand a newline", "third"} {
+		if err := spool.write(line); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+	}
+	spool.close()
+	fh, err := os.OpenFile(spool.segmentName(1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	fh.WriteString(`"fourth, cut sh`)
+	fh.Close()
+
+	spool, err = openSpool(dir, 0)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer spool.close()
+	spool.write("fourth")
+	first, lines, _ := spool.peek(10)
+	if first != 1 || strings.Join(lines, "|") != "first|with \"quotes\"\nand a newline|third|fourth" {
+		t.Errorf("Logit problem: from %d, %q", first, lines)
+	}
+}
+
+/*
+  TestLogConfigSpool
+  "urlSpoolDir" spools the lines of the logger, GetLogStats has the
+  depth of the spool while the log server is down
+*/
+func TestLogConfigSpool(t *testing.T) {
+	receiver := &urlReceiver_t{failures: 1000, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	dir := t.TempDir()
+	configFileName := filepath.Join(dir, "logtestcfg.json")
+	jsonTest := `
+	{
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "",
+		"url": "` + server.URL + `",
+		"urlSpoolDir": "` + filepath.ToSlash(filepath.Join(dir, "spool")) + `",
+		"urlSpoolMaxSize": "1MB",
+		"stdout": false,
+		"level": "INFO"
+	}`
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Spooled for the log server")
+	deadline := time.Now().Add(time.Second * 5)
+	for l.GetLogStats().urlSpoolDepth == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	stats := l.GetLogStats()
+	if stats.urlSpoolDepth == 0 || stats.urlSpoolBytes == 0 || stats.urlPending != int(stats.urlSpoolDepth) {
+		t.Errorf("Logit problem: depth %d, bytes %d, pending %d", stats.urlSpoolDepth, stats.urlSpoolBytes, stats.urlPending)
+	}
+	if config := effectiveConfig(configFileName, l.getLogFlags()); config.UrlSpoolMaxSize != 1<<20 {
+		t.Errorf("Logit problem: spool max size %d", config.UrlSpoolMaxSize)
+	}
+	l.Close()
+	if _, err := os.Stat(filepath.Join(dir, "spool", spoolIDFile)); err != nil {
+		t.Errorf("Logit problem: no sender id, %s", err.Error())
+	}
+}
@@ -0,0 +1,393 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// defaults for sending logs to syslog
+const (
+	defaultSyslogBuffer     = 10000            // max messages held while syslog is down
+	defaultSyslogMinBackoff = time.Second * 1  // first retry delay after a failed send
+	defaultSyslogMaxBackoff = time.Second * 60 // retry delay never grows past this
+	defaultSyslogCloseWait  = time.Second * 5  // how long Close waits for the last messages
+	syslogDialTimeout       = time.Second * 5  // how long a connect may take
+	syslogWriteTimeout      = time.Second * 5  // how long a send may take before reconnecting
+	defaultSyslogMaxSize    = 8192             // max bytes of a udp or unixgram message, the rest is cut
+	syslogMaxDatagram       = 65507            // no datagram can be longer
+)
+
+// syslogEnterprise is the RFC 5612 documentation enterprise number used in the SD-IDs
+const syslogEnterprise = "32473"
+
+// the syslog facilities by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// the syslog severity of each level
+var syslogSeverities = map[logLevel_t]int{
+	FATAL: 2, // critical
+	ERROR: 3, // error
+	WARN:  4, // warning
+	INFO:  6, // informational
+	DEBUG: 7, // debug
+	TRACE: 7, // debug
+}
+
+// syslogJson_t is the "syslog" of the config, or a syslog sink
+type syslogJson_t struct {
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Facility string `json:"facility"`
+	AppName  string `json:"appName"`
+	Buffer   int    `json:"bufferSize"`
+	MaxSize  int    `json:"maxMessageSize"`
+}
+
+// syslog_t holds the syslog settings of the config
+type syslog_t struct {
+	network  string // udp, tcp, unix or unixgram
+	address  string // host:port, or the path of the socket
+	facility int    // one of syslogFacilities
+	appName  string // the APP-NAME of every message
+	buffer   int    // max messages held while syslog is down
+	maxSize  int    // max bytes of a message, 0 for no limit
+}
+
+/*
+  parseSyslog
+  Check the syslog settings of the config and fill in the defaults.
+  No address means no syslog. A udp or unixgram message is cut to
+  8KB unless 'maxSize' says otherwise, a stream has no limit unless
+  it says one.
+*/
+func parseSyslog(network string, address string, facility string, appName string, buffer int, maxSize int) (syslog_t, error) {
+	if len(address) == 0 {
+		return syslog_t{}, nil
+	}
+	s := syslog_t{network: strings.ToLower(network), address: address, appName: appName, buffer: buffer, maxSize: maxSize}
+	switch s.network {
+	case "":
+		s.network = "udp"
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return syslog_t{}, fmt.Errorf("unknown syslog network '%s', use udp, tcp, unix or unixgram", network)
+	}
+	if len(facility) == 0 {
+		facility = "user"
+	}
+	code, found := syslogFacilities[strings.ToLower(facility)]
+	if !found {
+		return syslog_t{}, fmt.Errorf("unknown syslog facility '%s'", facility)
+	}
+	s.facility = code
+	if len(s.appName) == 0 {
+		s.appName = filepath.Base(os.Args[0])
+	}
+	if s.buffer <= 0 {
+		s.buffer = defaultSyslogBuffer
+	}
+	if s.maxSize < 0 {
+		return syslog_t{}, fmt.Errorf("syslog maxMessageSize %d is less than 0", maxSize)
+	}
+	if s.network == "udp" || s.network == "unixgram" {
+		if s.maxSize == 0 {
+			s.maxSize = defaultSyslogMaxSize
+		}
+		if s.maxSize > syslogMaxDatagram {
+			return syslog_t{}, fmt.Errorf("syslog maxMessageSize %d is more than a datagram can have, %d", maxSize, syslogMaxDatagram)
+		}
+	}
+	return s, nil
+}
+
+/*
+  syslogSender_t
+  Formats the records as RFC 5424 messages and sends them from its own
+  goroutine, so a slow or missing syslog never holds up the writer.
+  TCP and unix streams use octet counting, "LEN SP MSG".
+*/
+type syslogSender_t struct {
+	config     syslog_t
+	hostname   string        // the HOSTNAME of every message
+	procID     string        // the PROCID of every message
+	minBackoff time.Duration // first retry delay after a failed send
+	maxBackoff time.Duration // retry delay never grows past this
+
+	queue    chan string   // formatted messages waiting to be sent
+	stopChan chan bool     // tell the sender to finish up
+	doneChan chan struct{} // closed when the sender has finished
+
+	sentCount    int64 // messages written to syslog
+	droppedCount int64 // messages thrown away because the queue was full, or syslog can never take them
+}
+
+/*
+  newSyslogSender
+  Create a sender for the syslog settings.
+  The sender does nothing until 'start' is called.
+*/
+func newSyslogSender(config syslog_t) *syslogSender_t {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &syslogSender_t{
+		config:     config,
+		hostname:   hostname,
+		procID:     strconv.Itoa(os.Getpid()),
+		minBackoff: defaultSyslogMinBackoff,
+		maxBackoff: defaultSyslogMaxBackoff,
+		queue:      make(chan string, config.buffer),
+		stopChan:   make(chan bool),
+		doneChan:   make(chan struct{}),
+	}
+}
+
+/*
+  start
+  Start the background loop that sends the messages
+*/
+func (s *syslogSender_t) start() {
+	go s.run()
+}
+
+/*
+  post
+  Queue one record for syslog. This never blocks, if the queue is
+  full the message is thrown away.
+*/
+func (s *syslogSender_t) post(rec *Record_t) {
+	msg := formatSyslog(rec, s.config.facility, s.hostname, s.config.appName, s.procID, s.config.maxSize)
+	select {
+	case s.queue <- msg:
+	default:
+		atomic.AddInt64(&s.droppedCount, 1)
+	}
+}
+
+/*
+  stop
+  Tell the sender to send what is left and wait for it,
+  but no longer than 'wait'.
+*/
+func (s *syslogSender_t) stop(wait time.Duration) {
+	close(s.stopChan)
+	select {
+	case <-s.doneChan:
+	case <-time.After(wait):
+	}
+}
+
+/*
+  pending
+  How many messages are waiting to be sent
+*/
+func (s *syslogSender_t) pending() int {
+	return len(s.queue)
+}
+
+/*
+  Write, Flush and Close
+  The sender is the syslog sink, it formats the record itself.
+  Close waits for the last messages.
+*/
+func (s *syslogSender_t) Write(rec *Record_t, line string) error {
+	s.post(rec)
+	return nil
+}
+
+func (s *syslogSender_t) Flush() error { return nil }
+
+func (s *syslogSender_t) Close() error {
+	s.stop(defaultSyslogCloseWait)
+	return nil
+}
+
+/*
+  stream
+  TCP and unix sockets need the octet counting framing
+*/
+func (s *syslogSender_t) stream() bool {
+	return s.config.network == "tcp" || s.config.network == "unix"
+}
+
+/*
+  run
+  The background loop. It connects when there is something to send,
+  a failed connect or send is retried with a doubling backoff and the
+  message is kept for the retry. A message syslog can never take, one
+  too long for the transport, is dropped instead.
+*/
+func (s *syslogSender_t) run() {
+	defer close(s.doneChan)
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	var backoff time.Duration
+	var msg string
+	stopping := false
+	for {
+		if len(msg) == 0 { // get the next message
+			select {
+			case msg = <-s.queue:
+			case <-s.stopChan:
+				stopping = true
+				select { // anything left?
+				case msg = <-s.queue:
+				default:
+					return
+				}
+			}
+		}
+		var err error
+		if conn == nil {
+			conn, err = net.DialTimeout(s.config.network, s.config.address, syslogDialTimeout)
+			if err != nil {
+				conn = nil
+			}
+		}
+		if conn != nil {
+			frame := msg
+			if s.stream() {
+				frame = strconv.Itoa(len(msg)) + " " + msg
+			}
+			conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+			_, err = conn.Write([]byte(frame))
+			if err != nil {
+				conn.Close()
+				conn = nil
+			}
+		}
+		if err == nil {
+			atomic.AddInt64(&s.sentCount, 1)
+			msg = ""
+			backoff = 0
+			continue
+		}
+		if errors.Is(err, syscall.EMSGSIZE) { // no retry will ever send it
+			atomic.AddInt64(&s.droppedCount, 1)
+			msg = ""
+			continue
+		}
+		if stopping { // one try on the way out
+			return
+		}
+		if backoff == 0 {
+			backoff = s.minBackoff
+		} else {
+			backoff *= 2
+		}
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-s.stopChan:
+			stopping = true
+		}
+	}
+}
+
+/*
+  formatSyslog
+  One RFC 5424 message:
+  <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [logit@32473 ...][fields@32473 ...] MSG
+  The SiteID, SystemID, package, file and generation are structured data,
+  and so are the fields of a 'With' entry. A stack trace follows the
+  message. A message longer than 'max' bytes is cut, 0 is no limit.
+*/
+func formatSyslog(rec *Record_t, facility int, hostname string, appName string, procID string, max int) string {
+	var sb strings.Builder
+	sb.WriteString("<" + strconv.Itoa(facility*8+syslogSeverities[rec.Level]) + ">1 ")
+	sb.WriteString(rec.Time.Format("2006-01-02T15:04:05.000000Z07:00") + " ")
+	sb.WriteString(syslogHeader(hostname, 255) + " ")
+	sb.WriteString(syslogHeader(appName, 48) + " ")
+	sb.WriteString(syslogHeader(procID, 128) + " ")
+	sb.WriteString(syslogHeader(rec.label(), 32) + " ") // MSGID
+	sb.WriteString("[logit@" + syslogEnterprise)
+	writeSyslogParam(&sb, "SiteID", rec.SiteID)
+	writeSyslogParam(&sb, "SystemID", rec.SystemID)
+	writeSyslogParam(&sb, "pkg", rec.Pkg)
+	writeSyslogParam(&sb, "file", rec.File)
+	writeSyslogParam(&sb, "generation", strconv.Itoa(int(rec.Generation)))
+	sb.WriteString("]")
+	if len(rec.Fields) > 0 {
+		sb.WriteString("[fields@" + syslogEnterprise)
+		for _, field := range rec.Fields {
+			writeSyslogParam(&sb, field.Key, fieldString(field.Value))
+		}
+		sb.WriteString("]")
+	}
+	sb.WriteString(" " + rec.Msg)
+	if len(rec.Stack) > 0 {
+		sb.WriteString("\n" + strings.TrimRight(rec.Stack, "\n"))
+	}
+	msg := sb.String()
+	if max > 0 && len(msg) > max {
+		for max > 0 && !utf8.RuneStart(msg[max]) {
+			max-- // not in the middle of a character
+		}
+		msg = msg[:max]
+	}
+	return msg
+}
+
+/*
+  syslogHeader
+  A header field: printable ASCII only, no spaces, "-" if empty
+*/
+func syslogHeader(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(value) > max {
+		value = value[:max]
+	}
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+/*
+  writeSyslogParam
+  Add one SD-PARAM. The name loses the characters it cannot have,
+  the value escapes '"', '\' and ']'.
+*/
+func writeSyslogParam(sb *strings.Builder, name string, value string) {
+	name = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	if len(name) == 0 {
+		return
+	}
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	sb.WriteString(" " + name + `="` + value + `"`)
+}
@@ -0,0 +1,262 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+  TestSyslogFormat
+  The PRI, header, structured data and message of one record
+*/
+func TestSyslogFormat(t *testing.T) {
+	rec := &Record_t{
+		Time:       time.Date(2024, 3, 1, 10, 20, 30, 123456000, time.UTC),
+		Level:      WARN,
+		Pkg:        "logit",
+		File:       "logSyslog_test",
+		SiteID:     "Beyond AI",
+		SystemID:   `a"b]c\d`,
+		Generation: 2,
+		Fields:     []Field_t{{"user", "jeff"}, {"bad key=", 42}},
+		Msg:        "Disk is filling up",
+	}
+	line := formatSyslog(rec, 16, "host one", "", "123", 0)
+	expected := `<132>1 2024-03-01T10:20:30.123456Z host_one - 123 WARN ` +
+		`[logit@32473 SiteID="Beyond AI" SystemID="a\"b\]c\\d" pkg="logit" file="logSyslog_test" generation="2"]` +
+		`[fields@32473 user="jeff" badkey="42"] Disk is filling up`
+	if line != expected {
+		t.Errorf("Logit problem: syslog message\n%s\nexpected\n%s", line, expected)
+	}
+	for level, pri := range map[logLevel_t]string{FATAL: "<130>", ERROR: "<131>", INFO: "<134>", DEBUG: "<135>", TRACE: "<135>"} {
+		rec.Level = level
+		if line := formatSyslog(rec, 16, "host", "app", "1", 0); !strings.HasPrefix(line, pri+"1 ") {
+			t.Errorf("Logit problem: level %s starts '%s'", levelNames[level], line[:8])
+		}
+	}
+	rec.Stack = "goroutine 1 [running]:\nmain.main()\n"
+	if line := formatSyslog(rec, 16, "host", "app", "1", 0); !strings.HasSuffix(line, " Disk is filling up\ngoroutine 1 [running]:\nmain.main()") {
+		t.Errorf("Logit problem: no stack in '%s'", line)
+	}
+	rec.Stack, rec.Msg = "", "Disk is filling up: é"
+	full := formatSyslog(rec, 16, "host", "app", "1", 0)
+	if line := formatSyslog(rec, 16, "host", "app", "1", len(full)-1); line != strings.TrimSuffix(full, "é") {
+		t.Errorf("Logit problem: cut to '%s'", line)
+	}
+}
+
+/*
+  TestSyslogParse
+  The defaults, and the networks and facilities that are not known
+*/
+func TestSyslogParse(t *testing.T) {
+	s, err := parseSyslog("", "localhost:514", "", "", 0, 0)
+	if err != nil || s.network != "udp" || s.facility != 1 || len(s.appName) == 0 || s.buffer != defaultSyslogBuffer {
+		t.Errorf("Logit problem: syslog defaults %+v, %v", s, err)
+	}
+	s, err = parseSyslog("TCP", "localhost:514", "LOCAL7", "app", 10, 0)
+	if err != nil || s.network != "tcp" || s.facility != 23 || s.appName != "app" || s.buffer != 10 {
+		t.Errorf("Logit problem: syslog settings %+v, %v", s, err)
+	}
+	if s, err = parseSyslog("", "", "", "", 0, 0); err != nil || len(s.address) != 0 {
+		t.Errorf("Logit problem: no address should be no syslog, %+v, %v", s, err)
+	}
+	if _, err = parseSyslog("http", "localhost:514", "", "", 0, 0); err == nil {
+		t.Errorf("Logit problem: network http was accepted")
+	}
+	if _, err = parseSyslog("udp", "localhost:514", "local9", "", 0, 0); err == nil {
+		t.Errorf("Logit problem: facility local9 was accepted")
+	}
+	if s, _ = parseSyslog("udp", "localhost:514", "", "", 0, 0); s.maxSize != defaultSyslogMaxSize {
+		t.Errorf("Logit problem: udp max message size %d", s.maxSize)
+	}
+	if s, _ = parseSyslog("tcp", "localhost:514", "", "", 0, 0); s.maxSize != 0 {
+		t.Errorf("Logit problem: tcp max message size %d", s.maxSize)
+	}
+	if _, err = parseSyslog("udp", "localhost:514", "", "", 0, 70000); err == nil {
+		t.Errorf("Logit problem: a udp max message size of 70000 was accepted")
+	}
+}
+
+/*
+  TestSyslogTooLong
+  A datagram that is too long for udp is dropped, the messages after
+  it are still sent
+*/
+func TestSyslogTooLong(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no udp here: %s", err)
+	}
+	defer conn.Close()
+	received := readSyslogDatagrams(conn, " After the long one")
+
+	// no max size, and a retry that would never come
+	s := newSyslogSender(syslog_t{network: "udp", address: conn.LocalAddr().String(), buffer: 10})
+	s.minBackoff = time.Hour
+	s.start()
+	defer s.Close()
+	s.post(&Record_t{Level: ERROR, Msg: strings.Repeat("x", 70000)})
+	s.post(&Record_t{Level: ERROR, Msg: "After the long one"})
+	select {
+	case <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Logit problem: the message after the long one was not sent")
+	}
+	if dropped := atomic.LoadInt64(&s.droppedCount); dropped != 1 {
+		t.Errorf("Logit problem: %d dropped", dropped)
+	}
+}
+
+/*
+  TestSyslogUDP
+  The messages of the logger arrive as datagrams at a local listener
+*/
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no udp here: %s", err)
+	}
+	defer conn.Close()
+
+	received := readSyslogDatagrams(conn, " Sent to syslog")
+
+	l := newTestLogger(t, `
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "",
+		"syslog": { "network": "udp", "address": "`+conn.LocalAddr().String()+`",
+			"facility": "local0", "appName": "logtest" },
+		"stdout": false,
+		"level": "INFO"`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.With(&myFlags, "user", "jeff").Warn("Sent to syslog")
+
+	select {
+	case msg := <-received:
+		if !strings.HasPrefix(msg, "<132>1 ") || !strings.Contains(msg, " logtest ") ||
+			!strings.Contains(msg, `[logit@32473 SiteID="BeyondAI" SystemID="local" pkg="logit" file="logSyslog_test" generation="0"]`) ||
+			!strings.Contains(msg, `[fields@32473 user="jeff"]`) {
+			t.Errorf("Logit problem: syslog message '%s'", msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("Logit problem: syslog did not get the message")
+	}
+}
+
+/*
+  readSyslogDatagrams
+  Read the datagrams of a listener, like syslog does, and send
+  the first that ends with 'suffix' on the channel
+*/
+func readSyslogDatagrams(conn net.PacketConn, suffix string) <-chan string {
+	received := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if msg := string(buf[:n]); strings.HasSuffix(msg, suffix) {
+				received <- msg
+				return
+			}
+		}
+	}()
+	return received
+}
+
+/*
+  readSyslogFrames
+  Read the octet counted messages of a stream until it is closed
+*/
+func readSyslogFrames(t *testing.T, r io.Reader) []string {
+	var msgs []string
+	reader := bufio.NewReader(r)
+	for {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return msgs
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Errorf("Logit problem: frame length '%s'", length)
+			return msgs
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			t.Errorf("Logit problem: frame of %d is short, %s", n, err)
+			return msgs
+		}
+		msgs = append(msgs, string(msg))
+	}
+}
+
+/*
+  TestSyslogTCP
+  The messages of the logger arrive octet counted on a TCP stream
+*/
+func TestSyslogTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no tcp here: %s", err)
+	}
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		received <- readSyslogFrames(t, conn)
+	}()
+
+	l := newTestLogger(t, `
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "",
+		"syslog": { "network": "tcp", "address": "`+listener.Addr().String()+`",
+			"facility": "local0", "appName": "logtest" },
+		"stdout": false,
+		"level": "INFO"`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	for i := 0; i < 5; i++ {
+		l.Errorf(&myFlags, "Message %d with a\nnew line", i)
+	}
+	l.Close() // the stream is closed when the sender is done
+
+	var msgs []string
+	select {
+	case msgs = <-received:
+	case <-time.After(time.Second * 10):
+		t.Fatalf("Logit problem: the syslog stream was not closed")
+	}
+	count := 0
+	for _, msg := range msgs {
+		if !strings.HasPrefix(msg, "<1") || !strings.Contains(msg, " logtest ") {
+			t.Errorf("Logit problem: syslog message '%s'", msg)
+		}
+		if strings.Contains(msg, "new line") {
+			if !strings.HasPrefix(msg, "<131>1 ") || !strings.HasSuffix(msg, "Message "+strconv.Itoa(count)+" with a\nnew line") {
+				t.Errorf("Logit problem: syslog message '%s'", msg)
+			}
+			count += 1
+		}
+	}
+	if count != 5 {
+		t.Errorf("Logit problem: syslog got %d of the 5 messages in %q", count, msgs)
+	}
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+// +build !windows
+
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+  TestSyslogUnixgram
+  The messages of the logger arrive at a local unix datagram socket,
+  the way /dev/log works
+*/
+func TestSyslogUnixgram(t *testing.T) {
+	socketName := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenPacket("unixgram", socketName)
+	if err != nil {
+		t.Skipf("no unixgram here: %s", err)
+	}
+	defer conn.Close()
+
+	received := readSyslogDatagrams(conn, " Sent to the syslog socket")
+
+	l := newTestLogger(t, `
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "",
+		"syslog": { "network": "unixgram", "address": "`+socketName+`",
+			"facility": "local0", "appName": "logtest" },
+		"stdout": false,
+		"level": "INFO"`)
+	defer l.Close()
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Sent to the syslog socket")
+
+	select {
+	case msg := <-received:
+		if !strings.HasPrefix(msg, "<134>1 ") {
+			t.Errorf("Logit problem: syslog message '%s'", msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("Logit problem: syslog did not get the message")
+	}
+}
+
+/*
+  TestSyslogUnixStream
+  A unix stream socket is octet counted like TCP
+*/
+func TestSyslogUnixStream(t *testing.T) {
+	socketName := filepath.Join(t.TempDir(), "log.sock")
+	listener, err := net.Listen("unix", socketName)
+	if err != nil {
+		t.Skipf("no unix sockets here: %s", err)
+	}
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		received <- readSyslogFrames(t, conn)
+	}()
+
+	l := newTestLogger(t, `
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "",
+		"syslog": { "network": "unix", "address": "`+socketName+`",
+			"facility": "local0", "appName": "logtest" },
+		"stdout": false,
+		"level": "INFO"`)
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Info(&myFlags, "Sent to the syslog stream")
+	l.Close()
+
+	found := false
+	select {
+	case msgs := <-received:
+		for _, msg := range msgs {
+			if strings.HasPrefix(msg, "<134>1 ") && strings.HasSuffix(msg, " Sent to the syslog stream") {
+				found = true
+			}
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf("Logit problem: the syslog stream was not closed")
+	}
+	if !found {
+		t.Errorf("Logit problem: syslog did not get the message")
+	}
+}
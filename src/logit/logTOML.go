@@ -0,0 +1,438 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+  tomlParser_t
+  Reads the toml a config needs: key = value pairs, dotted keys,
+  [tables] and [[arrays of tables]], strings, numbers, booleans,
+  arrays and inline tables. Dates and multi-line strings are not a
+  config's business, they are errors.
+*/
+type tomlParser_t struct {
+	text   string
+	i      int
+	line   int
+	column int
+	doc    *configDoc_t
+	tables map[string]bool // the [tables] given, to warn about one given twice
+}
+
+/*
+  tomlError_t
+  What is wrong with the toml and where
+*/
+type tomlError_t struct {
+	pos configPos_t
+	msg string
+}
+
+func (e *tomlError_t) Error() string {
+	return e.msg
+}
+
+/*
+  parseTOML
+  The config of a toml file, nil if it is not good toml, its problem
+  is added to the doc
+*/
+func parseTOML(raw []byte, doc *configDoc_t) (value interface{}) {
+	p := &tomlParser_t{text: strings.Replace(string(raw), "\r\n", "\n", -1), line: 1, column: 1, doc: doc,
+		tables: make(map[string]bool)}
+	defer func() {
+		if r := recover(); r != nil {
+			tomlErr, isTOMLErr := r.(*tomlError_t)
+			if !isTOMLErr {
+				panic(r)
+			}
+			doc.add(tomlErr.pos, "", CONFIG_ERROR, "bad toml: "+tomlErr.msg)
+			value = nil
+		}
+	}()
+	doc.index[""] = configPos_t{line: 1, column: 1}
+	root := make(map[string]interface{})
+	table, path := root, ""
+	for {
+		p.skip(true)
+		if p.i >= len(p.text) {
+			return root
+		}
+		if p.text[p.i] == '[' {
+			table, path = p.parseHeader(root)
+		} else {
+			p.parseKeyValue(table, path)
+		}
+		p.skip(false)
+		if p.i < len(p.text) && p.text[p.i] != '\n' {
+			p.fail("the line goes on after its value")
+		}
+	}
+}
+
+func (p *tomlParser_t) pos() configPos_t {
+	return configPos_t{line: p.line, column: p.column}
+}
+
+func (p *tomlParser_t) fail(format string, args ...interface{}) {
+	panic(&tomlError_t{pos: p.pos(), msg: fmt.Sprintf(format, args...)})
+}
+
+/*
+  advance
+  Go past n bytes, counting the lines and the columns
+*/
+func (p *tomlParser_t) advance(n int) {
+	for ; n > 0 && p.i < len(p.text); n-- {
+		if p.text[p.i] == '\n' {
+			p.line++
+			p.column = 1
+		} else {
+			p.column++
+		}
+		p.i++
+	}
+}
+
+/*
+  skip
+  Go past spaces and comments, and the line breaks if 'lines'
+*/
+func (p *tomlParser_t) skip(lines bool) {
+	for p.i < len(p.text) {
+		switch c := p.text[p.i]; {
+		case c == ' ' || c == '\t' || (lines && c == '\n'):
+			p.advance(1)
+		case c == '#':
+			for p.i < len(p.text) && p.text[p.i] != '\n' {
+				p.advance(1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+/*
+  parseHeader
+  A [table] or an [[array of tables]] line, the table the keys after
+  it go in
+*/
+func (p *tomlParser_t) parseHeader(root map[string]interface{}) (map[string]interface{}, string) {
+	headerPos := p.pos()
+	array := strings.HasPrefix(p.text[p.i:], "[[")
+	p.advance(1)
+	if array {
+		p.advance(1)
+	}
+	keys := p.parseKey()
+	if array {
+		p.expect("]]")
+	} else {
+		p.expect("]")
+	}
+	end := p.pos()
+	p.line, p.column = headerPos.line, headerPos.column // what is wrong is the header
+	defer func() { p.line, p.column = end.line, end.column }()
+	table, path := root, ""
+	for n, key := range keys {
+		member := joinConfigPath(path, key)
+		last := n == len(keys)-1
+		switch existing := table[key].(type) {
+		case nil:
+			if last && array {
+				table[key] = []interface{}{}
+			} else {
+				table[key] = make(map[string]interface{})
+			}
+			p.doc.index[member] = headerPos
+		case []interface{}:
+			if !last {
+				if len(existing) == 0 {
+					p.fail("'%s' is not a table", member)
+				}
+				// a table under an array of tables is in its last table
+				member = fmt.Sprintf("%s[%d]", member, len(existing)-1)
+				table, path = existing[len(existing)-1].(map[string]interface{}), member
+				continue
+			}
+		case map[string]interface{}:
+		default:
+			p.fail("'%s' already has a value", member)
+		}
+		if last && array {
+			list, isList := table[key].([]interface{})
+			if !isList {
+				p.fail("'%s' is a table, not an array of tables", member)
+			}
+			item := make(map[string]interface{})
+			table[key] = append(list, item)
+			path = fmt.Sprintf("%s[%d]", member, len(list))
+			p.doc.index[path] = headerPos
+			return item, path
+		}
+		next, isTable := table[key].(map[string]interface{})
+		if !isTable {
+			p.fail("'%s' is an array of tables, use [[%s]]", member, member)
+		}
+		table, path = next, member
+	}
+	if p.tables[path] {
+		p.doc.add(headerPos, path, CONFIG_WARNING, "given more than once, the keys are merged")
+	}
+	p.tables[path] = true
+	return table, path
+}
+
+/*
+  expect
+  Go past this text, it must be next
+*/
+func (p *tomlParser_t) expect(text string) {
+	p.skip(false)
+	if !strings.HasPrefix(p.text[p.i:], text) {
+		p.fail("'%s' is missing", text)
+	}
+	p.advance(len(text))
+}
+
+/*
+  parseKey
+  A key, its parts if it is dotted: bare keys of letters, digits, '_'
+  and '-', or quoted keys
+*/
+func (p *tomlParser_t) parseKey() []string {
+	var keys []string
+	for {
+		p.skip(false)
+		if p.i >= len(p.text) {
+			p.fail("a key is missing")
+		}
+		switch c := p.text[p.i]; {
+		case c == '"' || c == '\'':
+			keys = append(keys, p.parseString())
+		default:
+			start := p.i
+			for p.i < len(p.text) && isTOMLBare(p.text[p.i]) {
+				p.advance(1)
+			}
+			if start == p.i {
+				p.fail("a key is missing")
+			}
+			keys = append(keys, p.text[start:p.i])
+		}
+		p.skip(false)
+		if p.i >= len(p.text) || p.text[p.i] != '.' {
+			return keys
+		}
+		p.advance(1)
+	}
+}
+
+/*
+  isTOMLBare
+  Check if a character can be in a bare key
+*/
+func isTOMLBare(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-'
+}
+
+/*
+  parseKeyValue
+  A key = value pair, put in the table. A dotted key makes the tables
+  on the way.
+*/
+func (p *tomlParser_t) parseKeyValue(table map[string]interface{}, path string) {
+	keyPos := p.pos()
+	keys := p.parseKey()
+	for _, key := range keys[:len(keys)-1] {
+		path = joinConfigPath(path, key)
+		if table[key] == nil {
+			table[key] = make(map[string]interface{})
+			p.doc.index[path] = keyPos
+		}
+		next, isTable := table[key].(map[string]interface{})
+		if !isTable {
+			p.line, p.column = keyPos.line, keyPos.column
+			p.fail("'%s' already has a value", path)
+		}
+		table = next
+	}
+	key := keys[len(keys)-1]
+	member := joinConfigPath(path, key)
+	p.expect("=")
+	if _, found := table[key]; found {
+		p.doc.add(keyPos, member, CONFIG_WARNING, "given more than once, the last one is used")
+	}
+	p.doc.index[member] = keyPos
+	table[key] = p.parseValue(member)
+}
+
+/*
+  parseValue
+  A string, a number, a boolean, an array or an inline table
+*/
+func (p *tomlParser_t) parseValue(path string) interface{} {
+	p.skip(false)
+	if p.i >= len(p.text) || p.text[p.i] == '\n' {
+		p.fail("a value is missing")
+	}
+	if _, found := p.doc.index[path]; !found {
+		p.doc.index[path] = p.pos()
+	}
+	switch c := p.text[p.i]; {
+	case c == '"' || c == '\'':
+		return p.parseString()
+	case c == '[':
+		p.advance(1)
+		list := []interface{}{}
+		for {
+			p.skip(true)
+			if p.i < len(p.text) && p.text[p.i] == ']' {
+				p.advance(1)
+				return list
+			}
+			list = append(list, p.parseValue(fmt.Sprintf("%s[%d]", path, len(list))))
+			p.skip(true)
+			if p.i < len(p.text) && p.text[p.i] == ',' {
+				p.advance(1)
+			} else if p.i >= len(p.text) || p.text[p.i] != ']' {
+				p.fail("a ',' or ']' is missing")
+			}
+		}
+	case c == '{':
+		p.advance(1)
+		table := make(map[string]interface{})
+		p.skip(false)
+		if p.i < len(p.text) && p.text[p.i] == '}' {
+			p.advance(1)
+			return table
+		}
+		for {
+			p.parseKeyValue(table, path)
+			p.skip(false)
+			if p.i < len(p.text) && p.text[p.i] == '}' {
+				p.advance(1)
+				return table
+			}
+			if p.i >= len(p.text) || p.text[p.i] != ',' {
+				p.fail("a ',' or '}' is missing, an inline table is on one line")
+			}
+			p.advance(1)
+		}
+	}
+	start, startPos := p.i, p.pos()
+	for p.i < len(p.text) && strings.IndexByte(" \t\n,]}#", p.text[p.i]) < 0 {
+		p.advance(1)
+	}
+	text := p.text[start:p.i]
+	if len(text) == 0 {
+		p.fail("a value is missing")
+	}
+	switch text {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if number, isNumber := tomlNumber(text); isNumber {
+		return number
+	}
+	p.line, p.column = startPos.line, startPos.column
+	if len(text) >= 10 && text[4] == '-' && text[7] == '-' {
+		p.fail("dates are not used in a config, use a string")
+	}
+	p.fail("'%s' is not a value, a string needs quotes", text)
+	return nil
+}
+
+/*
+  tomlNumber
+  An integer, in decimal, hex, octal or binary, or a float. '_' can
+  be between digits.
+*/
+func tomlNumber(text string) (float64, bool) {
+	digits := strings.Replace(text, "_", "", -1)
+	if len(digits) == 0 || strings.Contains(text, "__") || strings.HasPrefix(text, "_") || strings.HasSuffix(text, "_") {
+		return 0, false
+	}
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(digits, prefix) {
+			number, err := strconv.ParseInt(digits[2:], base, 64)
+			return float64(number), err == nil
+		}
+	}
+	if number, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		unsigned := strings.TrimLeft(digits, "+-")
+		return float64(number), len(unsigned) == 1 || unsigned[0] != '0'
+	}
+	number, err := strconv.ParseFloat(digits, 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) || strings.ContainsAny(digits, "xXpP") {
+		return 0, false
+	}
+	return number, true
+}
+
+/*
+  parseString
+  A basic "string" with escapes, or a literal 'string' without
+*/
+func (p *tomlParser_t) parseString() string {
+	quote := p.text[p.i]
+	if strings.HasPrefix(p.text[p.i:], strings.Repeat(string(quote), 3)) {
+		p.fail("multi-line strings are not used in a config")
+	}
+	start := p.pos()
+	p.advance(1)
+	var out strings.Builder
+	for {
+		if p.i >= len(p.text) || p.text[p.i] == '\n' {
+			p.line, p.column = start.line, start.column
+			p.fail("the string is not closed")
+		}
+		c := p.text[p.i]
+		switch {
+		case c == quote:
+			p.advance(1)
+			return out.String()
+		case c == '\\' && quote == '"':
+			p.parseEscape(&out)
+		default:
+			out.WriteByte(c)
+			p.advance(1)
+		}
+	}
+}
+
+/*
+  parseEscape
+  The escape of a basic string, \uXXXX and \UXXXXXXXX are unicode
+*/
+func (p *tomlParser_t) parseEscape(out *strings.Builder) {
+	if p.i+1 >= len(p.text) {
+		p.fail("the escape is not finished")
+	}
+	simple := map[byte]string{'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", '"': "\"", '\\': "\\"}
+	c := p.text[p.i+1]
+	if s, found := simple[c]; found {
+		out.WriteString(s)
+		p.advance(2)
+		return
+	}
+	size := map[byte]int{'u': 4, 'U': 8}[c]
+	if size == 0 || p.i+2+size > len(p.text) {
+		p.fail("bad escape '\\%c'", c)
+	}
+	code, err := strconv.ParseUint(p.text[p.i+2:p.i+2+size], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		p.fail("bad escape '%s'", p.text[p.i:p.i+2+size])
+	}
+	out.WriteRune(rune(code))
+	p.advance(2 + size)
+}
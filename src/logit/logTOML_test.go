@@ -0,0 +1,105 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/*
+  TestTOMLValues
+  The toml a config is written in reads as the same json: tables,
+  dotted keys, arrays of tables, arrays, inline tables, quoting,
+  comments and numbers
+*/
+func TestTOMLValues(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want string
+	}{
+		{"", `{}`},
+		{"# only a comment\n\n", `{}`},
+		{"a = 1\nb = 'lit\\n'\nc = \"esc\\t\\u00e9\"\nd = true\ne = -1.5\nf = 0x1F\ng = 1_000\nh = 1e3\ni = 0", `{"a":1,"b":"lit\\n","c":"esc\té","d":true,"e":-1.5,"f":31,"g":1000,"h":1000,"i":0}`},
+		{"[a.b]\nc = 1\n[a]\nd = 2", `{"a":{"b":{"c":1},"d":2}}`},
+		{"a.b.c = 1\n\"x.y\" = 2\n'z' = 3", `{"a":{"b":{"c":1}},"x.y":2,"z":3}`},
+		{"a = [\n  1, # one\n  2,\n]\nb = []\nc = [[1], ['x']]", `{"a":[1,2],"b":[],"c":[[1],["x"]]}`},
+		{"[[sinks]]\ntype = \"file\"\n[sinks.options]\nid = 1\n[[sinks]]\ntype = \"stdout\"", `{"sinks":[{"options":{"id":1},"type":"file"},{"type":"stdout"}]}`},
+		{"s = { network = \"udp\", n.x = 1 }\ne = {}", `{"e":{},"s":{"n":{"x":1},"network":"udp"}}`},
+		{"a = 1 # one\n  b = \"x # y\"  # two\n[ t ] # table\nc = 2", `{"a":1,"b":"x # y","t":{"c":2}}`},
+		{"a = 1\r\n[b]\r\nc = 2\r\n", `{"a":1,"b":{"c":2}}`},
+	} {
+		doc := parseConfig(CONFIG_TOML, []byte(test.raw))
+		got, _ := json.Marshal(doc.value)
+		if len(doc.problems) > 0 || string(got) != test.want {
+			t.Errorf("Logit problem: %q is %s, %v, wanted %s", test.raw, got, doc.problems, test.want)
+		}
+	}
+}
+
+/*
+  TestTOMLPositions
+  Every entry has the line and column of its key, its header or its
+  value in an array
+*/
+func TestTOMLPositions(t *testing.T) {
+	doc := parseConfig(CONFIG_TOML, []byte("level = \"INFO\"\n\n[[sinks]]\n  type = \"file\"\n  pkgs = [\"a\",\n    \"b\"]\n"))
+	for path, want := range map[string]configPos_t{
+		"level":            {line: 1, column: 1},
+		"sinks":            {line: 3, column: 1},
+		"sinks[0]":         {line: 3, column: 1},
+		"sinks[0].type":    {line: 4, column: 3},
+		"sinks[0].pkgs":    {line: 5, column: 3},
+		"sinks[0].pkgs[0]": {line: 5, column: 11},
+		"sinks[0].pkgs[1]": {line: 6, column: 5},
+	} {
+		if got := doc.index[path]; got != want {
+			t.Errorf("Logit problem: %s is at %d:%d, wanted %d:%d", path, got.line, got.column, want.line, want.column)
+		}
+	}
+}
+
+/*
+  TestTOMLBad
+  Toml that is not good is one error at the line and column where it
+  went wrong, and no config
+*/
+func TestTOMLBad(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want string
+	}{
+		{"a = 1 = 2", "1:7: error: bad toml: the line goes on after its value"},
+		{"a = value = other", "1:5: error: bad toml: 'value' is not a value, a string needs quotes"},
+		{"a = [1,,2]", "1:8: error: bad toml: a value is missing"},
+		{"a =", "1:4: error: bad toml: a value is missing"},
+		{"= 1", "1:1: error: bad toml: a key is missing"},
+		{"a b = 1", "1:3: error: bad toml: '=' is missing"},
+		{"[a", "1:3: error: bad toml: ']' is missing"},
+		{"[[a]", "1:4: error: bad toml: ']]' is missing"},
+		{"[a] b = 1", "1:5: error: bad toml: the line goes on after its value"},
+		{"a = [1, 2", "1:10: error: bad toml: a ',' or ']' is missing"},
+		{"a = {b = 1", "1:11: error: bad toml: a ',' or '}' is missing, an inline table is on one line"},
+		{"a = {b = 1,\nc = 2}", "1:12: error: bad toml: a key is missing"},
+		{"a = 'x\n'", "1:5: error: bad toml: the string is not closed"},
+		{`a = "\x"`, `1:6: error: bad toml: bad escape '\x'`},
+		{`a = "\uD800"`, `1:6: error: bad toml: bad escape '\uD800'`},
+		{"a = '''x'''", "1:5: error: bad toml: multi-line strings are not used in a config"},
+		{"a = 1979-05-27", "1:5: error: bad toml: dates are not used in a config, use a string"},
+		{"a = 01", "1:5: error: bad toml: '01' is not a value, a string needs quotes"},
+		{"a = 1__0", "1:5: error: bad toml: '1__0' is not a value, a string needs quotes"},
+		{"a = 1\na.b = 2", "2:1: error: bad toml: 'a' already has a value"},
+		{"[a]\nb = 1\n[a.b]", "3:1: error: bad toml: 'a.b' already has a value"},
+		{"[[a]]\n[a]", "2:1: error: bad toml: 'a' is an array of tables, use [[a]]"},
+		{"é = 1", "1:1: error: bad toml: a key is missing"},
+	} {
+		doc := parseConfig(CONFIG_TOML, []byte(test.raw))
+		if doc.value != nil || len(doc.problems) != 1 || doc.problems[0].String() != test.want {
+			t.Errorf("Logit problem: %q has %v, wanted '%s'", test.raw, doc.problems, test.want)
+		}
+	}
+	// a key given twice is a warning, the config is still read
+	doc := parseConfig(CONFIG_TOML, []byte("a = 1\na = 2"))
+	if doc.value == nil || len(doc.problems) != 1 || doc.problems[0].String() != "2:1: warning: a: given more than once, the last one is used" {
+		t.Errorf("Logit problem: %v", doc.problems)
+	}
+}
@@ -0,0 +1,356 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaults for shipping logs to the log server
+const (
+	defaultUrlBatchSize  = 100              // max lines in one post
+	defaultUrlBufferSize = 10000            // max lines held while the server is down
+	defaultUrlInterval   = time.Second * 2  // how often a partial batch is sent
+	defaultUrlMinBackoff = time.Second * 1  // first retry delay after a failed post
+	defaultUrlMaxBackoff = time.Second * 60 // retry delay never grows past this
+	defaultUrlCloseWait  = time.Second * 5  // how long CloseLog waits for the last posts
+)
+
+/*
+  urlBatch_t
+  The json body posted to the log server. The lines of a sender are
+  numbered from FirstSeq, a batch that is posted again after a failure
+  has the same numbers, so the server can skip what it already has.
+*/
+type urlBatch_t struct {
+	SiteID   string   `json:"SiteID"`             // customer site name
+	SystemID string   `json:"SystemID"`           // system that produced the lines
+	Sender   string   `json:"sender,omitempty"`   // the id of the sender, the same after a restart with a spool
+	FirstSeq int64    `json:"firstSeq,omitempty"` // the seq of the first line
+	Lines    []string `json:"lines"`              // the formatted log lines, oldest first
+}
+
+// urlShipper_t batches log lines and posts them to the log server
+type urlShipper_t struct {
+	url        string        // url of log server
+	siteID     string        // customer site name added to every batch
+	sysID      string        // system id added to every batch
+	client     *http.Client  // client used for the posts
+	batchSize  int           // max lines in one post
+	bufferSize int           // max lines held in memory, oldest dropped first
+	interval   time.Duration // how often a partial batch is sent
+	minBackoff time.Duration // first retry delay after a failed post
+	maxBackoff time.Duration // retry delay never grows past this
+
+	mutex    sync.Mutex // protects lines and firstSeq
+	lines    []string   // lines waiting to be posted, when there is no spool
+	firstSeq int64      // sequence number of lines[0]
+	sender   string     // the sender id of the batches
+	spool    *spool_t   // the lines wait on disk instead, nil for none, it has its own mutex
+
+	sentCount    int64 // lines accepted by the log server
+	droppedCount int64 // lines thrown away because the buffer was full or rejected
+
+	kickChan chan bool     // a full batch is waiting
+	stopChan chan bool     // tell the shipper to finish up
+	doneChan chan struct{} // closed when the shipper has finished
+}
+
+/*
+  newUrlShipper
+  Create a shipper for the log server with the default tuning.
+  The shipper does nothing until 'start' is called.
+*/
+func newUrlShipper(url string, siteID string, sysID string) *urlShipper_t {
+	return &urlShipper_t{
+		url:        url,
+		siteID:     siteID,
+		sysID:      sysID,
+		firstSeq:   1,
+		sender:     NewRequestID(),
+		client:     &http.Client{Timeout: time.Second * 10},
+		batchSize:  defaultUrlBatchSize,
+		bufferSize: defaultUrlBufferSize,
+		interval:   defaultUrlInterval,
+		minBackoff: defaultUrlMinBackoff,
+		maxBackoff: defaultUrlMaxBackoff,
+		kickChan:   make(chan bool, 1),
+		stopChan:   make(chan bool),
+		doneChan:   make(chan struct{}),
+	}
+}
+
+/*
+  useSpool
+  Keep the lines in a spool on disk, until the log server has them.
+  The lines an earlier run left in the spool go first.
+*/
+func (s *urlShipper_t) useSpool(spool *spool_t) {
+	s.spool = spool
+	s.sender = spool.sender
+}
+
+/*
+  start
+  Start the background loop that posts the batches
+*/
+func (s *urlShipper_t) start() {
+	go s.run()
+}
+
+/*
+  post
+  Queue one formatted log line for the log server.
+  This never blocks on the network, if the buffer is full
+  the oldest line is thrown away. With a spool the line goes to disk,
+  a line the spool cannot take is dropped.
+*/
+func (s *urlShipper_t) post(line string) {
+	if s.spool != nil {
+		capped := s.spool.droppedLines()
+		err := s.spool.write(line)
+		dropped := s.spool.droppedLines() - capped // the cap took the oldest segment
+		full := s.spool.depth() >= int64(s.batchSize)
+		if err != nil {
+			dropped++
+		}
+		if dropped > 0 {
+			atomic.AddInt64(&s.droppedCount, dropped)
+		}
+		if full {
+			s.kick()
+		}
+		return
+	}
+	s.mutex.Lock()
+	if len(s.lines) >= s.bufferSize {
+		s.lines = s.lines[1:]
+		s.firstSeq += 1
+		atomic.AddInt64(&s.droppedCount, 1)
+	}
+	s.lines = append(s.lines, line)
+	full := len(s.lines) >= s.batchSize
+	s.mutex.Unlock()
+	if full {
+		s.kick()
+	}
+}
+
+/*
+  kick
+  Wake up the shipper, but never wait on it
+*/
+func (s *urlShipper_t) kick() {
+	select {
+	case s.kickChan <- true:
+	default:
+	}
+}
+
+/*
+  stop
+  Tell the shipper to post what is left and wait for it,
+  but no longer than 'wait'.
+*/
+func (s *urlShipper_t) stop(wait time.Duration) {
+	close(s.stopChan)
+	select {
+	case <-s.doneChan:
+	case <-time.After(wait):
+	}
+}
+
+/*
+  Write, Flush and Close
+  The shipper is the sink of the log server, the SiteID and SystemID
+  go with each batch. Close waits for the last batches, what is left
+  in the spool stays there for the next run.
+*/
+func (s *urlShipper_t) Write(rec *Record_t, line string) error {
+	s.post(line)
+	return nil
+}
+
+func (s *urlShipper_t) Flush() error { return nil }
+
+func (s *urlShipper_t) Close() error {
+	s.stop(defaultUrlCloseWait)
+	if s.spool != nil {
+		return s.spool.close()
+	}
+	return nil
+}
+
+/*
+  run
+  The background loop. Posts a batch when one is full or the interval
+  has passed. When a post fails, the lines are kept and the post is
+  retried with a doubling backoff.
+*/
+func (s *urlShipper_t) run() {
+	defer close(s.doneChan)
+	var backoff time.Duration
+	for {
+		wait := s.interval
+		kickChan := s.kickChan
+		if backoff > 0 { // only the timer can end a backoff
+			wait = backoff
+			kickChan = nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-kickChan:
+			timer.Stop()
+		case <-s.stopChan:
+			timer.Stop()
+			s.sendAll() // one last try, give up on the first failure
+			return
+		}
+		if err := s.sendAll(); err != nil {
+			if backoff == 0 {
+				backoff = s.minBackoff
+			} else {
+				backoff *= 2
+			}
+			if backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+		} else {
+			backoff = 0
+		}
+	}
+}
+
+/*
+  sendAll
+  Post batches until the buffer is empty or a post fails
+*/
+func (s *urlShipper_t) sendAll() error {
+	if s.spool != nil {
+		return s.sendSpool()
+	}
+	for {
+		s.mutex.Lock()
+		count := len(s.lines)
+		if count > s.batchSize {
+			count = s.batchSize
+		}
+		batch := make([]string, count)
+		copy(batch, s.lines[:count])
+		firstSeq := s.firstSeq
+		endSeq := s.firstSeq + int64(count)
+		s.mutex.Unlock()
+		if count == 0 {
+			return nil
+		}
+		retry, err := s.send(firstSeq, batch)
+		if err != nil && retry {
+			return err
+		}
+		// either sent, or rejected for good, so take it out of the buffer
+		s.mutex.Lock()
+		remove := endSeq - s.firstSeq // old lines might have been dropped meanwhile
+		if remove > 0 {
+			s.lines = s.lines[remove:]
+			s.firstSeq = endSeq
+		}
+		s.mutex.Unlock()
+		if err != nil {
+			atomic.AddInt64(&s.droppedCount, int64(count))
+		} else {
+			atomic.AddInt64(&s.sentCount, int64(count))
+		}
+	}
+}
+
+/*
+  sendSpool
+  Post batches out of the spool, in order, until it is empty or a post
+  fails. A batch leaves the spool once the server has it, so a crash
+  in between posts it again, with the same seqs.
+*/
+func (s *urlShipper_t) sendSpool() error {
+	for {
+		firstSeq, batch, pos := s.spool.peek(s.batchSize)
+		if len(batch) == 0 {
+			return nil
+		}
+		retry, err := s.send(firstSeq, batch)
+		if err != nil && retry {
+			s.spool.failed()
+			return err
+		}
+		commitErr := s.spool.commit(pos) // either sent, or rejected for good
+		if err != nil {
+			atomic.AddInt64(&s.droppedCount, int64(len(batch)))
+		} else {
+			atomic.AddInt64(&s.sentCount, int64(len(batch)))
+		}
+		if commitErr != nil {
+			return commitErr // the cursor could not be saved, try again later
+		}
+	}
+}
+
+/*
+  send
+  Post a single batch to the log server.
+  The retry flag tells the caller if it is worth trying again later.
+*/
+func (s *urlShipper_t) send(firstSeq int64, lines []string) (bool, error) {
+	body, err := json.Marshal(urlBatch_t{SiteID: s.siteID, SystemID: s.sysID, Sender: s.sender,
+		FirstSeq: firstSeq, Lines: lines})
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body) // so the connection can be reused
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("log server '%s' returned status %d", s.url, resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return true, err
+	case resp.StatusCode >= 500:
+		return true, err
+	}
+	return false, err // the server will never take this batch
+}
+
+/*
+  pending
+  How many lines are waiting to be posted
+*/
+func (s *urlShipper_t) pending() int {
+	if s.spool != nil {
+		return int(s.spool.depth())
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.lines)
+}
+
+/*
+  spoolStats
+  The depth and bytes of the spool, the lines replayed out of it after
+  the log server was down, and how many are left to replay
+*/
+func (s *urlShipper_t) spoolStats() (depth int64, size int64, replayed int64, replayLeft int64) {
+	if s.spool == nil {
+		return 0, 0, 0, 0
+	}
+	return s.spool.stats()
+}
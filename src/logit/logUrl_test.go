@@ -0,0 +1,200 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// urlReceiver_t collects the batches posted to a test log server
+type urlReceiver_t struct {
+	mutex    sync.Mutex
+	batches  []urlBatch_t
+	failures int // how many posts to fail before accepting
+	status   int // the status used for the failures
+}
+
+func (r *urlReceiver_t) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.failures > 0 {
+		r.failures -= 1
+		w.WriteHeader(r.status)
+		return
+	}
+	var batch urlBatch_t
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.batches = append(r.batches, batch)
+}
+
+func (r *urlReceiver_t) lines() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var lines []string
+	for _, batch := range r.batches {
+		lines = append(lines, batch.Lines...)
+	}
+	return lines
+}
+
+/*
+  TestUrlShipperBatches
+  Lines are posted in batches tagged with the SiteID and SystemID
+*/
+func TestUrlShipperBatches(t *testing.T) {
+	receiver := &urlReceiver_t{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	shipper := newUrlShipper(server.URL, "BeyondAI", "local")
+	shipper.batchSize = 4
+	shipper.interval = time.Millisecond * 20
+	shipper.start()
+	for i := 0; i < 10; i++ {
+		shipper.post("line " + string(rune('a'+i)))
+	}
+	shipper.stop(time.Second * 5)
+
+	lines := receiver.lines()
+	if len(lines) != 10 {
+		t.Fatalf("Logit problem: log server got %d lines", len(lines))
+	}
+	for i, line := range lines {
+		if line != "line "+string(rune('a'+i)) {
+			t.Errorf("Logit problem: line %d is '%s', out of order", i, line)
+		}
+	}
+	for _, batch := range receiver.batches {
+		if batch.SiteID != "BeyondAI" || batch.SystemID != "local" {
+			t.Errorf("Logit problem: batch tagged with '%s'/'%s'", batch.SiteID, batch.SystemID)
+		}
+		if len(batch.Lines) > 4 {
+			t.Errorf("Logit problem: batch of %d lines is too big", len(batch.Lines))
+		}
+	}
+	if shipper.sentCount != 10 || shipper.droppedCount != 0 {
+		t.Errorf("Logit problem: sent %d, dropped %d", shipper.sentCount, shipper.droppedCount)
+	}
+}
+
+/*
+  TestUrlShipperRetry
+  A log server that is failing gets the lines once it recovers
+*/
+func TestUrlShipperRetry(t *testing.T) {
+	receiver := &urlReceiver_t{failures: 3, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	shipper := newUrlShipper(server.URL, "BeyondAI", "local")
+	shipper.interval = time.Millisecond * 10
+	shipper.minBackoff = time.Millisecond * 10
+	shipper.maxBackoff = time.Millisecond * 40
+	shipper.start()
+	shipper.post("first")
+	shipper.post("second")
+
+	deadline := time.Now().Add(time.Second * 5)
+	for len(receiver.lines()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	shipper.stop(time.Second)
+	lines := receiver.lines()
+	if len(lines) != 2 || lines[0] != "first" || lines[1] != "second" {
+		t.Errorf("Logit problem: log server got %q after retries", lines)
+	}
+}
+
+/*
+  TestUrlShipperBuffer
+  The buffer is bounded, the oldest lines go first
+*/
+func TestUrlShipperBuffer(t *testing.T) {
+	shipper := newUrlShipper("http://127.0.0.1:0/never", "BeyondAI", "local")
+	shipper.bufferSize = 5
+	for i := 0; i < 8; i++ {
+		shipper.post("line " + string(rune('a'+i)))
+	}
+	if shipper.pending() != 5 || shipper.droppedCount != 3 {
+		t.Errorf("Logit problem: pending %d, dropped %d", shipper.pending(), shipper.droppedCount)
+	}
+	if shipper.lines[0] != "line d" {
+		t.Errorf("Logit problem: oldest kept line is '%s'", shipper.lines[0])
+	}
+}
+
+/*
+  TestUrlShipperRejected
+  A batch the log server will never take is dropped, not retried forever
+*/
+func TestUrlShipperRejected(t *testing.T) {
+	receiver := &urlReceiver_t{failures: 1, status: http.StatusBadRequest}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	shipper := newUrlShipper(server.URL, "BeyondAI", "local")
+	shipper.post("rejected")
+	if err := shipper.sendAll(); err != nil {
+		t.Errorf("Logit problem: rejected batch returned '%s'", err)
+	}
+	if shipper.pending() != 0 || shipper.droppedCount != 1 {
+		t.Errorf("Logit problem: pending %d, dropped %d", shipper.pending(), shipper.droppedCount)
+	}
+}
+
+/*
+  TestLogConfigUrl
+  The configured url gets the log lines written through the logger
+*/
+func TestLogConfigUrl(t *testing.T) {
+	receiver := &urlReceiver_t{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	jsonTest := `
+	{
+		"SiteID": "BeyondAI",
+		"SystemID": "local",
+		"filename": "",
+		"url": "` + server.URL + `",
+		"stdout": false,
+		"level": "INFO"
+	}`
+	err := writeConfigFile(configFileName, jsonTest)
+	if err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	err = OpenLog(configFileName)
+	if err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	Info(&myFlags, "Shipped to the log server")
+	CloseLog()
+
+	found := false
+	for _, line := range receiver.lines() {
+		if strings.HasSuffix(line, "INFO[logit:logUrl_test] Shipped to the log server") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Logit problem: log server did not get the message, got %q", receiver.lines())
+	}
+	for _, batch := range receiver.batches {
+		if batch.SiteID != "BeyondAI" || batch.SystemID != "local" {
+			t.Errorf("Logit problem: batch tagged with '%s'/'%s'", batch.SiteID, batch.SystemID)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second        // how often the config is checked without file events
+	reloadSettle        = 100 * time.Millisecond // time for an editor to finish saving
+)
+
+// how often the config is checked next to the file events, they miss a
+// file changed behind a symlink or on NFS
+var slowPollInterval = 30 * time.Second
+
+/*
+  configWatcher_t
+  The goroutine that reloads the config when the file changes, and
+  reopens the log file and reloads the config on a SIGHUP.
+  File events come from the notifier where there is one, the file is
+  polled too, slowly next to the events.
+*/
+type configWatcher_t struct {
+	stop chan struct{} // closed by Close
+	done chan struct{} // closed when the goroutine is gone
+}
+
+// configStat_t is what is compared to see if the config file changed
+type configStat_t struct {
+	info os.FileInfo // nil if the file is not there
+}
+
+/*
+  statConfig
+  The state of the config file right now
+*/
+func statConfig(name string) configStat_t {
+	info, err := os.Stat(name)
+	if err != nil {
+		return configStat_t{}
+	}
+	return configStat_t{info: info}
+}
+
+/*
+  changedFrom
+  A file that was replaced by a rename is another file, even with
+  the same time and size. A missing file is not a change, the editor
+  may still be saving it.
+*/
+func (s configStat_t) changedFrom(base configStat_t) bool {
+	if s.info == nil {
+		return false
+	}
+	if base.info == nil {
+		return true
+	}
+	return !os.SameFile(s.info, base.info) || s.info.ModTime() != base.info.ModTime() ||
+		s.info.Size() != base.info.Size()
+}
+
+/*
+  watchLogConfig
+  Start watching the log configuration file for changes and for SIGHUP
+*/
+func (l *Logger) watchLogConfig(logConfigFileName string) *configWatcher_t {
+	w := &configWatcher_t{stop: make(chan struct{}), done: make(chan struct{})}
+	base := statConfig(logConfigFileName)
+	//
+	// file events if the system has them, with a slow poll for what they
+	// miss, polling only if not
+	//
+	pollInterval := slowPollInterval
+	events, closeNotifier, err := newNotifier(logConfigFileName)
+	if err != nil {
+		l.Debugfx(SHOWMONITOR, &l.myFlags, "log config file events are not available (%s), polling.", err)
+		pollInterval = defaultPollInterval
+		closeNotifier = func() {}
+	}
+	ticker := time.NewTicker(pollInterval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	l.Infof(&l.myFlags, "log config file '%s' is monitored", logConfigFileName)
+
+	go func() {
+		defer close(w.done)
+		defer closeNotifier()
+		defer ticker.Stop()
+		defer signal.Stop(hup)
+		settle := time.NewTimer(reloadSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-events: // wait until the events stop before looking
+				settle.Reset(reloadSettle)
+			case <-ticker.C:
+				l.Debugx(SHOWMONITOR, &l.myFlags, "log reconfiguration check.")
+				settle.Reset(0)
+			case <-settle.C:
+				current := statConfig(logConfigFileName)
+				if current.changedFrom(base) {
+					base = current
+					l.Infof(&l.myFlags, "log config file '%s' was modified.", logConfigFileName)
+					l.reloadConfig()
+				}
+			case <-hup:
+				l.Info(&l.myFlags, "SIGHUP received, reopen the log file and reload the config.")
+				if err := l.Reopen(); err != nil {
+					l.Errorf(&l.myFlags, "Log file could not be reopened: %s", err)
+				}
+				base = statConfig(logConfigFileName)
+				l.reloadConfig()
+			case <-w.stop:
+				settle.Stop()
+				l.Debugx(SHOWMONITOR, &l.myFlags, "Exiting log reconfiguration monitor.")
+				return
+			}
+		}
+	}()
+	return w
+}
+
+/*
+  stopWatch
+  Stop the watcher and wait for it, a reload in progress finishes first
+*/
+func (l *Logger) stopWatch(w *configWatcher_t) {
+	close(w.stop)
+	<-w.done
+	l.Infof(&l.myFlags, "log config file '%s' is no longer monitored.", l.configFileName)
+}
+
+/*
+  OnReload
+  Call 'fn' with the new generation after every successful reload
+  of the config. It is called from the goroutine that did the reload,
+  after the new config is in use.
+*/
+func (l *Logger) OnReload(fn func(generation int32)) {
+	l.callbackMutex.Lock()
+	defer l.callbackMutex.Unlock()
+	l.callbacks = append(l.callbacks, fn)
+}
+
+/*
+  callReloadCallbacks
+  Tell the OnReload functions about the new generation
+*/
+func (l *Logger) callReloadCallbacks(generation int32) {
+	l.callbackMutex.Lock()
+	callbacks := make([]func(int32), len(l.callbacks))
+	copy(callbacks, l.callbacks)
+	l.callbackMutex.Unlock()
+	for _, fn := range callbacks {
+		fn(generation)
+	}
+}
+
+/*
+  Generation
+  The generation of the config in use, 0 until the first reload
+*/
+func (l *Logger) Generation() int32 {
+	return l.getLogFlags().generation
+}
+
+/*
+  Reload
+  Load the config file again now, the same as a change of the file
+  or a SIGHUP does
+*/
+func (l *Logger) Reload() error {
+	return l.reloadConfig()
+}
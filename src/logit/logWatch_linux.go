@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// the events that can mean the config file has new contents
+const notifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY
+
+/*
+  newNotifier
+  inotify on the directory of the config file, so a file that an editor
+  saves by writing a new file and renaming it over the old one is seen.
+  Every event of the config file is sent on the channel, the returned
+  func stops it.
+*/
+func newNotifier(configFileName string) (<-chan struct{}, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, name := filepath.Split(configFileName)
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, notifyMask); err != nil {
+		syscall.Close(fd)
+		return nil, nil, err
+	}
+	file := os.NewFile(uintptr(fd), "inotify") // non blocking, so Close ends the Read
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				if nameEnd > n {
+					break
+				}
+				eventName := string(buf[nameStart:nameEnd])
+				for len(eventName) > 0 && eventName[len(eventName)-1] == 0 {
+					eventName = eventName[:len(eventName)-1] // the name is padded with NULs
+				}
+				if eventName == name {
+					select {
+					case events <- struct{}{}:
+					default: // one pending event is enough
+					}
+				}
+				offset = nameEnd
+			}
+		}
+	}()
+	return events, func() { file.Close() }, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"errors"
+)
+
+/*
+  newNotifier
+  There are no file events here, the config file is polled
+*/
+func newNotifier(configFileName string) (<-chan struct{}, func(), error) {
+	return nil, nil, errors.New("no file events on this system")
+}
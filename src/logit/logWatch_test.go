@@ -0,0 +1,135 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+  watchReloads
+  The generations of the reloads of the logger, as they happen
+*/
+func watchReloads(l *Logger) chan int32 {
+	reloaded := make(chan int32, 10)
+	l.OnReload(func(generation int32) { reloaded <- generation })
+	return reloaded
+}
+
+/*
+  waitReload
+  The generation of the next reload, 0 if there was none in time
+*/
+func waitReload(reloaded chan int32, wait time.Duration) int32 {
+	select {
+	case generation := <-reloaded:
+		return generation
+	case <-time.After(wait):
+		return 0
+	}
+}
+
+/*
+  TestWatchAtomicRename
+  An editor that saves by renaming a new file over the config
+  is seen, without anything being logged
+*/
+func TestWatchAtomicRename(t *testing.T) {
+	l := newTestLogger(t, ` "filename": "", "level": "WARN" `)
+	configFileName, reloaded := l.configFileName, watchReloads(l)
+	defer l.Close()
+	tmpName := configFileName + ".swp"
+	if err := ioutil.WriteFile(tmpName, []byte(`{ "filename": "", "level": "INFO" }`), 0644); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if err := os.Rename(tmpName, configFileName); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if generation := waitReload(reloaded, 2*defaultPollInterval); generation != 1 {
+		t.Fatalf("Logit problem: reload generation %d", generation)
+	}
+	if l.Generation() != 1 || l.getLogFlags().logLevel != INFO {
+		t.Errorf("Logit problem: generation %d, level %s", l.Generation(), l.getLogFlags().logLevel)
+	}
+}
+
+/*
+  TestWatchSymlink
+  The file behind a symlink is written in place, no event comes for
+  the directory of the config, the slow poll sees it
+*/
+func TestWatchSymlink(t *testing.T) {
+	defer func(interval time.Duration) { slowPollInterval = interval }(slowPollInterval)
+	slowPollInterval = 200 * time.Millisecond
+	target := filepath.Join(t.TempDir(), "real.json")
+	if err := writeConfigFile(target, `{ "filename": "", "level": "WARN" }`); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	if err := os.Symlink(target, configFileName); err != nil {
+		t.Skipf("no symlinks here: %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer l.Close()
+	reloaded := watchReloads(l)
+	if err := ioutil.WriteFile(target, []byte(`{ "filename": "", "level": "ERROR" }`), 0644); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if generation := waitReload(reloaded, 10*slowPollInterval); generation != 1 || l.getLogFlags().logLevel != ERROR {
+		t.Errorf("Logit problem: reload generation %d, level %s", generation, l.getLogFlags().logLevel)
+	}
+}
+
+/*
+  TestWatchUnchanged
+  Touching the directory, or rewriting another file, is no reload
+*/
+func TestWatchUnchanged(t *testing.T) {
+	l := newTestLogger(t, ` "filename": "", "level": "WARN" `)
+	configFileName, reloaded := l.configFileName, watchReloads(l)
+	defer l.Close()
+	other := filepath.Join(filepath.Dir(configFileName), "other.json")
+	if err := ioutil.WriteFile(other, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if generation := waitReload(reloaded, 3*reloadSettle); generation != 0 {
+		t.Errorf("Logit problem: reload generation %d without a change", generation)
+	}
+}
+
+/*
+  TestReloadCallbacks
+  Reload calls every OnReload function with the new generation,
+  a config that cannot be loaded keeps the old generation
+*/
+func TestReloadCallbacks(t *testing.T) {
+	l := newTestLogger(t, ` "filename": "", "level": "WARN" `)
+	configFileName, reloaded := l.configFileName, watchReloads(l)
+	defer l.Close()
+	var second int32
+	l.OnReload(func(generation int32) { second = generation })
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if generation := waitReload(reloaded, time.Second); generation != 1 || second != 1 {
+		t.Errorf("Logit problem: callbacks got %d and %d", generation, second)
+	}
+	// a broken config is not used, and not reported as a generation
+	l.stopWatch(l.watcher) // the broken file would be reloaded by the watcher as well
+	l.watcher = nil
+	if err := ioutil.WriteFile(configFileName, []byte(`{ "level": `), 0644); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	if err := l.Reload(); err == nil {
+		t.Errorf("Logit problem: a broken config was reloaded")
+	}
+	if generation := waitReload(reloaded, 3*reloadSettle); generation != 0 || l.Generation() != 1 {
+		t.Errorf("Logit problem: generation %d, %d after a broken config", generation, l.Generation())
+	}
+}
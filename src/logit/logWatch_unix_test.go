@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*
+  TestWatchHUP
+  A SIGHUP reloads the config even if it did not change
+*/
+func TestWatchHUP(t *testing.T) {
+	l := newTestLogger(t, ` "filename": "", "level": "WARN" `)
+	reloaded := watchReloads(l)
+	defer l.Close()
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if generation := waitReload(reloaded, 2*time.Second); generation != 1 {
+		t.Errorf("Logit problem: reload generation %d after SIGHUP", generation)
+	}
+}
@@ -0,0 +1,243 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type overflow_t int
+
+// what logMsg does when the writer queue is full
+const (
+	BLOCK       overflow_t = iota // wait for room in the queue
+	DROP_NEWEST                   // throw away the message being logged
+	DROP_OLDEST                   // throw away the oldest message in the queue
+)
+
+const defaultQueueSize = 1024    // messages waiting for the writer
+const syncWait = time.Second * 2 // how long Sync waits for the writer to catch up
+
+var overflowNames = map[string]overflow_t{
+	"block":      BLOCK,
+	"dropnewest": DROP_NEWEST,
+	"dropoldest": DROP_OLDEST,
+}
+
+/*
+  parseOverflow
+  Turn the "overflow" config string into the policy
+*/
+func parseOverflow(name string) (overflow_t, error) {
+	if len(name) == 0 {
+		return BLOCK, nil
+	}
+	policy, found := overflowNames[strings.ToLower(name)]
+	if !found {
+		return BLOCK, fmt.Errorf("unknown overflow policy '%s', use block, dropNewest or dropOldest", name)
+	}
+	return policy, nil
+}
+
+/*
+  String
+  The name of the policy
+*/
+func (policy overflow_t) String() string {
+	switch policy {
+	case DROP_NEWEST:
+		return "dropNewest"
+	case DROP_OLDEST:
+		return "dropOldest"
+	}
+	return "block"
+}
+
+/*
+  startWriter
+  Start the single goroutine that writes to the sinks.
+  Everything logged goes through its queue.
+*/
+func (l *Logger) startWriter(queueSize int, policy overflow_t) {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	l.queueMutex.Lock()
+	defer l.queueMutex.Unlock()
+	l.queue = make(chan *Record_t, queueSize)
+	l.queueDone = make(chan struct{})
+	l.overflow = policy
+	go l.runWriter(l.queue, l.queueDone)
+}
+
+/*
+  stopWriter
+  Close the queue and wait for the writer to drain it
+*/
+func (l *Logger) stopWriter() {
+	l.queueMutex.Lock()
+	queue, done := l.queue, l.queueDone
+	l.queue = nil // from now on messages are written directly
+	l.queueMutex.Unlock()
+	if queue == nil {
+		return
+	}
+	close(queue)
+	<-done
+}
+
+/*
+  runWriter
+  The writer loop. The file is flushed every time the queue runs empty
+*/
+func (l *Logger) runWriter(queue chan *Record_t, done chan struct{}) {
+	for msg := range queue {
+		if msg.synced != nil { // everything before it is written
+			l.flushMsgs()
+			close(msg.synced)
+			continue
+		}
+		l.writeMsg(msg)
+		if len(queue) == 0 {
+			l.flushMsgs()
+		}
+	}
+	l.flushMsgs()
+	close(done)
+}
+
+/*
+  queueMsg
+  Hand a message to the writer, following the overflow policy.
+  Returns false if the message was dropped.
+*/
+func (l *Logger) queueMsg(msg *Record_t) bool {
+	l.queueMutex.RLock()
+	defer l.queueMutex.RUnlock()
+	if l.queue == nil { // no writer, so write it here
+		l.writeMsg(msg)
+		return true
+	}
+	switch l.overflow {
+	case DROP_NEWEST:
+		select {
+		case l.queue <- msg:
+		default:
+			atomic.AddInt64(&l.stats.queueDropped, 1)
+			return false
+		}
+	case DROP_OLDEST:
+		for {
+			select {
+			case l.queue <- msg:
+				return true
+			default:
+			}
+			select { // make room, unless the writer just did
+			case old := <-l.queue: // it was counted as a line, and its level, when it was queued
+				if old.synced != nil { // not a message, the sync gives up
+					close(old.synced)
+					continue
+				}
+				atomic.AddInt64(&l.stats.queueDropped, 1)
+				atomic.AddInt32(&l.stats.lineCount, -1)
+				if old.counted {
+					l.countLevel(old.Level, -1)
+				}
+			default:
+			}
+		}
+	default:
+		l.queue <- msg
+	}
+	return true
+}
+
+/*
+  syncWriter
+  Wait a while for the writer to write what is queued so far and
+  flush the sinks, for a support bundle or a level policy
+*/
+func (l *Logger) syncWriter(wait time.Duration) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	synced := make(chan struct{})
+	l.queueMutex.RLock()
+	if l.queue == nil { // no writer, everything is written already
+		l.queueMutex.RUnlock()
+		l.flushMsgs()
+		return
+	}
+	select {
+	case l.queue <- &Record_t{synced: synced}:
+	case <-timeout.C:
+		l.queueMutex.RUnlock()
+		return
+	}
+	l.queueMutex.RUnlock()
+	select {
+	case <-synced:
+	case <-timeout.C:
+	}
+}
+
+/*
+  Sync
+  Wait, for up to two seconds, until what was logged so far is written
+  and the sinks are flushed, e.g. before a test looks at what was logged
+*/
+func (l *Logger) Sync() {
+	l.syncWriter(syncWait)
+}
+
+/*
+  writeMsg
+  Write one message to the sinks that accept it, each in its own
+  format, and to the slog output
+*/
+func (l *Logger) writeMsg(msg *Record_t) {
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	flags := msg.flags // the sinks of the config it was logged with
+	if flags == nil {
+		flags = l.getLogFlags()
+	}
+	l.writeSinks(flags, msg)
+	// hand it to the slog output
+	if h := l.getSlogOutput(); h != nil {
+		l.writeSlog(h, msg)
+	}
+}
+
+/*
+  flushMsgs
+  Push what the sinks buffer out
+*/
+func (l *Logger) flushMsgs() {
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	l.flushSinks()
+}
+
+/*
+  Reopen
+  Close the log file and open it by its name again, for rotators that
+  moved it from outside. A SIGHUP does the same, and reloads the config.
+  The audit chain goes on in the new file, after the head it had.
+*/
+func (l *Logger) Reopen() error {
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.reopen(); err != nil {
+		return err
+	}
+	if l.file.audit != nil {
+		l.writeAuditHead(l.getLogFlags(), " before the reopen", l.file.audit.head())
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+  TestParseOverflow
+  The overflow names from the config file
+*/
+func TestParseOverflow(t *testing.T) {
+	tests := map[string]overflow_t{
+		"":           BLOCK,
+		"block":      BLOCK,
+		"dropNewest": DROP_NEWEST,
+		"DROPOLDEST": DROP_OLDEST,
+	}
+	for name, want := range tests {
+		policy, err := parseOverflow(name)
+		if err != nil || policy != want {
+			t.Errorf("Logit problem: overflow '%s' is %d, %v", name, policy, err)
+		}
+	}
+	if _, err := parseOverflow("drop"); err == nil {
+		t.Errorf("Logit problem: unknown overflow policy was accepted")
+	}
+}
+
+/*
+  TestOverflowCounts
+  The levels are counted once the queue takes a message, the one that
+  dropoldest throws away is taken off its level again
+*/
+func TestOverflowCounts(t *testing.T) {
+	for policy, wantWarns := range map[overflow_t]int32{DROP_NEWEST: 1, DROP_OLDEST: 0} {
+		l := &Logger{queue: make(chan *Record_t, 1), overflow: policy} // no writer, the queue stays full
+		l.sendRecord(&Record_t{Level: WARN, counted: true})
+		l.sendRecord(&Record_t{Level: ERROR, counted: true})
+		l.sendRecord(&Record_t{Level: ERROR, Msg: "message repeated 2 times: disk full"}) // a summary
+		warns, errs := atomic.LoadInt32(&l.stats.warnCount), atomic.LoadInt32(&l.stats.errorCount)
+		lines, dropped := atomic.LoadInt32(&l.stats.lineCount), atomic.LoadInt64(&l.stats.queueDropped)
+		if warns != wantWarns || errs != 0 || lines != 1 || dropped != 2 {
+			t.Errorf("Logit problem: %s counted %d warnings, %d errors, %d lines, %d dropped",
+				policy, warns, errs, lines, dropped)
+		}
+	}
+}
+
+/*
+  TestLogConcurrent
+  Many goroutines log while the config is reloaded, for every overflow
+  policy. Run with -race. Every line counted must be in the file once
+  CloseLog returns.
+*/
+func TestLogConcurrent(t *testing.T) {
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags) // not inside the closure, that would change the package name
+	for _, overflow := range []string{"block", "dropNewest", "dropOldest"} {
+		t.Run(overflow, func(t *testing.T) {
+			dir := t.TempDir()
+			configFileName := filepath.Join(dir, "logtestcfg.json")
+			logFileName := filepath.Join(dir, "logTestFile.txt")
+			jsonTest := `
+			{
+				"SiteID": "BeyondAI",
+				"SystemID": "local",
+				"filename": "` + logFileName + `",
+				"stdout": false,
+				"level": "DEBUG",
+				"queueSize": 8,
+				"overflow": "` + overflow + `",
+				"debugFlags": [ { "pkg": "logit" } ],
+				"xFlags": [ { "pkg": "logit", "flags": "0x4" } ]
+			}`
+			err := writeConfigFile(configFileName, jsonTest)
+			if err != nil {
+				t.Fatalf("Logit problem %s", err.Error())
+			}
+			l, err := NewLogger(configFileName)
+			if err != nil {
+				t.Fatalf("Logit problem %s", err.Error())
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < 50; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						l.Infof(&myFlags, "goroutine %d line %d", g, i)
+						l.Debugx(0x04, &myFlags, "expert line")
+						l.GetLogStats()
+					}
+				}(g)
+			}
+			wg.Add(1)
+			go func() { // reload while the others are logging
+				defer wg.Done()
+				for gen := 1; gen <= 5; gen++ {
+					l.reloadConfig()
+				}
+			}()
+			wg.Wait()
+			l.Close()
+
+			lineCount := l.GetLogStats().lineCount
+			fileLines := countMessages(t, logFileName)
+			if int32(fileLines) != lineCount {
+				t.Errorf("Logit problem: %d lines counted, %d lines in the file", lineCount, fileLines)
+			}
+			t.Logf("%d lines in the file, %d dropped", fileLines, l.GetLogStats().queueDropped)
+			if overflow == "block" && fileLines < 50*100*2 {
+				t.Errorf("Logit problem: only %d lines in the file with blocking", fileLines)
+			}
+		})
+	}
+}
+
+/*
+  countMessages
+  Count the log messages in a file, some messages have more than one line
+*/
+func countMessages(t *testing.T, fileName string) int {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	defer fh.Close()
+	count := 0
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), " ") {
+			count++
+		}
+	}
+	return count
+}
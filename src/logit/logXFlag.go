@@ -0,0 +1,218 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const maxXFlagBits = 31 // the bits of an int32 that are not the sign
+
+var xFlagMutex sync.RWMutex
+var xFlagBits = make(map[string]map[string]int32)  // by package, the registered names, lower case, and their bits
+var xFlagNamed = make(map[string]map[int32]string) // by package, the registered names as given, by their bits
+
+/*
+  RegisterXFlag
+  Give a name to an xflag bit of the calling package and return the
+  bit, for Debugx and the config, e.g.
+  var cSHOWREQUESTHDRS = logit.RegisterXFlag("showRequestHdrs", 0x01)
+  The xFlags entries and overrides of the package can then say
+  "flags": [ "showRequestHdrs" ] instead of "flags": "0x1", which keeps
+  its meaning as the caller picks the bit. The bits belong to the
+  package, another package can give the same bit another name.
+  Registering a name again with its bit returns the bit, names do not
+  care about case. A bit that is not one of the 31 bits, or a name
+  with another bit or a bit with another name in the same package,
+  panics, as it is done when the packages are initialized.
+*/
+func RegisterXFlag(name string, bit int32) int32 {
+	pc, _, _, _ := runtime.Caller(1)
+	function := runtime.FuncForPC(pc).Name() // e.g. "logit/logittest.init" or "main.init.func1"
+	pkg := function
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		pkg = function[:slash+1+dot]
+	}
+	return registerXFlag(pkg, name, bit)
+}
+
+/*
+  registerXFlag
+  Give a name to an xflag bit of 'pkg'
+*/
+func registerXFlag(pkg string, name string, bit int32) int32 {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || strings.ContainsAny(name, ", ") {
+		panic(fmt.Sprintf("logit: xflag name '%s' is empty or has a comma or a space", name))
+	}
+	if _, err := strconv.ParseInt(name, 0, 32); err == nil {
+		panic(fmt.Sprintf("logit: xflag name '%s' is a number", name))
+	}
+	if bit <= 0 || bit&(bit-1) != 0 {
+		panic(fmt.Sprintf("logit: xflag '%s' has 0x%x, that is not one bit", name, bit))
+	}
+	xFlagMutex.Lock()
+	defer xFlagMutex.Unlock()
+	if xFlagBits[pkg] == nil {
+		xFlagBits[pkg] = make(map[string]int32)
+		xFlagNamed[pkg] = make(map[int32]string)
+	}
+	if old, found := xFlagBits[pkg][strings.ToLower(name)]; found && old != bit {
+		panic(fmt.Sprintf("logit: xflag '%s' of %s is 0x%x already, not 0x%x", name, pkg, old, bit))
+	}
+	if old, found := xFlagNamed[pkg][bit]; found && !strings.EqualFold(old, name) {
+		panic(fmt.Sprintf("logit: xflag 0x%x of %s is '%s' already, not '%s'", bit, pkg, old, name))
+	}
+	if _, found := xFlagNamed[pkg][bit]; !found {
+		xFlagBits[pkg][strings.ToLower(name)] = bit
+		xFlagNamed[pkg][bit] = name
+	}
+	return bit
+}
+
+/*
+  xFlagPkgs
+  The packages whose names count for 'pkg': itself, or every package
+  for all of them. xFlagMutex is held.
+*/
+func xFlagPkgs(pkg string) []string {
+	if len(pkg) > 0 && pkg != ALL_OVERRIDE {
+		return []string{pkg}
+	}
+	pkgs := make([]string, 0, len(xFlagNamed))
+	for name := range xFlagNamed {
+		pkgs = append(pkgs, name)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+/*
+  registeredXFlags
+  The xflag names registered by a package, by bit, or those of every
+  package for "" or all
+*/
+func registeredXFlags(pkg string) []string {
+	return xFlagNames(pkg, ^int32(0)>>1) // all 31 bits
+}
+
+/*
+  xFlagNames
+  The names the package gave to the bits that are set, by bit, for ""
+  or all the names any package gave them. Bits without a name are
+  left out.
+*/
+func xFlagNames(pkg string, value int32) []string {
+	xFlagMutex.RLock()
+	defer xFlagMutex.RUnlock()
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range xFlagPkgs(pkg) {
+		for i := 0; i < maxXFlagBits; i++ {
+			bit := int32(1) << uint(i)
+			if name, found := xFlagNamed[p][bit]; found && value&bit != 0 && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+/*
+  targetPkg
+  The package of an xFlags entry or override target, "pkg:file"
+*/
+func targetPkg(target string) string {
+	if i := strings.Index(target, ":"); i >= 0 {
+		return target[:i]
+	}
+	return target
+}
+
+/*
+  parseXFlags
+  The xflags of the config or of an override for the package 'pkg':
+  a number, "3" or "0x3", a name, names separated by commas, or a list
+  of names and numbers. The bits are or'ed together. A name is looked
+  up in the names of the package, for all packages it is the bits of
+  every package that has the name. Names that are not registered are
+  returned, they add nothing.
+*/
+func parseXFlags(pkg string, flags interface{}) (int32, []string, error) {
+	var value int32
+	var unknown []string
+	add := func(item string) error {
+		for _, part := range strings.Split(item, ",") {
+			part = strings.TrimSpace(part)
+			if len(part) == 0 {
+				continue
+			}
+			if number, err := strconv.ParseInt(part, 0, 32); err == nil {
+				value |= int32(number)
+				continue
+			} else if part[0] >= '0' && part[0] <= '9' {
+				return fmt.Errorf("xflag '%s' is not a number", part)
+			}
+			var bit int32
+			found := false
+			xFlagMutex.RLock()
+			for _, p := range xFlagPkgs(pkg) {
+				if b, named := xFlagBits[p][strings.ToLower(part)]; named {
+					bit |= b
+					found = true
+				}
+			}
+			xFlagMutex.RUnlock()
+			if !found {
+				unknown = append(unknown, part)
+				continue
+			}
+			value |= bit
+		}
+		return nil
+	}
+	switch v := flags.(type) {
+	case nil:
+	case string:
+		if err := add(v); err != nil {
+			return 0, nil, err
+		}
+	case float64:
+		value |= int32(v)
+	case []interface{}:
+		for _, item := range v {
+			switch i := item.(type) {
+			case string:
+				if err := add(i); err != nil {
+					return 0, nil, err
+				}
+			case float64:
+				value |= int32(i)
+			default:
+				return 0, nil, fmt.Errorf("xflag %v is not a name or a number", item)
+			}
+		}
+	default:
+		return 0, nil, fmt.Errorf("xflags %v are not names or a number", flags)
+	}
+	return value, unknown, nil
+}
+
+/*
+  describeXFlags
+  The xflags for the log, the number and the names the package gave
+  its bits
+*/
+func describeXFlags(pkg string, value int32) string {
+	names := xFlagNames(pkg, value)
+	if len(names) == 0 {
+		return fmt.Sprintf("0x%x", value)
+	}
+	return fmt.Sprintf("0x%x (%s)", value, strings.Join(names, ","))
+}
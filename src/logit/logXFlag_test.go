@@ -0,0 +1,157 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// registered once for all the tests, the bits of logit
+var testShowHeaders = RegisterXFlag("testShowHeaders", 0x100)
+var testShowBody = RegisterXFlag("testShowBody", 0x02)
+
+// the same bit with another name, in another package
+var testOtherShow = registerXFlag("other/pkg", "testOtherShow", 0x02)
+
+/*
+  TestRegisterXFlag
+  A name gets the bit it asks for, a name registered again with its bit
+  gets it again, bad names, bad bits and clashes in a package panic
+*/
+func TestRegisterXFlag(t *testing.T) {
+	if testShowHeaders != 0x100 || testShowBody != 0x02 {
+		t.Errorf("Logit problem: bits 0x%x and 0x%x", testShowHeaders, testShowBody)
+	}
+	if bit := RegisterXFlag("TESTSHOWHEADERS", 0x100); bit != testShowHeaders {
+		t.Errorf("Logit problem: registered again as 0x%x", bit)
+	}
+	for _, bad := range []struct {
+		name string
+		bit  int32
+	}{
+		{"", 0x01}, {"two words", 0x01}, {"a,b", 0x01}, {"0x10", 0x10},
+		{"noBit", 0}, {"twoBits", 0x03}, {"signBit", -0x80000000},
+		{"testShowHeaders", 0x200}, // the name has another bit
+		{"testShowOther", 0x02},    // the bit has another name
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Logit problem: '%s' was registered as 0x%x", bad.name, bad.bit)
+				}
+			}()
+			RegisterXFlag(bad.name, bad.bit)
+		}()
+	}
+	if names := xFlagNames("logit", testShowBody|testShowHeaders|0x01); strings.Join(names, ",") != "testShowBody,testShowHeaders" {
+		t.Errorf("Logit problem: names %v", names)
+	}
+	if names := xFlagNames("other/pkg", testShowBody|testShowHeaders); strings.Join(names, ",") != "testOtherShow" {
+		t.Errorf("Logit problem: names of the other package %v", names)
+	}
+	if names := xFlagNames(ALL_OVERRIDE, 0x02); strings.Join(names, ",") != "testShowBody,testOtherShow" {
+		t.Errorf("Logit problem: names of all packages %v", names)
+	}
+}
+
+/*
+  TestParseXFlags
+  Numbers, names, lists of both, and names that are not registered
+*/
+func TestParseXFlags(t *testing.T) {
+	both := testShowHeaders | testShowBody
+	for _, test := range []struct {
+		flags   interface{}
+		value   int32
+		unknown int
+	}{
+		{"3", 3, 0},
+		{"0x10", 0x10, 0},
+		{float64(5), 5, 0},
+		{nil, 0, 0},
+		{"testShowHeaders", testShowHeaders, 0},
+		{"testShowHeaders, testshowbody", both, 0},
+		{[]interface{}{"testShowBody", "testShowHeaders"}, both, 0},
+		{[]interface{}{"testShowBody", "0x40000000", float64(0x20000000)}, testShowBody | 0x60000000, 0},
+		{[]interface{}{"testShowBody", "noSuchFlag", "notThisOne"}, testShowBody, 2},
+	} {
+		value, unknown, err := parseXFlags("logit", test.flags)
+		if err != nil || value != test.value || len(unknown) != test.unknown {
+			t.Errorf("Logit problem: %v is 0x%x, unknown %v, %v", test.flags, value, unknown, err)
+		}
+	}
+	for _, bad := range []interface{}{"0xZZ", true, []interface{}{"testShowBody", false}} {
+		if _, _, err := parseXFlags("logit", bad); err == nil {
+			t.Errorf("Logit problem: %v was parsed", bad)
+		}
+	}
+	if _, unknown, _ := parseXFlags("logit", "testOtherShow"); len(unknown) != 1 {
+		t.Errorf("Logit problem: a name of another package is one of logit")
+	}
+	if value, unknown, _ := parseXFlags("", "testOtherShow,testShowHeaders"); value != testOtherShow|testShowHeaders || len(unknown) != 0 {
+		t.Errorf("Logit problem: the names of all packages are 0x%x, unknown %v", value, unknown)
+	}
+}
+
+/*
+  TestXFlagNamesConfig
+  The config turns on xflags by name and warns about unknown names, an
+  override takes names too
+*/
+func TestXFlagNamesConfig(t *testing.T) {
+	registerMemorySink(t)
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	jsonTest := `
+	{
+		"level": "DEBUG",
+		"xFlags": [
+			{ "pkg": "logit", "file": "logXFlag_test", "flags": [ "testShowHeaders", "testShowHedaers" ] },
+			{ "pkg": "logit", "file": "logOther", "flags": "testShowBody,testShowHeaders" },
+			{ "pkg": "other/pkg", "flags": "testOtherShow" }
+		],
+		"sinks": [ { "type": "memory", "id": "xflag-names", "pkgs": [ "logit:logXFlag_test" ] } ]
+	}`
+	if err := writeConfigFile(configFileName, jsonTest); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	parser := newLogger()
+	var tFlags logFlags_t
+	if err := parser.getConfig(configFileName, &tFlags); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	warned := false
+	for _, dlog := range parser.delayedLogs {
+		if dlog.logLevel == WARN && strings.Contains(dlog.msg, "xFlags[0].flags: unknown xflag name 'testShowHedaers'") {
+			warned = true
+		}
+	}
+	if !warned || tFlags.xFlags["logit:logOther"] != testShowHeaders|testShowBody || tFlags.xFlags["other/pkg"] != testOtherShow {
+		t.Errorf("Logit problem: warned %t, xflags %v", warned, tFlags.xFlags)
+	}
+
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	l.Debugx(testShowHeaders, &myFlags, "Headers shown")
+	l.Debugx(testShowBody, &myFlags, "Body not shown")
+	if info := findFlags(l, "logit:logXFlag_test"); info == nil || len(info.XFlagNames) != 1 || info.XFlagNames[0] != "testShowHeaders" {
+		t.Errorf("Logit problem: flags %+v", info)
+	}
+	if _, err := l.SetOverride(Override_t{Target: "logit:logXFlag_test", XFlag: "testShowBody"}); err != nil {
+		t.Errorf("Logit problem %s", err.Error())
+	}
+	l.Debugx(testShowBody, &myFlags, "Body shown")
+	if _, err := l.SetOverride(Override_t{Target: "logit", XFlag: "testShowBdy"}); err == nil {
+		t.Errorf("Logit problem: an override with an unknown name")
+	}
+	l.Close()
+
+	lines := getMemorySink(t, "xflag-names").getLines()
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "Headers shown") || !strings.HasSuffix(lines[1], "Body shown") {
+		t.Errorf("Logit problem: the sink got %q", lines)
+	}
+}
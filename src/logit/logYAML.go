@@ -0,0 +1,576 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+  yamlLine_t
+  A line of a yaml config without its comment, the column of its text
+  is its indent plus one
+*/
+type yamlLine_t struct {
+	number int
+	indent int
+	text   string
+}
+
+/*
+  yamlParser_t
+  Reads the yaml a config needs: mappings and sequences by indent,
+  flow [ lists ] and { maps } that can go over lines, quoted and plain
+  scalars, and comments. Anchors, tags, block scalars and more than one
+  document are not a config's business, they are errors.
+*/
+type yamlParser_t struct {
+	lines []yamlLine_t
+	next  int // the line being read
+	doc   *configDoc_t
+}
+
+/*
+  yamlError_t
+  What is wrong with the yaml and where
+*/
+type yamlError_t struct {
+	pos configPos_t
+	msg string
+}
+
+func (e *yamlError_t) Error() string {
+	return e.msg
+}
+
+/*
+  parseYAML
+  The config of a yaml file, nil if it is not good yaml, its problem
+  is added to the doc
+*/
+func parseYAML(raw []byte, doc *configDoc_t) (value interface{}) {
+	p := &yamlParser_t{doc: doc}
+	defer func() {
+		if r := recover(); r != nil {
+			yamlErr, isYAMLErr := r.(*yamlError_t)
+			if !isYAMLErr {
+				panic(r)
+			}
+			doc.add(yamlErr.pos, "", CONFIG_ERROR, "bad yaml: "+yamlErr.msg)
+			value = nil
+		}
+	}()
+	p.splitLines(string(raw))
+	doc.index[""] = configPos_t{line: 1, column: 1}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}
+	}
+	if p.lines[0].indent > 0 {
+		p.fail(p.lines[0], 0, "the config must start at the first column")
+	}
+	if first := p.lines[0]; strings.IndexByte("[{", first.text[0]) >= 0 {
+		p.next++
+		value = p.parseInline(first, 0, first.text, "") // json is yaml too
+	} else {
+		value = p.parseBlock("", 0)
+	}
+	if p.next < len(p.lines) {
+		p.fail(p.lines[p.next], 0, "this is not in the config, check the indent")
+	}
+	return value
+}
+
+/*
+  fail
+  Stop parsing, with what is wrong at a column of a line
+*/
+func (p *yamlParser_t) fail(line yamlLine_t, offset int, format string, args ...interface{}) {
+	panic(&yamlError_t{pos: configPos_t{line: line.number, column: line.indent + offset + 1}, msg: fmt.Sprintf(format, args...)})
+}
+
+/*
+  splitLines
+  The lines that have something, without their comments
+*/
+func (p *yamlParser_t) splitLines(text string) {
+	for i, raw := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		line := yamlLine_t{number: i + 1}
+		for line.indent < len(raw) && raw[line.indent] == ' ' {
+			line.indent++
+		}
+		if line.indent < len(raw) && raw[line.indent] == '\t' {
+			p.fail(line, 0, "tabs cannot indent yaml, use spaces")
+		}
+		line.text = strings.TrimRight(stripYAMLComment(raw[line.indent:]), " \t")
+		switch {
+		case len(line.text) == 0:
+			continue
+		case line.text == "---" && len(p.lines) == 0:
+			continue // the start of the document
+		case line.text == "---" || line.text == "...":
+			p.fail(line, 0, "a config is one document")
+		}
+		p.lines = append(p.lines, line)
+	}
+}
+
+/*
+  stripYAMLComment
+  The text before a '#' that starts a comment, one at the start or
+  after a space that is not in quotes
+*/
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [{,:", text[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+/*
+  isYAMLItem
+  Check if a line is an item of a sequence
+*/
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+/*
+  parseBlock
+  The mapping or the sequence that starts at the next line, at this
+  indent
+*/
+func (p *yamlParser_t) parseBlock(path string, indent int) interface{} {
+	if isYAMLItem(p.lines[p.next].text) {
+		return p.parseSequence(path, indent)
+	}
+	return p.parseMapping(path, indent)
+}
+
+/*
+  parseMapping
+  The "key: value" lines at this indent. A value that is on the lines
+  after its key is indented more, a sequence can be at the same indent.
+*/
+func (p *yamlParser_t) parseMapping(path string, indent int) map[string]interface{} {
+	mapping := make(map[string]interface{})
+	for p.next < len(p.lines) && p.lines[p.next].indent == indent && !isYAMLItem(p.lines[p.next].text) {
+		line := p.lines[p.next]
+		key, rest, restAt := p.splitKey(line)
+		member := joinConfigPath(path, key)
+		keyPos := configPos_t{line: line.number, column: line.indent + 1}
+		if _, found := mapping[key]; found {
+			p.doc.add(keyPos, member, CONFIG_WARNING, "given more than once, the last one is used")
+		}
+		p.doc.index[member] = keyPos
+		p.next++
+		if len(rest) > 0 {
+			mapping[key] = p.parseInline(line, restAt, rest, member)
+			continue
+		}
+		mapping[key] = nil
+		if p.next < len(p.lines) {
+			next := p.lines[p.next]
+			if next.indent > indent || (next.indent == indent && isYAMLItem(next.text)) {
+				mapping[key] = p.parseBlock(member, next.indent)
+			}
+		}
+	}
+	if p.next < len(p.lines) && p.lines[p.next].indent > indent {
+		p.fail(p.lines[p.next], 0, "this is indented more than the lines before it")
+	}
+	return mapping
+}
+
+/*
+  parseSequence
+  The "- item" lines at this indent. An item can be a mapping that
+  starts on the line of its dash.
+*/
+func (p *yamlParser_t) parseSequence(path string, indent int) []interface{} {
+	sequence := []interface{}{}
+	for p.next < len(p.lines) && p.lines[p.next].indent == indent && isYAMLItem(p.lines[p.next].text) {
+		line := p.lines[p.next]
+		item := fmt.Sprintf("%s[%d]", path, len(sequence))
+		p.doc.index[item] = configPos_t{line: line.number, column: line.indent + 1}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		restAt := len(line.text) - len(rest)
+		switch {
+		case len(rest) == 0:
+			p.next++
+			var value interface{}
+			if p.next < len(p.lines) && p.lines[p.next].indent > indent {
+				value = p.parseBlock(item, p.lines[p.next].indent)
+			}
+			sequence = append(sequence, value)
+		case isYAMLItem(rest) || p.isKey(rest):
+			// the rest of the line is the first line of a block, at the indent of its text
+			p.lines[p.next] = yamlLine_t{number: line.number, indent: line.indent + restAt, text: rest}
+			sequence = append(sequence, p.parseBlock(item, line.indent+restAt))
+		default:
+			p.next++
+			sequence = append(sequence, p.parseInline(line, restAt, rest, item))
+		}
+	}
+	if p.next < len(p.lines) && p.lines[p.next].indent > indent {
+		p.fail(p.lines[p.next], 0, "this is indented more than the lines before it")
+	}
+	return sequence
+}
+
+/*
+  isKey
+  Check if the text is "key: value", not a scalar or a flow
+*/
+func (p *yamlParser_t) isKey(text string) bool {
+	if strings.IndexByte("[{", text[0]) >= 0 {
+		return false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		return end > 0 && strings.HasPrefix(text[end+1:], ":") &&
+			(len(text) == end+2 || text[end+2] == ' ')
+	}
+	return strings.HasSuffix(text, ":") || strings.Contains(text, ": ")
+}
+
+/*
+  closingQuote
+  The index of the quote that ends the quoted text at the start, -1 if
+  there is none
+*/
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[0] == '"' && text[i] == '\\':
+			i++
+		case text[i] == text[0] && text[0] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++ // '' is a quote in a single quoted string
+		case text[i] == text[0]:
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+  splitKey
+  The key of a "key: value" line, the value and where it starts
+*/
+func (p *yamlParser_t) splitKey(line yamlLine_t) (string, string, int) {
+	text := line.text
+	var key, rest string
+	switch {
+	case strings.IndexByte("[{", text[0]) >= 0:
+		p.fail(line, 0, "a key cannot be a list or a map")
+	case text[0] == '&' || text[0] == '*' || text[0] == '!' || text[0] == '?':
+		p.fail(line, 0, "anchors, aliases, tags and complex keys are not used in a config")
+	case text[0] == '"' || text[0] == '\'':
+		end := closingQuote(text)
+		if end < 0 {
+			p.fail(line, 0, "the quote is not closed")
+		}
+		key = p.parseQuoted(line, 0, text[:end+1])
+		rest = text[end+1:]
+		if !strings.HasPrefix(rest, ":") {
+			p.fail(line, end+1, "a ':' must follow the key")
+		}
+		rest = rest[1:]
+	default:
+		colon := strings.Index(text, ": ")
+		if colon < 0 && strings.HasSuffix(text, ":") {
+			colon = len(text) - 1
+		}
+		if colon < 0 {
+			p.fail(line, 0, "'%s' is not 'key: value'", text)
+		}
+		key = strings.TrimRight(text[:colon], " ")
+		rest = text[colon+1:]
+	}
+	value := strings.TrimLeft(rest, " ")
+	return key, value, len(text) - len(value)
+}
+
+/*
+  parseInline
+  The value after a key or a dash: a flow list or map, which can go on
+  over the next lines, or a scalar. A plain scalar with ': ' in it is
+  a mapping where there cannot be one, an error like yaml has it.
+*/
+func (p *yamlParser_t) parseInline(line yamlLine_t, at int, text string, path string) interface{} {
+	switch text[0] {
+	case '[', '{':
+		f := &yamlFlow_t{p: p}
+		f.add(line, at, text)
+		for !f.balanced() && p.next < len(p.lines) {
+			next := p.lines[p.next]
+			f.add(next, -1, " "+next.text) // the space is where the line break was
+			p.next++
+		}
+		value := f.parseValue(path)
+		f.skipSpace()
+		if f.i < len(f.text) {
+			f.fail("there is more after the closing '%c'", map[byte]byte{'[': ']', '{': '}'}[text[0]])
+		}
+		return value
+	case '|', '>':
+		p.fail(line, at, "block scalars are not used in a config, use a quoted string")
+	case '&', '*', '!':
+		p.fail(line, at, "anchors, aliases and tags are not used in a config")
+	case '@', '`', '%':
+		p.fail(line, at, "a plain value cannot start with '%c', use a quoted string", text[0])
+	case '"', '\'':
+		end := closingQuote(text)
+		if end < 0 {
+			p.fail(line, at, "the quote is not closed")
+		}
+		if end != len(text)-1 {
+			p.fail(line, at+end+1, "there is more after the quoted string")
+		}
+		return p.parseQuoted(line, at, text)
+	}
+	if isYAMLItem(text) {
+		p.fail(line, at, "a list item cannot be on the line of its key")
+	}
+	if colon := strings.Index(text+" ", ": "); colon >= 0 {
+		p.fail(line, at+colon, "a plain value cannot have ': ' in it, use a quoted string")
+	}
+	return plainYAMLScalar(text)
+}
+
+/*
+  parseQuoted
+  A double quoted string has the escapes of Go, a single quoted one
+  has '' for a quote
+*/
+func (p *yamlParser_t) parseQuoted(line yamlLine_t, at int, text string) string {
+	if text[0] == '\'' {
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1)
+	}
+	unquoted, err := strconv.Unquote(text)
+	if err != nil {
+		p.fail(line, at, "bad escape in %s", text)
+	}
+	return unquoted
+}
+
+/*
+  plainYAMLScalar
+  What a plain scalar is: null, true or false, a number or a string
+*/
+func plainYAMLScalar(text string) interface{} {
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	digits, base := text, 10
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0o") {
+		digits, base = text[2:], map[byte]int{'x': 16, 'o': 8}[text[1]]
+	}
+	if number, err := strconv.ParseInt(digits, base, 64); err == nil && !strings.HasPrefix(digits, "+") {
+		return float64(number)
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil && !math.IsInf(number, 0) && !math.IsNaN(number) &&
+		strings.IndexAny(text, "0123456789") >= 0 {
+		return number
+	}
+	return text
+}
+
+/*
+  yamlFlow_t
+  A flow list or map, the text of its lines and where each character
+  is
+*/
+type yamlFlow_t struct {
+	p     *yamlParser_t
+	text  []byte
+	where []configPos_t
+	i     int
+}
+
+/*
+  add
+  Add the text of a line, from a column
+*/
+func (f *yamlFlow_t) add(line yamlLine_t, at int, text string) {
+	for j := 0; j < len(text); j++ {
+		f.text = append(f.text, text[j])
+		f.where = append(f.where, configPos_t{line: line.number, column: line.indent + at + j + 1})
+	}
+}
+
+/*
+  balanced
+  Check if every [ and { is closed
+*/
+func (f *yamlFlow_t) balanced() bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(f.text); i++ {
+		switch c := f.text[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [{,:", f.text[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+/*
+  pos
+  Where the character being read is, the end of the text after the
+  last one
+*/
+func (f *yamlFlow_t) pos() configPos_t {
+	if f.i < len(f.where) {
+		return f.where[f.i]
+	}
+	end := f.where[len(f.where)-1]
+	end.column++
+	return end
+}
+
+func (f *yamlFlow_t) fail(format string, args ...interface{}) {
+	panic(&yamlError_t{pos: f.pos(), msg: fmt.Sprintf(format, args...)})
+}
+
+func (f *yamlFlow_t) skipSpace() {
+	for f.i < len(f.text) && (f.text[f.i] == ' ' || f.text[f.i] == '\t') {
+		f.i++
+	}
+}
+
+/*
+  parseValue
+  A list, a map or a scalar of the flow
+*/
+func (f *yamlFlow_t) parseValue(path string) interface{} {
+	f.skipSpace()
+	if f.i >= len(f.text) {
+		f.fail("the flow ends before its value")
+	}
+	if _, found := f.p.doc.index[path]; !found {
+		f.p.doc.index[path] = f.pos()
+	}
+	switch f.text[f.i] {
+	case '[':
+		f.i++
+		list := []interface{}{}
+		first := true
+		for f.more(']', &first) {
+			list = append(list, f.parseValue(fmt.Sprintf("%s[%d]", path, len(list))))
+		}
+		return list
+	case '{':
+		f.i++
+		mapping := make(map[string]interface{})
+		first := true
+		for f.more('}', &first) {
+			keyPos := f.pos()
+			key, _ := f.parseScalar(true).(string)
+			member := joinConfigPath(path, key)
+			if _, found := mapping[key]; found {
+				f.p.doc.add(keyPos, member, CONFIG_WARNING, "given more than once, the last one is used")
+			}
+			f.p.doc.index[member] = keyPos
+			f.skipSpace()
+			if f.i >= len(f.text) || f.text[f.i] != ':' {
+				f.fail("a ':' must follow the key")
+			}
+			f.i++
+			mapping[key] = f.parseValue(member)
+		}
+		return mapping
+	}
+	return f.parseScalar(false)
+}
+
+/*
+  more
+  Check if there is another entry before the closing character, past
+  the comma after the one before. A comma can end the entries.
+*/
+func (f *yamlFlow_t) more(closing byte, first *bool) bool {
+	f.skipSpace()
+	if f.i < len(f.text) && f.text[f.i] == closing {
+		f.i++
+		return false
+	}
+	if !*first {
+		if f.i >= len(f.text) || f.text[f.i] != ',' {
+			f.fail("a ',' or '%c' is missing", closing)
+		}
+		f.i++
+		f.skipSpace()
+		if f.i < len(f.text) && f.text[f.i] == closing {
+			f.i++
+			return false
+		}
+	}
+	if f.i >= len(f.text) {
+		f.fail("'%c' is missing", closing)
+	}
+	*first = false
+	return true
+}
+
+/*
+  parseScalar
+  A quoted or a plain scalar of the flow, a plain one ends at a ',',
+  a ']' or a '}', or a ':' for a key
+*/
+func (f *yamlFlow_t) parseScalar(isKey bool) interface{} {
+	start := f.i
+	if c := f.text[f.i]; c == '"' || c == '\'' {
+		end := closingQuote(string(f.text[f.i:]))
+		if end < 0 {
+			f.fail("the quote is not closed")
+		}
+		f.i += end + 1
+		line := yamlLine_t{number: f.where[start].line, indent: f.where[start].column - 1}
+		return f.p.parseQuoted(line, 0, string(f.text[start:f.i]))
+	}
+	for f.i < len(f.text) && strings.IndexByte(",]}", f.text[f.i]) < 0 &&
+		!(f.text[f.i] == ':' && (isKey || f.i+1 == len(f.text) || f.text[f.i+1] == ' ')) {
+		f.i++
+	}
+	text := strings.TrimRight(string(f.text[start:f.i]), " ")
+	if len(text) == 0 {
+		f.fail("a value is missing")
+	}
+	if isKey {
+		return text
+	}
+	return plainYAMLScalar(text)
+}
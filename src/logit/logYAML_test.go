@@ -0,0 +1,105 @@
+// Package logit contains utility functions for logging for BeyondAI.
+package logit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/*
+  TestYAMLValues
+  The yaml a config is written in reads as the same json: nesting by
+  indent, lists, flows over lines, quoting, comments and scalars
+*/
+func TestYAMLValues(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want string
+	}{
+		{"", `{}`},
+		{"---\n# only a comment\n", `{}`},
+		{"a:\n  b:\n    c: 1\n  d: x\ne: y", `{"a":{"b":{"c":1},"d":"x"},"e":"y"}`},
+		{"list:\n  - one\n  - 2\nsame:\n- a\n-\n- - b", `{"list":["one",2],"same":["a",null,["b"]]}`},
+		{"sinks:\n  - type: file\n    level: WARN\n  - type: stdout", `{"sinks":[{"level":"WARN","type":"file"},{"type":"stdout"}]}`},
+		{"pkgs: [a, 'b c', {x: 1, y: [true]}]", `{"pkgs":["a","b c",{"x":1,"y":[true]}]}`},
+		{"pkgs: [\n  a,\n  b,\n]\nnext: 1", `{"next":1,"pkgs":["a","b"]}`},
+		{`a: "x: y # z"` + "\nb: 'it''s'\nc: \"tab\\tx\"\nd: '#no comment'", `{"a":"x: y # z","b":"it's","c":"tab\tx","d":"#no comment"}`},
+		{"'quoted key': 1\n\"other\": 2", `{"other":2,"quoted key":1}`},
+		{"# top\na: 1 # one\nb: x#y\n  # indented comment\nc: 2", `{"a":1,"b":"x#y","c":2}`},
+		{"a: ~\nb: null\nc: True\nd: false\ne: 0x10\nf: 1.5\ng: -3\nh: 1e3\ni: http://h:80/x\nj: 1.2.3", `{"a":null,"b":null,"c":true,"d":false,"e":16,"f":1.5,"g":-3,"h":1000,"i":"http://h:80/x","j":"1.2.3"}`},
+		{"empty:\nafter: 1", `{"after":1,"empty":null}`},
+		{`{"a": [1, 2], "b": {"c": "d"}}`, `{"a":[1,2],"b":{"c":"d"}}`},
+		{"a: 1\r\nb: 2\r\n", `{"a":1,"b":2}`},
+	} {
+		doc := parseConfig(CONFIG_YAML, []byte(test.raw))
+		got, _ := json.Marshal(doc.value)
+		if len(doc.problems) > 0 || string(got) != test.want {
+			t.Errorf("Logit problem: %q is %s, %v, wanted %s", test.raw, got, doc.problems, test.want)
+		}
+	}
+}
+
+/*
+  TestYAMLPositions
+  Every entry has the line and column of its key or its item
+*/
+func TestYAMLPositions(t *testing.T) {
+	doc := parseConfig(CONFIG_YAML, []byte("level: INFO\nsinks:\n  - type: file\n    pkgs: [a,\n      b]\n"))
+	for path, want := range map[string]configPos_t{
+		"level":            {line: 1, column: 1},
+		"sinks":            {line: 2, column: 1},
+		"sinks[0]":         {line: 3, column: 3},
+		"sinks[0].type":    {line: 3, column: 5},
+		"sinks[0].pkgs":    {line: 4, column: 5},
+		"sinks[0].pkgs[0]": {line: 4, column: 12},
+		"sinks[0].pkgs[1]": {line: 5, column: 7},
+	} {
+		if got := doc.index[path]; got != want {
+			t.Errorf("Logit problem: %s is at %d:%d, wanted %d:%d", path, got.line, got.column, want.line, want.column)
+		}
+	}
+}
+
+/*
+  TestYAMLBad
+  Yaml that is not good is one error at the line and column where it
+  went wrong, and no config
+*/
+func TestYAMLBad(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want string
+	}{
+		{"key: value: other", "1:11: error: bad yaml: a plain value cannot have ': ' in it, use a quoted string"},
+		{"a: b:", "1:5: error: bad yaml: a plain value cannot have ': ' in it, use a quoted string"},
+		{"sinks:\n  - type: file: json", "2:15: error: bad yaml: a plain value cannot have ': ' in it, use a quoted string"},
+		{"a: - b", "1:4: error: bad yaml: a list item cannot be on the line of its key"},
+		{"a: @x", "1:4: error: bad yaml: a plain value cannot start with '@', use a quoted string"},
+		{"a: &anchor x", "1:4: error: bad yaml: anchors, aliases and tags are not used in a config"},
+		{"? complex", "1:1: error: bad yaml: anchors, aliases, tags and complex keys are not used in a config"},
+		{"a: [1, 2", "1:9: error: bad yaml: a ',' or ']' is missing"},
+		{"a: {b 1}", "1:8: error: bad yaml: a ':' must follow the key"},
+		{"a: [1] 2", "1:8: error: bad yaml: there is more after the closing ']'"},
+		{`a: "x" y`, "1:7: error: bad yaml: there is more after the quoted string"},
+		{`a: "\q"`, `1:4: error: bad yaml: bad escape in "\q"`},
+		{`"a" b: 1`, "1:4: error: bad yaml: a ':' must follow the key"},
+		{"a:\n  [b]: 1", "2:3: error: bad yaml: a key cannot be a list or a map"},
+		{"[a]: 1", "1:4: error: bad yaml: there is more after the closing ']'"},
+		{"  a: 1", "1:3: error: bad yaml: the config must start at the first column"},
+		{"a:\n  - x\n  y: 1", "3:3: error: bad yaml: this is indented more than the lines before it"},
+		{"a: 1\n- b", "2:1: error: bad yaml: this is not in the config, check the indent"},
+		{"a:\n\t- b", "2:1: error: bad yaml: tabs cannot indent yaml, use spaces"},
+		{"a: >\n  text", "1:4: error: bad yaml: block scalars are not used in a config, use a quoted string"},
+		{"a: 1\n...", "2:1: error: bad yaml: a config is one document"},
+	} {
+		doc := parseConfig(CONFIG_YAML, []byte(test.raw))
+		if doc.value != nil || len(doc.problems) != 1 || doc.problems[0].String() != test.want {
+			t.Errorf("Logit problem: %q has %v, wanted '%s'", test.raw, doc.problems, test.want)
+		}
+	}
+	// a key given twice is a warning, the config is still read
+	doc := parseConfig(CONFIG_YAML, []byte("a: {b: 1, b: 2}"))
+	if len(doc.problems) != 1 || doc.problems[0].String() != "1:11: warning: a.b: given more than once, the last one is used" {
+		t.Errorf("Logit problem: %v", doc.problems)
+	}
+}
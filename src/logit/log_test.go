@@ -2,8 +2,11 @@
 package logit
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -296,6 +299,8 @@ func TestLogConfigChanges(t *testing.T) {
 		t.Errorf("Logit problem: Number of logged messages (%d) is incorrect", delta)
 	}
 	// remove then rewrite the config file again, to generate a modification event
+	reloaded := make(chan int32, 1)
+	Default().OnReload(func(generation int32) { reloaded <- generation })
 	time.Sleep(1 * time.Second)
 	Info(&myFlags, "Removing log config file")
 	removeConfigFile(configFileName)
@@ -304,21 +309,112 @@ func TestLogConfigChanges(t *testing.T) {
 	if err != nil {
 		t.Errorf("Logit problem %s", err.Error())
 	}
-	// wait for more than the monitor loop of 5 seconds
-	// to generate the event
+	// the reload happens without anything being logged,
+	// wait for more than the polling of 5 seconds
+	select {
+	case generation := <-reloaded:
+		if generation != 1 {
+			t.Errorf("Logit problem: reloaded generation %d is incorrect", generation)
+		}
+	case <-time.After(12 * time.Second):
+		t.Fatalf("Logit problem: the config was not reloaded")
+	}
+	// now all the debug messages are logged
 	startingLineCount2 := GetLogStats().lineCount
-	Info(&myFlags, "With next reconfig, no more DBGX messages")
-	time.Sleep(22 * time.Second)
-	Debug(&myFlags, "Sleep in main test loop just returned")
-	// get the size of the log
+	Debug(&myFlags, "Debug message after the reload")
 	lineCount2 := GetLogStats().lineCount
 	delta2 := lineCount2 - startingLineCount2
-	if delta2 != 12 {
+	if delta2 != 1 {
 		t.Errorf("Logit problem: Number of logged messages (%d) is incorrect", delta2)
 	}
 	CloseLog()
 }
 
+/*
+  TestLoggerIsolated
+  Two loggers side by side, each with its own file, level and stats.
+  The same DFlags_t is used with both.
+*/
+func TestLoggerIsolated(t *testing.T) {
+	dir := t.TempDir()
+	const jsonTest string = `
+	{
+		"SiteID": "BeyondAI",
+		"SystemID": "%s",
+		"filename": "%s",
+		"stdout": false,
+		"level": "%s",
+		"debugFlags": [ { "pkg": "logit" } ]
+	}`
+	configA := filepath.Join(dir, "configA.json")
+	configB := filepath.Join(dir, "configB.json")
+	fileA := filepath.Join(dir, "logA.txt")
+	fileB := filepath.Join(dir, "logB.txt")
+	writeConfigFile(configA, fmt.Sprintf(jsonTest, "A", fileA, "WARN"))
+	writeConfigFile(configB, fmt.Sprintf(jsonTest, "B", fileB, "DEBUG"))
+	loggerA, err := NewLogger(configA)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	loggerB, err := NewLogger(configB)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	var myFlags DFlags_t
+	GetMyLogInfo(&myFlags)
+	loggerA.Warn(&myFlags, "Warning only in A")
+	loggerA.Info(&myFlags, "Info is below the level of A")
+	loggerB.Info(&myFlags, "Info only in B")
+	loggerB.Debug(&myFlags, "Debug only in B")
+	loggerA.Debug(&myFlags, "Debug is off in A")
+	statsA := loggerA.GetLogStats()
+	statsB := loggerB.GetLogStats()
+	loggerA.Close()
+	loggerB.Close()
+
+	if statsA.warnCount != 1 || statsA.infoCount != 0 || statsA.debugCount != 0 {
+		t.Errorf("Logit problem: stats of A are %+v", statsA)
+	}
+	if statsB.warnCount != 0 || statsB.debugCount != 1 {
+		t.Errorf("Logit problem: stats of B are %+v", statsB)
+	}
+	rawA, _ := ioutil.ReadFile(fileA)
+	rawB, _ := ioutil.ReadFile(fileB)
+	for _, msg := range []string{"Warning only in A"} {
+		if !strings.Contains(string(rawA), msg) || strings.Contains(string(rawB), msg) {
+			t.Errorf("Logit problem: '%s' is in the wrong log", msg)
+		}
+	}
+	for _, msg := range []string{"Info only in B", "Debug only in B"} {
+		if !strings.Contains(string(rawB), msg) || strings.Contains(string(rawA), msg) {
+			t.Errorf("Logit problem: '%s' is in the wrong log", msg)
+		}
+	}
+	for _, msg := range []string{"Info is below the level of A", "Debug is off in A"} {
+		if strings.Contains(string(rawA)+string(rawB), msg) {
+			t.Errorf("Logit problem: '%s' should not be logged", msg)
+		}
+	}
+}
+
+//
+// newTestLogger
+// A logger of the config members of a test, written to a config file
+// in a temp dir, l.configFileName. The memory sinks can be used.
+//
+func newTestLogger(t *testing.T, config string) *Logger {
+	registerMemorySink(t)
+	configFileName := filepath.Join(t.TempDir(), "logtestcfg.json")
+	if err := writeConfigFile(configFileName, "{"+config+"\n}"); err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	l, err := NewLogger(configFileName)
+	if err != nil {
+		t.Fatalf("Logit problem %s", err.Error())
+	}
+	return l
+}
+
 //
 // writeConfigFile
 // Remove old file if present
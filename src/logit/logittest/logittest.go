@@ -0,0 +1,384 @@
+// Package logittest captures what is logged through logit in unit tests, to assert on it.
+package logittest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"logit"
+)
+
+const sinkType = "logittest" // the sink type the capture logger writes to
+
+/*
+  Options_t
+  What a capture keeps. The capture logger logs everything, these
+  pick what this test sees of it.
+*/
+type Options_t struct {
+	Level  string   // the most verbose level kept, TRACE when empty
+	Debug  []string // the packages, or package:files, whose debug messages are kept, all when empty
+	XFlags int32    // the xflags whose Debugx messages are kept, all when 0
+}
+
+/*
+  Capture_t
+  What is logged while a test runs. A record with the request ID of
+  the capture goes to it only, a record with no request ID, or one no
+  capture has, goes to every capture, so tests that run in parallel
+  each see the requests they made.
+*/
+type Capture_t struct {
+	t       testing.TB
+	id      string // the request ID of the capture
+	level   logit.Level_t
+	debug   map[string]bool
+	xflags  int32
+	mutex   sync.Mutex // protects records and lines
+	records []logit.Record_t
+	lines   []string // the records in the text format
+}
+
+var openMutex sync.Mutex                       // held while the capture logger opens or closes
+var captureMutex sync.Mutex                    // protects the variables below, the sink takes it
+var captureLogger *logit.Logger                // the default logger while there are captures
+var captureDir string                          // the config of the capture logger
+var captureGen int                             // counts the capture loggers, a closed one writes to no capture
+var previousLogger *logit.Logger               // the default logger before the captures
+var captures = make(map[testing.TB]*Capture_t) // the active captures, by test
+var captureIDs = make(map[string]*Capture_t)   // the active captures, by request ID
+var registerOnce sync.Once                     // registers the sink type
+var registerErr error                          // what went wrong registering it
+var levels = []logit.Level_t{logit.FATAL, logit.ERROR, logit.WARN, logit.INFO, logit.DEBUG, logit.TRACE}
+
+/*
+  captureSink_t
+  The sink of the capture logger, it hands the records to the captures
+*/
+type captureSink_t struct {
+	gen int // the capture logger it belongs to
+}
+
+func (sink *captureSink_t) Write(rec *logit.Record_t, line string) error {
+	id := ""
+	for _, field := range rec.Fields {
+		if field.Key == logit.RequestIDField {
+			id = fmt.Sprint(field.Value)
+		}
+	}
+	captureMutex.Lock()
+	defer captureMutex.Unlock()
+	if sink.gen != captureGen {
+		return nil // the logger is closing, its last words are for no test
+	}
+	if c := captureIDs[id]; c != nil {
+		c.add(rec, line)
+		return nil
+	}
+	for _, c := range captures {
+		c.add(rec, line)
+	}
+	return nil
+}
+
+func (sink *captureSink_t) Flush() error { return nil }
+
+func (sink *captureSink_t) Close() error { return nil }
+
+/*
+  Capture
+  Capture what is logged until the test ends. The first capture makes
+  a logger that logs everything to memory the default logger, the
+  default before it comes back when the last capture ends.
+  e.g. c := logittest.Capture(t, logittest.Options_t{Level: "DEBUG"})
+*/
+func Capture(t testing.TB, opts Options_t) *Capture_t {
+	t.Helper()
+	c := &Capture_t{t: t, id: logit.NewRequestID(), level: logit.TRACE, debug: make(map[string]bool), xflags: opts.XFlags}
+	if len(opts.Level) > 0 {
+		level, err := parseLevel(opts.Level)
+		if err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+		c.level = level
+	}
+	for _, target := range opts.Debug {
+		c.debug[strings.TrimSpace(target)] = true
+	}
+	openMutex.Lock()
+	defer openMutex.Unlock()
+	if captureLogger == nil {
+		if err := openCaptureLogger(); err != nil {
+			t.Fatalf("Logit problem %s", err.Error())
+		}
+	}
+	captureMutex.Lock()
+	defer captureMutex.Unlock()
+	if captures[t] != nil {
+		t.Fatalf("Logit problem: the test already has a capture")
+	}
+	captures[t] = c
+	captureIDs[c.id] = c
+	t.Cleanup(c.end)
+	return c
+}
+
+/*
+  openCaptureLogger
+  Open the logger of the captures and make it the default.
+  openMutex must be held.
+*/
+func openCaptureLogger() error {
+	registerOnce.Do(func() {
+		registerErr = logit.RegisterSink(sinkType, func(config logit.SinkConfig_t) (logit.Sink, error) {
+			return &captureSink_t{gen: captureGen}, nil // openMutex is held
+		})
+	})
+	if registerErr != nil {
+		return registerErr
+	}
+	dir, err := ioutil.TempDir("", "logittest")
+	if err != nil {
+		return err
+	}
+	captureMutex.Lock()
+	captureGen++
+	captureMutex.Unlock()
+	configFileName := filepath.Join(dir, "logitcfg.json")
+	config := `{
+	"filename": "",
+	"stdout": false,
+	"level": "TRACE",
+	"debugFlags": [ { "pkg": "all" } ],
+	"sinks": [ { "type": "` + sinkType + `", "format": "text" } ]
+}`
+	if err := ioutil.WriteFile(configFileName, []byte(config), 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	l, err := logit.NewLogger(configFileName)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if _, err := l.SetOverride(logit.Override_t{Target: logit.ALL_OVERRIDE, XFlag: "0x7fffffff"}); err != nil {
+		l.Close()
+		os.RemoveAll(dir)
+		return err
+	}
+	l.Sync() // what the logger says about itself is not for the captures
+	captureMutex.Lock()
+	captureLogger = l
+	captureDir = dir
+	previousLogger = logit.SetDefault(l)
+	captureMutex.Unlock()
+	return nil
+}
+
+/*
+  end
+  The test is over, the last capture puts the default logger back
+*/
+func (c *Capture_t) end() {
+	openMutex.Lock()
+	defer openMutex.Unlock()
+	captureLogger.Sync() // what the test logged still goes to it
+	captureMutex.Lock()
+	delete(captures, c.t)
+	delete(captureIDs, c.id)
+	if len(captures) > 0 {
+		captureMutex.Unlock()
+		return
+	}
+	l, dir := captureLogger, captureDir
+	logit.SetDefault(previousLogger)
+	captureLogger, previousLogger, captureDir = nil, nil, ""
+	captureGen++
+	captureMutex.Unlock()
+	l.Close() // its sink takes captureMutex
+	os.RemoveAll(dir)
+}
+
+/*
+  add
+  Keep a record if it passes the options. captureMutex is held.
+*/
+func (c *Capture_t) add(rec *logit.Record_t, line string) {
+	if rec.Level > c.level {
+		return
+	}
+	if rec.Level == logit.DEBUG && rec.XFlag == 0 && len(c.debug) > 0 && !c.debug[rec.Pkg] && !c.debug[rec.Pkg+":"+rec.File] {
+		return
+	}
+	if rec.XFlag != 0 && c.xflags != 0 && rec.XFlag&c.xflags == 0 {
+		return
+	}
+	c.mutex.Lock()
+	c.records = append(c.records, *rec)
+	c.lines = append(c.lines, line)
+	c.mutex.Unlock()
+}
+
+/*
+  RequestID
+  The request ID of the capture, what it logs goes to it only
+*/
+func (c *Capture_t) RequestID() string {
+	return c.id
+}
+
+/*
+  Context
+  A context with the request ID of the capture, for the ...Ctx
+  functions of logit
+*/
+func (c *Capture_t) Context(ctx context.Context) context.Context {
+	return logit.WithRequestID(ctx, c.id)
+}
+
+/*
+  Request
+  The request with the request ID of the capture, in its context and
+  in the header for logit.RequestIDMiddleware
+  e.g. handler.ServeHTTP(w, c.Request(httptest.NewRequest("GET", "/flags", nil)))
+*/
+func (c *Capture_t) Request(req *http.Request) *http.Request {
+	req.Header.Set(logit.RequestIDHeader, c.id)
+	return req.WithContext(c.Context(req.Context()))
+}
+
+/*
+  Records
+  The records captured so far, after what is queued is written
+*/
+func (c *Capture_t) Records() []logit.Record_t {
+	captureMutex.Lock()
+	l := captureLogger
+	captureMutex.Unlock()
+	if l != nil {
+		l.Sync()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]logit.Record_t(nil), c.records...)
+}
+
+/*
+  Lines
+  The records captured so far in the text format
+*/
+func (c *Capture_t) Lines() []string {
+	c.Records() // waits for the writer
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+/*
+  Logged
+  Check if a record of this level has a message that matches the
+  pattern, a regular expression. DEBUG has the Debugx messages too.
+*/
+func (c *Capture_t) Logged(level logit.Level_t, pattern string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range c.Records() {
+		if rec.Level == level && re.MatchString(rec.Msg) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+  AssertLogged
+  Fail the test if no record of this level matches the pattern
+*/
+func (c *Capture_t) AssertLogged(level logit.Level_t, pattern string) {
+	c.t.Helper()
+	c.assert(level, pattern, true)
+}
+
+/*
+  AssertNotLogged
+  Fail the test if a record of this level matches the pattern
+*/
+func (c *Capture_t) AssertNotLogged(level logit.Level_t, pattern string) {
+	c.t.Helper()
+	c.assert(level, pattern, false)
+}
+
+func (c *Capture_t) assert(level logit.Level_t, pattern string, want bool) {
+	c.t.Helper()
+	found, err := c.Logged(level, pattern)
+	if err != nil {
+		c.t.Fatalf("Logit problem %s", err.Error())
+	}
+	if found == want {
+		return
+	}
+	what := "nothing at %s matches '%s' in:\n%s"
+	if !want {
+		what = "something at %s matches '%s' in:\n%s"
+	}
+	c.t.Errorf("Logit problem: "+what, level, pattern, strings.Join(c.Lines(), "\n"))
+}
+
+/*
+  AssertLogged
+  Fail the test if no record of this level, captured for the test,
+  matches the pattern
+  e.g. logittest.AssertLogged(t, logit.INFO, "Starting server")
+*/
+func AssertLogged(t testing.TB, level logit.Level_t, pattern string) {
+	t.Helper()
+	captureOf(t).AssertLogged(level, pattern)
+}
+
+/*
+  AssertNotLogged
+  Fail the test if a record of this level, captured for the test,
+  matches the pattern
+*/
+func AssertNotLogged(t testing.TB, level logit.Level_t, pattern string) {
+	t.Helper()
+	captureOf(t).AssertNotLogged(level, pattern)
+}
+
+/*
+  captureOf
+  The capture of a test, it fails the test if it has none
+*/
+func captureOf(t testing.TB) *Capture_t {
+	t.Helper()
+	captureMutex.Lock()
+	c := captures[t]
+	captureMutex.Unlock()
+	if c == nil {
+		t.Fatalf("Logit problem: the test has no capture, call logittest.Capture first")
+	}
+	return c
+}
+
+/*
+  parseLevel
+  The level of a name, as logit names them
+*/
+func parseLevel(name string) (logit.Level_t, error) {
+	for _, level := range levels {
+		if strings.EqualFold(level.String(), strings.TrimSpace(name)) {
+			return level, nil
+		}
+	}
+	return logit.TRACE, fmt.Errorf("unknown log level '%s', use TRACE, DEBUG, INFO, WARN, ERROR or FATAL", name)
+}
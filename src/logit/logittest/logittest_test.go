@@ -0,0 +1,107 @@
+// Package logittest captures what is logged through logit in unit tests, to assert on it.
+package logittest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"logit"
+)
+
+var cTESTXFLAG = logit.RegisterXFlag("logittestShow", 0x01)
+var cOTHERXFLAG = logit.RegisterXFlag("logittestOther", 0x02)
+
+/*
+  TestCaptureOptions
+  The capture keeps the levels, debug packages and xflags of its
+  options, the package functions of logit log to it
+*/
+func TestCaptureOptions(t *testing.T) {
+	c := Capture(t, Options_t{Level: "DEBUG", Debug: []string{"logit/logittest:logittest_test"}, XFlags: cTESTXFLAG})
+	var myFlags logit.DFlags_t
+	logit.GetMyLogInfo(&myFlags)
+	logit.Infof(&myFlags, "Info number %d", 1)
+	logit.Warn(&myFlags, "A warning")
+	logit.Debug(&myFlags, "Debug of this file")
+	logit.Debugx(cTESTXFLAG, &myFlags, "Debugx that is shown")
+	logit.Debugx(cOTHERXFLAG, &myFlags, "Debugx that is not")
+	logit.Trace(&myFlags, "Trace is past the level")
+
+	AssertLogged(t, logit.INFO, `^Info number 1$`)
+	AssertLogged(t, logit.WARN, "warning")
+	AssertLogged(t, logit.DEBUG, "Debug of this file")
+	AssertLogged(t, logit.DEBUG, "that is shown")
+	AssertNotLogged(t, logit.DEBUG, "that is not")
+	AssertNotLogged(t, logit.TRACE, "Trace")
+	AssertNotLogged(t, logit.ERROR, "warning")
+	if lines := c.Lines(); len(lines) != 4 || !strings.Contains(lines[0], "INFO[logit/logittest:logittest_test] Info number 1") {
+		t.Errorf("Logit problem: captured %q", lines)
+	}
+	if found, err := c.Logged(logit.INFO, "(bad"); found || err == nil {
+		t.Errorf("Logit problem: a bad pattern matched")
+	}
+}
+
+/*
+  TestCaptureParallel
+  Handlers that run in parallel tests each log to the capture of their
+  test, the default logger is back when the tests are done
+*/
+func TestCaptureParallel(t *testing.T) {
+	before := logit.Default()
+	var myFlags logit.DFlags_t
+	logit.GetMyLogInfo(&myFlags)
+	handler := logit.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logit.InfofCtx(r.Context(), &myFlags, "Serving '%s'", r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "bad") {
+			logit.ErrorCtx(r.Context(), &myFlags, "Bad request")
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 8; i++ {
+			path := fmt.Sprintf("/item/%d", i)
+			if i%2 == 1 {
+				path += "/bad"
+			}
+			t.Run(path, func(t *testing.T) {
+				t.Parallel()
+				c := Capture(t, Options_t{})
+				for n := 0; n < 20; n++ {
+					w := httptest.NewRecorder()
+					handler.ServeHTTP(w, c.Request(httptest.NewRequest("GET", path, nil)))
+					if w.Header().Get(logit.RequestIDHeader) != c.RequestID() {
+						t.Fatalf("Logit problem: request ID '%s'", w.Header().Get(logit.RequestIDHeader))
+					}
+				}
+				logit.InfoCtx(c.Context(context.Background()), &myFlags, "Done with "+path)
+				AssertLogged(t, logit.INFO, "^Serving '"+path+"'$")
+				AssertLogged(t, logit.INFO, "^Done with "+path+"$")
+				if strings.HasSuffix(path, "bad") {
+					AssertLogged(t, logit.ERROR, "Bad request")
+				} else {
+					AssertNotLogged(t, logit.ERROR, "Bad request")
+				}
+				for _, rec := range c.Records() {
+					if strings.HasPrefix(rec.Msg, "Serving") && rec.Msg != "Serving '"+path+"'" {
+						t.Errorf("Logit problem: '%s' is from another test", rec.Msg)
+					}
+				}
+				want := 21 // a line for each request and the one of the test
+				if strings.HasSuffix(path, "bad") {
+					want += 20
+				}
+				if records := c.Records(); len(records) != want {
+					t.Errorf("Logit problem: %d records, wanted %d", len(records), want)
+				}
+			})
+		}
+	})
+	if logit.Default() != before {
+		t.Errorf("Logit problem: the default logger was not put back")
+	}
+}